@@ -0,0 +1,112 @@
+package collab
+
+import (
+	"errors"
+	"unicode/utf8"
+)
+
+var (
+	// ErrUnknownRevision is returned for operations based on a revision the document never had
+	ErrUnknownRevision = errors.New("unknown revision")
+	// ErrRevisionTooOld is returned when the operations since the base revision are no longer kept
+	ErrRevisionTooOld = errors.New("revision is too old")
+	// ErrTooLarge is returned when an operation would grow the text past the size limit
+	ErrTooLarge = errors.New("document is too large")
+)
+
+// Document is the authoritative copy of a text edited by several clients. Every applied
+// operation advances the revision; recent operations are kept so that edits made against
+// an older revision can be transformed before they are applied. It is not safe for
+// concurrent use.
+type Document struct {
+	text     []rune
+	revision int
+	// history holds the operations that led to revisions revision-len(history)+1 … revision
+	history    []Operation
+	maxHistory int
+	maxBytes   int
+}
+
+// NewDocument starts a document at revision 0, keeping up to maxHistory operations and
+// refusing edits that make the text longer than maxBytes UTF-8 bytes
+func NewDocument(text string, maxHistory, maxBytes int) *Document {
+	return &Document{
+		text:       []rune(text),
+		history:    make([]Operation, 0, maxHistory),
+		maxHistory: maxHistory,
+		maxBytes:   maxBytes,
+	}
+}
+
+// Text returns the current text
+func (d *Document) Text() string {
+	return string(d.text)
+}
+
+// Runes returns a copy of the current text
+func (d *Document) Runes() []rune {
+	return append([]rune(nil), d.text...)
+}
+
+// Len is the length of the text in code points
+func (d *Document) Len() int {
+	return len(d.text)
+}
+
+// Revision is the number of operations applied so far
+func (d *Document) Revision() int {
+	return d.revision
+}
+
+// Apply transforms op, made against the given revision, over the operations applied since
+// then and applies the result. It returns the operation as applied and the new revision.
+func (d *Document) Apply(revision int, op Operation) (Operation, int, error) {
+	missed, err := d.Since(revision)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, concurrent := range missed {
+		if op, _, err = Transform(op, concurrent); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	text, err := op.Apply(d.text)
+	if err != nil {
+		return nil, 0, err
+	}
+	if d.maxBytes > 0 && utf8Len(text) > d.maxBytes && utf8Len(text) > utf8Len(d.text) {
+		return nil, 0, ErrTooLarge
+	}
+
+	d.text = text
+	d.revision++
+	if len(d.history) == d.maxHistory && d.maxHistory > 0 {
+		d.history = append(d.history[:0], d.history[1:]...)
+	}
+	if d.maxHistory > 0 {
+		d.history = append(d.history, op)
+	}
+	return op, d.revision, nil
+}
+
+// Since returns the operations applied after the given revision, oldest first
+func (d *Document) Since(revision int) ([]Operation, error) {
+	if revision < 0 || revision > d.revision {
+		return nil, ErrUnknownRevision
+	}
+	missed := d.revision - revision
+	if missed > len(d.history) {
+		return nil, ErrRevisionTooOld
+	}
+	return d.history[len(d.history)-missed:], nil
+}
+
+func utf8Len(text []rune) int {
+	n := 0
+	for _, r := range text {
+		n += utf8.RuneLen(r)
+	}
+	return n
+}
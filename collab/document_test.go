@@ -0,0 +1,151 @@
+package collab
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestDocumentApplyConcurrent(t *testing.T) {
+	d := NewDocument("abc", 10, 0)
+	if _, rev, err := d.Apply(0, op(1, "X", 2)); err != nil || rev != 1 {
+		t.Fatalf("Apply: revision %d, err = %v", rev, err)
+	}
+
+	// Made against revision 0 without seeing the insert
+	applied, rev, err := d.Apply(0, op(1, -1, 1))
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if rev != 2 || d.Revision() != 2 {
+		t.Errorf("revision = %d, document at %d, want 2", rev, d.Revision())
+	}
+	if got := d.Text(); got != "aXc" {
+		t.Errorf("text = %q, want %q", got, "aXc")
+	}
+	if want := op(2, -1, 1); !slices.Equal(applied, want) {
+		t.Errorf("applied operation = %v, want %v", applied, want)
+	}
+}
+
+func TestDocumentSince(t *testing.T) {
+	d := NewDocument("", 2, 0)
+	for i := 0; i < 3; i++ {
+		if _, _, err := d.Apply(i, op(i, "x")); err != nil {
+			t.Fatalf("Apply: %v", err)
+		}
+	}
+
+	tests := []struct {
+		revision int
+		wantOps  int
+		wantErr  error
+	}{
+		{3, 0, nil},
+		{2, 1, nil},
+		{1, 2, nil},
+		{0, 0, ErrRevisionTooOld},
+		{-1, 0, ErrUnknownRevision},
+		{4, 0, ErrUnknownRevision},
+	}
+	for _, tt := range tests {
+		ops, err := d.Since(tt.revision)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("Since(%d): err = %v, want %v", tt.revision, err, tt.wantErr)
+			continue
+		}
+		if len(ops) != tt.wantOps {
+			t.Errorf("Since(%d) = %d operations, want %d", tt.revision, len(ops), tt.wantOps)
+		}
+	}
+}
+
+func TestDocumentApplyTrimmedHistory(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxHistory int
+		maxBytes   int
+		revision   int
+		op         Operation
+		wantText   string
+		wantErr    error
+	}{
+		{"within the history", 2, 0, 1, op("X", 1), "Xabc", nil},
+		{"history trimmed", 2, 0, 0, op("X"), "", ErrRevisionTooOld},
+		{"no history", 0, 0, 2, op(1, "X"), "", ErrRevisionTooOld},
+		{"no history at the current revision", 0, 0, 3, op(3, "X"), "abcX", nil},
+		{"unknown revision", 2, 0, 4, op(3, "X"), "", ErrUnknownRevision},
+		{"too large after transforming", 2, 4, 1, op("XY", 1), "", ErrTooLarge},
+		{"at the limit after transforming", 2, 4, 1, op("X", 1), "Xabc", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Three edits build "abc" one character at a time
+			d := NewDocument("", tt.maxHistory, tt.maxBytes)
+			for i, s := range []string{"a", "b", "c"} {
+				if _, _, err := d.Apply(i, op(i, s)); err != nil {
+					t.Fatalf("Apply %q: %v", s, err)
+				}
+			}
+
+			_, rev, err := d.Apply(tt.revision, tt.op)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Apply: err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if d.Text() != "abc" || d.Revision() != 3 {
+					t.Errorf("failed Apply changed the document to %q at revision %d", d.Text(), d.Revision())
+				}
+				return
+			}
+			if rev != 4 || d.Text() != tt.wantText {
+				t.Errorf("document = %q at revision %d, want %q at 4", d.Text(), rev, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestDocumentMaxBytes(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		op       Operation
+		wantText string
+		wantErr  error
+	}{
+		{"within the limit", "abc", op(3, "de"), "abcde", nil},
+		{"over the limit", "abc", op(3, "def"), "", ErrTooLarge},
+		{"counted in bytes", "ab", op(2, "éé"), "", ErrTooLarge},
+		{"replacing within the limit", "abcde", op(-2, "XY", 3), "XYcde", nil},
+		{"shrinking a document over the limit", "abcdefgh", op(-1, 7), "bcdefgh", nil},
+		{"growing a document over the limit", "abcdefgh", op(8, "X"), "", ErrTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDocument(tt.text, 10, 5)
+			_, _, err := d.Apply(0, tt.op)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Apply: err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if d.Text() != tt.text || d.Revision() != 0 {
+					t.Errorf("failed Apply changed the document to %q at revision %d", d.Text(), d.Revision())
+				}
+				return
+			}
+			if d.Text() != tt.wantText {
+				t.Errorf("text = %q, want %q", d.Text(), tt.wantText)
+			}
+		})
+	}
+}
+
+func TestDocumentApplyLengthMismatch(t *testing.T) {
+	d := NewDocument("abc", 10, 0)
+	if _, _, err := d.Apply(0, op(2, "X")); !errors.Is(err, ErrLengthMismatch) {
+		t.Errorf("Apply: err = %v, want ErrLengthMismatch", err)
+	}
+	if d.Revision() != 0 {
+		t.Errorf("revision = %d after a failed Apply, want 0", d.Revision())
+	}
+}
@@ -0,0 +1,347 @@
+// Package collab implements operational transformation on plain text, the basis of
+// real-time collaborative editing. Positions and lengths count Unicode code points.
+package collab
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+// ErrLengthMismatch is returned when an operation does not cover the text it is applied to
+var ErrLengthMismatch = errors.New("operation length does not match the document")
+
+// MaxLength bounds the text a decoded operation may retain and delete, far above the
+// length of any article, so lengths from clients cannot overflow
+const MaxLength = 1 << 24
+
+// Component is one step of an operation; exactly one field is set
+type Component struct {
+	Retain int
+	Delete int
+	Insert string
+}
+
+// Operation is a sequence of components that walks over a whole document. It encodes to
+// JSON as an array where positive numbers retain characters, negative numbers delete them
+// and strings insert text, e.g. [5, "hello", -3, 2].
+type Operation []Component
+
+// Retain appends skipping n characters
+func (o *Operation) Retain(n int) *Operation {
+	if n <= 0 {
+		return o
+	}
+	if last := len(*o) - 1; last >= 0 && (*o)[last].Retain > 0 {
+		(*o)[last].Retain += n
+		return o
+	}
+	*o = append(*o, Component{Retain: n})
+	return o
+}
+
+// Insert appends inserting s at the current position
+func (o *Operation) Insert(s string) *Operation {
+	if s == "" {
+		return o
+	}
+	ops := *o
+	last := len(ops) - 1
+	switch {
+	case last >= 0 && ops[last].Insert != "":
+		ops[last].Insert += s
+	case last >= 0 && ops[last].Delete > 0:
+		// Keep inserts before deletes so equal operations look the same
+		if last > 0 && ops[last-1].Insert != "" {
+			ops[last-1].Insert += s
+		} else {
+			ops = append(ops, Component{})
+			copy(ops[last+1:], ops[last:])
+			ops[last] = Component{Insert: s}
+		}
+	default:
+		ops = append(ops, Component{Insert: s})
+	}
+	*o = ops
+	return o
+}
+
+// Delete appends removing n characters
+func (o *Operation) Delete(n int) *Operation {
+	if n <= 0 {
+		return o
+	}
+	if last := len(*o) - 1; last >= 0 && (*o)[last].Delete > 0 {
+		(*o)[last].Delete += n
+		return o
+	}
+	*o = append(*o, Component{Delete: n})
+	return o
+}
+
+// BaseLen is the length of the text the operation applies to, or -1 when a component has
+// a negative length or the total overflows
+func (o Operation) BaseLen() int {
+	n := 0
+	for _, c := range o {
+		if n = addLen(n, c.Retain); n < 0 {
+			return -1
+		}
+		if n = addLen(n, c.Delete); n < 0 {
+			return -1
+		}
+	}
+	return n
+}
+
+// TargetLen is the length of the text the operation produces, or -1 when a component has
+// a negative length or the total overflows
+func (o Operation) TargetLen() int {
+	n := 0
+	for _, c := range o {
+		if n = addLen(n, c.Retain); n < 0 {
+			return -1
+		}
+		if n = addLen(n, utf8.RuneCountInString(c.Insert)); n < 0 {
+			return -1
+		}
+	}
+	return n
+}
+
+// addLen adds a component length to a non-negative total, returning -1 when the length is
+// negative or the sum overflows
+func addLen(total, n int) int {
+	if n < 0 || total > math.MaxInt-n {
+		return -1
+	}
+	return total + n
+}
+
+// IsNoop reports whether the operation leaves the text unchanged
+func (o Operation) IsNoop() bool {
+	for _, c := range o {
+		if c.Retain == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply returns the text with the operation applied
+func (o Operation) Apply(text []rune) ([]rune, error) {
+	if o.BaseLen() != len(text) {
+		return nil, ErrLengthMismatch
+	}
+	targetLen := o.TargetLen()
+	if targetLen < 0 {
+		return nil, ErrLengthMismatch
+	}
+	result := make([]rune, 0, targetLen)
+	pos := 0
+	for _, c := range o {
+		switch {
+		case c.Retain > 0:
+			if c.Retain > len(text)-pos {
+				return nil, ErrLengthMismatch
+			}
+			result = append(result, text[pos:pos+c.Retain]...)
+			pos += c.Retain
+		case c.Delete > 0:
+			if c.Delete > len(text)-pos {
+				return nil, ErrLengthMismatch
+			}
+			pos += c.Delete
+		default:
+			result = append(result, []rune(c.Insert)...)
+		}
+	}
+	return result, nil
+}
+
+// Transform takes two operations made concurrently on the same text and returns a' and b'
+// such that applying a then b' gives the same text as applying b then a'. When both insert
+// at the same position the text of a comes first.
+func Transform(a, b Operation) (Operation, Operation, error) {
+	if a.BaseLen() != b.BaseLen() || a.BaseLen() < 0 {
+		return nil, nil, ErrLengthMismatch
+	}
+
+	var aPrime, bPrime Operation
+	i, j := 0, 0
+	var ca, cb Component
+	next := func(ops Operation, k *int) Component {
+		if *k >= len(ops) {
+			return Component{}
+		}
+		c := ops[*k]
+		*k++
+		return c
+	}
+	ca, cb = next(a, &i), next(b, &j)
+
+	for {
+		aDone := ca == Component{}
+		bDone := cb == Component{}
+		if aDone && bDone {
+			return aPrime, bPrime, nil
+		}
+
+		// Inserts do not depend on the other side; a's go first
+		if ca.Insert != "" {
+			aPrime.Insert(ca.Insert)
+			bPrime.Retain(utf8.RuneCountInString(ca.Insert))
+			ca = next(a, &i)
+			continue
+		}
+		if cb.Insert != "" {
+			aPrime.Retain(utf8.RuneCountInString(cb.Insert))
+			bPrime.Insert(cb.Insert)
+			cb = next(b, &j)
+			continue
+		}
+		if aDone || bDone {
+			return nil, nil, ErrLengthMismatch
+		}
+
+		// Both components now consume text; handle the overlapping part
+		na, nb := ca.Retain+ca.Delete, cb.Retain+cb.Delete
+		n := min(na, nb)
+		switch {
+		case ca.Retain > 0 && cb.Retain > 0:
+			aPrime.Retain(n)
+			bPrime.Retain(n)
+		case ca.Delete > 0 && cb.Retain > 0:
+			aPrime.Delete(n)
+		case ca.Retain > 0 && cb.Delete > 0:
+			bPrime.Delete(n)
+		}
+		// When both delete the same text neither side has anything left to do
+
+		ca = shorten(ca, n)
+		cb = shorten(cb, n)
+		if ca == (Component{}) {
+			ca = next(a, &i)
+		}
+		if cb == (Component{}) {
+			cb = next(b, &j)
+		}
+	}
+}
+
+// shorten drops the first n characters of a retain or delete component
+func shorten(c Component, n int) Component {
+	if c.Retain > 0 {
+		c.Retain -= n
+	} else {
+		c.Delete -= n
+	}
+	return c
+}
+
+// TransformIndex moves a position in the text to where it is after the operation, so
+// cursors stay on the same character. Text inserted at the position pushes it forward.
+func TransformIndex(index int, op Operation) int {
+	newIndex := index
+	for _, c := range op {
+		switch {
+		case c.Retain > 0:
+			index -= c.Retain
+		case c.Delete > 0:
+			newIndex -= min(index, c.Delete)
+			index -= c.Delete
+		default:
+			newIndex += utf8.RuneCountInString(c.Insert)
+		}
+		if index < 0 {
+			break
+		}
+	}
+	return newIndex
+}
+
+// Diff returns an operation turning from into to. It only trims the common prefix and
+// suffix, which is enough to merge edits that reach the document as whole new texts.
+func Diff(from, to []rune) Operation {
+	prefix := 0
+	for prefix < len(from) && prefix < len(to) && from[prefix] == to[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(from)-prefix && suffix < len(to)-prefix &&
+		from[len(from)-1-suffix] == to[len(to)-1-suffix] {
+		suffix++
+	}
+
+	var op Operation
+	op.Retain(prefix)
+	op.Insert(string(to[prefix : len(to)-suffix]))
+	op.Delete(len(from) - prefix - suffix)
+	op.Retain(suffix)
+	return op
+}
+
+// MarshalJSON encodes the operation as an array of numbers and strings
+func (o Operation) MarshalJSON() ([]byte, error) {
+	items := make([]interface{}, len(o))
+	for i, c := range o {
+		switch {
+		case c.Retain > 0:
+			items[i] = c.Retain
+		case c.Delete > 0:
+			items[i] = -c.Delete
+		default:
+			items[i] = c.Insert
+		}
+	}
+	return json.Marshal(items)
+}
+
+// UnmarshalJSON decodes an array of numbers and strings, merging adjacent components. It
+// rejects operations retaining and deleting more than MaxLength characters in total.
+func (o *Operation) UnmarshalJSON(data []byte) error {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return fmt.Errorf("operation must be an array: %v", err)
+	}
+
+	var op Operation
+	baseLen := 0
+	for i, item := range items {
+		var n int
+		if err := json.Unmarshal(item, &n); err == nil {
+			if n == 0 {
+				return fmt.Errorf("component %d: zero length", i)
+			}
+			if n > MaxLength || n < -MaxLength || baseLen+abs(n) > MaxLength {
+				return fmt.Errorf("component %d: the operation spans more than %d characters", i, MaxLength)
+			}
+			baseLen += abs(n)
+			if n > 0 {
+				op.Retain(n)
+			} else {
+				op.Delete(-n)
+			}
+			continue
+		}
+		var s string
+		if err := json.Unmarshal(item, &s); err != nil {
+			return fmt.Errorf("component %d must be an integer or a string", i)
+		}
+		if s == "" {
+			return fmt.Errorf("component %d: empty insert", i)
+		}
+		op.Insert(s)
+	}
+	*o = op
+	return nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,230 @@
+package collab
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// op builds an operation from JSON-style components: positive numbers retain, negative
+// numbers delete and strings insert
+func op(items ...interface{}) Operation {
+	var o Operation
+	for _, item := range items {
+		switch v := item.(type) {
+		case int:
+			if v > 0 {
+				o.Retain(v)
+			} else {
+				o.Delete(-v)
+			}
+		case string:
+			o.Insert(v)
+		default:
+			panic(fmt.Sprintf("unexpected component %v", item))
+		}
+	}
+	return o
+}
+
+func apply(t *testing.T, text string, o Operation) string {
+	t.Helper()
+	result, err := o.Apply([]rune(text))
+	if err != nil {
+		t.Fatalf("Apply(%q, %v): %v", text, o, err)
+	}
+	return string(result)
+}
+
+func TestTransformConverges(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		a, b Operation
+		want string
+	}{
+		{"insert and insert at the same position", "abc", op(1, "X", 2), op(1, "Y", 2), "aXYbc"},
+		{"insert and insert at the start", "abc", op("X", 3), op("Y", 3), "XYabc"},
+		{"insert and insert at the end", "abc", op(3, "X"), op(3, "YZ"), "abcXYZ"},
+		{"insert and insert apart", "abc", op("X", 3), op(3, "Y"), "XabcY"},
+		{"insert before a delete", "abcdef", op(1, "X", 5), op(2, -2, 2), "aXbef"},
+		{"insert inside a delete", "abcdef", op(3, "X", 3), op(2, -2, 2), "abXef"},
+		{"insert at the start of a delete", "abcdef", op(2, "X", 4), op(2, -2, 2), "abXef"},
+		{"delete and insert", "abcdef", op(2, -2, 2), op(4, "X", 2), "abXef"},
+		{"insert into text deleted entirely", "abc", op("X", 3), op(-3), "X"},
+		{"overlapping deletes", "abcdef", op(1, -3, 2), op(2, -3, 1), "af"},
+		{"delete inside a delete", "abcdef", op(1, -4, 1), op(2, -2, 2), "af"},
+		{"identical deletes", "abcd", op(1, -2, 1), op(1, -2, 1), "ad"},
+		{"delete and no-op", "abcd", op(-1, 3), op(4), "bcd"},
+		{"code points", "héllo wörld", op(6, "🙂 ", 5), op(1, -1, "e", 9), "hello 🙂 wörld"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aPrime, bPrime, err := Transform(tt.a, tt.b)
+			if err != nil {
+				t.Fatalf("Transform: %v", err)
+			}
+			ab := apply(t, apply(t, tt.text, tt.a), bPrime)
+			ba := apply(t, apply(t, tt.text, tt.b), aPrime)
+			if ab != ba {
+				t.Fatalf("a then b' = %q, b then a' = %q", ab, ba)
+			}
+			if ab != tt.want {
+				t.Errorf("converged on %q, want %q", ab, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformLengthMismatch(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Operation
+	}{
+		{"different base lengths", op(3, "X"), op(4)},
+		{"negative length", Operation{{Retain: -1}}, Operation{{Retain: -1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := Transform(tt.a, tt.b); !errors.Is(err, ErrLengthMismatch) {
+				t.Errorf("Transform: err = %v, want ErrLengthMismatch", err)
+			}
+		})
+	}
+}
+
+func TestApplyLengthMismatch(t *testing.T) {
+	tests := []struct {
+		name string
+		op   Operation
+	}{
+		{"too short", op(2)},
+		{"too long", op(2, -2)},
+		{"negative length", Operation{{Retain: 4}, {Retain: -1}}},
+		{"overflowing length", Operation{{Retain: math.MaxInt}, {Delete: math.MaxInt}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.op.Apply([]rune("abc")); !errors.Is(err, ErrLengthMismatch) {
+				t.Errorf("Apply: err = %v, want ErrLengthMismatch", err)
+			}
+		})
+	}
+}
+
+func TestTransformIndex(t *testing.T) {
+	// All operations apply to "abcdef"
+	tests := []struct {
+		name  string
+		index int
+		op    Operation
+		want  int
+	}{
+		{"no-op", 3, op(6), 3},
+		{"insert before", 3, op(1, "XY", 5), 5},
+		{"insert at the cursor", 3, op(3, "XY", 3), 5},
+		{"insert after", 3, op(4, "XY", 2), 3},
+		{"delete before", 3, op(-2, 4), 1},
+		{"delete after", 3, op(3, -2, 1), 3},
+		{"delete around", 3, op(1, -4, 1), 1},
+		{"delete up to the cursor", 3, op(1, -2, 3), 1},
+		{"delete from the cursor", 3, op(3, -3), 3},
+		{"replace before", 4, op(1, "XYZ", -2, 3), 5},
+		{"start", 0, op("XY", -1, 5), 2},
+		{"end", 6, op(-1, 4, "X", 1), 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TransformIndex(tt.index, tt.op); got != tt.want {
+				t.Errorf("TransformIndex(%d, %v) = %d, want %d", tt.index, tt.op, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		from, to string
+	}{
+		{"", ""},
+		{"", "hello"},
+		{"hello", ""},
+		{"hello", "hello"},
+		{"hello world", "hello brave world"},
+		{"hello brave world", "hello world"},
+		{"hello world", "jello world"},
+		{"hello world", "hello worlds"},
+		{"aaaa", "aa"},
+		{"abcabc", "abc"},
+		{"héllo", "hällo 🙂"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from+"→"+tt.to, func(t *testing.T) {
+			d := Diff([]rune(tt.from), []rune(tt.to))
+			if got := apply(t, tt.from, d); got != tt.to {
+				t.Errorf("Diff applied = %q, want %q", got, tt.to)
+			}
+			if d.IsNoop() != (tt.from == tt.to) {
+				t.Errorf("Diff(%q, %q) = %v, no-op %v", tt.from, tt.to, d, d.IsNoop())
+			}
+		})
+	}
+}
+
+func TestOperationJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{`[5, "hello", -3, 2]`, `[5,"hello",-3,2]`},
+		{`[1, 2, "a", "b", -1, -1]`, `[3,"ab",-2]`},
+		{`[-2, "x"]`, `["x",-2]`},
+		{`[]`, `[]`},
+		{fmt.Sprintf(`[%d]`, MaxLength), fmt.Sprintf(`[%d]`, MaxLength)},
+		{fmt.Sprintf(`[%d, -%d]`, MaxLength/2, MaxLength/2), fmt.Sprintf(`[%d,-%d]`, MaxLength/2, MaxLength/2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			var o Operation
+			if err := json.Unmarshal([]byte(tt.in), &o); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			data, err := json.Marshal(o)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("round trip = %s, want %s", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestOperationJSONRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"not an array", `{"retain": 1}`},
+		{"zero length", `[1, 0]`},
+		{"empty insert", `[1, ""]`},
+		{"fraction", `[1.5]`},
+		{"other types", `[true]`},
+		{"retain over the limit", fmt.Sprintf(`[%d]`, MaxLength+1)},
+		{"delete over the limit", fmt.Sprintf(`[-%d]`, MaxLength+1)},
+		{"total over the limit", fmt.Sprintf(`[%d, -%d, 1]`, MaxLength/2, MaxLength/2)},
+		{"largest int", fmt.Sprintf(`[%d]`, math.MaxInt)},
+		{"smallest int", fmt.Sprintf(`[%d]`, math.MinInt)},
+		{"beyond int", `[99999999999999999999]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var o Operation
+			if err := json.Unmarshal([]byte(tt.in), &o); err == nil {
+				t.Errorf("Unmarshal(%s) = %v, want an error", tt.in, o)
+			}
+		})
+	}
+}
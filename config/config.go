@@ -0,0 +1,698 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the complete application configuration
+type Config struct {
+	Server    ServerConfig    `yaml:"server" toml:"server"`
+	Log       LogConfig       `yaml:"log" toml:"log"`
+	Database  DatabaseConfig  `yaml:"database" toml:"database"`
+	Auth      AuthConfig      `yaml:"auth" toml:"auth"`
+	CORS      CORSConfig      `yaml:"cors" toml:"cors"`
+	Drive     DriveConfig     `yaml:"drive" toml:"drive"`
+	Storage   StorageConfig   `yaml:"storage" toml:"storage"`
+	Uploads   UploadsConfig   `yaml:"uploads" toml:"uploads"`
+	Quota     QuotaConfig     `yaml:"quota" toml:"quota"`
+	Images    ImagesConfig    `yaml:"images" toml:"images"`
+	FileTypes FileTypesConfig `yaml:"file_types" toml:"file_types"`
+	Scanner   ScannerConfig   `yaml:"scanner" toml:"scanner"`
+	Import    ImportConfig    `yaml:"import" toml:"import"`
+	Events    EventsConfig    `yaml:"events" toml:"events"`
+	Collab    CollabConfig    `yaml:"collab" toml:"collab"`
+	Metrics   MetricsConfig   `yaml:"metrics" toml:"metrics"`
+}
+
+// ServerConfig holds the HTTP server settings
+type ServerConfig struct {
+	Addr            string        `yaml:"addr" toml:"addr"`
+	ReadTimeout     time.Duration `yaml:"read_timeout" toml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout" toml:"write_timeout"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout" toml:"idle_timeout"`
+	MaxHeaderBytes  int           `yaml:"max_header_bytes" toml:"max_header_bytes"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+}
+
+// LogConfig holds the logging settings
+type LogConfig struct {
+	Level  string `yaml:"level" toml:"level"`
+	Format string `yaml:"format" toml:"format"`
+}
+
+// DatabaseConfig holds the MySQL connection settings
+type DatabaseConfig struct {
+	Host     string `yaml:"host" toml:"host"`
+	Port     string `yaml:"port" toml:"port"`
+	Name     string `yaml:"name" toml:"name"`
+	User     string `yaml:"user" toml:"user"`
+	Password Secret `yaml:"password" toml:"password"`
+}
+
+// AuthConfig holds the Google OAuth and JWT settings
+type AuthConfig struct {
+	JWTSecret          Secret `yaml:"jwt_secret" toml:"jwt_secret"`
+	GoogleClientID     string `yaml:"google_client_id" toml:"google_client_id"`
+	GoogleClientSecret Secret `yaml:"google_client_secret" toml:"google_client_secret"`
+	GoogleRedirectURL  string `yaml:"google_redirect_url" toml:"google_redirect_url"`
+	FrontendURL        string `yaml:"frontend_url" toml:"frontend_url"`
+}
+
+// CORSConfig holds the allowed CORS origins; "*" allows all
+type CORSConfig struct {
+	AllowedOrigins []string `yaml:"allowed_origins" toml:"allowed_origins"`
+}
+
+// DriveConfig holds the Google Drive upload credentials.
+// Exactly one of a refresh token, service account JSON or service account file is used.
+type DriveConfig struct {
+	RefreshToken       Secret `yaml:"refresh_token" toml:"refresh_token"`
+	ServiceAccountJSON Secret `yaml:"service_account_json" toml:"service_account_json"`
+	ServiceAccountFile string `yaml:"service_account_file" toml:"service_account_file"`
+	FolderID           string `yaml:"folder_id" toml:"folder_id"`
+	ReadinessCheck     bool   `yaml:"readiness_check" toml:"readiness_check"`
+	// ConnectRedirectURL is the OAuth callback used when users link their own Drive
+	ConnectRedirectURL string `yaml:"connect_redirect_url" toml:"connect_redirect_url"`
+	// AppFolderName is the folder created in each connected user's Drive
+	AppFolderName string `yaml:"app_folder_name" toml:"app_folder_name"`
+	// TokenEncryptionKey encrypts stored user refresh tokens; defaults to the JWT secret
+	TokenEncryptionKey Secret `yaml:"token_encryption_key" toml:"token_encryption_key"`
+}
+
+// StorageConfig selects and configures the file storage backend
+type StorageConfig struct {
+	// Backend is one of drive, local or s3
+	Backend string             `yaml:"backend" toml:"backend"`
+	Local   LocalStorageConfig `yaml:"local" toml:"local"`
+	S3      S3Config           `yaml:"s3" toml:"s3"`
+}
+
+// LocalStorageConfig holds the local filesystem backend settings
+type LocalStorageConfig struct {
+	Dir string `yaml:"dir" toml:"dir"`
+	// BaseURL is the public API URL used to build signed download links
+	BaseURL string `yaml:"base_url" toml:"base_url"`
+	// SigningKey signs download links; defaults to the JWT secret
+	SigningKey Secret `yaml:"signing_key" toml:"signing_key"`
+}
+
+// S3Config holds the S3-compatible backend settings
+type S3Config struct {
+	Endpoint        string `yaml:"endpoint" toml:"endpoint"`
+	Region          string `yaml:"region" toml:"region"`
+	Bucket          string `yaml:"bucket" toml:"bucket"`
+	Prefix          string `yaml:"prefix" toml:"prefix"`
+	AccessKeyID     string `yaml:"access_key_id" toml:"access_key_id"`
+	SecretAccessKey Secret `yaml:"secret_access_key" toml:"secret_access_key"`
+	UseSSL          bool   `yaml:"use_ssl" toml:"use_ssl"`
+	PathStyle       bool   `yaml:"path_style" toml:"path_style"`
+}
+
+// UploadsConfig holds the resumable (tus) upload settings
+type UploadsConfig struct {
+	// Dir keeps partial uploads until they are complete and pushed to storage
+	Dir     string `yaml:"dir" toml:"dir"`
+	MaxSize int64  `yaml:"max_size" toml:"max_size"`
+	// Expiry is how long an unfinished upload is kept after its last chunk
+	Expiry time.Duration `yaml:"expiry" toml:"expiry"`
+	// ChunkTimeout replaces the server read timeout for a single PATCH request
+	ChunkTimeout time.Duration `yaml:"chunk_timeout" toml:"chunk_timeout"`
+}
+
+// QuotaConfig holds the per-user storage limits; zero means unlimited
+type QuotaConfig struct {
+	MaxBytes int64 `yaml:"max_bytes" toml:"max_bytes"`
+	MaxFiles int   `yaml:"max_files" toml:"max_files"`
+}
+
+// ImagesConfig holds the image processing settings
+type ImagesConfig struct {
+	// ThumbnailSizes are the longest-side pixel sizes generated for every uploaded image
+	ThumbnailSizes []int `yaml:"thumbnail_sizes" toml:"thumbnail_sizes"`
+	// StripMetadata removes EXIF/XMP metadata such as GPS position from stored originals
+	StripMetadata bool `yaml:"strip_metadata" toml:"strip_metadata"`
+	// MaxBytes is the largest image that is stripped and thumbnailed; larger ones are stored as is
+	MaxBytes int64 `yaml:"max_bytes" toml:"max_bytes"`
+}
+
+// FileTypesConfig controls which kinds of files may be uploaded. Types are media
+// types or wildcards such as image/*, matched against the type detected from the
+// content as well as the declared type and the file name extension.
+type FileTypesConfig struct {
+	// Allowed, when not empty, is the only types accepted
+	Allowed []string `yaml:"allowed" toml:"allowed"`
+	// Denied types are always refused
+	Denied []string `yaml:"denied" toml:"denied"`
+	// MismatchPolicy handles files whose content contradicts the declared type:
+	// reject them, store them under the detected type, or keep the declared type
+	MismatchPolicy string `yaml:"mismatch_policy" toml:"mismatch_policy"`
+}
+
+// File type mismatch policies
+const (
+	MismatchReject   = "reject"
+	MismatchDetected = "detected"
+	MismatchDeclared = "declared"
+)
+
+// ScannerConfig holds the malware scanning settings
+type ScannerConfig struct {
+	// Backend is none (every file counts as clean) or clamd
+	Backend string `yaml:"backend" toml:"backend"`
+	// Address of clamd, tcp://host:port or unix:///path/to/clamd.sock
+	Address string `yaml:"address" toml:"address"`
+	// Timeout bounds the scan of a single file
+	Timeout time.Duration `yaml:"timeout" toml:"timeout"`
+}
+
+// ImportConfig holds the limits of note imports
+type ImportConfig struct {
+	// MaxBytes bounds the size of an uploaded import file
+	MaxBytes int64 `yaml:"max_bytes" toml:"max_bytes"`
+	// MaxNotes bounds the number of notes in a single import
+	MaxNotes int `yaml:"max_notes" toml:"max_notes"`
+	// MaxUncompressedBytes bounds the size of an import once its archive is decompressed
+	MaxUncompressedBytes int64 `yaml:"max_uncompressed_bytes" toml:"max_uncompressed_bytes"`
+}
+
+// EventsConfig holds the settings of the real-time event stream
+type EventsConfig struct {
+	// ReplayBuffer is how many recent events are kept for clients resuming with Last-Event-ID
+	ReplayBuffer int `yaml:"replay_buffer" toml:"replay_buffer"`
+	// Heartbeat is how often an idle stream sends a comment to keep the connection open
+	Heartbeat time.Duration `yaml:"heartbeat" toml:"heartbeat"`
+}
+
+// CollabConfig holds the settings of collaborative editing sessions
+type CollabConfig struct {
+	// SnapshotInterval is how often the text of a session with unsaved edits is written
+	// back to the article
+	SnapshotInterval time.Duration `yaml:"snapshot_interval" toml:"snapshot_interval"`
+	// History is how many recent edits a session keeps to merge edits from lagging clients
+	History int `yaml:"history" toml:"history"`
+}
+
+// MetricsConfig holds the /metrics endpoint settings
+type MetricsConfig struct {
+	Token Secret `yaml:"token" toml:"token"`
+}
+
+// Default returns the configuration used when nothing is overridden
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:            ":8080",
+			ReadTimeout:     30 * time.Second,
+			WriteTimeout:    60 * time.Second,
+			IdleTimeout:     120 * time.Second,
+			MaxHeaderBytes:  1 << 20,
+			ShutdownTimeout: 30 * time.Second,
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "json",
+		},
+		Database: DatabaseConfig{
+			Host:     "localhost",
+			Port:     "3306",
+			Name:     "simple_go_api",
+			User:     "api_user",
+			Password: "api_password",
+		},
+		Auth: AuthConfig{
+			GoogleRedirectURL: "http://localhost:8080/auth/google/callback",
+			FrontendURL:       "http://localhost:3000",
+		},
+		CORS: CORSConfig{
+			AllowedOrigins: []string{"*"},
+		},
+		Drive: DriveConfig{
+			ConnectRedirectURL: "http://localhost:8080/drive/callback",
+			AppFolderName:      "PersonalNote",
+		},
+		Storage: StorageConfig{
+			Backend: "drive",
+			Local: LocalStorageConfig{
+				Dir:     "./data/blobs",
+				BaseURL: "http://localhost:8080",
+			},
+			S3: S3Config{
+				Region: "us-east-1",
+				UseSSL: true,
+			},
+		},
+		Uploads: UploadsConfig{
+			Dir:          "./data/uploads",
+			MaxSize:      2 << 30,
+			Expiry:       24 * time.Hour,
+			ChunkTimeout: 10 * time.Minute,
+		},
+		Quota: QuotaConfig{
+			MaxBytes: 1 << 30,
+			MaxFiles: 5000,
+		},
+		Images: ImagesConfig{
+			ThumbnailSizes: []int{128, 256, 512},
+			StripMetadata:  true,
+			MaxBytes:       50 << 20,
+		},
+		FileTypes: FileTypesConfig{
+			Denied: []string{
+				"text/html",
+				"application/xhtml+xml",
+				"application/vnd.microsoft.portable-executable",
+				"application/x-msdownload",
+				"application/x-msi",
+				"application/x-elf",
+				"application/x-mach-binary",
+				"application/x-sh",
+				"application/x-bat",
+				"application/x-powershell",
+				"application/x-vbscript",
+				"application/java-archive",
+				"application/vnd.android.package-archive",
+			},
+			MismatchPolicy: MismatchReject,
+		},
+		Scanner: ScannerConfig{
+			Backend: "none",
+			Address: "tcp://127.0.0.1:3310",
+			Timeout: 2 * time.Minute,
+		},
+		Import: ImportConfig{
+			MaxBytes:             200 << 20,
+			MaxNotes:             20000,
+			MaxUncompressedBytes: 1 << 30,
+		},
+		Events: EventsConfig{
+			ReplayBuffer: 1000,
+			Heartbeat:    25 * time.Second,
+		},
+		Collab: CollabConfig{
+			SnapshotInterval: 10 * time.Second,
+			History:          1000,
+		},
+	}
+}
+
+// Load builds the configuration from defaults, the optional config file at path
+// (YAML or TOML, chosen by extension) and environment variables, in that order
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	case ".toml":
+		err = toml.Unmarshal(data, c)
+	default:
+		return fmt.Errorf("unsupported config file extension %q: expected .yaml, .yml or .toml", filepath.Ext(path))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// loadEnv overrides settings with the environment variables that are set
+func (c *Config) loadEnv() error {
+	var errs []error
+	str := func(key string, dst *string) {
+		if value, ok := os.LookupEnv(key); ok && value != "" {
+			*dst = value
+		}
+	}
+	secret := func(key string, dst *Secret) {
+		if value, ok := os.LookupEnv(key); ok && value != "" {
+			*dst = Secret(value)
+		}
+	}
+	duration := func(key string, dst *time.Duration) {
+		if value, ok := os.LookupEnv(key); ok && value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid duration %q", key, value))
+				return
+			}
+			*dst = d
+		}
+	}
+	integer := func(key string, dst *int) {
+		if value, ok := os.LookupEnv(key); ok && value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid integer %q", key, value))
+				return
+			}
+			*dst = n
+		}
+	}
+	integer64 := func(key string, dst *int64) {
+		if value, ok := os.LookupEnv(key); ok && value != "" {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid integer %q", key, value))
+				return
+			}
+			*dst = n
+		}
+	}
+	boolean := func(key string, dst *bool) {
+		if value, ok := os.LookupEnv(key); ok && value != "" {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid boolean %q", key, value))
+				return
+			}
+			*dst = b
+		}
+	}
+	list := func(key string, dst *[]string) {
+		if value, ok := os.LookupEnv(key); ok && strings.TrimSpace(value) != "" {
+			*dst = splitList(value)
+		}
+	}
+	integers := func(key string, dst *[]int) {
+		if value, ok := os.LookupEnv(key); ok && strings.TrimSpace(value) != "" {
+			var out []int
+			for _, item := range splitList(value) {
+				n, err := strconv.Atoi(item)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s: invalid integer %q", key, item))
+					return
+				}
+				out = append(out, n)
+			}
+			*dst = out
+		}
+	}
+
+	str("SERVER_ADDR", &c.Server.Addr)
+	duration("SERVER_READ_TIMEOUT", &c.Server.ReadTimeout)
+	duration("SERVER_WRITE_TIMEOUT", &c.Server.WriteTimeout)
+	duration("SERVER_IDLE_TIMEOUT", &c.Server.IdleTimeout)
+	integer("SERVER_MAX_HEADER_BYTES", &c.Server.MaxHeaderBytes)
+	duration("SERVER_SHUTDOWN_TIMEOUT", &c.Server.ShutdownTimeout)
+
+	str("LOG_LEVEL", &c.Log.Level)
+	str("LOG_FORMAT", &c.Log.Format)
+
+	str("DB_HOST", &c.Database.Host)
+	str("DB_PORT", &c.Database.Port)
+	str("DB_NAME", &c.Database.Name)
+	str("DB_USER", &c.Database.User)
+	secret("DB_PASSWORD", &c.Database.Password)
+
+	secret("JWT_SECRET", &c.Auth.JWTSecret)
+	str("GOOGLE_CLIENT_ID", &c.Auth.GoogleClientID)
+	secret("GOOGLE_CLIENT_SECRET", &c.Auth.GoogleClientSecret)
+	str("GOOGLE_REDIRECT_URL", &c.Auth.GoogleRedirectURL)
+	str("FRONTEND_URL", &c.Auth.FrontendURL)
+
+	list("CORS_ALLOWED_ORIGINS", &c.CORS.AllowedOrigins)
+
+	secret("GOOGLE_REFRESH_TOKEN", &c.Drive.RefreshToken)
+	secret("GOOGLE_SERVICE_ACCOUNT_JSON", &c.Drive.ServiceAccountJSON)
+	str("GOOGLE_SERVICE_ACCOUNT_FILE", &c.Drive.ServiceAccountFile)
+	str("GOOGLE_DRIVE_FOLDER_ID", &c.Drive.FolderID)
+	boolean("READYZ_CHECK_DRIVE", &c.Drive.ReadinessCheck)
+	str("GOOGLE_DRIVE_REDIRECT_URL", &c.Drive.ConnectRedirectURL)
+	str("GOOGLE_DRIVE_APP_FOLDER", &c.Drive.AppFolderName)
+	secret("DRIVE_TOKEN_ENCRYPTION_KEY", &c.Drive.TokenEncryptionKey)
+
+	str("STORAGE_BACKEND", &c.Storage.Backend)
+	str("STORAGE_LOCAL_DIR", &c.Storage.Local.Dir)
+	str("STORAGE_LOCAL_BASE_URL", &c.Storage.Local.BaseURL)
+	secret("STORAGE_SIGNING_KEY", &c.Storage.Local.SigningKey)
+	str("S3_ENDPOINT", &c.Storage.S3.Endpoint)
+	str("S3_REGION", &c.Storage.S3.Region)
+	str("S3_BUCKET", &c.Storage.S3.Bucket)
+	str("S3_PREFIX", &c.Storage.S3.Prefix)
+	str("S3_ACCESS_KEY_ID", &c.Storage.S3.AccessKeyID)
+	secret("S3_SECRET_ACCESS_KEY", &c.Storage.S3.SecretAccessKey)
+	boolean("S3_USE_SSL", &c.Storage.S3.UseSSL)
+	boolean("S3_PATH_STYLE", &c.Storage.S3.PathStyle)
+
+	str("UPLOADS_DIR", &c.Uploads.Dir)
+	integer64("UPLOADS_MAX_SIZE", &c.Uploads.MaxSize)
+	duration("UPLOADS_EXPIRY", &c.Uploads.Expiry)
+	duration("UPLOADS_CHUNK_TIMEOUT", &c.Uploads.ChunkTimeout)
+
+	integer64("QUOTA_MAX_BYTES", &c.Quota.MaxBytes)
+	integer("QUOTA_MAX_FILES", &c.Quota.MaxFiles)
+
+	integers("IMAGE_THUMBNAIL_SIZES", &c.Images.ThumbnailSizes)
+	boolean("IMAGE_STRIP_METADATA", &c.Images.StripMetadata)
+	integer64("IMAGE_MAX_BYTES", &c.Images.MaxBytes)
+
+	list("FILE_TYPES_ALLOWED", &c.FileTypes.Allowed)
+	list("FILE_TYPES_DENIED", &c.FileTypes.Denied)
+	str("FILE_TYPES_MISMATCH_POLICY", &c.FileTypes.MismatchPolicy)
+
+	str("SCANNER_BACKEND", &c.Scanner.Backend)
+	str("CLAMD_ADDRESS", &c.Scanner.Address)
+	duration("SCANNER_TIMEOUT", &c.Scanner.Timeout)
+
+	integer64("IMPORT_MAX_BYTES", &c.Import.MaxBytes)
+	integer("IMPORT_MAX_NOTES", &c.Import.MaxNotes)
+	integer64("IMPORT_MAX_UNCOMPRESSED_BYTES", &c.Import.MaxUncompressedBytes)
+
+	integer("EVENTS_REPLAY_BUFFER", &c.Events.ReplayBuffer)
+	duration("EVENTS_HEARTBEAT", &c.Events.Heartbeat)
+
+	duration("COLLAB_SNAPSHOT_INTERVAL", &c.Collab.SnapshotInterval)
+	integer("COLLAB_HISTORY", &c.Collab.History)
+
+	secret("METRICS_TOKEN", &c.Metrics.Token)
+
+	return errors.Join(errs...)
+}
+
+// Validate checks that required settings are present and values are usable
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Server.Addr == "" {
+		fail("server.addr is required (SERVER_ADDR)")
+	}
+	positive := func(name string, d time.Duration) {
+		if d <= 0 {
+			fail("%s must be positive, got %s", name, d)
+		}
+	}
+	positive("server.read_timeout (SERVER_READ_TIMEOUT)", c.Server.ReadTimeout)
+	positive("server.write_timeout (SERVER_WRITE_TIMEOUT)", c.Server.WriteTimeout)
+	positive("server.idle_timeout (SERVER_IDLE_TIMEOUT)", c.Server.IdleTimeout)
+	positive("server.shutdown_timeout (SERVER_SHUTDOWN_TIMEOUT)", c.Server.ShutdownTimeout)
+	if c.Server.MaxHeaderBytes <= 0 {
+		fail("server.max_header_bytes must be positive (SERVER_MAX_HEADER_BYTES)")
+	}
+
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		fail("log.level must be one of debug, info, warn, error (LOG_LEVEL), got %q", c.Log.Level)
+	}
+	switch strings.ToLower(c.Log.Format) {
+	case "json", "text":
+	default:
+		fail("log.format must be json or text (LOG_FORMAT), got %q", c.Log.Format)
+	}
+
+	if c.Database.Host == "" {
+		fail("database.host is required (DB_HOST)")
+	}
+	if _, err := strconv.Atoi(c.Database.Port); err != nil {
+		fail("database.port must be a number (DB_PORT), got %q", c.Database.Port)
+	}
+	if c.Database.Name == "" {
+		fail("database.name is required (DB_NAME)")
+	}
+	if c.Database.User == "" {
+		fail("database.user is required (DB_USER)")
+	}
+
+	if c.Auth.JWTSecret == "" {
+		fail("auth.jwt_secret is required (JWT_SECRET)")
+	} else if len(c.Auth.JWTSecret) < 32 {
+		fail("auth.jwt_secret must be at least 32 characters (JWT_SECRET)")
+	}
+	if (c.Auth.GoogleClientID == "") != (c.Auth.GoogleClientSecret == "") {
+		fail("auth.google_client_id and auth.google_client_secret must be set together (GOOGLE_CLIENT_ID, GOOGLE_CLIENT_SECRET)")
+	}
+	if !isAbsoluteURL(c.Auth.GoogleRedirectURL) {
+		fail("auth.google_redirect_url must be an absolute URL (GOOGLE_REDIRECT_URL), got %q", c.Auth.GoogleRedirectURL)
+	}
+	if !isAbsoluteURL(c.Auth.FrontendURL) {
+		fail("auth.frontend_url must be an absolute URL (FRONTEND_URL), got %q", c.Auth.FrontendURL)
+	}
+
+	if len(c.CORS.AllowedOrigins) == 0 {
+		fail("cors.allowed_origins must not be empty; use \"*\" to allow all (CORS_ALLOWED_ORIGINS)")
+	}
+
+	if c.Drive.RefreshToken != "" && c.Auth.GoogleClientID == "" {
+		fail("drive.refresh_token requires auth.google_client_id and auth.google_client_secret")
+	}
+	if c.Drive.ReadinessCheck && !c.Drive.Configured() {
+		fail("drive.readiness_check is enabled but no Drive credentials are configured")
+	}
+	if !isAbsoluteURL(c.Drive.ConnectRedirectURL) {
+		fail("drive.connect_redirect_url must be an absolute URL (GOOGLE_DRIVE_REDIRECT_URL), got %q", c.Drive.ConnectRedirectURL)
+	}
+	if c.Drive.AppFolderName == "" {
+		fail("drive.app_folder_name is required (GOOGLE_DRIVE_APP_FOLDER)")
+	}
+	if c.Drive.TokenEncryptionKey != "" && len(c.Drive.TokenEncryptionKey) < 32 {
+		fail("drive.token_encryption_key must be at least 32 characters (DRIVE_TOKEN_ENCRYPTION_KEY)")
+	}
+
+	switch c.Storage.Backend {
+	case "drive":
+	case "local":
+		if c.Storage.Local.Dir == "" {
+			fail("storage.local.dir is required for the local backend (STORAGE_LOCAL_DIR)")
+		}
+		if !isAbsoluteURL(c.Storage.Local.BaseURL) {
+			fail("storage.local.base_url must be an absolute URL (STORAGE_LOCAL_BASE_URL), got %q", c.Storage.Local.BaseURL)
+		}
+	case "s3":
+		if c.Storage.S3.Endpoint == "" {
+			fail("storage.s3.endpoint is required for the s3 backend (S3_ENDPOINT)")
+		}
+		if c.Storage.S3.Bucket == "" {
+			fail("storage.s3.bucket is required for the s3 backend (S3_BUCKET)")
+		}
+		if c.Storage.S3.AccessKeyID == "" || c.Storage.S3.SecretAccessKey == "" {
+			fail("storage.s3.access_key_id and storage.s3.secret_access_key are required for the s3 backend (S3_ACCESS_KEY_ID, S3_SECRET_ACCESS_KEY)")
+		}
+	default:
+		fail("storage.backend must be one of drive, local, s3 (STORAGE_BACKEND), got %q", c.Storage.Backend)
+	}
+
+	if c.Uploads.Dir == "" {
+		fail("uploads.dir is required (UPLOADS_DIR)")
+	}
+	if c.Uploads.MaxSize <= 0 {
+		fail("uploads.max_size must be positive (UPLOADS_MAX_SIZE)")
+	}
+	positive("uploads.expiry (UPLOADS_EXPIRY)", c.Uploads.Expiry)
+	positive("uploads.chunk_timeout (UPLOADS_CHUNK_TIMEOUT)", c.Uploads.ChunkTimeout)
+
+	if c.Quota.MaxBytes < 0 {
+		fail("quota.max_bytes must not be negative (QUOTA_MAX_BYTES)")
+	}
+	if c.Quota.MaxFiles < 0 {
+		fail("quota.max_files must not be negative (QUOTA_MAX_FILES)")
+	}
+
+	for _, size := range c.Images.ThumbnailSizes {
+		if size < 16 || size > 4096 {
+			fail("images.thumbnail_sizes must be between 16 and 4096 pixels (IMAGE_THUMBNAIL_SIZES), got %d", size)
+		}
+	}
+	if c.Images.MaxBytes <= 0 {
+		fail("images.max_bytes must be positive (IMAGE_MAX_BYTES)")
+	}
+
+	switch c.FileTypes.MismatchPolicy {
+	case MismatchReject, MismatchDetected, MismatchDeclared:
+	default:
+		fail("file_types.mismatch_policy must be one of reject, detected, declared (FILE_TYPES_MISMATCH_POLICY), got %q", c.FileTypes.MismatchPolicy)
+	}
+
+	switch c.Scanner.Backend {
+	case "none":
+	case "clamd":
+		if c.Scanner.Address == "" {
+			fail("scanner.address is required for the clamd scanner (CLAMD_ADDRESS)")
+		}
+	default:
+		fail("scanner.backend must be one of none, clamd (SCANNER_BACKEND), got %q", c.Scanner.Backend)
+	}
+	positive("scanner.timeout (SCANNER_TIMEOUT)", c.Scanner.Timeout)
+
+	if c.Import.MaxBytes <= 0 {
+		fail("import.max_bytes must be positive (IMPORT_MAX_BYTES)")
+	}
+	if c.Import.MaxNotes <= 0 {
+		fail("import.max_notes must be positive (IMPORT_MAX_NOTES)")
+	}
+	if c.Import.MaxUncompressedBytes <= 0 {
+		fail("import.max_uncompressed_bytes must be positive (IMPORT_MAX_UNCOMPRESSED_BYTES)")
+	}
+	if c.Events.ReplayBuffer <= 0 {
+		fail("events.replay_buffer must be positive (EVENTS_REPLAY_BUFFER)")
+	}
+	positive("events.heartbeat (EVENTS_HEARTBEAT)", c.Events.Heartbeat)
+	positive("collab.snapshot_interval (COLLAB_SNAPSHOT_INTERVAL)", c.Collab.SnapshotInterval)
+	if c.Collab.History <= 0 {
+		fail("collab.history must be positive (COLLAB_HISTORY)")
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+}
+
+// Configured reports whether any Drive credential option is set
+func (d DriveConfig) Configured() bool {
+	return d.RefreshToken != "" || d.ServiceAccountJSON != "" || d.ServiceAccountFile != ""
+}
+
+// YAML renders the configuration as YAML with secrets redacted
+func (c *Config) YAML() (string, error) {
+	out, err := yaml.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func splitList(raw string) []string {
+	var items []string
+	for _, part := range strings.Split(raw, ",") {
+		if item := strings.TrimSpace(part); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func isAbsoluteURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
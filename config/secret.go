@@ -0,0 +1,35 @@
+package config
+
+// redacted replaces secret values whenever a configuration is printed
+const redacted = "[REDACTED]"
+
+// Secret is a string setting that never prints its value
+type Secret string
+
+// Value returns the secret itself
+func (s Secret) Value() string {
+	return string(s)
+}
+
+// String returns a redacted placeholder, or an empty string for unset secrets
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+// GoString redacts the secret for %#v
+func (s Secret) GoString() string {
+	return s.String()
+}
+
+// MarshalYAML redacts the secret when rendering YAML
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+// MarshalText redacts the secret when rendering JSON or TOML
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
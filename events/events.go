@@ -0,0 +1,175 @@
+// Package events is an in-process publish/subscribe bus for change notifications. Recent
+// events are kept in a bounded buffer so subscribers can resume after a reconnect.
+package events
+
+import (
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	ArticleCreated = "article.created"
+	ArticleUpdated = "article.updated"
+	ArticleDeleted = "article.deleted"
+)
+
+// subscriberBuffer is how many events a subscriber may fall behind before it is dropped
+const subscriberBuffer = 64
+
+// Event is a change to an article, delivered to every user who can access it
+type Event struct {
+	ID          uint64    `json:"id"`
+	Type        string    `json:"type"`
+	ArticleID   int       `json:"article_id"`
+	WorkspaceID int       `json:"workspace_id"`
+	Version     int       `json:"version,omitempty"`
+	ActorID     int       `json:"actor_id"`
+	Time        time.Time `json:"time"`
+	// Recipients are the users the event is delivered to
+	Recipients []int `json:"-"`
+}
+
+// EventID formats the ID as sent in the SSE id field
+func (e *Event) EventID() string {
+	return strconv.FormatUint(e.ID, 10)
+}
+
+// Subscription receives the events of one user until it is closed
+type Subscription struct {
+	// Start is the ID of the last event published before the subscription began
+	Start  uint64
+	userID int
+	events chan Event
+	done   chan struct{}
+	once   sync.Once
+}
+
+// Events delivers the user's events in order
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Done is closed when the subscription ends because the bus closed or the subscriber
+// fell too far behind. Subscribers should reconnect and resume from their last event.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Subscription) close() {
+	s.once.Do(func() { close(s.done) })
+}
+
+// Bus fans published events out to subscribers and remembers the most recent ones
+type Bus struct {
+	mu          sync.Mutex
+	lastID      uint64
+	buffer      []Event
+	next        int
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+// NewBus creates a bus replaying up to size recent events
+func NewBus(size int) *Bus {
+	return &Bus{
+		// Start from the clock so IDs from before a restart never look current
+		lastID:      uint64(time.Now().UnixMicro()),
+		buffer:      make([]Event, 0, size),
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish assigns the event an ID and delivers it to the subscriptions of its recipients.
+// Subscribers that cannot keep up are dropped.
+func (b *Bus) Publish(e Event) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	e.ID = b.lastID
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	if len(b.buffer) < cap(b.buffer) {
+		b.buffer = append(b.buffer, e)
+	} else {
+		b.buffer[b.next] = e
+		b.next = (b.next + 1) % len(b.buffer)
+	}
+
+	for sub := range b.subscribers {
+		if !slices.Contains(e.Recipients, sub.userID) {
+			continue
+		}
+		select {
+		case sub.events <- e:
+		default:
+			delete(b.subscribers, sub)
+			sub.close()
+		}
+	}
+	return e
+}
+
+// Subscribe starts delivering a user's events. With a non-zero lastID it also returns the
+// buffered events after it; complete is false when some of them are no longer buffered,
+// so the subscriber has to reload instead.
+func (b *Bus) Subscribe(userID int, lastID uint64) (sub *Subscription, replay []Event, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub = &Subscription{
+		Start:  b.lastID,
+		userID: userID,
+		events: make(chan Event, subscriberBuffer),
+		done:   make(chan struct{}),
+	}
+	if b.closed {
+		sub.close()
+		return sub, nil, true
+	}
+	b.subscribers[sub] = struct{}{}
+
+	if lastID == 0 {
+		return sub, nil, true
+	}
+
+	// The buffer is a ring starting at next once it is full
+	ordered := append(slices.Clone(b.buffer[b.next:]), b.buffer[:b.next]...)
+	oldest := b.lastID + 1
+	if len(ordered) > 0 {
+		oldest = ordered[0].ID
+	}
+	complete = lastID <= b.lastID && lastID+1 >= oldest
+	for _, e := range ordered {
+		if e.ID > lastID && slices.Contains(e.Recipients, userID) {
+			replay = append(replay, e)
+		}
+	}
+	return sub, replay, complete
+}
+
+// Unsubscribe stops a subscription
+func (b *Bus) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subscribers, sub)
+	sub.close()
+}
+
+// Close ends all subscriptions; later ones end immediately
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for sub := range b.subscribers {
+		sub.close()
+	}
+	clear(b.subscribers)
+}
@@ -0,0 +1,192 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+// publishN publishes n events for the recipients and returns their IDs
+func publishN(b *Bus, n int, recipients ...int) []uint64 {
+	ids := make([]uint64, n)
+	for i := range ids {
+		ids[i] = b.Publish(Event{Type: ArticleUpdated, ArticleID: i + 1, Recipients: recipients}).ID
+	}
+	return ids
+}
+
+func isDone(sub *Subscription) bool {
+	select {
+	case <-sub.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestPublishSeedsIDsFromClock(t *testing.T) {
+	before := uint64(time.Now().UnixMicro())
+	b := NewBus(4)
+
+	first := b.Publish(Event{Type: ArticleCreated, Recipients: []int{1}})
+	second := b.Publish(Event{Type: ArticleUpdated, Recipients: []int{1}})
+	if first.ID <= before {
+		t.Errorf("first ID = %d, want it seeded from the clock (after %d)", first.ID, before)
+	}
+	if second.ID != first.ID+1 {
+		t.Errorf("second ID = %d, want %d", second.ID, first.ID+1)
+	}
+	if first.Time.IsZero() {
+		t.Error("Publish did not set the event time")
+	}
+	if first.EventID() == "" || first.EventID() == second.EventID() {
+		t.Errorf("EventID = %q and %q, want distinct IDs", first.EventID(), second.EventID())
+	}
+
+	// A bus started later, as after a restart, never hands out an ID already used
+	time.Sleep(time.Millisecond)
+	restarted := NewBus(4)
+	if id := restarted.Publish(Event{Recipients: []int{1}}).ID; id <= second.ID {
+		t.Errorf("ID after restart = %d, want more than %d", id, second.ID)
+	}
+}
+
+func TestSubscribeReplay(t *testing.T) {
+	b := NewBus(4)
+	// Events 0-5 for user 1, with event 4 for user 2 only; the ring keeps events 2-5
+	ids := publishN(b, 4, 1)
+	ids = append(ids, b.Publish(Event{Type: ArticleUpdated, Recipients: []int{2}}).ID)
+	ids = append(ids, b.Publish(Event{Type: ArticleDeleted, Recipients: []int{1, 2}}).ID)
+
+	tests := []struct {
+		name         string
+		userID       int
+		lastID       uint64
+		wantReplay   []uint64
+		wantComplete bool
+	}{
+		{"new subscription", 1, 0, nil, true},
+		{"up to date", 1, ids[5], nil, true},
+		{"within the buffer", 1, ids[3], []uint64{ids[5]}, true},
+		{"just before the oldest buffered event", 1, ids[1], []uint64{ids[2], ids[3], ids[5]}, true},
+		{"only events for the user", 2, ids[1], []uint64{ids[4], ids[5]}, true},
+		{"fallen out of the buffer", 1, ids[0], []uint64{ids[2], ids[3], ids[5]}, false},
+		{"long gone", 1, 1, []uint64{ids[2], ids[3], ids[5]}, false},
+		{"from the future", 1, ids[5] + 1, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, replay, complete := b.Subscribe(tt.userID, tt.lastID)
+			defer b.Unsubscribe(sub)
+
+			if complete != tt.wantComplete {
+				t.Errorf("complete = %v, want %v", complete, tt.wantComplete)
+			}
+			if sub.Start != ids[5] {
+				t.Errorf("Start = %d, want %d", sub.Start, ids[5])
+			}
+			var got []uint64
+			for _, e := range replay {
+				got = append(got, e.ID)
+			}
+			if len(got) != len(tt.wantReplay) {
+				t.Fatalf("replay = %v, want %v", got, tt.wantReplay)
+			}
+			for i := range got {
+				if got[i] != tt.wantReplay[i] {
+					t.Fatalf("replay = %v, want %v", got, tt.wantReplay)
+				}
+			}
+		})
+	}
+}
+
+func TestSubscribeAfterRestart(t *testing.T) {
+	old := NewBus(4)
+	lastSeen := old.Publish(Event{Recipients: []int{1}}).ID
+
+	// Nothing is buffered yet, so whatever the client missed cannot be replayed
+	time.Sleep(time.Millisecond)
+	b := NewBus(4)
+	sub, replay, complete := b.Subscribe(1, lastSeen)
+	defer b.Unsubscribe(sub)
+	if complete || len(replay) != 0 {
+		t.Errorf("Subscribe = %d events, complete %v; want none, incomplete", len(replay), complete)
+	}
+}
+
+func TestPublishDelivers(t *testing.T) {
+	b := NewBus(4)
+	sub, _, _ := b.Subscribe(1, 0)
+	defer b.Unsubscribe(sub)
+
+	b.Publish(Event{Type: ArticleUpdated, ArticleID: 7, Recipients: []int{2}})
+	sent := b.Publish(Event{Type: ArticleUpdated, ArticleID: 8, Recipients: []int{1, 2}})
+
+	select {
+	case e := <-sub.Events():
+		if e.ID != sent.ID || e.ArticleID != 8 {
+			t.Errorf("received event %d for article %d, want %d for article 8", e.ID, e.ArticleID, sent.ID)
+		}
+	default:
+		t.Fatal("no event delivered")
+	}
+	select {
+	case e := <-sub.Events():
+		t.Errorf("received event %d meant for another user", e.ID)
+	default:
+	}
+}
+
+func TestSlowSubscriberDropped(t *testing.T) {
+	b := NewBus(4)
+	slow, _, _ := b.Subscribe(1, 0)
+	other, _, _ := b.Subscribe(2, 0)
+	defer b.Unsubscribe(other)
+
+	publishN(b, subscriberBuffer, 1)
+	if isDone(slow) {
+		t.Fatal("subscriber dropped before its buffer was full")
+	}
+
+	publishN(b, 1, 1)
+	if !isDone(slow) {
+		t.Fatal("subscriber kept after its buffer overflowed")
+	}
+	if n := len(slow.Events()); n != subscriberBuffer {
+		t.Errorf("dropped subscriber holds %d events, want the %d delivered before", n, subscriberBuffer)
+	}
+
+	// Later events go neither to the dropped subscriber nor stop the others
+	publishN(b, 1, 1, 2)
+	if n := len(slow.Events()); n != subscriberBuffer {
+		t.Errorf("dropped subscriber received more events: %d", n)
+	}
+	if isDone(other) || len(other.Events()) != 1 {
+		t.Errorf("other subscriber: done %v with %d events, want open with 1", isDone(other), len(other.Events()))
+	}
+}
+
+func TestUnsubscribeAndClose(t *testing.T) {
+	b := NewBus(4)
+	sub, _, _ := b.Subscribe(1, 0)
+	b.Unsubscribe(sub)
+	if !isDone(sub) {
+		t.Error("Unsubscribe did not end the subscription")
+	}
+	b.Unsubscribe(sub)
+
+	open, _, _ := b.Subscribe(1, 0)
+	b.Close()
+	if !isDone(open) {
+		t.Error("Close did not end the subscription")
+	}
+
+	late, replay, complete := b.Subscribe(1, 0)
+	if !isDone(late) || replay != nil || !complete {
+		t.Error("subscription after Close did not end immediately")
+	}
+	b.Publish(Event{Recipients: []int{1}})
+	if len(late.Events()) != 0 {
+		t.Error("event delivered after Close")
+	}
+}
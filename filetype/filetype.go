@@ -0,0 +1,215 @@
+// Package filetype identifies uploaded files by their content and checks
+// the result against what the client claimed
+package filetype
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Octet is the type of content that could not be identified
+const Octet = "application/octet-stream"
+
+// SniffLen is the number of leading bytes Detect looks at
+const SniffLen = 512
+
+// signatures are checked before net/http's sniffer, which does not know executables or SVG
+var signatures = []struct {
+	prefix []byte
+	typ    string
+}{
+	{[]byte("MZ"), "application/vnd.microsoft.portable-executable"},
+	{[]byte("\x7fELF"), "application/x-elf"},
+	{[]byte{0xFE, 0xED, 0xFA, 0xCE}, "application/x-mach-binary"},
+	{[]byte{0xFE, 0xED, 0xFA, 0xCF}, "application/x-mach-binary"},
+	{[]byte{0xCE, 0xFA, 0xED, 0xFE}, "application/x-mach-binary"},
+	{[]byte{0xCF, 0xFA, 0xED, 0xFE}, "application/x-mach-binary"},
+	{[]byte("#!"), "application/x-sh"},
+}
+
+// Detect returns the media type of content from its first bytes, without parameters
+func Detect(header []byte) string {
+	if len(header) > SniffLen {
+		header = header[:SniffLen]
+	}
+	for _, sig := range signatures {
+		if bytes.HasPrefix(header, sig.prefix) {
+			return sig.typ
+		}
+	}
+
+	typ := Base(http.DetectContentType(header))
+	if (typ == "text/xml" || typ == "text/plain") && isSVG(header) {
+		return "image/svg+xml"
+	}
+	return typ
+}
+
+func isSVG(header []byte) bool {
+	trimmed := bytes.TrimLeft(bytes.TrimPrefix(header, []byte("\xEF\xBB\xBF")), " \t\r\n")
+	lower := bytes.ToLower(trimmed)
+	if bytes.HasPrefix(lower, []byte("<svg")) {
+		return true
+	}
+	return (bytes.HasPrefix(lower, []byte("<?xml")) || bytes.HasPrefix(lower, []byte("<!doctype svg"))) &&
+		bytes.Contains(lower, []byte("<svg"))
+}
+
+// extensionTypes cover extensions whose type the system MIME table may not know
+var extensionTypes = map[string]string{
+	".exe":   "application/vnd.microsoft.portable-executable",
+	".dll":   "application/vnd.microsoft.portable-executable",
+	".scr":   "application/vnd.microsoft.portable-executable",
+	".com":   "application/vnd.microsoft.portable-executable",
+	".msi":   "application/x-msi",
+	".bat":   "application/x-bat",
+	".cmd":   "application/x-bat",
+	".ps1":   "application/x-powershell",
+	".vbs":   "application/x-vbscript",
+	".sh":    "application/x-sh",
+	".jar":   "application/java-archive",
+	".apk":   "application/vnd.android.package-archive",
+	".htm":   "text/html",
+	".html":  "text/html",
+	".xhtml": "application/xhtml+xml",
+	".md":    "text/markdown",
+}
+
+// FromExtension returns the media type registered for the extension of name, or ""
+func FromExtension(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == "" {
+		return ""
+	}
+	if typ, ok := extensionTypes[ext]; ok {
+		return typ
+	}
+	return Base(mime.TypeByExtension(ext))
+}
+
+// Base strips parameters from a media type and lower-cases it, returning "" when it is malformed
+func Base(typ string) string {
+	if typ == "" {
+		return ""
+	}
+	base, _, err := mime.ParseMediaType(typ)
+	if err != nil {
+		return ""
+	}
+	return base
+}
+
+// Match reports whether typ matches pattern, which is a media type or a wildcard such as image/*
+func Match(pattern, typ string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	switch {
+	case pattern == "*" || pattern == "*/*":
+		return true
+	case strings.HasSuffix(pattern, "/*"):
+		return strings.HasPrefix(typ, strings.TrimSuffix(pattern, "*"))
+	default:
+		return pattern == typ
+	}
+}
+
+// MatchAny reports whether typ matches one of patterns
+func MatchAny(patterns []string, typ string) bool {
+	for _, pattern := range patterns {
+		if Match(pattern, typ) {
+			return true
+		}
+	}
+	return false
+}
+
+// sniffable are types Detect recognises by signature, so a file declared as one of
+// them but detected as something else is certainly not what it claims to be
+var sniffable = []string{
+	"image/png", "image/jpeg", "image/gif", "image/webp", "image/bmp", "image/x-icon",
+	"audio/mpeg", "audio/wave", "audio/aiff", "audio/midi", "application/ogg",
+	"video/mp4", "video/webm", "video/avi",
+	"application/pdf", "application/postscript", "application/zip", "application/x-gzip",
+	"application/x-rar-compressed", "application/wasm", "text/html",
+}
+
+// zipContainers are formats stored as ZIP archives
+var zipContainers = []string{
+	"application/vnd.openxmlformats-officedocument.*",
+	"application/vnd.oasis.opendocument.*",
+	"application/epub+zip",
+	"application/java-archive",
+	"application/vnd.android.package-archive",
+	"application/x-zip-compressed",
+}
+
+// aliases map alternative names to the type Detect reports
+var aliases = map[string]string{
+	"application/gzip":             "application/x-gzip",
+	"application/x-zip-compressed": "application/zip",
+	"image/jpg":                    "image/jpeg",
+	"image/pjpeg":                  "image/jpeg",
+	"audio/mp3":                    "audio/mpeg",
+	"audio/x-wav":                  "audio/wave",
+	"audio/wav":                    "audio/wave",
+	"application/xml":              "text/xml",
+}
+
+// Compatible reports whether content detected as detected may be what the client declared.
+// Detection only recognises some formats, so generic results (plain text, unknown binary,
+// ZIP) are compatible with the more specific formats they can hold.
+func Compatible(declared, detected string) bool {
+	if declared == "" || declared == detected {
+		return true
+	}
+	if alias, ok := aliases[declared]; ok && alias == detected {
+		return true
+	}
+
+	switch {
+	case detected == Octet:
+		canonical := declared
+		if alias, ok := aliases[declared]; ok {
+			canonical = alias
+		}
+		return !matchPrefixList(sniffable, canonical)
+	case detected == "text/plain":
+		return isText(declared)
+	case detected == "text/xml":
+		return isText(declared) || strings.HasSuffix(declared, "+xml")
+	case detected == "application/zip":
+		return matchPrefixList(zipContainers, declared)
+	}
+	return false
+}
+
+func matchPrefixList(patterns []string, typ string) bool {
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, ".*") {
+			if strings.HasPrefix(typ, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		} else if Match(pattern, typ) {
+			return true
+		}
+	}
+	return false
+}
+
+// isText reports whether typ is a textual format that net/http sniffs as plain text
+func isText(typ string) bool {
+	if typ == "text/html" {
+		return false
+	}
+	if strings.HasPrefix(typ, "text/") || strings.HasSuffix(typ, "+json") || strings.HasSuffix(typ, "+xml") {
+		return true
+	}
+	switch typ {
+	case "application/json", "application/xml", "application/javascript", "application/x-yaml",
+		"application/yaml", "application/toml", "application/sql", "application/x-tex", "application/x-subrip":
+		return true
+	}
+	return false
+}
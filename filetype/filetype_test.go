@@ -0,0 +1,107 @@
+package filetype
+
+import "testing"
+
+var (
+	pngHeader  = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR")
+	jpegHeader = []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x10, 'J', 'F', 'I', 'F', 0x00}
+	htmlBytes  = []byte("<!DOCTYPE html><html><body><script>alert(1)</script></body></html>")
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		want   string
+	}{
+		{"png", pngHeader, "image/png"},
+		{"jpeg", jpegHeader, "image/jpeg"},
+		{"pdf", []byte("%PDF-1.7\n"), "application/pdf"},
+		{"html", htmlBytes, "text/html"},
+		{"windows executable", []byte("MZ\x90\x00\x03\x00"), "application/vnd.microsoft.portable-executable"},
+		{"elf", []byte("\x7fELF\x02\x01\x01"), "application/x-elf"},
+		{"shell script", []byte("#!/bin/sh\nrm -rf /\n"), "application/x-sh"},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`), "image/svg+xml"},
+		{"svg with prolog", []byte(`<?xml version="1.0"?>` + "\n" + `<svg xmlns="http://www.w3.org/2000/svg"/>`), "image/svg+xml"},
+		{"plain text", []byte("just some notes\n"), "text/plain"},
+		{"binary", []byte{0x00, 0x01, 0x02, 0x03}, Octet},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Detect(tt.header); got != tt.want {
+				t.Errorf("Detect = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestExtensionAgainstContent checks the declared type of a file name against its sniffed
+// content, as uploads do
+func TestExtensionAgainstContent(t *testing.T) {
+	tests := []struct {
+		name       string
+		fileName   string
+		content    []byte
+		compatible bool
+	}{
+		{"png named png", "photo.png", pngHeader, true},
+		{"html named png", "photo.png", htmlBytes, false},
+		{"png named jpeg", "photo.jpg", pngHeader, false},
+		{"executable named pdf", "invoice.pdf", []byte("MZ\x90\x00"), false},
+		{"script named txt", "notes.txt", []byte("#!/bin/sh\n"), false},
+		{"svg named png", "logo.png", []byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`), false},
+		{"html named html", "page.html", htmlBytes, true},
+		{"markdown", "notes.md", []byte("# Notes\n"), true},
+		{"unknown binary named docx", "report.docx", []byte{0x00, 0x01}, true},
+		{"unknown binary named png", "photo.png", []byte{0x00, 0x01}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			declared, detected := FromExtension(tt.fileName), Detect(tt.content)
+			if got := Compatible(declared, detected); got != tt.compatible {
+				t.Errorf("Compatible(%q, %q) = %v, want %v", declared, detected, got, tt.compatible)
+			}
+		})
+	}
+}
+
+func TestCompatible(t *testing.T) {
+	tests := []struct {
+		declared, detected string
+		want               bool
+	}{
+		{"", "text/html", true},
+		{"image/jpg", "image/jpeg", true},
+		{"application/json", "text/plain", true},
+		{"image/svg+xml", "text/xml", true},
+		{"application/vnd.openxmlformats-officedocument.wordprocessingml.document", "application/zip", true},
+		{"text/html", "text/plain", false},
+		{"image/png", "text/html", false},
+		{"application/pdf", "application/zip", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.declared+" as "+tt.detected, func(t *testing.T) {
+			if got := Compatible(tt.declared, tt.detected); got != tt.want {
+				t.Errorf("Compatible = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, typ string
+		want         bool
+	}{
+		{"image/*", "image/png", true},
+		{"image/*", "application/pdf", false},
+		{"*/*", "text/html", true},
+		{" Text/HTML ", "text/html", true},
+		{"text/html", "text/plain", false},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.typ); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.typ, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package filetype
+
+import (
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxNameBytes is the longest file name most filesystems and Drive accept
+const maxNameBytes = 255
+
+// DefaultName replaces file names that sanitize to nothing
+const DefaultName = "file"
+
+// reservedNames are device names Windows refuses as file names, with or without extension
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeName turns a client supplied file name into one that is safe to store and
+// offer for download: directory components are dropped, the name is NFC normalized,
+// control, bidirectional override and path characters are removed or replaced, and
+// the result is limited to 255 bytes with its extension kept.
+func SanitizeName(name string) string {
+	name = strings.ToValidUTF8(name, "")
+
+	// Browsers on Windows may send the full client path
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = norm.NFC.String(name)
+
+	var b strings.Builder
+	space := false
+	for _, r := range name {
+		switch {
+		case unicode.IsSpace(r):
+			// Collapse runs of whitespace into a single space
+			if !space {
+				b.WriteByte(' ')
+			}
+			space = true
+			continue
+		case unicode.IsControl(r), unicode.Is(unicode.Bidi_Control, r), r == '\u200b', r == '\ufeff':
+			// Invisible characters can disguise the real extension
+		case strings.ContainsRune(`<>:"|?*`, r):
+			b.WriteByte('_')
+		default:
+			b.WriteRune(r)
+		}
+		space = false
+	}
+
+	name = strings.Trim(b.String(), " .")
+	if name == "" {
+		return DefaultName
+	}
+
+	stem := strings.TrimSuffix(name, path.Ext(name))
+	if reservedNames[strings.ToUpper(strings.TrimRight(stem, " "))] {
+		name = "_" + name
+	}
+
+	return truncateName(name)
+}
+
+// truncateName shortens name to maxNameBytes on a rune boundary, keeping a reasonable extension
+func truncateName(name string) string {
+	if len(name) <= maxNameBytes {
+		return name
+	}
+
+	ext := path.Ext(name)
+	if len(ext) > 16 {
+		ext = ""
+	}
+	stem := name[:len(name)-len(ext)]
+	limit := maxNameBytes - len(ext)
+	for limit > 0 && !utf8.RuneStart(stem[limit]) {
+		limit--
+	}
+	return strings.TrimRight(stem[:limit], " .") + ext
+}
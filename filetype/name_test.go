@@ -0,0 +1,70 @@
+package filetype
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "report.pdf", "report.pdf"},
+		{"unix path", "../../etc/passwd", "passwd"},
+		{"windows path", `C:\Users\someone\Desktop\report.pdf`, "report.pdf"},
+		{"trailing separator", "notes/", DefaultName},
+		{"nul byte", "evil.php\x00.png", "evil.php.png"},
+		{"control characters", "re\x01po\x1frt\x7f.pdf", "report.pdf"},
+		{"newlines", "a\r\nb.txt", "a b.txt"},
+		{"right-to-left override", "invoice\u202Efdp.exe", "invoicefdp.exe"},
+		{"zero width space", "photo\u200b.jpg", "photo.jpg"},
+		{"reserved characters", `a<b>c:d"e|f?g*h.txt`, "a_b_c_d_e_f_g_h.txt"},
+		{"collapsed whitespace", "  my \t  notes  .md", "my notes .md"},
+		{"leading dots", "..hidden", "hidden"},
+		{"only dots", "...", DefaultName},
+		{"empty", "", DefaultName},
+		{"invalid utf-8", "caf\xe9.txt", "caf.txt"},
+		{"nfc", "cafe\u0301.txt", "caf\u00e9.txt"},
+		{"reserved device name", "CON", "_CON"},
+		{"reserved device name with extension", "nul.txt", "_nul.txt"},
+		{"reserved device name lower case", "com1.log", "_com1.log"},
+		{"not reserved", "console.txt", "console.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SanitizeName(tt.in); got != tt.want {
+				t.Errorf("SanitizeName(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeNameLength(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		wantExt string
+	}{
+		{"ascii", strings.Repeat("a", 300) + ".pdf", ".pdf"},
+		{"multibyte", strings.Repeat("é", 200) + ".txt", ".txt"},
+		{"long extension dropped", strings.Repeat("a", 300) + "." + strings.Repeat("x", 20), ""},
+		{"no extension", strings.Repeat("ü", 300), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SanitizeName(tt.in)
+			if len(got) > maxNameBytes {
+				t.Errorf("len = %d, want at most %d", len(got), maxNameBytes)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("SanitizeName cut a character: %q", got)
+			}
+			if tt.wantExt != "" && !strings.HasSuffix(got, tt.wantExt) {
+				t.Errorf("SanitizeName = %q, want the extension %s kept", got, tt.wantExt)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"personalnote.eu/simple-go-api/models"
+	"personalnote.eu/simple-go-api/utils"
+)
+
+// ArticleACLHandler manages who else can access an article under /article/{id}/acl:
+// GET lists the grants, POST grants a role to a user ID or email address, and
+// DELETE /article/{id}/acl/{entryID} revokes one. Only the owner may change access,
+// except that grantees can remove themselves.
+func ArticleACLHandler(w http.ResponseWriter, r *http.Request) {
+	userID, authenticated := checkAuth(w, r)
+	if !authenticated {
+		return
+	}
+
+	// Expected format: /article/{id}/acl or /article/{id}/acl/{entryID}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Invalid ID", "Article ID must be a valid integer")
+		return
+	}
+
+	article, ok := loadArticle(w, r, id, userID)
+	if !ok {
+		return
+	}
+
+	switch {
+	case len(parts) == 3 && r.Method == http.MethodGet:
+		if !requireOwner(w, article, "see who can access it") {
+			return
+		}
+		entries, err := utils.GetArticleACL(r.Context(), id)
+		if err != nil {
+			utils.SendErrorResponse(w, http.StatusInternalServerError,
+				"Database error", "Failed to retrieve access list")
+			return
+		}
+		utils.SendJSONResponse(w, http.StatusOK, map[string]interface{}{
+			"article_id": id,
+			"entries":    entries,
+			"count":      len(entries),
+		})
+	case len(parts) == 3 && r.Method == http.MethodPost:
+		if !requireOwner(w, article, "share it") {
+			return
+		}
+		grantArticleAccess(w, r, article, userID)
+	case len(parts) == 4 && r.Method == http.MethodDelete:
+		revokeArticleAccess(w, r, article, userID, parts[3])
+	default:
+		utils.SendErrorResponse(w, http.StatusMethodNotAllowed,
+			"Method not allowed", fmt.Sprintf("Method %s is not supported for this endpoint", r.Method))
+	}
+}
+
+func grantArticleAccess(w http.ResponseWriter, r *http.Request, article *models.Article, userID int) {
+	ctx := r.Context()
+
+	var req struct {
+		UserID *int   `json:"user_id"`
+		Email  string `json:"email"`
+		Role   string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Invalid request body", "Failed to parse JSON")
+		return
+	}
+	if !utils.ValidRole(req.Role) {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Validation error", "role must be viewer or editor")
+		return
+	}
+	if (req.UserID == nil) == (req.Email == "") {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Validation error", "Either user_id or email is required")
+		return
+	}
+
+	entry := &models.ArticleACL{ArticleID: article.ID, Role: req.Role, GrantedBy: userID}
+	if req.UserID != nil {
+		if _, err := utils.GetUserByID(ctx, *req.UserID); err != nil {
+			utils.SendErrorResponse(w, http.StatusNotFound,
+				"User not found", fmt.Sprintf("User with ID %d not found", *req.UserID))
+			return
+		}
+		entry.UserID = req.UserID
+	} else {
+		address, err := mail.ParseAddress(req.Email)
+		if err != nil || address.Name != "" {
+			utils.SendErrorResponse(w, http.StatusBadRequest,
+				"Validation error", "email must be a valid email address")
+			return
+		}
+		// Users whose address Google verified get access right away; anyone else gets an
+		// invite that only a verified sign-in with the address can claim
+		entry.Email = address.Address
+		if user, err := utils.GetVerifiedUserByEmail(ctx, address.Address); err == nil {
+			entry.UserID = &user.ID
+		}
+	}
+	if entry.UserID != nil && *entry.UserID == article.UserID {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Validation error", "The owner already has full access")
+		return
+	}
+
+	if err := utils.GrantArticleAccess(ctx, entry); err != nil {
+		utils.SendErrorResponse(w, http.StatusInternalServerError,
+			"Database error", "Failed to grant access")
+		return
+	}
+
+	entries, err := utils.GetArticleACL(ctx, article.ID)
+	if err != nil {
+		utils.SendErrorResponse(w, http.StatusInternalServerError,
+			"Database error", "Access granted but failed to retrieve it")
+		return
+	}
+	for _, saved := range entries {
+		if (entry.UserID != nil && saved.UserID != nil && *saved.UserID == *entry.UserID) ||
+			(entry.UserID == nil && saved.Pending && strings.EqualFold(saved.Email, entry.Email)) {
+			utils.SendJSONResponse(w, http.StatusCreated, saved)
+			return
+		}
+	}
+	utils.SendJSONResponse(w, http.StatusCreated, entry)
+}
+
+func revokeArticleAccess(w http.ResponseWriter, r *http.Request, article *models.Article, userID int, rawEntryID string) {
+	entryID, err := strconv.Atoi(rawEntryID)
+	if err != nil {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Invalid ID", "Entry ID must be a valid integer")
+		return
+	}
+
+	ctx := r.Context()
+	entry, err := utils.GetArticleACLEntry(ctx, article.ID, entryID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			utils.SendErrorResponse(w, http.StatusNotFound,
+				"Entry not found", fmt.Sprintf("Access entry %d not found", entryID))
+		} else {
+			utils.SendErrorResponse(w, http.StatusInternalServerError,
+				"Database error", "Failed to retrieve access list")
+		}
+		return
+	}
+
+	leaving := entry.UserID != nil && *entry.UserID == userID
+	if !leaving && !requireOwner(w, article, "change who can access it") {
+		return
+	}
+
+	if err := utils.RevokeArticleAccess(ctx, article.ID, entryID); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			utils.SendErrorResponse(w, http.StatusNotFound,
+				"Entry not found", fmt.Sprintf("Access entry %d not found", entryID))
+		} else {
+			utils.SendErrorResponse(w, http.StatusInternalServerError,
+				"Database error", "Failed to revoke access")
+		}
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"article_id": article.ID,
+		"id":         entryID,
+		"message":    "Access revoked",
+	})
+}
+
+// SharedWithMeHandler handles GET /articles/shared-with-me, listing the articles other
+// users have shared with the caller
+func SharedWithMeHandler(w http.ResponseWriter, r *http.Request) {
+	if !utils.ValidateHTTPMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	userID, authenticated := checkAuth(w, r)
+	if !authenticated {
+		return
+	}
+
+	articles, err := utils.GetSharedArticles(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to fetch shared articles", "error", err)
+		utils.SendErrorResponse(w, http.StatusInternalServerError,
+			"Database error", "Failed to retrieve articles from database")
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, models.ArticleListResponse{
+		Articles: articles,
+		Count:    len(articles),
+		Message:  fmt.Sprintf("Successfully retrieved %d articles", len(articles)),
+	})
+}
+
+// loadArticle fetches an article the user can access in the active workspace. It writes the
+// error response and returns false when there is none.
+func loadArticle(w http.ResponseWriter, r *http.Request, id, userID int) (*models.Article, bool) {
+	workspace, ok := activeWorkspace(w, r, userID)
+	if !ok {
+		return nil, false
+	}
+
+	article, err := utils.GetArticleByID(r.Context(), workspace.ID, id, userID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			utils.SendErrorResponse(w, http.StatusNotFound,
+				"Article not found", fmt.Sprintf("Article with ID %d not found", id))
+		} else {
+			slog.ErrorContext(r.Context(), "failed to fetch article", "article_id", id, "error", err)
+			utils.SendErrorResponse(w, http.StatusInternalServerError,
+				"Database error", "Failed to retrieve article from database")
+		}
+		return nil, false
+	}
+	return article, true
+}
+
+// requireOwner writes a 403 response and returns false unless the user owns the article
+func requireOwner(w http.ResponseWriter, article *models.Article, action string) bool {
+	if article.Role == models.RoleOwner {
+		return true
+	}
+	utils.SendErrorResponse(w, http.StatusForbidden,
+		"Access denied", "Only the owner of the article can "+action)
+	return false
+}
@@ -1,39 +1,52 @@
 package handlers
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"personalnote.eu/simple-go-api/config"
 	"personalnote.eu/simple-go-api/middleware"
 	"personalnote.eu/simple-go-api/models"
+	"personalnote.eu/simple-go-api/scanner"
+	"personalnote.eu/simple-go-api/storage"
 	"personalnote.eu/simple-go-api/utils"
 )
 
 var (
+	appConfig         *config.Config
 	googleOAuthConfig *oauth2.Config
 )
 
-// InitOAuth initializes the OAuth configuration
-func InitOAuth() {
-	redirectURL := os.Getenv("GOOGLE_REDIRECT_URL")
-	if redirectURL == "" {
-		redirectURL = "http://localhost:8080/auth/google/callback" // fallback for local dev
-	}
+// Init stores the application configuration, storage backend, Drive client and malware scanner used by the
+// handlers and initializes OAuth, resumable uploads, scan retries and thumbnail generation
+func Init(cfg *config.Config, store storage.BlobStore, drive storage.DriveClient, scan scanner.Scanner) {
+	appConfig = cfg
+	blobStore = store
+	driveClient = drive
+	fileScanner = scan
+	InitOAuth(cfg.Auth)
+	initDriveConnect(cfg)
+	initUploads()
+	initImports()
+	initEvents()
+	initScanner()
+	initThumbnails()
+}
 
+// InitOAuth initializes the OAuth configuration
+func InitOAuth(cfg config.AuthConfig) {
 	googleOAuthConfig = &oauth2.Config{
-		ClientID:     os.Getenv("GOOGLE_CLIENT_ID"),
-		ClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
-		RedirectURL:  redirectURL,
+		ClientID:     cfg.GoogleClientID,
+		ClientSecret: cfg.GoogleClientSecret.Value(),
+		RedirectURL:  cfg.GoogleRedirectURL,
 		Scopes: []string{
 			"https://www.googleapis.com/auth/userinfo.email",
 			"https://www.googleapis.com/auth/userinfo.profile",
@@ -41,7 +54,7 @@ func InitOAuth() {
 		Endpoint: google.Endpoint,
 	}
 
-	log.Printf("🔐 OAuth initialized with redirect URL: %s", googleOAuthConfig.RedirectURL)
+	slog.Info("OAuth initialized", "redirect_url", googleOAuthConfig.RedirectURL)
 }
 
 // GoogleLoginHandler redirects user to Google OAuth consent page
@@ -52,7 +65,7 @@ func GoogleLoginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	url := googleOAuthConfig.AuthCodeURL("state", oauth2.AccessTypeOffline)
-	log.Printf("Starting Google Login. Auth URL: %s", url)
+	slog.DebugContext(r.Context(), "starting Google login")
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 }
 
@@ -64,17 +77,17 @@ func GoogleCallbackHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := googleOAuthConfig.Exchange(context.Background(), code)
+	token, err := googleOAuthConfig.Exchange(r.Context(), code)
 	if err != nil {
-		log.Printf("Failed to exchange token: %v", err)
+		slog.ErrorContext(r.Context(), "failed to exchange token", "error", err)
 		http.Error(w, `{"error":"Failed to exchange token"}`, http.StatusInternalServerError)
 		return
 	}
 
-	client := googleOAuthConfig.Client(context.Background(), token)
+	client := googleOAuthConfig.Client(r.Context(), token)
 	resp, err := client.Get("https://www.googleapis.com/oauth2/v2/userinfo")
 	if err != nil {
-		log.Printf("Failed to get user info: %v", err)
+		slog.ErrorContext(r.Context(), "failed to get user info", "error", err)
 		http.Error(w, `{"error":"Failed to get user info"}`, http.StatusInternalServerError)
 		return
 	}
@@ -82,81 +95,60 @@ func GoogleCallbackHandler(w http.ResponseWriter, r *http.Request) {
 
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Printf("Failed to read user info: %v", err)
+		slog.ErrorContext(r.Context(), "failed to read user info", "error", err)
 		http.Error(w, `{"error":"Failed to read user info"}`, http.StatusInternalServerError)
 		return
 	}
 
 	var googleUser models.GoogleUserInfo
 	if err := json.Unmarshal(data, &googleUser); err != nil {
-		log.Printf("Failed to parse user info: %v", err)
+		slog.ErrorContext(r.Context(), "failed to parse user info", "error", err)
 		http.Error(w, `{"error":"Failed to parse user info"}`, http.StatusInternalServerError)
 		return
 	}
 
 	// Store or update user in database
-	user, err := utils.CreateOrUpdateUser(googleUser.ID, googleUser.Email, googleUser.Name, googleUser.Picture)
+	user, err := utils.CreateOrUpdateUser(r.Context(), googleUser.ID, googleUser.Email, googleUser.VerifiedEmail, googleUser.Name, googleUser.Picture)
 	if err != nil {
-		log.Printf("Failed to create/update user: %v", err)
+		slog.ErrorContext(r.Context(), "failed to create or update user", "error", err)
 		http.Error(w, `{"error":"Failed to save user"}`, http.StatusInternalServerError)
 		return
 	}
 
+	// Turn pending email invites into grants and memberships for this account. Only a
+	// verified address shows the invites were meant for this user.
+	if googleUser.VerifiedEmail {
+		if err := utils.ClaimArticleInvites(r.Context(), user.ID, googleUser.Email); err != nil {
+			slog.WarnContext(r.Context(), "failed to claim article invites", "user_id", user.ID, "error", err)
+		}
+		if err := utils.ClaimWorkspaceInvites(r.Context(), user.ID, googleUser.Email); err != nil {
+			slog.WarnContext(r.Context(), "failed to claim workspace invites", "user_id", user.ID, "error", err)
+		}
+	}
+
 	// Generate JWT token
 	jwtToken, err := generateJWT(user)
 	if err != nil {
-		log.Printf("Failed to generate JWT: %v", err)
+		slog.ErrorContext(r.Context(), "failed to generate JWT", "user_id", user.ID, "error", err)
 		http.Error(w, `{"error":"Failed to generate token"}`, http.StatusInternalServerError)
 		return
 	}
 
 	// Redirect to frontend with token
-	frontendURL := os.Getenv("FRONTEND_URL")
-	if frontendURL == "" {
-		frontendURL = "http://localhost:3000"
-	}
 	// Ensure we don't end up with double slashes when building the callback URL
-	frontendURL = strings.TrimRight(frontendURL, "/")
+	frontendURL := strings.TrimRight(appConfig.Auth.FrontendURL, "/")
 	http.Redirect(w, r, fmt.Sprintf("%s/auth/callback?token=%s", frontendURL, jwtToken), http.StatusTemporaryRedirect)
 }
 
 // UserInfoHandler returns the current user's info
 func UserInfoHandler(w http.ResponseWriter, r *http.Request) {
-	// Extract and validate token
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		http.Error(w, `{"error":"Authentication required"}`, http.StatusUnauthorized)
-		return
-	}
-
-	parts := strings.Split(authHeader, " ")
-	if len(parts) != 2 || parts[0] != "Bearer" {
-		http.Error(w, `{"error":"Invalid authorization header"}`, http.StatusUnauthorized)
-		return
-	}
-
-	tokenString := parts[1]
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		http.Error(w, `{"error":"Server configuration error"}`, http.StatusInternalServerError)
-		return
-	}
-
-	claims := &middleware.Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method")
-		}
-		return []byte(jwtSecret), nil
-	})
-
-	if err != nil || !token.Valid {
-		http.Error(w, `{"error":"Invalid or expired token"}`, http.StatusUnauthorized)
+	userID, authenticated := checkAuth(w, r)
+	if !authenticated {
 		return
 	}
 
 	// Get user from database
-	user, err := utils.GetUserByID(claims.UserID)
+	user, err := utils.GetUserByID(r.Context(), userID)
 	if err != nil {
 		http.Error(w, `{"error":"User not found"}`, http.StatusNotFound)
 		return
@@ -168,9 +160,9 @@ func UserInfoHandler(w http.ResponseWriter, r *http.Request) {
 
 // generateJWT creates a JWT token for the user
 func generateJWT(user *models.User) (string, error) {
-	jwtSecret := os.Getenv("JWT_SECRET")
+	jwtSecret := appConfig.Auth.JWTSecret.Value()
 	if jwtSecret == "" {
-		return "", fmt.Errorf("JWT_SECRET not set")
+		return "", fmt.Errorf("JWT secret not configured")
 	}
 
 	claims := &middleware.Claims{
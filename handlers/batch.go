@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"personalnote.eu/simple-go-api/events"
+	"personalnote.eu/simple-go-api/markdown"
+	"personalnote.eu/simple-go-api/metrics"
+	"personalnote.eu/simple-go-api/models"
+	"personalnote.eu/simple-go-api/utils"
+)
+
+// maxBatchOperations bounds the number of operations in one batch request
+const maxBatchOperations = 500
+
+// Batch operation kinds
+const (
+	batchCreate = "create"
+	batchUpdate = "update"
+	batchDelete = "delete"
+)
+
+// Batch result states; rolled_back and skipped only occur in atomic batches
+const (
+	batchStatusOK         = "ok"
+	batchStatusError      = "error"
+	batchStatusRolledBack = "rolled_back"
+	batchStatusSkipped    = "skipped"
+)
+
+// batchOperation is one entry of a batch request
+type batchOperation struct {
+	Op            string `json:"op"`
+	ID            int    `json:"id,omitempty"`
+	Title         string `json:"title,omitempty"`
+	Content       string `json:"content,omitempty"`
+	ContentFormat string `json:"content_format,omitempty"`
+}
+
+// batchResult reports the outcome of one operation, with the status code the matching
+// single-article endpoint would have returned
+type batchResult struct {
+	Index      int             `json:"index"`
+	Op         string          `json:"op"`
+	ID         int             `json:"id,omitempty"`
+	Status     string          `json:"status"`
+	StatusCode int             `json:"status_code,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Article    *models.Article `json:"article,omitempty"`
+}
+
+// batchScope is the user running a batch and the workspace it runs in
+type batchScope struct {
+	workspace *models.Workspace
+	userID    int
+}
+
+// batchError is a failed operation, carrying the status code to report
+type batchError struct {
+	code    int
+	message string
+}
+
+func (e *batchError) Error() string {
+	return e.message
+}
+
+// ArticlesBatchHandler handles POST /articles/batch, which applies a list of create,
+// update and delete operations. Each operation gets its own result. With "atomic" the
+// batch runs in one transaction and is rolled back entirely when any operation fails.
+func ArticlesBatchHandler(w http.ResponseWriter, r *http.Request) {
+	if !utils.ValidateHTTPMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	userID, authenticated := checkAuth(w, r)
+	if !authenticated {
+		return
+	}
+
+	var req struct {
+		Atomic     bool             `json:"atomic"`
+		Operations []batchOperation `json:"operations"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Invalid request body", "Failed to parse JSON")
+		return
+	}
+	if len(req.Operations) == 0 {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Validation error", "At least one operation is required")
+		return
+	}
+	if len(req.Operations) > maxBatchOperations {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Validation error", fmt.Sprintf("A batch may hold at most %d operations", maxBatchOperations))
+		return
+	}
+
+	workspace, ok := activeWorkspace(w, r, userID)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	scope := batchScope{workspace: workspace, userID: userID}
+	results := make([]batchResult, len(req.Operations))
+	for i, op := range req.Operations {
+		results[i] = batchResult{Index: i, Op: op.Op, ID: op.ID}
+	}
+
+	var failed int
+	if req.Atomic {
+		failed = runAtomicBatch(ctx, scope, req.Operations, results)
+	} else {
+		for i := range req.Operations {
+			if !applyBatchOperation(ctx, scope, &req.Operations[i], &results[i]) {
+				failed++
+			}
+		}
+	}
+
+	// Count and announce writes once they are permanent
+	for _, result := range results {
+		if result.Status == batchStatusOK {
+			metrics.ArticleOperations.WithLabelValues(result.Op).Inc()
+			publishBatchResult(ctx, &result, workspace.ID, userID)
+		}
+	}
+
+	slog.InfoContext(ctx, "applied article batch", "operations", len(results), "failed", failed, "atomic", req.Atomic)
+
+	status := http.StatusOK
+	message := fmt.Sprintf("Applied %d of %d operations", len(results)-failed, len(results))
+	if req.Atomic && failed > 0 {
+		// Nothing was applied
+		status = http.StatusConflict
+		message = "The batch was rolled back because an operation failed"
+	}
+	utils.SendJSONResponse(w, status, map[string]interface{}{
+		"atomic":    req.Atomic,
+		"results":   results,
+		"succeeded": len(results) - failed,
+		"failed":    failed,
+		"message":   message,
+	})
+}
+
+// runAtomicBatch applies the operations in one transaction, stopping at the first failure.
+// It returns the number of operations that did not take effect.
+func runAtomicBatch(ctx context.Context, scope batchScope, operations []batchOperation, results []batchResult) int {
+	failedAt := -1
+	err := utils.InTransaction(ctx, func(ctx context.Context) error {
+		for i := range operations {
+			if !applyBatchOperation(ctx, scope, &operations[i], &results[i]) {
+				failedAt = i
+				return errors.New(results[i].Error)
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		return 0
+	}
+
+	for i := range results {
+		switch {
+		case failedAt < 0:
+			// The transaction itself failed, e.g. on commit
+			results[i].Status = batchStatusError
+			results[i].StatusCode = http.StatusInternalServerError
+			results[i].Error = "Failed to apply the batch"
+		case i < failedAt:
+			results[i].Status = batchStatusRolledBack
+			results[i].StatusCode = 0
+			results[i].Article = nil
+			if results[i].Op == batchCreate {
+				results[i].ID = 0
+			}
+		case i > failedAt:
+			results[i].Status = batchStatusSkipped
+		}
+	}
+	if failedAt < 0 {
+		slog.ErrorContext(ctx, "failed to apply article batch", "error", err)
+	}
+	return len(results)
+}
+
+// publishBatchResult sends the change event of an applied operation
+func publishBatchResult(ctx context.Context, result *batchResult, workspaceID, userID int) {
+	switch {
+	case result.Op == batchDelete:
+		publishArticleEvent(ctx, events.ArticleDeleted, result.ID, workspaceID, 0, userID)
+	case result.Article == nil:
+	case result.Op == batchCreate:
+		publishArticleEvent(ctx, events.ArticleCreated, result.ID, result.Article.WorkspaceID, result.Article.Version, userID)
+	default:
+		publishArticleEvent(ctx, events.ArticleUpdated, result.ID, result.Article.WorkspaceID, result.Article.Version, userID)
+	}
+}
+
+// applyBatchOperation runs one operation and fills in its result, reporting success
+func applyBatchOperation(ctx context.Context, scope batchScope, op *batchOperation, result *batchResult) bool {
+	article, code, err := runBatchOperation(ctx, scope, op)
+	if err != nil {
+		var opErr *batchError
+		if !errors.As(err, &opErr) {
+			slog.ErrorContext(ctx, "failed to apply batch operation", "op", op.Op, "article_id", op.ID, "error", err)
+			opErr = &batchError{http.StatusInternalServerError, "Database error"}
+		}
+		result.Status = batchStatusError
+		result.StatusCode = opErr.code
+		result.Error = opErr.message
+		return false
+	}
+
+	result.Status = batchStatusOK
+	result.StatusCode = code
+	result.Article = article
+	if article != nil {
+		result.ID = article.ID
+	}
+	return true
+}
+
+// runBatchOperation validates and applies one operation with the same rules and
+// permission checks as the single-article endpoints
+func runBatchOperation(ctx context.Context, scope batchScope, op *batchOperation) (*models.Article, int, error) {
+	workspaceID, userID := scope.workspace.ID, scope.userID
+	if op.ContentFormat != "" && !markdown.ValidFormat(op.ContentFormat) {
+		return nil, 0, &batchError{http.StatusBadRequest, "content_format must be plain or markdown"}
+	}
+
+	switch op.Op {
+	case batchCreate:
+		if op.Title == "" {
+			return nil, 0, &batchError{http.StatusBadRequest, "Title is required"}
+		}
+		if scope.workspace.Role == models.WorkspaceGuest {
+			return nil, 0, &batchError{http.StatusForbidden, "Guests cannot create articles in this workspace"}
+		}
+		format := op.ContentFormat
+		if format == "" {
+			format = markdown.FormatPlain
+		}
+		id, err := utils.CreateArticle(ctx, workspaceID, userID, op.Title, op.Content, format)
+		if err != nil {
+			return nil, 0, err
+		}
+		article, err := utils.GetArticleByID(ctx, workspaceID, id, userID)
+		return article, http.StatusCreated, err
+
+	case batchUpdate:
+		if op.ID <= 0 {
+			return nil, 0, &batchError{http.StatusBadRequest, "Article ID is required"}
+		}
+		if op.Title == "" {
+			return nil, 0, &batchError{http.StatusBadRequest, "Title is required"}
+		}
+		if op.Content == "" {
+			return nil, 0, &batchError{http.StatusBadRequest, "Content is required"}
+		}
+		if err := utils.UpdateArticle(ctx, workspaceID, op.ID, userID, op.Title, op.Content, op.ContentFormat); err != nil {
+			if strings.Contains(err.Error(), "not found") {
+				return nil, 0, &batchError{http.StatusForbidden, "Article not found or you don't have permission to update it"}
+			}
+			return nil, 0, err
+		}
+		article, err := utils.GetArticleByID(ctx, workspaceID, op.ID, userID)
+		return article, http.StatusOK, err
+
+	case batchDelete:
+		if op.ID <= 0 {
+			return nil, 0, &batchError{http.StatusBadRequest, "Article ID is required"}
+		}
+		if err := utils.DeleteArticle(ctx, workspaceID, op.ID, userID); err != nil {
+			if strings.Contains(err.Error(), "not found") {
+				return nil, 0, &batchError{http.StatusForbidden, "Article not found or you don't have permission to delete it"}
+			}
+			return nil, 0, err
+		}
+		return nil, http.StatusOK, nil
+
+	default:
+		return nil, 0, &batchError{http.StatusBadRequest, fmt.Sprintf("Unknown operation %q, expected create, update or delete", op.Op)}
+	}
+}
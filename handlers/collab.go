@@ -0,0 +1,668 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"personalnote.eu/simple-go-api/collab"
+	"personalnote.eu/simple-go-api/events"
+	"personalnote.eu/simple-go-api/models"
+	"personalnote.eu/simple-go-api/utils"
+)
+
+const (
+	// collabProtocol is the WebSocket subprotocol spoken on /article/{id}/collab
+	collabProtocol = "collab.v1"
+	// collabTokenPrefix marks a token offered as a subprotocol by browsers, which cannot
+	// set the Authorization header on WebSocket requests
+	collabTokenPrefix = "bearer."
+
+	collabWriteWait    = 10 * time.Second
+	collabPongWait     = 60 * time.Second
+	collabPingInterval = 25 * time.Second
+	// collabSendBuffer is how many messages a client may fall behind before it is dropped
+	collabSendBuffer = 256
+	// collabMaxMessage bounds a client message; an operation may insert a whole article
+	collabMaxMessage = 8 * maxArticleBytes
+)
+
+// Close codes beyond the WebSocket ones
+const (
+	// collabCloseResync asks the client to reconnect and start over from the current text
+	collabCloseResync = 4000
+	// collabCloseDeleted ends sessions of an article that was deleted
+	collabCloseDeleted = 4004
+)
+
+var collabUpgrader = websocket.Upgrader{
+	Subprotocols: []string{collabProtocol},
+	// WithCORS has already turned away origins that are not allowed
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+var (
+	collabMu sync.Mutex
+	// collabSessions are the open editing sessions by article ID
+	collabSessions = make(map[int]*collabSession)
+)
+
+// collabSelection is a cursor or selected range, in code points
+type collabSelection struct {
+	Anchor int `json:"anchor"`
+	Head   int `json:"head"`
+}
+
+// collabPeer describes a connected client to the others
+type collabPeer struct {
+	ClientID  string           `json:"client_id"`
+	UserID    int              `json:"user_id"`
+	Name      string           `json:"name"`
+	CanEdit   bool             `json:"can_edit"`
+	Selection *collabSelection `json:"selection"`
+}
+
+// collabMessage is a message from a client
+type collabMessage struct {
+	Type      string           `json:"type"`
+	Revision  int              `json:"revision"`
+	Op        collab.Operation `json:"op"`
+	Selection *collabSelection `json:"selection"`
+}
+
+// collabClient is one connection to a session. Its fields other than conn and send are
+// guarded by the session mutex.
+type collabClient struct {
+	collabPeer
+	conn *websocket.Conn
+	send chan []byte
+	// closeCode and closeReason are sent in the close frame once send is closed
+	closeCode   int
+	closeReason string
+}
+
+// writeLoop delivers queued messages and pings until send is closed or writing fails
+func (c *collabClient) writeLoop() {
+	ping := time.NewTicker(collabPingInterval)
+	defer func() {
+		ping.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case msg, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(collabWriteWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(c.closeCode, c.closeReason))
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(collabWriteWait)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// collabSession holds the authoritative text of an article while clients edit it and
+// writes it back to the article periodically. Only its run goroutine touches the database.
+type collabSession struct {
+	articleID   int
+	workspaceID int
+
+	mu      sync.Mutex
+	doc     *collab.Document
+	clients map[*collabClient]struct{}
+	// version is the article version the saved text belongs to
+	version int
+	// saved is the text last read from or written to the article, at savedRevision of doc;
+	// savedRevision is -1 when that text is not a state doc went through
+	saved         []rune
+	savedRevision int
+	dirty         bool
+	lastEditor    int
+	closed        bool
+
+	// changed wakes run up to merge an update made outside the session
+	changed chan struct{}
+	// empty wakes run up to save and close once the last client left
+	empty chan struct{}
+}
+
+func newCollabSession(article *models.Article) *collabSession {
+	return &collabSession{
+		articleID:   article.ID,
+		workspaceID: article.WorkspaceID,
+		doc:         collab.NewDocument(article.Content, appConfig.Collab.History, maxArticleBytes),
+		clients:     make(map[*collabClient]struct{}),
+		version:     article.Version,
+		saved:       []rune(article.Content),
+		changed:     make(chan struct{}, 1),
+		empty:       make(chan struct{}, 1),
+	}
+}
+
+// ArticleCollabHandler handles GET /article/{id}/collab, a WebSocket for editing the article
+// together with others. Everyone who can read the article may join and share their cursor;
+// editing needs the editor or owner role. The text is saved back to the article every
+// snapshot interval while there are unsaved edits and when the last client leaves.
+func ArticleCollabHandler(w http.ResponseWriter, r *http.Request) {
+	if !utils.ValidateHTTPMethod(w, r, http.MethodGet) {
+		return
+	}
+	if !websocket.IsWebSocketUpgrade(r) {
+		utils.SendErrorResponse(w, http.StatusUpgradeRequired,
+			"Upgrade required", "Connect with a WebSocket to edit an article collaboratively")
+		return
+	}
+
+	if r.Header.Get("Authorization") == "" {
+		for _, protocol := range websocket.Subprotocols(r) {
+			if token, ok := strings.CutPrefix(protocol, collabTokenPrefix); ok {
+				r.Header.Set("Authorization", "Bearer "+token)
+			}
+		}
+	}
+	userID, authenticated := checkAuth(w, r)
+	if !authenticated {
+		return
+	}
+
+	// Expected format: /article/{id}/collab
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Invalid ID", "Article ID must be a valid integer")
+		return
+	}
+
+	article, ok := loadArticle(w, r, id, userID)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	user, err := utils.GetUserByID(ctx, userID)
+	if err != nil {
+		utils.SendErrorResponse(w, http.StatusInternalServerError,
+			"Database error", "Failed to retrieve user")
+		return
+	}
+
+	// The upgrader answers failed handshakes itself
+	conn, err := collabUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.DebugContext(ctx, "collaboration handshake failed", "error", err)
+		return
+	}
+
+	client := &collabClient{
+		collabPeer: collabPeer{
+			ClientID: newUploadID(),
+			UserID:   userID,
+			Name:     user.Name,
+			CanEdit:  article.Role != models.RoleViewer,
+		},
+		conn: conn,
+		send: make(chan []byte, collabSendBuffer),
+	}
+	go client.writeLoop()
+
+	session := joinCollabSession(article, client)
+	slog.InfoContext(ctx, "joined collaboration session", "article_id", id, "client_id", client.ClientID)
+	session.readLoop(client)
+	session.leave(client, websocket.CloseNormalClosure, "")
+	slog.InfoContext(ctx, "left collaboration session", "article_id", id, "client_id", client.ClientID)
+}
+
+// joinCollabSession adds the client to the article's session, opening one if needed
+func joinCollabSession(article *models.Article, client *collabClient) *collabSession {
+	collabMu.Lock()
+	defer collabMu.Unlock()
+
+	session := collabSessions[article.ID]
+	if session == nil {
+		session = newCollabSession(article)
+		collabSessions[article.ID] = session
+		utils.Go(fmt.Sprintf("collab-%d", article.ID), session.run)
+	}
+	session.join(client)
+	return session
+}
+
+// notifyCollabSession lets an open session pick up a change made through other endpoints
+func notifyCollabSession(eventType string, articleID, version int) {
+	collabMu.Lock()
+	session := collabSessions[articleID]
+	collabMu.Unlock()
+	if session == nil {
+		return
+	}
+
+	session.mu.Lock()
+	stale := eventType == events.ArticleDeleted || version > session.version
+	session.mu.Unlock()
+	if stale {
+		select {
+		case session.changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *collabSession) join(client *collabClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peers := make([]collabPeer, 0, len(s.clients))
+	for c := range s.clients {
+		peers = append(peers, c.collabPeer)
+	}
+	s.clients[client] = struct{}{}
+
+	s.sendTo(client, map[string]interface{}{
+		"type":       "init",
+		"client_id":  client.ClientID,
+		"article_id": s.articleID,
+		"version":    s.version,
+		"revision":   s.doc.Revision(),
+		"content":    s.doc.Text(),
+		"can_edit":   client.CanEdit,
+		"peers":      peers,
+	})
+	s.broadcast(client, map[string]interface{}{
+		"type": "join",
+		"peer": client.collabPeer,
+	})
+}
+
+// leave removes the client and closes its connection with the given code
+func (s *collabSession) leave(client *collabClient, code int, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.drop(client, code, reason)
+}
+
+// drop removes a client; s.mu must be held
+func (s *collabSession) drop(client *collabClient, code int, reason string) {
+	if _, ok := s.clients[client]; !ok {
+		return
+	}
+	delete(s.clients, client)
+	client.closeCode = code
+	client.closeReason = reason
+	close(client.send)
+
+	s.broadcast(nil, map[string]interface{}{
+		"type":      "leave",
+		"client_id": client.ClientID,
+	})
+	if len(s.clients) == 0 {
+		select {
+		case s.empty <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// sendTo queues a message for one client, dropping it when it cannot keep up; s.mu must be held
+func (s *collabSession) sendTo(client *collabClient, msg interface{}) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		slog.Error("failed to encode collaboration message", "error", err)
+		return
+	}
+	s.queue(client, data)
+}
+
+// broadcast queues a message for every client except one; s.mu must be held
+func (s *collabSession) broadcast(except *collabClient, msg interface{}) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		slog.Error("failed to encode collaboration message", "error", err)
+		return
+	}
+	for c := range s.clients {
+		if c != except {
+			s.queue(c, data)
+		}
+	}
+}
+
+func (s *collabSession) queue(client *collabClient, data []byte) {
+	if _, ok := s.clients[client]; !ok {
+		return
+	}
+	select {
+	case client.send <- data:
+	default:
+		s.drop(client, collabCloseResync, "Too far behind")
+	}
+}
+
+// readLoop handles the client's messages until the connection fails or closes
+func (s *collabSession) readLoop(client *collabClient) {
+	conn := client.conn
+	conn.SetReadLimit(collabMaxMessage)
+	conn.SetReadDeadline(time.Now().Add(collabPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(collabPongWait))
+	})
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(collabPongWait))
+
+		var msg collabMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			s.fail(client, fmt.Sprintf("Invalid message: %v", err))
+			continue
+		}
+		switch msg.Type {
+		case "op":
+			s.edit(client, msg.Revision, msg.Op)
+		case "selection":
+			s.selection(client, msg.Revision, msg.Selection)
+		default:
+			s.fail(client, fmt.Sprintf("Unknown message type %q", msg.Type))
+		}
+	}
+}
+
+// fail tells the client its last message was rejected
+func (s *collabSession) fail(client *collabClient, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sendTo(client, map[string]interface{}{
+		"type":    "error",
+		"message": message,
+	})
+}
+
+// edit applies an operation the client made against revision and forwards it
+func (s *collabSession) edit(client *collabClient, revision int, op collab.Operation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.clients[client]; !ok {
+		return
+	}
+	if !client.CanEdit {
+		s.sendTo(client, map[string]interface{}{
+			"type":    "error",
+			"message": "You can view this article but not edit it",
+		})
+		return
+	}
+
+	applied, newRevision, err := s.doc.Apply(revision, op)
+	switch {
+	case errors.Is(err, collab.ErrTooLarge):
+		s.sendTo(client, map[string]interface{}{
+			"type":     "error",
+			"message":  fmt.Sprintf("Articles cannot be longer than %d bytes", maxArticleBytes),
+			"revision": revision,
+		})
+		// The client has applied the edit locally, so it has to start over
+		s.drop(client, collabCloseResync, "Edit rejected")
+		return
+	case err != nil:
+		// Out of step with the session, for example after missing too many edits
+		s.drop(client, collabCloseResync, err.Error())
+		return
+	}
+
+	s.dirty = true
+	s.lastEditor = client.UserID
+	s.transformSelections(applied)
+
+	s.sendTo(client, map[string]interface{}{
+		"type":     "ack",
+		"revision": newRevision,
+	})
+	s.broadcast(client, map[string]interface{}{
+		"type":      "op",
+		"revision":  newRevision,
+		"op":        applied,
+		"client_id": client.ClientID,
+		"user_id":   client.UserID,
+	})
+}
+
+// selection records where the client's cursor is, as of revision, and shows it to the others
+func (s *collabSession) selection(client *collabClient, revision int, selection *collabSelection) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.clients[client]; !ok {
+		return
+	}
+	if selection != nil {
+		// Selections made against an older text follow the edits made since
+		missed, err := s.doc.Since(revision)
+		if err != nil {
+			return
+		}
+		for _, op := range missed {
+			selection.Anchor = collab.TransformIndex(selection.Anchor, op)
+			selection.Head = collab.TransformIndex(selection.Head, op)
+		}
+		selection.Anchor = min(max(selection.Anchor, 0), s.doc.Len())
+		selection.Head = min(max(selection.Head, 0), s.doc.Len())
+	}
+	client.Selection = selection
+
+	s.broadcast(client, map[string]interface{}{
+		"type":      "selection",
+		"revision":  s.doc.Revision(),
+		"client_id": client.ClientID,
+		"selection": selection,
+	})
+}
+
+// transformSelections keeps the stored cursors on the same text; s.mu must be held
+func (s *collabSession) transformSelections(op collab.Operation) {
+	for c := range s.clients {
+		if c.Selection == nil {
+			continue
+		}
+		moved := collabSelection{
+			Anchor: collab.TransformIndex(c.Selection.Anchor, op),
+			Head:   collab.TransformIndex(c.Selection.Head, op),
+		}
+		c.Selection = &moved
+	}
+}
+
+// run saves the text periodically and merges outside updates until the session ends
+func (s *collabSession) run(ctx context.Context) {
+	ticker := time.NewTicker(appConfig.Collab.SnapshotInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Save what is there before the database goes away
+			saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), collabWriteWait)
+			s.save(saveCtx)
+			cancel()
+			s.close(websocket.CloseGoingAway, "The server is shutting down")
+			return
+		case <-s.changed:
+			s.merge(ctx)
+			s.save(ctx)
+		case <-ticker.C:
+			s.save(ctx)
+		case <-s.empty:
+			s.save(ctx)
+		}
+		if s.closeIfIdle() {
+			return
+		}
+	}
+}
+
+// save writes unsaved edits to the article. An update made meanwhile through other
+// endpoints is merged in first.
+func (s *collabSession) save(ctx context.Context) {
+	s.mu.Lock()
+	if !s.dirty || s.closed {
+		s.mu.Unlock()
+		return
+	}
+	text := s.doc.Runes()
+	revision := s.doc.Revision()
+	version := s.version
+	actorID := s.lastEditor
+	s.mu.Unlock()
+
+	err := utils.SaveArticleContent(ctx, s.articleID, version, string(text))
+	if err != nil && strings.Contains(err.Error(), "changed") {
+		s.merge(ctx)
+		s.mu.Lock()
+		text = s.doc.Runes()
+		revision = s.doc.Revision()
+		version = s.version
+		s.mu.Unlock()
+		err = utils.SaveArticleContent(ctx, s.articleID, version, string(text))
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			s.close(collabCloseDeleted, "The article was deleted")
+			return
+		}
+		// Try again with the next snapshot
+		slog.ErrorContext(ctx, "failed to save collaboration snapshot", "article_id", s.articleID, "error", err)
+		return
+	}
+
+	s.mu.Lock()
+	s.version = version + 1
+	s.saved = text
+	s.savedRevision = revision
+	s.dirty = s.doc.Revision() != revision
+	s.broadcast(nil, map[string]interface{}{
+		"type":     "saved",
+		"version":  s.version,
+		"revision": revision,
+	})
+	s.mu.Unlock()
+
+	slog.DebugContext(ctx, "saved collaboration snapshot", "article_id", s.articleID, "version", version+1)
+	publishArticleEvent(ctx, events.ArticleUpdated, s.articleID, s.workspaceID, version+1, actorID)
+}
+
+// merge folds an update made through other endpoints into the session. The change since the
+// last saved text is applied as an edit made at that point, so it combines with edits made
+// in the session since.
+func (s *collabSession) merge(ctx context.Context) {
+	content, version, err := utils.GetArticleContent(ctx, s.articleID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			s.close(collabCloseDeleted, "The article was deleted")
+		} else {
+			slog.ErrorContext(ctx, "failed to reload article for collaboration", "article_id", s.articleID, "error", err)
+		}
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if version <= s.version {
+		return
+	}
+
+	target := []rune(content)
+	applied, revision, err := s.doc.Apply(s.savedRevision, collab.Diff(s.saved, target))
+	if err != nil {
+		// The edits since the last save are no longer kept; the update wins where they overlap
+		applied, revision, err = s.doc.Apply(s.doc.Revision(), collab.Diff(s.doc.Runes(), target))
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to merge article update", "article_id", s.articleID, "error", err)
+		return
+	}
+
+	s.version = version
+	s.saved = target
+	if s.doc.Text() == content {
+		s.savedRevision = revision
+		s.dirty = false
+	} else {
+		s.savedRevision = -1
+		s.dirty = true
+	}
+	if applied.IsNoop() {
+		return
+	}
+
+	s.transformSelections(applied)
+	s.broadcast(nil, map[string]interface{}{
+		"type":     "op",
+		"revision": revision,
+		"op":       applied,
+	})
+}
+
+// closeIfIdle ends the session once everyone left and everything is saved
+func (s *collabSession) closeIfIdle() bool {
+	collabMu.Lock()
+	defer collabMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return true
+	}
+	if len(s.clients) > 0 || s.dirty {
+		return false
+	}
+	s.closed = true
+	delete(collabSessions, s.articleID)
+	return true
+}
+
+// close disconnects every client and ends the session
+func (s *collabSession) close(code int, reason string) {
+	collabMu.Lock()
+	s.mu.Lock()
+	s.closed = true
+	if collabSessions[s.articleID] == s {
+		delete(collabSessions, s.articleID)
+	}
+	clients := make([]*collabClient, 0, len(s.clients))
+	for c := range s.clients {
+		delete(s.clients, c)
+		c.closeCode = code
+		c.closeReason = reason
+		close(c.send)
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+	collabMu.Unlock()
+
+	// Say goodbye right away rather than through the writers, which may not get to run
+	// before the process exits on shutdown
+	message := websocket.FormatCloseMessage(code, reason)
+	for _, c := range clients {
+		c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(collabWriteWait))
+	}
+}
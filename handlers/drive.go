@@ -0,0 +1,363 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+	"personalnote.eu/simple-go-api/config"
+	"personalnote.eu/simple-go-api/models"
+	"personalnote.eu/simple-go-api/storage"
+	"personalnote.eu/simple-go-api/utils"
+)
+
+// driveStateTTL is how long a user has to finish the Drive consent screen
+const driveStateTTL = 10 * time.Minute
+
+// googleRevokeURL revokes an OAuth token at Google
+const googleRevokeURL = "https://oauth2.googleapis.com/revoke"
+
+var (
+	// driveOAuthConfig requests the drive.file scope on top of the sign-in scopes
+	driveOAuthConfig *oauth2.Config
+	// tokenCipher encrypts the refresh tokens of connected Drives
+	tokenCipher *utils.TokenCipher
+)
+
+// userDriveStores caches one store per connected user so clients and access tokens are reused
+var userDriveStores = struct {
+	sync.Mutex
+	stores map[int]*storage.DriveStore
+}{stores: map[int]*storage.DriveStore{}}
+
+// initDriveConnect prepares the incremental OAuth flow for users linking their own Drive
+func initDriveConnect(cfg *config.Config) {
+	driveOAuthConfig = &oauth2.Config{
+		ClientID:     cfg.Auth.GoogleClientID,
+		ClientSecret: cfg.Auth.GoogleClientSecret.Value(),
+		RedirectURL:  cfg.Drive.ConnectRedirectURL,
+		Scopes:       []string{drive.DriveFileScope},
+		Endpoint:     google.Endpoint,
+	}
+
+	key := cfg.Drive.TokenEncryptionKey.Value()
+	if key == "" {
+		key = cfg.Auth.JWTSecret.Value()
+	}
+	cipher, err := utils.NewTokenCipher(key)
+	if err != nil {
+		slog.Error("failed to initialize token encryption, Drive connections are disabled", "error", err)
+		return
+	}
+	tokenCipher = cipher
+}
+
+// DriveConnectHandler returns the Google consent URL for linking the user's own Drive.
+// Only drive.file is requested, so the app sees just the files it creates.
+func DriveConnectHandler(w http.ResponseWriter, r *http.Request) {
+	if !utils.ValidateHTTPMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	userID, authenticated := checkAuth(w, r)
+	if !authenticated {
+		return
+	}
+
+	if driveOAuthConfig.ClientID == "" || tokenCipher == nil {
+		utils.SendErrorResponse(w, http.StatusInternalServerError,
+			"Configuration error", "Google OAuth is not configured")
+		return
+	}
+
+	opts := []oauth2.AuthCodeOption{
+		oauth2.AccessTypeOffline,
+		// Force the consent screen so Google issues a refresh token every time
+		oauth2.ApprovalForce,
+		oauth2.SetAuthURLParam("include_granted_scopes", "true"),
+	}
+	if user, err := utils.GetUserByID(r.Context(), userID); err == nil && user.Email != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("login_hint", user.Email))
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"url": driveOAuthConfig.AuthCodeURL(signDriveState(userID, time.Now().Add(driveStateTTL)), opts...),
+	})
+}
+
+// DriveCallbackHandler completes the Drive consent, creates the app folder in the
+// user's Drive and stores the encrypted refresh token
+func DriveCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	ctx := r.Context()
+
+	userID, err := verifyDriveState(query.Get("state"))
+	if err != nil {
+		slog.WarnContext(ctx, "invalid drive connect state", "error", err)
+		utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid request", "Invalid or expired state")
+		return
+	}
+
+	if reason := query.Get("error"); reason != "" {
+		slog.InfoContext(ctx, "drive connection declined", "user_id", userID, "reason", reason)
+		redirectDriveResult(w, r, "denied")
+		return
+	}
+	if tokenCipher == nil {
+		redirectDriveResult(w, r, "error")
+		return
+	}
+
+	token, err := driveOAuthConfig.Exchange(ctx, query.Get("code"))
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to exchange drive token", "user_id", userID, "error", err)
+		redirectDriveResult(w, r, "error")
+		return
+	}
+	if granted, _ := token.Extra("scope").(string); !strings.Contains(granted, drive.DriveFileScope) {
+		slog.InfoContext(ctx, "drive scope not granted", "user_id", userID)
+		redirectDriveResult(w, r, "denied")
+		return
+	}
+	if token.RefreshToken == "" {
+		slog.ErrorContext(ctx, "drive consent returned no refresh token", "user_id", userID)
+		redirectDriveResult(w, r, "error")
+		return
+	}
+
+	client, err := storage.NewDriveClientWithOptions(ctx, "",
+		option.WithTokenSource(driveOAuthConfig.TokenSource(context.Background(), token)))
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to create user drive client", "user_id", userID, "error", err)
+		redirectDriveResult(w, r, "error")
+		return
+	}
+
+	folderID, err := client.EnsureFolder(ctx, appConfig.Drive.AppFolderName)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to prepare drive app folder", "user_id", userID, "error", err)
+		redirectDriveResult(w, r, "error")
+		return
+	}
+	email, err := client.UserEmail(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to read drive account email", "user_id", userID, "error", err)
+	}
+
+	encrypted, err := tokenCipher.Encrypt(token.RefreshToken)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to encrypt drive token", "user_id", userID, "error", err)
+		redirectDriveResult(w, r, "error")
+		return
+	}
+
+	conn := &models.DriveConnection{
+		UserID:       userID,
+		RefreshToken: encrypted,
+		FolderID:     folderID,
+		Email:        email,
+	}
+	if err := utils.SaveDriveConnection(ctx, conn); err != nil {
+		redirectDriveResult(w, r, "error")
+		return
+	}
+	forgetUserDriveStore(userID)
+
+	slog.InfoContext(ctx, "connected user drive", "user_id", userID, "folder_id", folderID)
+	redirectDriveResult(w, r, "connected")
+}
+
+// DriveStatusHandler reports whether the user has linked their own Drive
+func DriveStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if !utils.ValidateHTTPMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	userID, authenticated := checkAuth(w, r)
+	if !authenticated {
+		return
+	}
+
+	conn, err := utils.GetDriveConnection(r.Context(), userID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			utils.SendJSONResponse(w, http.StatusOK, models.DriveStatusResponse{Connected: false})
+		} else {
+			utils.SendErrorResponse(w, http.StatusInternalServerError,
+				"Database error", "Failed to retrieve Drive connection")
+		}
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, models.DriveStatusResponse{
+		Connected:   true,
+		Email:       conn.Email,
+		FolderID:    conn.FolderID,
+		ConnectedAt: conn.Updated,
+	})
+}
+
+// DriveDisconnectHandler revokes the app's access to the user's Drive and forgets the token.
+// Files already uploaded stay in the user's Drive.
+func DriveDisconnectHandler(w http.ResponseWriter, r *http.Request) {
+	if !utils.ValidateHTTPMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	userID, authenticated := checkAuth(w, r)
+	if !authenticated {
+		return
+	}
+
+	ctx := r.Context()
+	conn, err := utils.GetDriveConnection(ctx, userID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			utils.SendErrorResponse(w, http.StatusNotFound,
+				"Not connected", "No Google Drive is connected")
+		} else {
+			utils.SendErrorResponse(w, http.StatusInternalServerError,
+				"Database error", "Failed to retrieve Drive connection")
+		}
+		return
+	}
+
+	if err := utils.DeleteDriveConnection(ctx, userID); err != nil {
+		utils.SendErrorResponse(w, http.StatusInternalServerError,
+			"Database error", "Failed to disconnect Google Drive")
+		return
+	}
+	forgetUserDriveStore(userID)
+
+	// Revocation is best effort; the token is gone from our side either way
+	if refreshToken, err := tokenCipher.Decrypt(conn.RefreshToken); err == nil {
+		if err := revokeGoogleToken(ctx, refreshToken); err != nil {
+			slog.WarnContext(ctx, "failed to revoke drive token", "error", err)
+		}
+	}
+
+	slog.InfoContext(ctx, "disconnected user drive")
+	utils.SendSuccessResponse(w, "Google Drive disconnected")
+}
+
+// userDriveStore returns the store for the user's own Drive, or nil if they have not connected one
+func userDriveStore(ctx context.Context, userID int) (*storage.DriveStore, error) {
+	userDriveStores.Lock()
+	defer userDriveStores.Unlock()
+
+	if store, ok := userDriveStores.stores[userID]; ok {
+		return store, nil
+	}
+
+	conn, err := utils.GetDriveConnection(ctx, userID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if tokenCipher == nil {
+		return nil, fmt.Errorf("token encryption not initialized")
+	}
+
+	refreshToken, err := tokenCipher.Decrypt(conn.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+	tokenSource := driveOAuthConfig.TokenSource(context.Background(), &oauth2.Token{RefreshToken: refreshToken})
+	client, err := storage.NewDriveClientWithOptions(ctx, conn.FolderID, option.WithTokenSource(tokenSource))
+	if err != nil {
+		return nil, err
+	}
+
+	store := storage.NewUserDriveStore(client, conn.FolderID)
+	userDriveStores.stores[userID] = store
+	return store, nil
+}
+
+func forgetUserDriveStore(userID int) {
+	userDriveStores.Lock()
+	defer userDriveStores.Unlock()
+	delete(userDriveStores.stores, userID)
+}
+
+// redirectDriveResult sends the browser back to the frontend with the outcome of the consent
+func redirectDriveResult(w http.ResponseWriter, r *http.Request, status string) {
+	frontendURL := strings.TrimRight(appConfig.Auth.FrontendURL, "/")
+	http.Redirect(w, r, fmt.Sprintf("%s/drive/callback?status=%s", frontendURL, url.QueryEscape(status)), http.StatusTemporaryRedirect)
+}
+
+// signDriveState encodes the user and an expiry into an HMAC signed OAuth state value
+func signDriveState(userID int, expires time.Time) string {
+	nonce := make([]byte, 8)
+	rand.Read(nonce)
+	payload := fmt.Sprintf("%d.%d.%s", userID, expires.Unix(), hex.EncodeToString(nonce))
+	return payload + "." + driveStateSignature(payload)
+}
+
+// verifyDriveState checks the state signature and expiry and returns the user ID
+func verifyDriveState(state string) (int, error) {
+	i := strings.LastIndex(state, ".")
+	if i < 0 {
+		return 0, fmt.Errorf("malformed state")
+	}
+	payload, signature := state[:i], state[i+1:]
+	if !hmac.Equal([]byte(signature), []byte(driveStateSignature(payload))) {
+		return 0, fmt.Errorf("invalid state signature")
+	}
+
+	parts := strings.Split(payload, ".")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("malformed state")
+	}
+	userID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("malformed state")
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return 0, fmt.Errorf("state expired")
+	}
+	return userID, nil
+}
+
+func driveStateSignature(payload string) string {
+	mac := hmac.New(sha256.New, []byte(appConfig.Auth.JWTSecret.Value()))
+	mac.Write([]byte("drive-connect\n" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// revokeGoogleToken asks Google to invalidate a refresh token
+func revokeGoogleToken(ctx context.Context, token string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, googleRevokeURL,
+		strings.NewReader(url.Values{"token": {token}}.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("revoke returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"personalnote.eu/simple-go-api/events"
+	"personalnote.eu/simple-go-api/utils"
+)
+
+// eventRetry is the reconnect delay suggested to event stream clients
+const eventRetry = 3 * time.Second
+
+// eventReset tells a resuming client that events were missed and it has to reload
+const eventReset = "reset"
+
+// eventBus carries article changes to the /events streams
+var eventBus *events.Bus
+
+func initEvents() {
+	eventBus = events.NewBus(appConfig.Events.ReplayBuffer)
+}
+
+// CloseEventStreams ends all open event streams, so they do not hold up a shutdown
+func CloseEventStreams() {
+	if eventBus != nil {
+		eventBus.Close()
+	}
+}
+
+// publishArticleEvent notifies everyone who can access an article of a change by actorID,
+// as well as its editing session if one is open. When the audience cannot be looked up
+// only the actor is notified.
+func publishArticleEvent(ctx context.Context, eventType string, articleID, workspaceID, version, actorID int) {
+	notifyCollabSession(eventType, articleID, version)
+	if eventBus == nil {
+		return
+	}
+
+	recipients, err := utils.GetArticleAudience(ctx, articleID)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to look up article audience", "article_id", articleID, "error", err)
+		recipients = []int{actorID}
+	}
+
+	eventBus.Publish(events.Event{
+		Type:        eventType,
+		ArticleID:   articleID,
+		WorkspaceID: workspaceID,
+		Version:     version,
+		ActorID:     actorID,
+		Recipients:  recipients,
+	})
+}
+
+// EventsHandler handles GET /events, a Server-Sent Events stream of changes to the articles
+// the user can access. Clients resume with the Last-Event-ID header; when the missed events
+// are no longer buffered the stream starts with a reset event and the client should reload.
+func EventsHandler(w http.ResponseWriter, r *http.Request) {
+	if !utils.ValidateHTTPMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	userID, authenticated := checkAuth(w, r)
+	if !authenticated {
+		return
+	}
+
+	var lastID uint64
+	if raw := r.Header.Get("Last-Event-ID"); raw != "" {
+		id, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			utils.SendErrorResponse(w, http.StatusBadRequest,
+				"Invalid Last-Event-ID", "Last-Event-ID must be an event ID from this stream")
+			return
+		}
+		lastID = id
+	}
+
+	ctx := r.Context()
+	controller := http.NewResponseController(w)
+	// Streams stay open far longer than the server write timeout
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil {
+		slog.DebugContext(ctx, "could not clear write deadline for event stream", "error", err)
+	}
+
+	sub, replay, complete := eventBus.Subscribe(userID, lastID)
+	defer eventBus.Unsubscribe(sub)
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-store")
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", eventRetry.Milliseconds())
+	if !complete {
+		fmt.Fprintf(w, "id: %d\nevent: %s\ndata: {\"message\":\"Missed events are no longer available, reload the articles\"}\n\n",
+			sub.Start, eventReset)
+		replay = nil
+	}
+	for i := range replay {
+		if err := writeEvent(w, &replay[i]); err != nil {
+			return
+		}
+	}
+	if err := controller.Flush(); err != nil {
+		return
+	}
+
+	slog.DebugContext(ctx, "event stream opened", "replayed", len(replay), "complete", complete)
+
+	heartbeat := time.NewTicker(appConfig.Events.Heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case <-sub.Done():
+			return
+		case event := <-sub.Events():
+			err = writeEvent(w, &event)
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": ping\n\n")
+		}
+		if err == nil {
+			err = controller.Flush()
+		}
+		if err != nil {
+			slog.DebugContext(ctx, "event stream closed", "error", err)
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event *events.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.EventID(), event.Type, data)
+	return err
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"personalnote.eu/simple-go-api/filetype"
+	"personalnote.eu/simple-go-api/models"
+	"personalnote.eu/simple-go-api/utils"
+)
+
+// exportTimeout replaces the server write timeout while an export is streamed
+const exportTimeout = 30 * time.Minute
+
+// exportFrontMatter is the YAML header of an exported article
+type exportFrontMatter struct {
+	ID      int    `yaml:"id"`
+	Title   string `yaml:"title"`
+	Created string `yaml:"created,omitempty"`
+	Updated string `yaml:"updated,omitempty"`
+}
+
+// ArticlesExportHandler handles GET /articles/export?format=markdown and streams a ZIP
+// archive with one Markdown file per article. Articles are written as they are read from
+// the database, so the archive is never held in memory.
+func ArticlesExportHandler(w http.ResponseWriter, r *http.Request) {
+	if !utils.ValidateHTTPMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	userID, authenticated := checkAuth(w, r)
+	if !authenticated {
+		return
+	}
+
+	if format := r.URL.Query().Get("format"); format != "" && format != "markdown" {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Invalid format", "Supported export formats: markdown")
+		return
+	}
+
+	workspace, ok := activeWorkspace(w, r, userID)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(exportTimeout)); err != nil {
+		slog.DebugContext(ctx, "could not extend write deadline for export", "error", err)
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", "application/zip")
+	header.Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="articles-%s.zip"`, time.Now().UTC().Format("20060102")))
+	header.Set("Cache-Control", "no-store")
+
+	archive := zip.NewWriter(w)
+	names := make(map[string]bool)
+	count := 0
+
+	err := utils.EachArticle(ctx, workspace.ID, userID, func(article *models.Article) error {
+		if err := writeExportedArticle(archive, exportFileName(article.Title, names), article); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil {
+		// Headers are usually gone by now; a truncated archive tells the client it failed
+		slog.ErrorContext(ctx, "failed to export articles", "user_id", userID, "exported", count, "error", err)
+		if count == 0 {
+			utils.SendErrorResponse(w, http.StatusInternalServerError,
+				"Export failed", "Failed to export articles")
+		}
+		return
+	}
+
+	slog.InfoContext(ctx, "exported articles", "user_id", userID, "count", count)
+}
+
+// writeExportedArticle adds one article to the archive as Markdown with YAML front matter
+func writeExportedArticle(archive *zip.Writer, name string, article *models.Article) error {
+	meta := exportFrontMatter{ID: article.ID, Title: article.Title}
+	modified := time.Now()
+	if article.Created != nil {
+		meta.Created = article.Created.UTC().Format(time.RFC3339)
+	}
+	if article.Updated != nil {
+		meta.Updated = article.Updated.UTC().Format(time.RFC3339)
+		modified = *article.Updated
+	}
+
+	frontMatter, err := yaml.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("failed to encode front matter: %v", err)
+	}
+
+	file, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modified,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add %s to archive: %v", name, err)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("---\n")
+	buf.Write(frontMatter)
+	buf.WriteString("---\n\n")
+	buf.WriteString(article.Content)
+	if !strings.HasSuffix(article.Content, "\n") {
+		buf.WriteByte('\n')
+	}
+	if _, err := buf.WriteTo(file); err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	return nil
+}
+
+// exportFileName derives a file name from an article title that is unique within the
+// archive, case-insensitively, by appending -2, -3, ... on collisions
+func exportFileName(title string, used map[string]bool) string {
+	stem := strings.NewReplacer("/", "-", `\`, "-").Replace(strings.TrimSpace(title))
+	if stem == "" {
+		stem = "untitled"
+	}
+	stem = strings.TrimSuffix(filetype.SanitizeName(stem+".md"), ".md")
+
+	name := stem + ".md"
+	for n := 2; used[strings.ToLower(name)]; n++ {
+		name = fmt.Sprintf("%s-%d.md", stem, n)
+	}
+	used[strings.ToLower(name)] = true
+	return name
+}
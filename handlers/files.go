@@ -0,0 +1,377 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"personalnote.eu/simple-go-api/models"
+	"personalnote.eu/simple-go-api/storage"
+	"personalnote.eu/simple-go-api/utils"
+)
+
+// signedLinkExpiry is how long links returned by /files/{id}/link work
+const signedLinkExpiry = 15 * time.Minute
+
+// FilesHandler lists the files uploaded by the authenticated user
+func FilesHandler(w http.ResponseWriter, r *http.Request) {
+	if !utils.ValidateHTTPMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	userID, authenticated := checkAuth(w, r)
+	if !authenticated {
+		return
+	}
+
+	attachments, err := utils.GetAttachmentsByUser(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to fetch files", "error", err)
+		utils.SendErrorResponse(w, http.StatusInternalServerError,
+			"Database error", "Failed to retrieve files from database")
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, models.AttachmentListResponse{
+		Attachments: attachments,
+		Count:       len(attachments),
+		Message:     fmt.Sprintf("Successfully retrieved %d files", len(attachments)),
+	})
+}
+
+// FileHandler handles requests for a single file and routes file sub-resources
+func FileHandler(w http.ResponseWriter, r *http.Request) {
+	// Sub-resources: /files/{id}/{resource}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) == 3 {
+		switch parts[2] {
+		case "content":
+			FileContentHandler(w, r)
+			return
+		case "thumbnail":
+			FileThumbnailHandler(w, r)
+			return
+		case "link":
+			FileLinkHandler(w, r)
+			return
+		}
+	}
+
+	switch r.Method {
+	case http.MethodDelete:
+		DeleteFileHandler(w, r)
+	default:
+		utils.SendErrorResponse(w, http.StatusMethodNotAllowed,
+			"Method not allowed", fmt.Sprintf("Method %s is not supported for this endpoint", r.Method))
+	}
+}
+
+// DeleteFileHandler removes a file from its storage backend and deletes its record
+func DeleteFileHandler(w http.ResponseWriter, r *http.Request) {
+	userID, authenticated := checkAuth(w, r)
+	if !authenticated {
+		return
+	}
+
+	// Extract file ID from URL path
+	// Expected format: /files/{id}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 2 {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Invalid URL", "Expected format: /files/{id}")
+		return
+	}
+
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Invalid ID", "File ID must be a number")
+		return
+	}
+
+	ctx := r.Context()
+	attachment, err := utils.GetAttachmentByID(ctx, id, userID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			utils.SendErrorResponse(w, http.StatusNotFound,
+				"File not found", fmt.Sprintf("File with ID %d not found", id))
+		} else {
+			slog.ErrorContext(ctx, "failed to fetch file", "attachment_id", id, "error", err)
+			utils.SendErrorResponse(w, http.StatusInternalServerError,
+				"Database error", "Failed to retrieve file from database")
+		}
+		return
+	}
+
+	store, err := storeFor(ctx, attachment.Backend, userID)
+	switch {
+	case err != nil && attachment.Backend == storage.UserDriveBackend:
+		// The user disconnected their Drive; the file stays there under their control
+		slog.InfoContext(ctx, "removing record of file in disconnected drive", "attachment_id", id)
+	case err != nil:
+		slog.ErrorContext(ctx, "cannot delete file from unavailable backend", "attachment_id", id, "backend", attachment.Backend)
+		utils.SendErrorResponse(w, http.StatusConflict,
+			"Storage unavailable", fmt.Sprintf("Files stored in %s cannot be deleted by this server", attachment.Backend))
+		return
+	}
+
+	// A remote object that is already gone should not keep the record alive
+	if store != nil {
+		deleteThumbnails(ctx, store, id)
+		if err := store.Delete(ctx, attachment.RemoteID); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			slog.ErrorContext(ctx, "failed to delete remote file", "attachment_id", id, "file_id", attachment.RemoteID, "error", err)
+			utils.SendErrorResponse(w, http.StatusBadGateway,
+				"Storage error", "Failed to delete file from storage")
+			return
+		}
+	}
+
+	if err := utils.DeleteAttachment(ctx, id, userID); err != nil {
+		slog.ErrorContext(ctx, "failed to delete file record", "attachment_id", id, "error", err)
+		utils.SendErrorResponse(w, http.StatusInternalServerError,
+			"Database error", "Failed to delete file")
+		return
+	}
+
+	slog.InfoContext(ctx, "deleted file", "attachment_id", id, "backend", attachment.Backend)
+	utils.SendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"id":      id,
+		"message": "File deleted successfully",
+	})
+}
+
+// FileContentHandler streams a file owned by the caller from its storage backend.
+// The file is addressed by its backend ID (e.g. the Drive file ID) or attachment ID.
+// Files not scanned clean are refused. Range, If-None-Match and If-Modified-Since are honoured; ?disposition=inline|attachment
+// selects the Content-Disposition (default attachment).
+func FileContentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		utils.SendErrorResponse(w, http.StatusMethodNotAllowed,
+			"Method not allowed", "Only GET and HEAD requests are accepted")
+		return
+	}
+
+	userID, authenticated := checkAuth(w, r)
+	if !authenticated {
+		return
+	}
+
+	disposition := r.URL.Query().Get("disposition")
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	if disposition != "attachment" && disposition != "inline" {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Invalid disposition", "disposition must be inline or attachment")
+		return
+	}
+
+	// Expected format: /files/{fileId}/content
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	ref := parts[1]
+
+	ctx := r.Context()
+	attachment, err := findAttachment(ctx, ref, userID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			utils.SendErrorResponse(w, http.StatusNotFound,
+				"File not found", fmt.Sprintf("File %s not found", ref))
+		} else {
+			slog.ErrorContext(ctx, "failed to fetch file", "file_id", ref, "error", err)
+			utils.SendErrorResponse(w, http.StatusInternalServerError,
+				"Database error", "Failed to retrieve file from database")
+		}
+		return
+	}
+
+	if !checkScanned(w, attachment) {
+		return
+	}
+
+	store, err := storeFor(ctx, attachment.Backend, userID)
+	if err != nil {
+		utils.SendErrorResponse(w, http.StatusConflict,
+			"Storage unavailable", fmt.Sprintf("Files stored in %s cannot be served by this server", attachment.Backend))
+		return
+	}
+
+	serveAttachment(w, r, store, attachment, disposition)
+}
+
+// serveAttachment streams a clean attachment from store with headers that keep its content
+// from running on the API origin
+func serveAttachment(w http.ResponseWriter, r *http.Request, store storage.BlobStore, attachment *models.Attachment, disposition string) {
+	ctx := r.Context()
+
+	// Stat first so a missing remote object is reported before any bytes are written
+	info, err := store.Stat(ctx, attachment.RemoteID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			utils.SendErrorResponse(w, http.StatusNotFound,
+				"File not found", "The file no longer exists in storage")
+		} else {
+			slog.ErrorContext(ctx, "failed to stat remote file", "file_id", attachment.RemoteID, "error", err)
+			utils.SendErrorResponse(w, http.StatusBadGateway,
+				"Storage error", "Failed to read file from storage")
+		}
+		return
+	}
+	size := attachment.Size
+	if info.Size > 0 {
+		size = info.Size
+	}
+
+	contentType := attachment.MimeType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Name}))
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Cache-Control", "private, no-cache")
+	if attachment.SHA256 != "" {
+		header.Set("ETag", `"`+attachment.SHA256+`"`)
+	}
+	// Keep user supplied HTML or SVG from running scripts on the API origin
+	header.Set("Content-Security-Policy", "sandbox")
+
+	var modTime time.Time
+	if attachment.Created != nil {
+		modTime = *attachment.Created
+	}
+
+	// Large files take longer to stream than the server write timeout allows for ordinary requests
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		slog.DebugContext(ctx, "could not clear write deadline for file download", "error", err)
+	}
+
+	content := storage.NewReadSeeker(ctx, store, attachment.RemoteID, size)
+	defer content.Close()
+
+	http.ServeContent(w, r, attachment.Name, modTime, content)
+}
+
+// FileLinkHandler returns a URL that downloads a clean file without authentication until
+// it expires. Local files are served by /blobs/, S3 files through a presigned URL; Drive
+// links do not expire and follow the file's Drive permissions.
+func FileLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if !utils.ValidateHTTPMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	userID, authenticated := checkAuth(w, r)
+	if !authenticated {
+		return
+	}
+
+	// Expected format: /files/{fileId}/link
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	ref := parts[1]
+
+	ctx := r.Context()
+	attachment, err := findAttachment(ctx, ref, userID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			utils.SendErrorResponse(w, http.StatusNotFound,
+				"File not found", fmt.Sprintf("File %s not found", ref))
+		} else {
+			slog.ErrorContext(ctx, "failed to fetch file", "file_id", ref, "error", err)
+			utils.SendErrorResponse(w, http.StatusInternalServerError,
+				"Database error", "Failed to retrieve file from database")
+		}
+		return
+	}
+
+	if !checkScanned(w, attachment) {
+		return
+	}
+
+	store, err := storeFor(ctx, attachment.Backend, userID)
+	if err != nil {
+		utils.SendErrorResponse(w, http.StatusConflict,
+			"Storage unavailable", fmt.Sprintf("Files stored in %s cannot be served by this server", attachment.Backend))
+		return
+	}
+
+	expires := time.Now().Add(signedLinkExpiry).UTC()
+	link, err := store.SignedURL(ctx, attachment.RemoteID, signedLinkExpiry)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			utils.SendErrorResponse(w, http.StatusNotFound,
+				"File not found", "The file no longer exists in storage")
+		} else {
+			slog.ErrorContext(ctx, "failed to sign file link", "file_id", attachment.RemoteID, "error", err)
+			utils.SendErrorResponse(w, http.StatusBadGateway,
+				"Storage error", "Failed to create a link to the file")
+		}
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"id":      attachment.ID,
+		"url":     link,
+		"expires": expires,
+	})
+}
+
+// findAttachment looks a file up by backend ID, falling back to the numeric attachment ID
+func findAttachment(ctx context.Context, ref string, userID int) (*models.Attachment, error) {
+	attachment, err := utils.GetAttachmentByRemoteID(ctx, ref, userID)
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		return attachment, err
+	}
+
+	id, convErr := strconv.Atoi(ref)
+	if convErr != nil {
+		return nil, err
+	}
+	return utils.GetAttachmentByID(ctx, id, userID)
+}
+
+// ArticleAttachmentsHandler lists the files linked to an article
+func ArticleAttachmentsHandler(w http.ResponseWriter, r *http.Request) {
+	if !utils.ValidateHTTPMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	userID, authenticated := checkAuth(w, r)
+	if !authenticated {
+		return
+	}
+
+	// Expected format: /article/{id}/attachments
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Invalid ID", "Article ID must be a valid integer")
+		return
+	}
+
+	ctx := r.Context()
+	if _, ok := loadArticle(w, r, id, userID); !ok {
+		return
+	}
+
+	attachments, err := utils.GetAttachmentsByArticle(ctx, id, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to fetch article attachments", "article_id", id, "error", err)
+		utils.SendErrorResponse(w, http.StatusInternalServerError,
+			"Database error", "Failed to retrieve attachments from database")
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, models.AttachmentListResponse{
+		Attachments: attachments,
+		Count:       len(attachments),
+		Message:     fmt.Sprintf("Successfully retrieved %d attachments", len(attachments)),
+	})
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"personalnote.eu/simple-go-api/config"
+	"personalnote.eu/simple-go-api/filetype"
+)
+
+// fileTypeError reports an upload whose type is not accepted
+type fileTypeError struct {
+	message string
+}
+
+func (e *fileTypeError) Error() string {
+	return e.message
+}
+
+// fileTypes is the outcome of checkFileType
+type fileTypes struct {
+	// Effective is the type the file is stored and served as
+	Effective string
+	Declared  string
+	Detected  string
+}
+
+// checkFileType detects the type of content from its bytes, applies the configured
+// allow and deny lists and the mismatch policy, and picks the type the file is stored
+// under. content is rewound afterwards. Refused files yield a *fileTypeError.
+func checkFileType(ctx context.Context, name, declared string, content io.ReadSeeker) (*fileTypes, error) {
+	header := make([]byte, filetype.SniffLen)
+	n, err := io.ReadFull(content, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, fmt.Errorf("failed to read upload: %v", err)
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind upload: %v", err)
+	}
+
+	types := &fileTypes{
+		Declared: filetype.Base(declared),
+		Detected: filetype.Detect(header[:n]),
+	}
+	claimed, err := checkDeclaredFileType(name, declared)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTypeLists(types.Detected, false); err != nil {
+		return nil, err
+	}
+
+	types.Effective = claimed
+	if types.Effective == "" {
+		types.Effective = types.Detected
+	}
+
+	if !filetype.Compatible(claimed, types.Detected) {
+		switch appConfig.FileTypes.MismatchPolicy {
+		case config.MismatchDetected:
+			types.Effective = types.Detected
+		case config.MismatchDeclared:
+		default:
+			return nil, &fileTypeError{fmt.Sprintf("File content (%s) does not match its declared type (%s)", types.Detected, claimed)}
+		}
+		slog.WarnContext(ctx, "upload content does not match declared type",
+			"file_name", name, "declared", claimed, "detected", types.Detected, "stored_as", types.Effective)
+	}
+
+	if err := checkTypeLists(types.Effective, true); err != nil {
+		return nil, err
+	}
+	return types, nil
+}
+
+// checkDeclaredFileType checks the type a client claims for a file, from the declared
+// type or else the file name extension, before any content is seen. It returns the
+// claimed type, or "" when the client gave no usable type.
+func checkDeclaredFileType(name, declared string) (string, error) {
+	claimed := filetype.Base(declared)
+	if claimed == filetype.Octet {
+		claimed = ""
+	}
+
+	extension := filetype.FromExtension(name)
+	if claimed == "" {
+		claimed = extension
+	}
+
+	if extension != "" {
+		if err := checkTypeLists(extension, false); err != nil {
+			return "", err
+		}
+	}
+	if claimed != "" {
+		if err := checkTypeLists(claimed, true); err != nil {
+			return "", err
+		}
+	}
+	return claimed, nil
+}
+
+// checkTypeLists refuses denied types and, when allowed is set, types missing from the allow list
+func checkTypeLists(typ string, allowed bool) error {
+	if filetype.MatchAny(appConfig.FileTypes.Denied, typ) {
+		return &fileTypeError{fmt.Sprintf("Files of type %s are not allowed", typ)}
+	}
+	if allowed && len(appConfig.FileTypes.Allowed) > 0 && !filetype.MatchAny(appConfig.FileTypes.Allowed, typ) {
+		return &fileTypeError{fmt.Sprintf("Files of type %s are not accepted", typ)}
+	}
+	return nil
+}
@@ -3,13 +3,15 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 
-	"github.com/golang-jwt/jwt/v5"
+	"personalnote.eu/simple-go-api/events"
+	"personalnote.eu/simple-go-api/logging"
+	"personalnote.eu/simple-go-api/markdown"
+	"personalnote.eu/simple-go-api/metrics"
 	"personalnote.eu/simple-go-api/middleware"
 	"personalnote.eu/simple-go-api/models"
 	"personalnote.eu/simple-go-api/utils"
@@ -31,28 +33,14 @@ func checkAuth(w http.ResponseWriter, r *http.Request) (int, bool) {
 		return 0, false
 	}
 
-	tokenString := parts[1]
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Println("JWT_SECRET not set")
-		http.Error(w, `{"error":"Server configuration error"}`, http.StatusInternalServerError)
-		return 0, false
-	}
-
-	claims := &middleware.Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method")
-		}
-		return []byte(jwtSecret), nil
-	})
-
-	if err != nil || !token.Valid {
-		log.Printf("Invalid token: %v", err)
+	claims, err := middleware.ParseToken(parts[1])
+	if err != nil {
+		slog.WarnContext(r.Context(), "invalid token", "error", err)
 		http.Error(w, `{"error":"Invalid or expired token"}`, http.StatusUnauthorized)
 		return 0, false
 	}
 
+	logging.SetUserID(r.Context(), claims.UserID)
 	return claims.UserID, true
 }
 
@@ -63,9 +51,7 @@ func HelloHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Counter", strconv.Itoa(counter))
 
 	resp := models.Response{Message: "Hallo, from Go!"}
-	log.Printf("Received request #%d", counter)
-	log.Printf("Query params: %v", r.URL.Query())
-	log.Printf("URI: %v", r.RequestURI)
+	slog.DebugContext(r.Context(), "hello request", "counter", counter)
 
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -82,12 +68,15 @@ func ArticlesHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		log.Printf("📚 Fetching articles for user %d from database", userID)
+		workspace, ok := activeWorkspace(w, r, userID)
+		if !ok {
+			return
+		}
 
-		// Get articles from database for this user
-		articles, err := utils.GetAllArticles(userID)
+		// Get articles of the active workspace from database
+		articles, err := utils.GetAllArticles(r.Context(), workspace.ID, userID)
 		if err != nil {
-			log.Printf("Error fetching articles: %v", err)
+			slog.ErrorContext(r.Context(), "failed to fetch articles", "error", err)
 			utils.SendErrorResponse(w, http.StatusInternalServerError,
 				"Database error", "Failed to retrieve articles from database")
 			return
@@ -101,7 +90,7 @@ func ArticlesHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Log success
-		log.Printf("✅ Successfully fetched %d articles for user %d", len(articles), userID)
+		slog.InfoContext(r.Context(), "fetched articles", "count", len(articles))
 
 		// Send JSON response
 		utils.SendJSONResponse(w, http.StatusOK, response)
@@ -115,8 +104,9 @@ func ArticlesHandler(w http.ResponseWriter, r *http.Request) {
 
 		// Parse request body
 		var req struct {
-			Title   string `json:"title"`
-			Content string `json:"content"`
+			Title         string `json:"title"`
+			Content       string `json:"content"`
+			ContentFormat string `json:"content_format"`
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -132,21 +122,41 @@ func ArticlesHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Create article with user_id
-		id, err := utils.CreateArticle(userID, req.Title, req.Content)
+		if req.ContentFormat == "" {
+			req.ContentFormat = markdown.FormatPlain
+		}
+		if !markdown.ValidFormat(req.ContentFormat) {
+			utils.SendErrorResponse(w, http.StatusBadRequest,
+				"Validation error", "content_format must be plain or markdown")
+			return
+		}
+
+		workspace, ok := activeWorkspace(w, r, userID)
+		if !ok || !canWriteArticles(w, workspace) {
+			return
+		}
+
+		// Create article in the active workspace
+		id, err := utils.CreateArticle(r.Context(), workspace.ID, userID, req.Title, req.Content, req.ContentFormat)
 		if err != nil {
-			log.Printf("Error creating article: %v", err)
+			slog.ErrorContext(r.Context(), "failed to create article", "error", err)
 			utils.SendErrorResponse(w, http.StatusInternalServerError,
 				"Database error", "Failed to create article")
 			return
 		}
 
+		metrics.ArticleOperations.WithLabelValues("create").Inc()
+		publishArticleEvent(r.Context(), events.ArticleCreated, id, workspace.ID, 1, userID)
+
 		// Return the created article with its ID
 		response := map[string]interface{}{
-			"id":      id,
-			"title":   req.Title,
-			"content": req.Content,
-			"message": "Article created successfully",
+			"id":             id,
+			"workspace_id":   workspace.ID,
+			"title":          req.Title,
+			"content":        req.Content,
+			"content_format": req.ContentFormat,
+			"version":        1,
+			"message":        "Article created successfully",
 		}
 
 		utils.SendJSONResponse(w, http.StatusCreated, response)
@@ -157,8 +167,34 @@ func ArticlesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// ArticleHandler handles GET, PUT, and DELETE requests for articles
+// ArticleHandler handles GET, PUT, and DELETE requests for articles and routes article sub-resources
 func ArticleHandler(w http.ResponseWriter, r *http.Request) {
+	// Sub-resources: /article/{id}/{resource}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) == 4 && parts[2] == "acl" {
+		ArticleACLHandler(w, r)
+		return
+	}
+	if len(parts) == 3 {
+		switch parts[2] {
+		case "acl":
+			ArticleACLHandler(w, r)
+			return
+		case "attachments":
+			ArticleAttachmentsHandler(w, r)
+			return
+		case "collab":
+			ArticleCollabHandler(w, r)
+			return
+		case "render":
+			ArticleRenderHandler(w, r)
+			return
+		case "share":
+			ArticleShareHandler(w, r)
+			return
+		}
+	}
+
 	switch r.Method {
 	case http.MethodGet:
 		ArticleByIDHandler(w, r)
@@ -197,16 +233,19 @@ func ArticleByIDHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("📄 Fetching article with ID: %d for user %d", id, userID)
+	workspace, ok := activeWorkspace(w, r, userID)
+	if !ok {
+		return
+	}
 
-	// Get article from database (with user ownership check)
-	article, err := utils.GetArticleByID(id, userID)
+	// Get article from database (with permission check)
+	article, err := utils.GetArticleByID(r.Context(), workspace.ID, id, userID)
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			utils.SendErrorResponse(w, http.StatusNotFound,
 				"Article not found", fmt.Sprintf("Article with ID %d not found", id))
 		} else {
-			log.Printf("Error fetching article: %v", err)
+			slog.ErrorContext(r.Context(), "failed to fetch article", "article_id", id, "error", err)
 			utils.SendErrorResponse(w, http.StatusInternalServerError,
 				"Database error", "Failed to retrieve article from database")
 		}
@@ -214,7 +253,7 @@ func ArticleByIDHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Log success
-	log.Printf("✅ Successfully fetched article: %s (ID: %d)", article.Title, article.ID)
+	slog.InfoContext(r.Context(), "fetched article", "article_id", article.ID)
 
 	// Send JSON response
 	utils.SendJSONResponse(w, http.StatusOK, article)
@@ -227,6 +266,16 @@ func ArticleFindHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Search the articles of the active workspace
+	userID, authenticated := checkAuth(w, r)
+	if !authenticated {
+		return
+	}
+	workspace, ok := activeWorkspace(w, r, userID)
+	if !ok {
+		return
+	}
+
 	// Extract filter parameters from URL path
 	// Expected format: /article/filter/aaa/bbb
 	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
@@ -236,8 +285,6 @@ func ArticleFindHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("Extracted parts: %v", parts)
-
 	param1 := parts[2]
 	keyword := parts[3]
 
@@ -246,9 +293,9 @@ func ArticleFindHandler(w http.ResponseWriter, r *http.Request) {
 
 	switch param1 {
 	case "title":
-		article, err = utils.FindArticlesByTitle(keyword)
+		article, err = utils.FindArticlesByTitle(r.Context(), workspace.ID, userID, keyword)
 	case "all":
-		article, err = utils.FindArticlesByAll(keyword)
+		article, err = utils.FindArticlesByAll(r.Context(), workspace.ID, userID, keyword)
 	}
 
 	if err != nil {
@@ -256,7 +303,7 @@ func ArticleFindHandler(w http.ResponseWriter, r *http.Request) {
 			utils.SendErrorResponse(w, http.StatusNotFound,
 				"Article not found", fmt.Sprintf("Article with title '%s' not found", keyword))
 		} else {
-			log.Printf("Error fetching article: %v", err)
+			slog.ErrorContext(r.Context(), "failed to find articles", "mode", param1, "error", err)
 			utils.SendErrorResponse(w, http.StatusInternalServerError,
 				"Database error", "Failed to retrieve article from database")
 		}
@@ -310,29 +357,44 @@ func UpdateArticleHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Update article in database (with ownership check)
-	if err := utils.UpdateArticle(id, userID, article.Title, article.Content); err != nil {
+	if article.ContentFormat != "" && !markdown.ValidFormat(article.ContentFormat) {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Validation error", "content_format must be plain or markdown")
+		return
+	}
+
+	workspace, ok := activeWorkspace(w, r, userID)
+	if !ok {
+		return
+	}
+
+	// Update article in database (with permission check)
+	if err := utils.UpdateArticle(r.Context(), workspace.ID, id, userID, article.Title, article.Content, article.ContentFormat); err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			utils.SendErrorResponse(w, http.StatusForbidden,
 				"Access denied", "Article not found or you don't have permission to update it")
 		} else {
-			log.Printf("Error updating article: %v", err)
+			slog.ErrorContext(r.Context(), "failed to update article", "article_id", id, "error", err)
 			utils.SendErrorResponse(w, http.StatusInternalServerError,
 				"Database error", "Failed to update article")
 		}
 		return
 	}
 
+	metrics.ArticleOperations.WithLabelValues("update").Inc()
+
 	// Fetch updated article
-	updatedArticle, err := utils.GetArticleByID(id, userID)
+	updatedArticle, err := utils.GetArticleByID(r.Context(), workspace.ID, id, userID)
 	if err != nil {
-		log.Printf("Error fetching updated article: %v", err)
+		slog.ErrorContext(r.Context(), "failed to fetch updated article", "article_id", id, "error", err)
 		utils.SendErrorResponse(w, http.StatusInternalServerError,
 			"Database error", "Article updated but failed to retrieve")
 		return
 	}
 
-	log.Printf("✅ Successfully updated article: %s (ID: %d)", updatedArticle.Title, id)
+	publishArticleEvent(r.Context(), events.ArticleUpdated, id, updatedArticle.WorkspaceID, updatedArticle.Version, userID)
+
+	slog.InfoContext(r.Context(), "updated article", "article_id", id)
 	utils.SendJSONResponse(w, http.StatusOK, updatedArticle)
 }
 
@@ -360,20 +422,27 @@ func DeleteArticleHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Perform soft delete (with ownership check)
-	if err := utils.DeleteArticle(id, userID); err != nil {
+	workspace, ok := activeWorkspace(w, r, userID)
+	if !ok {
+		return
+	}
+
+	// Perform soft delete (with permission check)
+	if err := utils.DeleteArticle(r.Context(), workspace.ID, id, userID); err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			utils.SendErrorResponse(w, http.StatusForbidden,
 				"Access denied", "Article not found or you don't have permission to delete it")
 		} else {
-			log.Printf("Error deleting article: %v", err)
+			slog.ErrorContext(r.Context(), "failed to delete article", "article_id", id, "error", err)
 			utils.SendErrorResponse(w, http.StatusInternalServerError,
 				"Database error", "Failed to delete article")
 		}
 		return
 	}
 
-	log.Printf("✅ Successfully deleted article ID: %d", id)
+	metrics.ArticleOperations.WithLabelValues("delete").Inc()
+	publishArticleEvent(r.Context(), events.ArticleDeleted, id, workspace.ID, 0, userID)
+	slog.InfoContext(r.Context(), "deleted article", "article_id", id)
 	utils.SendJSONResponse(w, http.StatusOK, map[string]interface{}{
 		"id":      id,
 		"message": "Article deleted successfully",
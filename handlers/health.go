@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"personalnote.eu/simple-go-api/models"
+	"personalnote.eu/simple-go-api/utils"
+)
+
+// healthCheckTimeout bounds each readiness dependency check
+const healthCheckTimeout = 2 * time.Second
+
+// HealthzHandler reports that the process is alive
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	if !utils.ValidateHTTPMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, models.HealthResponse{Status: "ok"})
+}
+
+// ReadyzHandler reports whether the dependencies needed to serve requests are available
+func ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	if !utils.ValidateHTTPMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	checks := map[string]func(context.Context) error{
+		"database": utils.PingDB,
+		"tables":   utils.CheckTables,
+	}
+
+	if appConfig.Drive.ReadinessCheck || appConfig.Storage.Backend == "drive" {
+		checks["drive"] = checkDrive
+	}
+	if fileScanner.Name() != "none" {
+		checks["scanner"] = checkScanner
+	}
+
+	response := models.HealthResponse{
+		Status: "ok",
+		Checks: make(map[string]models.DependencyStatus, len(checks)),
+	}
+	statusCode := http.StatusOK
+
+	for name, check := range checks {
+		result := runHealthCheck(r.Context(), name, check)
+		if result.Status != "ok" {
+			response.Status = "unavailable"
+			statusCode = http.StatusServiceUnavailable
+		}
+		response.Checks[name] = result
+	}
+
+	utils.SendJSONResponse(w, statusCode, response)
+}
+
+// runHealthCheck runs a single dependency check with a timeout and measures its latency.
+// Errors are only logged: they can name hosts and credentials, and /readyz is public.
+func runHealthCheck(ctx context.Context, name string, check func(context.Context) error) models.DependencyStatus {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check(ctx)
+	result := models.DependencyStatus{
+		Status:    "ok",
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "readiness check failed", "dependency", name, "error", err)
+		result.Status = "error"
+		result.Error = "unavailable"
+	}
+	return result
+}
+
+// checkDrive reports whether the shared Drive client exists and, when
+// drive.readiness_check is enabled, whether Drive and the upload folder are reachable
+func checkDrive(ctx context.Context) error {
+	if driveClient == nil {
+		return fmt.Errorf("drive credentials not configured")
+	}
+	if !appConfig.Drive.ReadinessCheck {
+		return nil
+	}
+	return driveClient.Ping(ctx)
+}
@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"personalnote.eu/simple-go-api/events"
+	"personalnote.eu/simple-go-api/filetype"
+	"personalnote.eu/simple-go-api/importer"
+	"personalnote.eu/simple-go-api/metrics"
+	"personalnote.eu/simple-go-api/models"
+	"personalnote.eu/simple-go-api/utils"
+)
+
+const (
+	// maxImportErrors bounds the per-note errors kept for an import
+	maxImportErrors = 1000
+	// importProgressInterval is how often the progress of a running import is saved
+	importProgressInterval = 2 * time.Second
+	// maxArticleBytes is the capacity of the article content column
+	maxArticleBytes = 65535
+)
+
+// importSlots limits how many imports run at once; further imports wait queued
+var importSlots = make(chan struct{}, 2)
+
+// importDir keeps uploaded import files until their import finishes
+func importDir() string {
+	return filepath.Join(appConfig.Uploads.Dir, "imports")
+}
+
+// initImports marks imports interrupted by a previous run as failed and removes their files
+func initImports() {
+	if err := os.MkdirAll(importDir(), 0o750); err != nil {
+		slog.Error("failed to create imports directory", "dir", importDir(), "error", err)
+		return
+	}
+
+	ids, err := utils.FailUnfinishedImports(context.Background(), "The import was interrupted by a server restart")
+	if err != nil {
+		slog.Warn("failed to clean up interrupted imports", "error", err)
+		return
+	}
+	for _, id := range ids {
+		os.Remove(filepath.Join(importDir(), id))
+	}
+	if len(ids) > 0 {
+		slog.Info("marked interrupted imports as failed", "count", len(ids))
+	}
+}
+
+// ArticlesImportHandler handles POST /articles/import, which queues an import of notes
+// from a multipart "file" (Markdown, text, ZIP, Evernote .enex or Google Keep .json),
+// and GET /articles/import/{id}, which reports its progress
+func ArticlesImportHandler(w http.ResponseWriter, r *http.Request) {
+	userID, authenticated := checkAuth(w, r)
+	if !authenticated {
+		return
+	}
+
+	// Expected format: /articles/import or /articles/import/{id}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 2 && r.Method == http.MethodPost:
+		createImport(w, r, userID)
+	case len(parts) == 3 && r.Method == http.MethodGet:
+		importStatus(w, r, userID, parts[2])
+	case len(parts) == 2 || len(parts) == 3:
+		utils.SendErrorResponse(w, http.StatusMethodNotAllowed,
+			"Method not allowed", fmt.Sprintf("Method %s is not supported for this endpoint", r.Method))
+	default:
+		utils.SendErrorResponse(w, http.StatusNotFound, "Not found", "Expected format: /articles/import/{id}")
+	}
+}
+
+func createImport(w http.ResponseWriter, r *http.Request, userID int) {
+	ctx := r.Context()
+	r.Body = http.MaxBytesReader(w, r.Body, appConfig.Import.MaxBytes+1<<20)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid request", "Could not parse multipart form")
+		return
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid file", "No file provided")
+			return
+		}
+		if err != nil {
+			sendImportReadError(w, r, err)
+			return
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		name := filetype.SanitizeName(part.FileName())
+		if !importer.Supported(name) {
+			utils.SendErrorResponse(w, http.StatusUnsupportedMediaType,
+				"Unsupported file", "Import files must be .md, .markdown, .txt, .zip, .enex or Google Keep .json")
+			return
+		}
+
+		workspace, ok := activeWorkspace(w, r, userID)
+		if !ok || !canWriteArticles(w, workspace) {
+			return
+		}
+
+		job := &models.ImportJob{ID: newUploadID(), UserID: userID, Name: name}
+		path := filepath.Join(importDir(), job.ID)
+		if err := saveImportFile(path, part); err != nil {
+			os.Remove(path)
+			sendImportReadError(w, r, err)
+			return
+		}
+
+		if err := utils.CreateImportJob(ctx, job); err != nil {
+			os.Remove(path)
+			utils.SendErrorResponse(w, http.StatusInternalServerError,
+				"Database error", "Failed to create import")
+			return
+		}
+		job.Status = models.ImportStatusQueued
+		job.Errors = []models.ImportItemError{}
+
+		queued := *job
+		utils.Go("import-"+job.ID, func(ctx context.Context) {
+			runImport(ctx, &queued, workspace.ID, path)
+		})
+
+		w.Header().Set("Location", "/articles/import/"+job.ID)
+		utils.SendJSONResponse(w, http.StatusAccepted, job)
+		return
+	}
+}
+
+// saveImportFile copies an uploaded import file to disk, enforcing the size limit
+func saveImportFile(path string, content io.Reader) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	written, err := io.Copy(file, io.LimitReader(content, appConfig.Import.MaxBytes+1))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && written > appConfig.Import.MaxBytes {
+		err = &http.MaxBytesError{Limit: appConfig.Import.MaxBytes}
+	}
+	return err
+}
+
+func sendImportReadError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		utils.SendErrorResponse(w, http.StatusRequestEntityTooLarge,
+			"File too large", fmt.Sprintf("Import files are limited to %d bytes", appConfig.Import.MaxBytes))
+		return
+	}
+	slog.WarnContext(r.Context(), "failed to receive import file", "error", err)
+	utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid request", "Failed to read the uploaded file")
+}
+
+func importStatus(w http.ResponseWriter, r *http.Request, userID int, id string) {
+	// Import IDs have the same shape as upload IDs
+	if !validUploadID(id) {
+		utils.SendErrorResponse(w, http.StatusNotFound, "Import not found", fmt.Sprintf("Import %s not found", id))
+		return
+	}
+
+	job, err := utils.GetImportJob(r.Context(), id, userID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			utils.SendErrorResponse(w, http.StatusNotFound, "Import not found", fmt.Sprintf("Import %s not found", id))
+		} else {
+			utils.SendErrorResponse(w, http.StatusInternalServerError,
+				"Database error", "Failed to retrieve import")
+		}
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, job)
+}
+
+// runImport reads the notes of an import file and creates an article in the workspace for
+// each one, keeping their original timestamps. Notes that fail are recorded and skipped.
+func runImport(ctx context.Context, job *models.ImportJob, workspaceID int, path string) {
+	defer os.Remove(path)
+
+	select {
+	case importSlots <- struct{}{}:
+		defer func() { <-importSlots }()
+	case <-ctx.Done():
+		finishImport(job, models.ImportStatusFailed, "The import was interrupted by a server shutdown")
+		return
+	}
+
+	job.Status = models.ImportStatusRunning
+	utils.UpdateImportJob(ctx, job)
+
+	lastSaved := time.Now()
+	err := parseImportFile(job, path, func(item importer.Item) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if item.Err == nil {
+			item.Err = importNote(ctx, workspaceID, job.UserID, item.Note)
+		}
+		if item.Err != nil {
+			job.Failed++
+			if len(job.Errors) < maxImportErrors {
+				job.Errors = append(job.Errors, models.ImportItemError{Item: item.Name, Error: item.Err.Error()})
+			}
+		} else {
+			job.Imported++
+		}
+		// The total is only known once the whole file has been read
+		job.Total++
+		job.Processed++
+
+		if time.Since(lastSaved) >= importProgressInterval {
+			utils.UpdateImportJob(ctx, job)
+			lastSaved = time.Now()
+		}
+		return nil
+	})
+	metrics.ArticleOperations.WithLabelValues("import").Add(float64(job.Imported))
+	if ctx.Err() != nil {
+		finishImport(job, models.ImportStatusFailed, "The import was interrupted by a server shutdown")
+		return
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "failed to read import file", "import_id", job.ID, "error", err)
+		finishImport(job, models.ImportStatusFailed, err.Error())
+		return
+	}
+
+	slog.InfoContext(ctx, "finished import", "import_id", job.ID, "user_id", job.UserID,
+		"imported", job.Imported, "failed", job.Failed)
+	finishImport(job, models.ImportStatusComplete, "")
+}
+
+// parseImportFile passes the notes of an import file to handle one at a time. Each note is
+// limited to the size of an article and the whole import to the uncompressed size limit.
+func parseImportFile(job *models.ImportJob, path string, handle func(importer.Item) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open import file: %v", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to open import file: %v", err)
+	}
+	return importer.Parse(job.Name, file, info.Size(), importer.Limits{
+		MaxNotes:      appConfig.Import.MaxNotes,
+		MaxNoteBytes:  maxArticleBytes,
+		MaxTotalBytes: appConfig.Import.MaxUncompressedBytes,
+	}, handle)
+}
+
+// importNote stores one note as an article with its original timestamps
+func importNote(ctx context.Context, workspaceID, userID int, note *importer.Note) error {
+	if len(note.Content) > maxArticleBytes {
+		return fmt.Errorf("the note is longer than %d bytes", maxArticleBytes)
+	}
+
+	id, err := utils.CreateArticle(ctx, workspaceID, userID, note.Title, note.Content, note.Format)
+	if err != nil {
+		return errors.New("failed to store the note")
+	}
+	publishArticleEvent(ctx, events.ArticleCreated, id, workspaceID, 1, userID)
+
+	updated := note.Updated
+	if updated == nil {
+		updated = note.Created
+	}
+	if note.Created != nil || updated != nil {
+		if err := utils.SetArticleTimestamps(ctx, id, userID, note.Created, updated); err != nil {
+			return errors.New("the note was imported without its original dates")
+		}
+	}
+	return nil
+}
+
+// finishImport records the final state of an import. It uses its own context so the
+// result is saved even while the server shuts down.
+func finishImport(job *models.ImportJob, status, message string) {
+	job.Status = status
+	job.Error = message
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	utils.UpdateImportJob(ctx, job)
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"personalnote.eu/simple-go-api/markdown"
+	"personalnote.eu/simple-go-api/utils"
+)
+
+// renderCacheSize is the number of rendered articles kept in memory
+const renderCacheSize = 512
+
+// renderCache holds rendered articles keyed by ID and version, so edits invalidate entries
+var renderCache = markdown.NewCache(renderCacheSize)
+
+// ArticleRenderHandler handles GET /article/{id}/render and returns the article as
+// sanitized HTML with a table of contents
+func ArticleRenderHandler(w http.ResponseWriter, r *http.Request) {
+	if !utils.ValidateHTTPMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	userID, authenticated := checkAuth(w, r)
+	if !authenticated {
+		return
+	}
+
+	// Expected format: /article/{id}/render
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Invalid ID", "Article ID must be a valid integer")
+		return
+	}
+
+	ctx := r.Context()
+	article, ok := loadArticle(w, r, id, userID)
+	if !ok {
+		return
+	}
+
+	etag := fmt.Sprintf(`"%d-v%d"`, article.ID, article.Version)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if match := r.Header.Get("If-None-Match"); match != "" && strings.Contains(match, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	rendered, ok := renderCache.Get(article.ID, article.Version)
+	if !ok {
+		rendered, err = markdown.Render(article.Content, article.ContentFormat)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to render article", "article_id", id, "error", err)
+			utils.SendErrorResponse(w, http.StatusInternalServerError,
+				"Render error", "Failed to render article")
+			return
+		}
+		renderCache.Add(article.ID, article.Version, rendered)
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"id":             article.ID,
+		"version":        article.Version,
+		"content_format": article.ContentFormat,
+		"html":           rendered.HTML,
+		"toc":            rendered.TOC,
+	})
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"personalnote.eu/simple-go-api/models"
+	"personalnote.eu/simple-go-api/scanner"
+	"personalnote.eu/simple-go-api/storage"
+	"personalnote.eu/simple-go-api/utils"
+)
+
+// fileScanner checks uploads for malware; scanner.Noop when scanning is disabled
+var fileScanner scanner.Scanner = scanner.Noop{}
+
+// scanRetryInterval is how often files whose scan could not run are scanned again
+const scanRetryInterval = time.Minute
+
+// infectedError reports an upload in which the scanner found malware
+type infectedError struct {
+	signature string
+}
+
+func (e *infectedError) Error() string {
+	return fmt.Sprintf("The file contains malware (%s) and was not stored", e.signature)
+}
+
+// unscannableError reports an upload the scanner could not check
+type unscannableError struct {
+	tooLarge bool
+}
+
+func (e *unscannableError) Error() string {
+	if e.tooLarge {
+		return "The file is too large to be checked for malware and was not stored"
+	}
+	return "The file could not be checked for malware and was not stored"
+}
+
+func initScanner() {
+	if fileScanner.Name() != "none" {
+		utils.Go("scan-retry", runScanRetry)
+	}
+}
+
+// scanUpload scans content before it is stored and rewinds it. Infected content yields an
+// *infectedError. When the scanner is unavailable the upload goes ahead as pending and
+// is scanned again in the background; until then it cannot be downloaded. Content the
+// scanner cannot check, such as files above clamd's size limit, yields an *unscannableError.
+func scanUpload(ctx context.Context, content io.ReadSeeker) (string, error) {
+	result, err := fileScanner.Scan(ctx, content)
+	if _, seekErr := content.Seek(0, io.SeekStart); seekErr != nil {
+		return "", fmt.Errorf("failed to rewind upload: %v", seekErr)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if errors.Is(err, scanner.ErrUnavailable) {
+			slog.WarnContext(ctx, "malware scanner unavailable, storing file as pending", "scanner", fileScanner.Name(), "error", err)
+			return models.ScanStatusPending, nil
+		}
+		slog.WarnContext(ctx, "malware scan failed, rejecting upload", "scanner", fileScanner.Name(), "error", err)
+		return "", &unscannableError{tooLarge: errors.Is(err, scanner.ErrTooLarge)}
+	}
+	if result.Infected {
+		slog.WarnContext(ctx, "rejected infected upload", "scanner", fileScanner.Name(), "signature", result.Signature)
+		return "", &infectedError{signature: result.Signature}
+	}
+	return models.ScanStatusClean, nil
+}
+
+// runScanRetry periodically scans files that were stored while the scanner was unavailable
+func runScanRetry(ctx context.Context) {
+	ticker := time.NewTicker(scanRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		rescanPending(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func rescanPending(ctx context.Context) {
+	pending, err := utils.GetAttachmentsByScanStatus(ctx, models.ScanStatusPending, 100)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to load files pending a scan", "error", err)
+		return
+	}
+
+	for _, attachment := range pending {
+		err := rescanAttachment(ctx, &attachment)
+		if ctx.Err() != nil {
+			return
+		}
+		switch {
+		case err == nil:
+		case errors.Is(err, scanner.ErrUnavailable):
+			// The scanner is still down; try them all next time
+			slog.WarnContext(ctx, "malware scanner unavailable, retrying pending files later", "error", err)
+			return
+		default:
+			// Leaving the file pending would retry it forever ahead of the files behind it
+			slog.WarnContext(ctx, "failed to scan pending file, marking it failed", "attachment_id", attachment.ID, "error", err)
+			if err := utils.UpdateScanStatus(ctx, attachment.ID, models.ScanStatusFailed, ""); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// rescanAttachment reads a stored file back from its backend and records the scan result.
+// Infected files are kept but can no longer be downloaded.
+func rescanAttachment(ctx context.Context, attachment *models.Attachment) error {
+	store, err := storeFor(ctx, attachment.Backend, attachment.UserID)
+	if err != nil {
+		return err
+	}
+	reader, err := store.Get(ctx, attachment.RemoteID, storage.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	defer reader.Close()
+
+	result, err := fileScanner.Scan(ctx, reader)
+	if err != nil {
+		return err
+	}
+
+	status := models.ScanStatusClean
+	if result.Infected {
+		status = models.ScanStatusInfected
+		slog.WarnContext(ctx, "stored file is infected", "attachment_id", attachment.ID, "user_id", attachment.UserID, "signature", result.Signature)
+	}
+	if err := utils.UpdateScanStatus(ctx, attachment.ID, status, result.Signature); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "scanned pending file", "attachment_id", attachment.ID, "status", status)
+	return nil
+}
+
+// checkScanned writes an error response and returns false unless the attachment was scanned clean
+func checkScanned(w http.ResponseWriter, attachment *models.Attachment) bool {
+	switch attachment.ScanStatus {
+	case models.ScanStatusClean:
+		return true
+	case models.ScanStatusInfected:
+		utils.SendErrorResponse(w, http.StatusForbidden,
+			"File blocked", "The file contains malware and cannot be downloaded")
+	case models.ScanStatusFailed:
+		utils.SendErrorResponse(w, http.StatusForbidden,
+			"File blocked", "The file could not be checked for malware and cannot be downloaded")
+	default:
+		w.Header().Set("Retry-After", "60")
+		utils.SendErrorResponse(w, http.StatusForbidden,
+			"File not scanned", "The file has not been checked for malware yet")
+	}
+	return false
+}
+
+// checkScanner reports whether the malware scanner is reachable
+func checkScanner(ctx context.Context) error {
+	return fileScanner.Ping(ctx)
+}
@@ -0,0 +1,351 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"personalnote.eu/simple-go-api/markdown"
+	"personalnote.eu/simple-go-api/models"
+	"personalnote.eu/simple-go-api/utils"
+)
+
+// sharePasswordHeader carries the password of a protected share link for API clients
+const sharePasswordHeader = "X-Share-Password"
+
+// maxSharePasswordBytes is the longest password bcrypt accepts
+const maxSharePasswordBytes = 72
+
+// ArticleShareHandler handles /article/{id}/share: POST creates or replaces the public
+// link of an article, GET shows it and DELETE revokes it. The link belongs to the article,
+// so any owner of the article can manage it, whoever created it.
+func ArticleShareHandler(w http.ResponseWriter, r *http.Request) {
+	userID, authenticated := checkAuth(w, r)
+	if !authenticated {
+		return
+	}
+
+	// Expected format: /article/{id}/share
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Invalid ID", "Article ID must be a valid integer")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		createShare(w, r, id, userID)
+	case http.MethodGet:
+		article, ok := loadArticle(w, r, id, userID)
+		if !ok || !requireOwner(w, article, "view the share link") {
+			return
+		}
+		share, err := utils.GetShare(r.Context(), id)
+		if err != nil {
+			sendShareLookupError(w, r, id, err)
+			return
+		}
+		utils.SendJSONResponse(w, http.StatusOK, models.ShareResponse{
+			ArticleShare:      *share,
+			PasswordProtected: share.PasswordHash != "",
+		})
+	case http.MethodDelete:
+		article, ok := loadArticle(w, r, id, userID)
+		if !ok || !requireOwner(w, article, "revoke the share link") {
+			return
+		}
+		if err := utils.DeleteShare(r.Context(), id); err != nil {
+			sendShareLookupError(w, r, id, err)
+			return
+		}
+		utils.SendJSONResponse(w, http.StatusOK, map[string]interface{}{
+			"article_id": id,
+			"message":    "Share link revoked",
+		})
+	default:
+		utils.SendErrorResponse(w, http.StatusMethodNotAllowed,
+			"Method not allowed", fmt.Sprintf("Method %s is not supported for this endpoint", r.Method))
+	}
+}
+
+func createShare(w http.ResponseWriter, r *http.Request, id, userID int) {
+	ctx := r.Context()
+
+	var req struct {
+		ExpiresAt *time.Time `json:"expires_at"`
+		Password  string     `json:"password"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.SendErrorResponse(w, http.StatusBadRequest,
+				"Invalid request body", "Failed to parse JSON")
+			return
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Validation error", "expires_at must be in the future")
+		return
+	}
+	if len(req.Password) > maxSharePasswordBytes {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Validation error", fmt.Sprintf("password must be at most %d bytes", maxSharePasswordBytes))
+		return
+	}
+
+	article, ok := loadArticle(w, r, id, userID)
+	if !ok {
+		return
+	}
+	if !requireOwner(w, article, "create a share link") {
+		return
+	}
+
+	token := newShareToken()
+	share := &models.ArticleShare{
+		ArticleID: id,
+		CreatedBy: userID,
+		TokenHash: hashShareToken(token),
+		Expires:   req.ExpiresAt,
+	}
+	if req.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to hash share password", "error", err)
+			utils.SendErrorResponse(w, http.StatusInternalServerError,
+				"Share error", "Failed to create share link")
+			return
+		}
+		share.PasswordHash = string(hash)
+	}
+
+	if err := utils.SaveShare(ctx, share); err != nil {
+		utils.SendErrorResponse(w, http.StatusInternalServerError,
+			"Database error", "Failed to create share link")
+		return
+	}
+
+	saved, err := utils.GetShare(ctx, id)
+	if err != nil {
+		saved = share
+	}
+	utils.SendJSONResponse(w, http.StatusCreated, models.ShareResponse{
+		ArticleShare:      *saved,
+		Token:             token,
+		URL:               "/s/" + token,
+		PasswordProtected: share.PasswordHash != "",
+	})
+}
+
+func sendShareLookupError(w http.ResponseWriter, r *http.Request, id int, err error) {
+	if strings.Contains(err.Error(), "not found") {
+		utils.SendErrorResponse(w, http.StatusNotFound,
+			"Share link not found", fmt.Sprintf("Article %d has no share link", id))
+		return
+	}
+	utils.SendErrorResponse(w, http.StatusInternalServerError,
+		"Database error", "Failed to retrieve share link")
+}
+
+// SharedArticleHandler serves GET /s/{token}, the public read-only view of a shared article.
+// It returns an HTML page, or JSON with ?format=json or Accept: application/json.
+// Password protected links take the password from the X-Share-Password header, or from
+// the form of the HTML page posted back to the same URL.
+func SharedArticleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		utils.SendErrorResponse(w, http.StatusMethodNotAllowed,
+			"Method not allowed", fmt.Sprintf("Method %s is not supported for this endpoint", r.Method))
+		return
+	}
+
+	w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Cache-Control", "private, no-store")
+	asJSON := wantsJSON(r)
+
+	// Expected format: /s/{token}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 2 || parts[1] == "" {
+		sendShareError(w, r, asJSON, http.StatusNotFound, "Not found", "This link does not exist")
+		return
+	}
+
+	ctx := r.Context()
+	share, err := utils.GetShareByToken(ctx, hashShareToken(parts[1]))
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			sendShareError(w, r, asJSON, http.StatusNotFound, "Not found", "This link does not exist or was revoked")
+		} else {
+			sendShareError(w, r, asJSON, http.StatusInternalServerError, "Database error", "Failed to load the shared note")
+		}
+		return
+	}
+	if share.Expires != nil && time.Now().After(*share.Expires) {
+		sendShareError(w, r, asJSON, http.StatusGone, "Link expired", "This link has expired")
+		return
+	}
+
+	if share.PasswordHash != "" {
+		password := r.Header.Get(sharePasswordHeader)
+		if password == "" && r.Method == http.MethodPost {
+			password = r.PostFormValue("password")
+		}
+		if password == "" || bcrypt.CompareHashAndPassword([]byte(share.PasswordHash), []byte(password)) != nil {
+			if asJSON {
+				utils.SendErrorResponse(w, http.StatusUnauthorized,
+					"Password required", "This link is password protected; send the password in "+sharePasswordHeader)
+				return
+			}
+			renderSharePage(w, r, http.StatusUnauthorized, sharePage{PasswordRequired: true, WrongPassword: password != ""})
+			return
+		}
+	} else if r.Method == http.MethodPost {
+		utils.SendErrorResponse(w, http.StatusMethodNotAllowed,
+			"Method not allowed", fmt.Sprintf("Method %s is not supported for this endpoint", r.Method))
+		return
+	}
+
+	// The link belongs to the article, so it keeps working when its creator loses access
+	article, err := utils.GetSharedArticle(ctx, share.ArticleID)
+	if err != nil {
+		sendShareError(w, r, asJSON, http.StatusNotFound, "Not found", "This link does not exist or was revoked")
+		return
+	}
+
+	rendered, ok := renderCache.Get(article.ID, article.Version)
+	if !ok {
+		rendered, err = markdown.Render(article.Content, article.ContentFormat)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to render article", "article_id", article.ID, "error", err)
+			sendShareError(w, r, asJSON, http.StatusInternalServerError, "Render error", "Failed to render the shared note")
+			return
+		}
+		renderCache.Add(article.ID, article.Version, rendered)
+	}
+
+	if err := utils.RecordShareView(ctx, share.ArticleID); err != nil {
+		slog.WarnContext(ctx, "failed to count share view", "article_id", share.ArticleID, "error", err)
+	}
+
+	if asJSON {
+		utils.SendJSONResponse(w, http.StatusOK, map[string]interface{}{
+			"title":          article.Title,
+			"content_format": article.ContentFormat,
+			"html":           rendered.HTML,
+			"toc":            rendered.TOC,
+			"created":        article.Created,
+			"updated":        article.Updated,
+		})
+		return
+	}
+	renderSharePage(w, r, http.StatusOK, sharePage{
+		Title:   article.Title,
+		Body:    template.HTML(rendered.HTML),
+		Updated: article.Updated,
+	})
+}
+
+// wantsJSON reports whether the client asked for JSON rather than an HTML page
+func wantsJSON(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format == "json"
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
+
+func sendShareError(w http.ResponseWriter, r *http.Request, asJSON bool, status int, title, message string) {
+	if asJSON {
+		utils.SendErrorResponse(w, status, title, message)
+		return
+	}
+	renderSharePage(w, r, status, sharePage{Title: title, Message: message})
+}
+
+// sharePage is the data of the public page template
+type sharePage struct {
+	Title            string
+	Body             template.HTML
+	Message          string
+	Updated          *time.Time
+	PasswordRequired bool
+	WrongPassword    bool
+}
+
+var sharePageTemplate = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex, nofollow">
+<title>{{if .PasswordRequired}}Protected note{{else}}{{.Title}}{{end}}</title>
+<style>
+body { font-family: system-ui, sans-serif; line-height: 1.6; max-width: 46rem; margin: 2rem auto; padding: 0 1rem; color: #222; }
+pre, code { background: #f4f4f4; border-radius: 3px; }
+pre { padding: .75rem; overflow-x: auto; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: .25rem .5rem; }
+a.anchor { margin-left: .4rem; color: #bbb; text-decoration: none; }
+.meta, .message { color: #666; }
+</style>
+</head>
+<body>
+{{if .PasswordRequired}}
+<h1>This note is password protected</h1>
+{{if .WrongPassword}}<p class="message">The password is not correct.</p>{{end}}
+<form method="post">
+<input type="password" name="password" autofocus required aria-label="Password">
+<button type="submit">Open</button>
+</form>
+{{else if .Message}}
+<h1>{{.Title}}</h1>
+<p class="message">{{.Message}}</p>
+{{else}}
+<article>
+<h1>{{.Title}}</h1>
+{{if .Updated}}<p class="meta">Updated {{.Updated.Format "2 January 2006"}}</p>{{end}}
+{{.Body}}
+</article>
+{{end}}
+</body>
+</html>
+`))
+
+func renderSharePage(w http.ResponseWriter, r *http.Request, status int, page sharePage) {
+	header := w.Header()
+	header.Set("Content-Type", "text/html; charset=utf-8")
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Content-Security-Policy",
+		"default-src 'none'; style-src 'unsafe-inline'; img-src https: data:; form-action 'self'; frame-ancestors 'none'; base-uri 'none'")
+	w.WriteHeader(status)
+	if err := sharePageTemplate.Execute(w, page); err != nil {
+		slog.WarnContext(r.Context(), "failed to write share page", "error", err)
+	}
+}
+
+// newShareToken returns an unguessable URL-safe token with 256 bits of randomness
+func newShareToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// hashShareToken returns the form of a token that is stored, so a database leak does not
+// expose working links
+func hashShareToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,367 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"personalnote.eu/simple-go-api/events"
+	"personalnote.eu/simple-go-api/markdown"
+	"personalnote.eu/simple-go-api/metrics"
+	"personalnote.eu/simple-go-api/models"
+	"personalnote.eu/simple-go-api/utils"
+)
+
+const (
+	// defaultSyncLimit and maxSyncLimit bound the changes returned by one GET /sync
+	defaultSyncLimit = 100
+	maxSyncLimit     = 1000
+	// maxSyncClientIDBytes matches the client_id column that makes offline creates idempotent
+	maxSyncClientIDBytes = 64
+)
+
+// Sync result states
+const (
+	syncStatusApplied  = "applied"
+	syncStatusConflict = "conflict"
+	syncStatusError    = "error"
+)
+
+// syncClientChange is a change a client made while offline. Updates and deletes carry the
+// version of the article the change was made on.
+type syncClientChange struct {
+	Op            string `json:"op"`
+	ID            int    `json:"id,omitempty"`
+	ClientID      string `json:"client_id,omitempty"`
+	WorkspaceID   int    `json:"workspace_id,omitempty"`
+	BaseVersion   int    `json:"base_version,omitempty"`
+	Title         string `json:"title,omitempty"`
+	Content       string `json:"content,omitempty"`
+	ContentFormat string `json:"content_format,omitempty"`
+}
+
+// syncResult reports the outcome of one client change. Conflicts carry the client's change
+// and the current server version.
+type syncResult struct {
+	Index      int               `json:"index"`
+	Op         string            `json:"op"`
+	ID         int               `json:"id,omitempty"`
+	ClientID   string            `json:"client_id,omitempty"`
+	Status     string            `json:"status"`
+	StatusCode int               `json:"status_code,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	Article    *models.Article   `json:"article,omitempty"`
+	Client     *syncClientChange `json:"client,omitempty"`
+	Server     *models.Article   `json:"server,omitempty"`
+	// ServerDeleted marks conflicts with an article that was deleted or is out of reach
+	ServerDeleted bool `json:"server_deleted,omitempty"`
+}
+
+// SyncHandler handles /sync for offline clients: GET returns the changes to the user's
+// articles since a sequence number, POST applies changes made offline
+func SyncHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		getSyncChanges(w, r)
+	case http.MethodPost:
+		applySyncChanges(w, r)
+	default:
+		utils.SendErrorResponse(w, http.StatusMethodNotAllowed,
+			"Method not allowed", fmt.Sprintf("Method %s is not supported for this endpoint", r.Method))
+	}
+}
+
+// getSyncChanges handles GET /sync?since={seq}&limit={n}, returning the articles of every
+// workspace and share that were created, updated or deleted after since, oldest first.
+// Clients pass the returned seq as since next time, right away while has_more is set.
+func getSyncChanges(w http.ResponseWriter, r *http.Request) {
+	userID, authenticated := checkAuth(w, r)
+	if !authenticated {
+		return
+	}
+
+	query := r.URL.Query()
+	var since int64
+	if raw := query.Get("since"); raw != "" {
+		value, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || value < 0 {
+			utils.SendErrorResponse(w, http.StatusBadRequest,
+				"Invalid since", "since must be a sequence number returned by an earlier sync")
+			return
+		}
+		since = value
+	}
+	limit := defaultSyncLimit
+	if raw := query.Get("limit"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 1 || value > maxSyncLimit {
+			utils.SendErrorResponse(w, http.StatusBadRequest,
+				"Invalid limit", fmt.Sprintf("limit must be between 1 and %d", maxSyncLimit))
+			return
+		}
+		limit = value
+	}
+
+	ctx := r.Context()
+	// Changes recorded while this request runs wait for the next sync
+	current, err := utils.GetSyncSequence(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get change sequence", "error", err)
+		utils.SendErrorResponse(w, http.StatusInternalServerError,
+			"Database error", "Failed to retrieve changes")
+		return
+	}
+	if since > current {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Invalid since", "since is ahead of the change feed; sync again from 0")
+		return
+	}
+
+	// Fetch one extra change to learn whether there are more
+	changes, err := utils.GetSyncChanges(ctx, userID, since, current, limit+1)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get changes", "error", err)
+		utils.SendErrorResponse(w, http.StatusInternalServerError,
+			"Database error", "Failed to retrieve changes")
+		return
+	}
+
+	changes, seq, hasMore := syncPage(changes, limit, current)
+	utils.SendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"since":    since,
+		"seq":      seq,
+		"has_more": hasMore,
+		"changes":  changes,
+		"count":    len(changes),
+	})
+}
+
+// syncPage trims changes fetched with limit+1 to a page of at most limit changes. seq is
+// where the next sync continues: the last change of the page while there are more, else
+// current, which the whole feed was read up to.
+func syncPage(changes []models.SyncChange, limit int, current int64) (page []models.SyncChange, seq int64, hasMore bool) {
+	seq = current
+	hasMore = len(changes) > limit
+	if hasMore {
+		changes = changes[:limit]
+		seq = changes[limit-1].Seq
+	}
+	if changes == nil {
+		changes = []models.SyncChange{}
+	}
+	return changes, seq, hasMore
+}
+
+// applySyncChanges handles POST /sync with the changes a client made offline. Every change
+// is applied on its own; updates and deletes of articles that changed on the server since
+// their base version are not applied but reported as conflicts with both versions. Applied
+// changes also show up in the feed, so clients pull once more afterwards.
+func applySyncChanges(w http.ResponseWriter, r *http.Request) {
+	userID, authenticated := checkAuth(w, r)
+	if !authenticated {
+		return
+	}
+
+	var req struct {
+		Changes []syncClientChange `json:"changes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Invalid request body", "Failed to parse JSON")
+		return
+	}
+	if len(req.Changes) == 0 {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Validation error", "At least one change is required")
+		return
+	}
+	if len(req.Changes) > maxBatchOperations {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Validation error", fmt.Sprintf("A sync may hold at most %d changes", maxBatchOperations))
+		return
+	}
+
+	ctx := r.Context()
+	// Start the feed first, so it records the changes made here
+	if _, err := utils.GetSyncSequence(ctx, userID); err != nil {
+		slog.ErrorContext(ctx, "failed to get change sequence", "error", err)
+		utils.SendErrorResponse(w, http.StatusInternalServerError,
+			"Database error", "Failed to apply changes")
+		return
+	}
+
+	results := make([]syncResult, len(req.Changes))
+	var applied, conflicts, failed int
+	for i := range req.Changes {
+		change := &req.Changes[i]
+		results[i] = syncResult{Index: i, Op: change.Op, ID: change.ID, ClientID: change.ClientID}
+		applySyncChange(ctx, userID, change, &results[i])
+
+		switch results[i].Status {
+		case syncStatusApplied:
+			applied++
+		case syncStatusConflict:
+			conflicts++
+		default:
+			failed++
+		}
+	}
+
+	slog.InfoContext(ctx, "applied sync changes", "changes", len(results), "conflicts", conflicts, "failed", failed)
+	utils.SendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"results":   results,
+		"applied":   applied,
+		"conflicts": conflicts,
+		"failed":    failed,
+	})
+}
+
+// applySyncChange applies one client change and fills in its result
+func applySyncChange(ctx context.Context, userID int, change *syncClientChange, result *syncResult) {
+	fail := func(code int, message string) {
+		result.Status = syncStatusError
+		result.StatusCode = code
+		result.Error = message
+	}
+	conflict := func() {
+		result.Status = syncStatusConflict
+		result.StatusCode = http.StatusConflict
+		result.Client = change
+		result.Server, _ = utils.GetArticleByID(ctx, 0, change.ID, userID)
+		result.ServerDeleted = result.Server == nil
+	}
+
+	if change.Op != batchCreate && change.Op != batchUpdate && change.Op != batchDelete {
+		fail(http.StatusBadRequest, fmt.Sprintf("Unknown operation %q, expected create, update or delete", change.Op))
+		return
+	}
+	if change.ContentFormat != "" && !markdown.ValidFormat(change.ContentFormat) {
+		fail(http.StatusBadRequest, "content_format must be plain or markdown")
+		return
+	}
+	if change.Op != batchCreate && (change.ID <= 0 || change.BaseVersion <= 0) {
+		fail(http.StatusBadRequest, "Article ID and base_version are required")
+		return
+	}
+	if change.Op != batchDelete && change.Title == "" {
+		fail(http.StatusBadRequest, "Title is required")
+		return
+	}
+	if len(change.ClientID) > maxSyncClientIDBytes {
+		fail(http.StatusBadRequest, fmt.Sprintf("client_id is limited to %d bytes", maxSyncClientIDBytes))
+		return
+	}
+
+	var err error
+	// replayed marks a create the client already sent, answered with the article it made
+	var replayed bool
+	switch change.Op {
+	case batchCreate:
+		var workspace *models.Workspace
+		if change.WorkspaceID == 0 {
+			workspace, err = utils.GetPersonalWorkspace(ctx, userID)
+		} else {
+			workspace, err = utils.GetWorkspace(ctx, change.WorkspaceID, userID)
+		}
+		if err != nil {
+			if strings.Contains(err.Error(), "not found") {
+				fail(http.StatusNotFound, fmt.Sprintf("Workspace with ID %d not found", change.WorkspaceID))
+				return
+			}
+			break
+		}
+		if workspace.Role == models.WorkspaceGuest {
+			fail(http.StatusForbidden, "Guests cannot create articles in this workspace")
+			return
+		}
+		format := change.ContentFormat
+		if format == "" {
+			format = markdown.FormatPlain
+		}
+		if change.ClientID == "" {
+			result.ID, err = utils.CreateArticle(ctx, workspace.ID, userID, change.Title, change.Content, format)
+			break
+		}
+		var created bool
+		result.ID, created, err = utils.CreateSyncArticle(ctx, workspace.ID, userID, change.ClientID,
+			change.Title, change.Content, format)
+		replayed = err == nil && !created
+
+	case batchUpdate:
+		if change.Content == "" {
+			fail(http.StatusBadRequest, "Content is required")
+			return
+		}
+		err = utils.UpdateArticleAtVersion(ctx, 0, change.ID, userID, change.BaseVersion,
+			change.Title, change.Content, change.ContentFormat)
+
+	case batchDelete:
+		// Remember the workspace for the event
+		if article, err := utils.GetArticleByID(ctx, 0, change.ID, userID); err == nil {
+			result.Article = article
+		}
+		err = utils.DeleteArticleAtVersion(ctx, 0, change.ID, userID, change.BaseVersion)
+	}
+
+	if err != nil {
+		result.Article = nil
+		switch {
+		case strings.Contains(err.Error(), "changed"):
+			conflict()
+		case strings.Contains(err.Error(), "for editing"):
+			fail(http.StatusForbidden, "You don't have permission to update this article")
+		case strings.Contains(err.Error(), "for deleting"):
+			fail(http.StatusForbidden, "You don't have permission to delete this article")
+		case strings.Contains(err.Error(), "not found"):
+			gone, tombstoneErr := utils.IsSyncTombstone(ctx, userID, change.ID)
+			switch {
+			case tombstoneErr != nil || !gone:
+				fail(http.StatusNotFound, fmt.Sprintf("Article with ID %d not found", change.ID))
+			case change.Op == batchDelete:
+				// Already gone; nothing left to do
+				result.Status = syncStatusApplied
+				result.StatusCode = http.StatusOK
+			default:
+				conflict()
+			}
+		default:
+			slog.ErrorContext(ctx, "failed to apply sync change", "op", change.Op, "article_id", change.ID, "error", err)
+			fail(http.StatusInternalServerError, "Database error")
+		}
+		return
+	}
+
+	result.Status = syncStatusApplied
+	result.StatusCode = http.StatusOK
+	if replayed {
+		result.Article, err = utils.GetArticleByID(ctx, 0, result.ID, userID)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to fetch article of repeated create", "article_id", result.ID, "error", err)
+		}
+		return
+	}
+	metrics.ArticleOperations.WithLabelValues(change.Op).Inc()
+	if change.Op == batchDelete {
+		if result.Article != nil {
+			publishArticleEvent(ctx, events.ArticleDeleted, change.ID, result.Article.WorkspaceID, 0, userID)
+			result.Article = nil
+		}
+		return
+	}
+
+	result.Article, err = utils.GetArticleByID(ctx, 0, result.ID, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to fetch synced article", "article_id", result.ID, "error", err)
+		return
+	}
+	eventType := events.ArticleUpdated
+	if change.Op == batchCreate {
+		result.StatusCode = http.StatusCreated
+		eventType = events.ArticleCreated
+	}
+	publishArticleEvent(ctx, eventType, result.ID, result.Article.WorkspaceID, result.Article.Version, userID)
+}
@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"personalnote.eu/simple-go-api/config"
+	"personalnote.eu/simple-go-api/middleware"
+	"personalnote.eu/simple-go-api/models"
+	"personalnote.eu/simple-go-api/utils"
+)
+
+// testDatabase connects to the MySQL server in TEST_DB_HOST, with the optional TEST_DB_PORT,
+// TEST_DB_NAME, TEST_DB_USER and TEST_DB_PASSWORD overriding the defaults, and skips the
+// test without one. Tests create their own users, so the database may hold other data.
+func testDatabase(t *testing.T) {
+	t.Helper()
+	host := os.Getenv("TEST_DB_HOST")
+	if host == "" {
+		t.Skip("set TEST_DB_HOST to run the tests that need MySQL")
+	}
+	if utils.DB != nil {
+		return
+	}
+
+	cfg := config.Default()
+	cfg.Database.Host = host
+	if port := os.Getenv("TEST_DB_PORT"); port != "" {
+		cfg.Database.Port = port
+	}
+	if name := os.Getenv("TEST_DB_NAME"); name != "" {
+		cfg.Database.Name = name
+	}
+	if user := os.Getenv("TEST_DB_USER"); user != "" {
+		cfg.Database.User = user
+	}
+	if password := os.Getenv("TEST_DB_PASSWORD"); password != "" {
+		cfg.Database.Password = config.Secret(password)
+	}
+	if err := utils.InitDB(cfg.Database); err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+
+	cfg.Auth.JWTSecret = "sync-test-secret"
+	appConfig = cfg
+	middleware.Init(cfg)
+}
+
+// testUser creates a user whose change feed has started, so it records their changes
+func testUser(t *testing.T) *models.User {
+	t.Helper()
+	ctx := context.Background()
+	googleID := fmt.Sprintf("sync-test-%d", time.Now().UnixNano())
+	user, err := utils.CreateOrUpdateUser(ctx, googleID, googleID+"@example.com", true, "Sync Test", "")
+	if err != nil {
+		t.Fatalf("CreateOrUpdateUser: %v", err)
+	}
+	if _, err := utils.GetSyncSequence(ctx, user.ID); err != nil {
+		t.Fatalf("GetSyncSequence: %v", err)
+	}
+	return user
+}
+
+// testArticle creates an article in the user's personal workspace
+func testArticle(t *testing.T, userID int, title string) int {
+	t.Helper()
+	ctx := context.Background()
+	workspace, err := utils.GetPersonalWorkspace(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetPersonalWorkspace: %v", err)
+	}
+	id, err := utils.CreateArticle(ctx, workspace.ID, userID, title, "content", "plain")
+	if err != nil {
+		t.Fatalf("CreateArticle: %v", err)
+	}
+	return id
+}
+
+func syncChange(t *testing.T, userID int, change syncClientChange) syncResult {
+	t.Helper()
+	result := syncResult{Op: change.Op, ID: change.ID, ClientID: change.ClientID}
+	applySyncChange(context.Background(), userID, &change, &result)
+	return result
+}
+
+func TestSyncPage(t *testing.T) {
+	feed := func(n int) []models.SyncChange {
+		changes := make([]models.SyncChange, n)
+		for i := range changes {
+			changes[i] = models.SyncChange{Seq: int64(10 * (i + 1)), Type: models.SyncUpdated, ArticleID: i + 1}
+		}
+		return changes
+	}
+
+	tests := []struct {
+		name        string
+		fetched     []models.SyncChange
+		limit       int
+		wantCount   int
+		wantSeq     int64
+		wantHasMore bool
+	}{
+		{"no changes", nil, 3, 0, 100, false},
+		{"fewer than the limit", feed(2), 3, 2, 100, false},
+		{"exactly the limit", feed(3), 3, 3, 100, false},
+		{"one more than the limit", feed(4), 3, 3, 30, true},
+		{"limit of one", feed(2), 1, 1, 10, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, seq, hasMore := syncPage(tt.fetched, tt.limit, 100)
+			if page == nil {
+				t.Fatal("page is nil, want an empty list")
+			}
+			if len(page) != tt.wantCount || seq != tt.wantSeq || hasMore != tt.wantHasMore {
+				t.Errorf("syncPage = %d changes, seq %d, has_more %v; want %d, %d, %v",
+					len(page), seq, hasMore, tt.wantCount, tt.wantSeq, tt.wantHasMore)
+			}
+		})
+	}
+}
+
+func TestApplySyncChangeValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		change syncClientChange
+	}{
+		{"unknown operation", syncClientChange{Op: "move", ID: 1, BaseVersion: 1, Title: "t"}},
+		{"unknown format", syncClientChange{Op: batchCreate, Title: "t", ContentFormat: "html"}},
+		{"update without a base version", syncClientChange{Op: batchUpdate, ID: 1, Title: "t", Content: "c"}},
+		{"delete without an ID", syncClientChange{Op: batchDelete, BaseVersion: 1}},
+		{"create without a title", syncClientChange{Op: batchCreate, Content: "c"}},
+		{"client ID too long", syncClientChange{Op: batchCreate, Title: "t", ClientID: strings.Repeat("x", maxSyncClientIDBytes+1)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Validation fails before the database is used
+			result := syncChange(t, 1, tt.change)
+			if result.Status != syncStatusError || result.StatusCode != http.StatusBadRequest {
+				t.Errorf("result = %s %d (%s), want error 400", result.Status, result.StatusCode, result.Error)
+			}
+		})
+	}
+}
+
+func TestApplySyncChangeCreateIdempotent(t *testing.T) {
+	testDatabase(t)
+	user := testUser(t)
+	create := syncClientChange{Op: batchCreate, ClientID: "note-1", Title: "Offline", Content: "written offline"}
+
+	first := syncChange(t, user.ID, create)
+	if first.Status != syncStatusApplied || first.StatusCode != http.StatusCreated || first.ID == 0 {
+		t.Fatalf("first create = %s %d for article %d (%s), want applied 201", first.Status, first.StatusCode, first.ID, first.Error)
+	}
+
+	// The client did not get the answer and sends the create again
+	again := syncChange(t, user.ID, create)
+	if again.Status != syncStatusApplied || again.StatusCode != http.StatusOK {
+		t.Errorf("repeated create = %s %d (%s), want applied 200", again.Status, again.StatusCode, again.Error)
+	}
+	if again.ID != first.ID || again.Article == nil || again.Article.ID != first.ID {
+		t.Errorf("repeated create answered article %d, want %d", again.ID, first.ID)
+	}
+
+	other := syncChange(t, user.ID, syncClientChange{Op: batchCreate, ClientID: "note-2", Title: "Offline", Content: "written offline"})
+	if other.ID == first.ID {
+		t.Error("another client ID returned the same article")
+	}
+	withoutID := syncChange(t, user.ID, syncClientChange{Op: batchCreate, Title: "Offline"})
+	withoutIDAgain := syncChange(t, user.ID, syncClientChange{Op: batchCreate, Title: "Offline"})
+	if withoutID.ID == withoutIDAgain.ID {
+		t.Error("creates without a client ID were merged")
+	}
+}
+
+func TestApplySyncChangeConflict(t *testing.T) {
+	testDatabase(t)
+	user := testUser(t)
+	id := testArticle(t, user.ID, "Shared")
+
+	update := syncChange(t, user.ID, syncClientChange{Op: batchUpdate, ID: id, BaseVersion: 1, Title: "First", Content: "first"})
+	if update.Status != syncStatusApplied || update.Article == nil || update.Article.Version != 2 {
+		t.Fatalf("update = %s %d (%s), want applied at version 2", update.Status, update.StatusCode, update.Error)
+	}
+
+	tests := []struct {
+		name   string
+		change syncClientChange
+	}{
+		{"update of an older version", syncClientChange{Op: batchUpdate, ID: id, BaseVersion: 1, Title: "Second", Content: "second"}},
+		{"delete of an older version", syncClientChange{Op: batchDelete, ID: id, BaseVersion: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := syncChange(t, user.ID, tt.change)
+			if result.Status != syncStatusConflict || result.StatusCode != http.StatusConflict {
+				t.Fatalf("result = %s %d (%s), want conflict 409", result.Status, result.StatusCode, result.Error)
+			}
+			if result.Client == nil || result.Client.BaseVersion != 1 {
+				t.Error("conflict does not carry the client's change")
+			}
+			if result.Server == nil || result.Server.Version != 2 || result.Server.Title != "First" || result.ServerDeleted {
+				t.Errorf("conflict server version = %+v, want the article at version 2", result.Server)
+			}
+		})
+	}
+}
+
+func TestApplySyncChangeDeleted(t *testing.T) {
+	testDatabase(t)
+	user := testUser(t)
+	id := testArticle(t, user.ID, "Doomed")
+
+	deleted := syncChange(t, user.ID, syncClientChange{Op: batchDelete, ID: id, BaseVersion: 1})
+	if deleted.Status != syncStatusApplied || deleted.StatusCode != http.StatusOK {
+		t.Fatalf("delete = %s %d (%s), want applied 200", deleted.Status, deleted.StatusCode, deleted.Error)
+	}
+
+	tests := []struct {
+		name        string
+		change      syncClientChange
+		wantStatus  string
+		wantCode    int
+		wantDeleted bool
+	}{
+		{"deleted again", syncClientChange{Op: batchDelete, ID: id, BaseVersion: 1},
+			syncStatusApplied, http.StatusOK, false},
+		{"updated after the delete", syncClientChange{Op: batchUpdate, ID: id, BaseVersion: 1, Title: "Edited", Content: "edited"},
+			syncStatusConflict, http.StatusConflict, true},
+		{"delete of an unknown article", syncClientChange{Op: batchDelete, ID: id + 1000000, BaseVersion: 1},
+			syncStatusError, http.StatusNotFound, false},
+		{"update of an unknown article", syncClientChange{Op: batchUpdate, ID: id + 1000000, BaseVersion: 1, Title: "t", Content: "c"},
+			syncStatusError, http.StatusNotFound, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := syncChange(t, user.ID, tt.change)
+			if result.Status != tt.wantStatus || result.StatusCode != tt.wantCode {
+				t.Fatalf("result = %s %d (%s), want %s %d", result.Status, result.StatusCode, result.Error, tt.wantStatus, tt.wantCode)
+			}
+			if result.ServerDeleted != tt.wantDeleted {
+				t.Errorf("server_deleted = %v, want %v", result.ServerDeleted, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestGetSyncChangesPaging(t *testing.T) {
+	testDatabase(t)
+	user := testUser(t)
+	for _, title := range []string{"one", "two", "three"} {
+		testArticle(t, user.ID, title)
+	}
+	token, err := generateJWT(user)
+	if err != nil {
+		t.Fatalf("generateJWT: %v", err)
+	}
+
+	type page struct {
+		Seq     int64               `json:"seq"`
+		HasMore bool                `json:"has_more"`
+		Changes []models.SyncChange `json:"changes"`
+	}
+	get := func(since int64, limit int) (int, page) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/sync?since=%d&limit=%d", since, limit), nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		SyncHandler(w, r)
+		var p page
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+		}
+		return w.Code, p
+	}
+
+	_, all := get(0, 3)
+	if len(all.Changes) != 3 || all.HasMore {
+		t.Fatalf("limit 3 = %d changes, has_more %v; want 3 without more", len(all.Changes), all.HasMore)
+	}
+	current := all.Seq
+
+	_, first := get(0, 2)
+	if len(first.Changes) != 2 || !first.HasMore || first.Seq != first.Changes[1].Seq {
+		t.Fatalf("first page = %d changes, has_more %v, seq %d; want 2 with more, seq of the second",
+			len(first.Changes), first.HasMore, first.Seq)
+	}
+	_, second := get(first.Seq, 2)
+	if len(second.Changes) != 1 || second.HasMore || second.Seq != current {
+		t.Fatalf("second page = %d changes, has_more %v, seq %d; want 1 without more, seq %d",
+			len(second.Changes), second.HasMore, second.Seq, current)
+	}
+	if second.Changes[0].Article == nil || second.Changes[0].Article.Title != "three" {
+		t.Errorf("second page holds %+v, want the third article", second.Changes[0])
+	}
+	_, empty := get(current, 2)
+	if empty.Changes == nil || len(empty.Changes) != 0 || empty.HasMore || empty.Seq != current {
+		t.Errorf("caught up = %d changes, has_more %v, seq %d; want none, seq %d",
+			len(empty.Changes), empty.HasMore, empty.Seq, current)
+	}
+
+	if code, _ := get(current+1, 2); code != http.StatusBadRequest {
+		t.Errorf("since ahead of the feed = %d, want 400", code)
+	}
+}
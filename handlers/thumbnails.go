@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"personalnote.eu/simple-go-api/imaging"
+	"personalnote.eu/simple-go-api/models"
+	"personalnote.eu/simple-go-api/storage"
+	"personalnote.eu/simple-go-api/utils"
+)
+
+// thumbnailQueue holds IDs of attachments waiting for thumbnails. When it is full
+// the periodic scan picks the attachment up instead.
+var thumbnailQueue = make(chan int, 256)
+
+// thumbnailScanInterval is how often attachments left pending are looked for
+const thumbnailScanInterval = 5 * time.Minute
+
+// thumbnailRetryAfter is suggested to clients asking for a thumbnail that is not ready yet
+const thumbnailRetryAfter = "2"
+
+func initThumbnails() {
+	utils.Go("thumbnails", runThumbnailWorker)
+}
+
+// enqueueThumbnail schedules thumbnail generation for an image attachment
+func enqueueThumbnail(ctx context.Context, attachmentID int) {
+	select {
+	case thumbnailQueue <- attachmentID:
+	default:
+		slog.WarnContext(ctx, "thumbnail queue full, deferring to periodic scan", "attachment_id", attachmentID)
+	}
+}
+
+// runThumbnailWorker generates thumbnails one attachment at a time. Attachments still
+// pending from before a restart, or dropped from a full queue, are found by a periodic scan.
+func runThumbnailWorker(ctx context.Context) {
+	ticker := time.NewTicker(thumbnailScanInterval)
+	defer ticker.Stop()
+
+	scanPendingThumbnails(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-thumbnailQueue:
+			generateThumbnails(ctx, id)
+		case <-ticker.C:
+			scanPendingThumbnails(ctx)
+		}
+	}
+}
+
+// scanPendingThumbnails processes attachments whose thumbnails are still pending
+func scanPendingThumbnails(ctx context.Context) {
+	pending, err := utils.GetAttachmentsByThumbnailStatus(ctx, models.ThumbnailStatusPending, 100)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to load pending thumbnails", "error", err)
+		return
+	}
+	for _, attachment := range pending {
+		if ctx.Err() != nil {
+			return
+		}
+		generateThumbnails(ctx, attachment.ID)
+	}
+}
+
+// generateThumbnails renders every configured thumbnail size of an attachment and stores
+// them in the same backend as the original. Failures mark the attachment as failed;
+// shutdown leaves it pending so it is retried on the next start.
+func generateThumbnails(ctx context.Context, attachmentID int) {
+	// Attachments deleted or already processed in the meantime are skipped
+	attachment, err := utils.GetAttachmentByThumbnailStatus(ctx, attachmentID, models.ThumbnailStatusPending)
+	if err != nil {
+		if !strings.Contains(err.Error(), "not found") {
+			slog.WarnContext(ctx, "failed to load attachment for thumbnails", "attachment_id", attachmentID, "error", err)
+		}
+		return
+	}
+
+	status := models.ThumbnailStatusReady
+	if err := renderThumbnails(ctx, attachment); err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		slog.WarnContext(ctx, "failed to generate thumbnails", "attachment_id", attachmentID, "error", err)
+		status = models.ThumbnailStatusFailed
+	}
+
+	if err := utils.UpdateThumbnailStatus(context.WithoutCancel(ctx), attachmentID, status); err == nil {
+		slog.InfoContext(ctx, "generated thumbnails", "attachment_id", attachmentID, "status", status)
+	}
+}
+
+func renderThumbnails(ctx context.Context, attachment *models.Attachment) error {
+	if attachment.Size > appConfig.Images.MaxBytes {
+		return fmt.Errorf("image is larger than %s", formatBytes(appConfig.Images.MaxBytes))
+	}
+
+	store, err := storeFor(ctx, attachment.Backend, attachment.UserID)
+	if err != nil {
+		return err
+	}
+
+	reader, err := store.Get(ctx, attachment.RemoteID, storage.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to read original: %w", err)
+	}
+	data, err := io.ReadAll(io.LimitReader(reader, appConfig.Images.MaxBytes+1))
+	reader.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read original: %w", err)
+	}
+
+	for _, size := range appConfig.Images.ThumbnailSizes {
+		thumb, err := imaging.MakeThumbnail(data, size)
+		if err != nil {
+			return err
+		}
+
+		name := fmt.Sprintf("%s.thumb-%d.%s", attachment.Name, size, strings.TrimPrefix(thumb.ContentType, "image/"))
+		object, err := store.Put(ctx, name, bytes.NewReader(thumb.Data), storage.PutOptions{
+			ContentType: thumb.ContentType,
+			Size:        int64(len(thumb.Data)),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to store %dpx thumbnail: %w", size, err)
+		}
+
+		err = utils.SaveThumbnail(ctx, &models.Thumbnail{
+			AttachmentID: attachment.ID,
+			Size:         size,
+			Backend:      store.Name(),
+			RemoteID:     object.ID,
+			MimeType:     thumb.ContentType,
+			Width:        thumb.Width,
+			Height:       thumb.Height,
+			Bytes:        int64(len(thumb.Data)),
+		})
+		if err != nil {
+			if delErr := store.Delete(context.WithoutCancel(ctx), object.ID); delErr != nil {
+				slog.WarnContext(ctx, "failed to remove orphaned thumbnail", "file_id", object.ID, "error", delErr)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// prepareImage recognises uploaded images and, when enabled, returns a copy of the
+// content without EXIF/XMP metadata. Images whose metadata cannot be removed are re-encoded
+// or, failing that, refused with a *fileTypeError. It reports the detected image format, or
+// "" for other files and images too large to process, which are passed through unchanged.
+func prepareImage(ctx context.Context, content io.ReadSeeker) (io.ReadSeeker, string, error) {
+	header := make([]byte, 16)
+	n, err := io.ReadFull(content, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, "", fmt.Errorf("failed to read upload: %v", err)
+	}
+	format := imaging.DetectFormat(header[:n])
+
+	size, err := content.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = content.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to rewind upload: %v", err)
+	}
+	if format == "" || size > appConfig.Images.MaxBytes {
+		return content, "", nil
+	}
+	if !appConfig.Images.StripMetadata {
+		return content, format, nil
+	}
+
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read upload: %v", err)
+	}
+	stripped, err := imaging.StripMetadata(data, format)
+	if err != nil {
+		// Never fall back to the original, which may carry a GPS position
+		slog.WarnContext(ctx, "failed to strip image metadata, re-encoding", "format", format, "error", err)
+		stripped, err = imaging.Reencode(data, format)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to re-encode image", "format", format, "error", err)
+			return nil, "", &fileTypeError{fmt.Sprintf("The %s image could not be read to remove its metadata", strings.ToUpper(format))}
+		}
+	}
+	if removed := len(data) - len(stripped); removed > 0 {
+		slog.DebugContext(ctx, "stripped image metadata", "format", format, "bytes_removed", removed)
+	}
+	return bytes.NewReader(stripped), format, nil
+}
+
+// FileThumbnailHandler serves a thumbnail of an image file owned by the caller.
+// ?size= picks the smallest generated thumbnail at least that large (default 256),
+// or the largest one when none is. Thumbnails still being generated answer 202.
+func FileThumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		utils.SendErrorResponse(w, http.StatusMethodNotAllowed,
+			"Method not allowed", "Only GET and HEAD requests are accepted")
+		return
+	}
+
+	userID, authenticated := checkAuth(w, r)
+	if !authenticated {
+		return
+	}
+
+	size := 256
+	if raw := r.URL.Query().Get("size"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			utils.SendErrorResponse(w, http.StatusBadRequest,
+				"Invalid size", "size must be a positive integer")
+			return
+		}
+		size = n
+	}
+
+	// Expected format: /files/{fileId}/thumbnail
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	ref := parts[1]
+
+	ctx := r.Context()
+	attachment, err := findAttachment(ctx, ref, userID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			utils.SendErrorResponse(w, http.StatusNotFound,
+				"File not found", fmt.Sprintf("File %s not found", ref))
+		} else {
+			slog.ErrorContext(ctx, "failed to fetch file", "file_id", ref, "error", err)
+			utils.SendErrorResponse(w, http.StatusInternalServerError,
+				"Database error", "Failed to retrieve file from database")
+		}
+		return
+	}
+
+	if !checkScanned(w, attachment) {
+		return
+	}
+
+	switch {
+	case attachment.ThumbnailStatus == nil:
+		utils.SendErrorResponse(w, http.StatusNotFound,
+			"Thumbnail not available", "Thumbnails are only generated for JPEG, PNG, GIF and WebP images")
+		return
+	case *attachment.ThumbnailStatus == models.ThumbnailStatusPending:
+		w.Header().Set("Retry-After", thumbnailRetryAfter)
+		utils.SendJSONResponse(w, http.StatusAccepted, map[string]interface{}{
+			"status":  models.ThumbnailStatusPending,
+			"message": "Thumbnail is being generated",
+		})
+		return
+	case *attachment.ThumbnailStatus == models.ThumbnailStatusFailed:
+		utils.SendErrorResponse(w, http.StatusNotFound,
+			"Thumbnail not available", "The image could not be processed")
+		return
+	}
+
+	thumbnails, err := utils.GetThumbnails(ctx, attachment.ID)
+	if err != nil {
+		utils.SendErrorResponse(w, http.StatusInternalServerError,
+			"Database error", "Failed to retrieve thumbnail from database")
+		return
+	}
+	if len(thumbnails) == 0 {
+		utils.SendErrorResponse(w, http.StatusNotFound,
+			"Thumbnail not available", "No thumbnails exist for this file")
+		return
+	}
+	thumb := thumbnails[len(thumbnails)-1]
+	for _, t := range thumbnails {
+		if t.Size >= size {
+			thumb = t
+			break
+		}
+	}
+
+	store, err := storeFor(ctx, thumb.Backend, userID)
+	if err != nil {
+		utils.SendErrorResponse(w, http.StatusConflict,
+			"Storage unavailable", fmt.Sprintf("Files stored in %s cannot be served by this server", thumb.Backend))
+		return
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", thumb.MimeType)
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Cache-Control", "private, max-age=86400")
+	header.Set("ETag", fmt.Sprintf(`"%s-%d"`, attachment.SHA256, thumb.Size))
+
+	var modTime time.Time
+	if attachment.Created != nil {
+		modTime = *attachment.Created
+	}
+
+	content := storage.NewReadSeeker(ctx, store, thumb.RemoteID, thumb.Bytes)
+	defer content.Close()
+
+	http.ServeContent(w, r, "", modTime, content)
+}
+
+// deleteThumbnails removes the stored thumbnails of an attachment; their records go
+// with the attachment. Failures are logged, as leftover thumbnails are harmless.
+func deleteThumbnails(ctx context.Context, store storage.BlobStore, attachmentID int) {
+	thumbnails, err := utils.GetThumbnails(ctx, attachmentID)
+	if err != nil {
+		return
+	}
+	for _, thumb := range thumbnails {
+		if err := store.Delete(ctx, thumb.RemoteID); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			slog.WarnContext(ctx, "failed to delete thumbnail", "attachment_id", attachmentID, "file_id", thumb.RemoteID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,615 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"personalnote.eu/simple-go-api/filetype"
+	"personalnote.eu/simple-go-api/models"
+	"personalnote.eu/simple-go-api/utils"
+)
+
+// tus protocol constants (https://tus.io/protocols/resumable-upload)
+const (
+	tusVersion     = "1.0.0"
+	tusExtensions  = "creation,termination,expiration"
+	tusContentType = "application/offset+octet-stream"
+)
+
+// uploadJanitorInterval is how often expired partial uploads are removed
+const uploadJanitorInterval = time.Hour
+
+// activeUploads holds the IDs of uploads currently being written, so that
+// concurrent PATCH requests for the same upload cannot interleave
+var activeUploads = struct {
+	sync.Mutex
+	ids map[string]bool
+}{ids: map[string]bool{}}
+
+// initUploads prepares the partial upload directory and starts the janitor that resumes
+// interrupted processing and removes expired uploads
+func initUploads() {
+	if err := os.MkdirAll(appConfig.Uploads.Dir, 0o750); err != nil {
+		slog.Error("failed to create uploads directory", "dir", appConfig.Uploads.Dir, "error", err)
+		return
+	}
+	utils.Go("upload-janitor", runUploadJanitor)
+}
+
+// TusHandler implements the tus 1.0 core protocol with the creation, termination and
+// expiration extensions under /uploads/. GET /uploads/{id} additionally reports the
+// processing status and resulting attachment of an upload as JSON.
+func TusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	// Expected format: /uploads/ or /uploads/{id}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) > 2 {
+		utils.SendErrorResponse(w, http.StatusNotFound, "Not found", "Expected format: /uploads/{id}")
+		return
+	}
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(appConfig.Uploads.MaxSize, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		utils.SendErrorResponse(w, http.StatusPreconditionFailed,
+			"Unsupported tus version", "Tus-Resumable must be "+tusVersion)
+		return
+	}
+
+	if len(parts) == 1 {
+		if r.Method != http.MethodPost {
+			utils.SendErrorResponse(w, http.StatusMethodNotAllowed,
+				"Method not allowed", "Only POST requests are accepted")
+			return
+		}
+		CreateUploadHandler(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead:
+		UploadOffsetHandler(w, r)
+	case http.MethodPatch:
+		UploadChunkHandler(w, r)
+	case http.MethodDelete:
+		TerminateUploadHandler(w, r)
+	case http.MethodGet:
+		UploadStatusHandler(w, r)
+	default:
+		utils.SendErrorResponse(w, http.StatusMethodNotAllowed,
+			"Method not allowed", fmt.Sprintf("Method %s is not supported for this endpoint", r.Method))
+	}
+}
+
+// CreateUploadHandler starts a resumable upload (tus creation extension).
+// Upload-Metadata may carry filename, filetype and article_id.
+func CreateUploadHandler(w http.ResponseWriter, r *http.Request) {
+	userID, authenticated := checkAuth(w, r)
+	if !authenticated {
+		return
+	}
+
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Invalid request", "Upload-Defer-Length is not supported")
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Invalid request", "Upload-Length must be a non-negative integer")
+		return
+	}
+	if length > appConfig.Uploads.MaxSize {
+		utils.SendErrorResponse(w, http.StatusRequestEntityTooLarge,
+			"Upload too large", fmt.Sprintf("Uploads are limited to %d bytes", appConfig.Uploads.MaxSize))
+		return
+	}
+
+	rawMetadata := r.Header.Get("Upload-Metadata")
+	metadata, err := parseUploadMetadata(rawMetadata)
+	if err != nil {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Invalid request", "Upload-Metadata is malformed")
+		return
+	}
+
+	articleID, ok := resolveUploadArticle(w, r, metadata["article_id"], userID)
+	if !ok {
+		return
+	}
+
+	// Reject uploads that cannot fit before any data is sent; the check is repeated once complete
+	if err := checkQuota(r.Context(), userID, length); err != nil {
+		var quotaErr *quotaError
+		if errors.As(err, &quotaErr) {
+			utils.SendErrorResponse(w, http.StatusRequestEntityTooLarge, "Quota exceeded", quotaErr.Error())
+		} else {
+			utils.SendErrorResponse(w, http.StatusInternalServerError, "Database error", "Failed to check storage quota")
+		}
+		return
+	}
+
+	// Refuse file types that are never accepted before any data is sent; the content is checked once complete
+	name := filetype.SanitizeName(metadata["filename"])
+	if _, err := checkDeclaredFileType(name, metadata["filetype"]); err != nil {
+		utils.SendErrorResponse(w, http.StatusUnsupportedMediaType, "Unsupported file type", err.Error())
+		return
+	}
+
+	ctx := r.Context()
+	upload := &models.Upload{
+		ID:        newUploadID(),
+		UserID:    userID,
+		ArticleID: articleID,
+		Name:      name,
+		MimeType:  metadata["filetype"],
+		Metadata:  rawMetadata,
+		Length:    length,
+	}
+
+	part, err := os.OpenFile(uploadPartPath(upload.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to create upload file", "upload_id", upload.ID, "error", err)
+		utils.SendErrorResponse(w, http.StatusInternalServerError,
+			"Upload error", "Failed to create upload")
+		return
+	}
+	part.Close()
+
+	if err := utils.CreateUpload(ctx, upload); err != nil {
+		os.Remove(uploadPartPath(upload.ID))
+		utils.SendErrorResponse(w, http.StatusInternalServerError,
+			"Database error", "Failed to create upload")
+		return
+	}
+
+	// Empty files are complete as soon as they are created
+	if length == 0 {
+		if err := startUploadProcessing(ctx, upload); err != nil {
+			utils.SendErrorResponse(w, http.StatusInternalServerError,
+				"Database error", "Failed to complete upload")
+			return
+		}
+	} else {
+		w.Header().Set("Upload-Expires", uploadExpires(time.Now()))
+	}
+
+	w.Header().Set("Location", "/uploads/"+upload.ID)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// UploadOffsetHandler reports how many bytes of an upload have been received
+func UploadOffsetHandler(w http.ResponseWriter, r *http.Request) {
+	upload, ok := loadUpload(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if upload.Metadata != "" {
+		w.Header().Set("Upload-Metadata", upload.Metadata)
+	}
+	if upload.Status == models.UploadStatusUploading && upload.Updated != nil {
+		w.Header().Set("Upload-Expires", uploadExpires(*upload.Updated))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// UploadChunkHandler appends the request body to an upload at Upload-Offset.
+// Bytes are synced to disk before the new offset is persisted, so a restarted
+// server never reports more than it actually holds.
+func UploadChunkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != tusContentType {
+		utils.SendErrorResponse(w, http.StatusUnsupportedMediaType,
+			"Invalid content type", "Content-Type must be "+tusContentType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Invalid request", "Upload-Offset must be a non-negative integer")
+		return
+	}
+
+	upload, ok := loadUpload(w, r)
+	if !ok {
+		return
+	}
+
+	if !lockUpload(upload.ID) {
+		utils.SendErrorResponse(w, http.StatusLocked,
+			"Upload locked", "Another request is writing to this upload")
+		return
+	}
+	defer unlockUpload(upload.ID)
+
+	// Another request may have written to the upload since it was loaded
+	if upload, ok = reloadUpload(w, r, upload); !ok {
+		return
+	}
+	if upload.Status != models.UploadStatusUploading {
+		utils.SendErrorResponse(w, http.StatusConflict,
+			"Upload complete", "The upload has already received all of its data")
+		return
+	}
+	if offset != upload.Offset {
+		utils.SendErrorResponse(w, http.StatusConflict,
+			"Offset mismatch", fmt.Sprintf("Upload-Offset must be %d", upload.Offset))
+		return
+	}
+
+	// Large chunks take longer than the server read timeout allows for ordinary requests
+	if err := http.NewResponseController(w).SetReadDeadline(time.Now().Add(appConfig.Uploads.ChunkTimeout)); err != nil {
+		slog.DebugContext(r.Context(), "could not extend read deadline for upload chunk", "error", err)
+	}
+
+	ctx := r.Context()
+	written, copyErr := appendUploadChunk(upload, r.Body)
+	if errors.Is(copyErr, errUploadTooLong) {
+		utils.SendErrorResponse(w, http.StatusRequestEntityTooLarge,
+			"Upload too large", "The request body exceeds the remaining Upload-Length")
+		return
+	}
+
+	// Record whatever reached the disk, even when the client went away mid-chunk
+	upload.Offset += written
+	if err := utils.UpdateUploadOffset(context.WithoutCancel(ctx), upload.ID, upload.Offset); err != nil {
+		utils.SendErrorResponse(w, http.StatusInternalServerError,
+			"Database error", "Failed to record upload progress")
+		return
+	}
+	if copyErr != nil {
+		slog.WarnContext(ctx, "upload chunk interrupted", "upload_id", upload.ID, "offset", upload.Offset, "error", copyErr)
+		utils.SendErrorResponse(w, http.StatusInternalServerError,
+			"Upload interrupted", "The chunk was only partially received; resume from the current offset")
+		return
+	}
+
+	if upload.Offset == upload.Length {
+		if err := startUploadProcessing(ctx, upload); err != nil {
+			utils.SendErrorResponse(w, http.StatusInternalServerError,
+				"Database error", "Failed to complete upload")
+			return
+		}
+	} else {
+		w.Header().Set("Upload-Expires", uploadExpires(time.Now()))
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TerminateUploadHandler discards an unfinished upload (tus termination extension)
+func TerminateUploadHandler(w http.ResponseWriter, r *http.Request) {
+	upload, ok := loadUpload(w, r)
+	if !ok {
+		return
+	}
+
+	if !lockUpload(upload.ID) {
+		utils.SendErrorResponse(w, http.StatusLocked,
+			"Upload locked", "Another request is writing to this upload")
+		return
+	}
+	defer unlockUpload(upload.ID)
+
+	// A chunk finishing since the upload was loaded may have started processing
+	if upload, ok = reloadUpload(w, r, upload); !ok {
+		return
+	}
+	if upload.Status == models.UploadStatusProcessing {
+		utils.SendErrorResponse(w, http.StatusConflict,
+			"Upload processing", "The upload is being stored and cannot be terminated")
+		return
+	}
+
+	if err := removeUpload(r.Context(), upload.ID); err != nil {
+		utils.SendErrorResponse(w, http.StatusInternalServerError,
+			"Database error", "Failed to terminate upload")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UploadStatusHandler returns an upload's progress, status and resulting attachment
+func UploadStatusHandler(w http.ResponseWriter, r *http.Request) {
+	upload, ok := loadUpload(w, r)
+	if !ok {
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, upload)
+}
+
+// loadUpload authenticates the request and fetches the caller's upload named in the path
+func loadUpload(w http.ResponseWriter, r *http.Request) (*models.Upload, bool) {
+	userID, authenticated := checkAuth(w, r)
+	if !authenticated {
+		return nil, false
+	}
+
+	id := strings.TrimPrefix(strings.Trim(r.URL.Path, "/"), "uploads/")
+	if !validUploadID(id) {
+		utils.SendErrorResponse(w, http.StatusNotFound,
+			"Upload not found", fmt.Sprintf("Upload %s not found", id))
+		return nil, false
+	}
+
+	upload, err := utils.GetUploadByID(r.Context(), id, userID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			utils.SendErrorResponse(w, http.StatusNotFound,
+				"Upload not found", fmt.Sprintf("Upload %s not found", id))
+		} else {
+			utils.SendErrorResponse(w, http.StatusInternalServerError,
+				"Database error", "Failed to retrieve upload from database")
+		}
+		return nil, false
+	}
+
+	if upload.Status == models.UploadStatusUploading && upload.Updated != nil &&
+		time.Since(*upload.Updated) > appConfig.Uploads.Expiry {
+		utils.SendErrorResponse(w, http.StatusGone,
+			"Upload expired", fmt.Sprintf("Upload %s has expired", id))
+		return nil, false
+	}
+
+	return upload, true
+}
+
+// reloadUpload fetches the current state of an upload once its lock is held
+func reloadUpload(w http.ResponseWriter, r *http.Request, upload *models.Upload) (*models.Upload, bool) {
+	current, err := utils.GetUploadByID(r.Context(), upload.ID, upload.UserID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			utils.SendErrorResponse(w, http.StatusNotFound,
+				"Upload not found", fmt.Sprintf("Upload %s not found", upload.ID))
+		} else {
+			utils.SendErrorResponse(w, http.StatusInternalServerError,
+				"Database error", "Failed to retrieve upload from database")
+		}
+		return nil, false
+	}
+	return current, true
+}
+
+// errUploadTooLong is returned when a chunk would grow an upload past its declared length
+var errUploadTooLong = errors.New("chunk exceeds upload length")
+
+// appendUploadChunk writes body to the upload's partial file at its current offset and
+// returns the number of bytes durably written
+func appendUploadChunk(upload *models.Upload, body io.Reader) (int64, error) {
+	part, err := os.OpenFile(uploadPartPath(upload.ID), os.O_WRONLY, 0)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open upload file: %v", err)
+	}
+	defer part.Close()
+
+	// Drop bytes from an earlier request that were written but never recorded
+	if err := part.Truncate(upload.Offset); err != nil {
+		return 0, fmt.Errorf("failed to truncate upload file: %v", err)
+	}
+	if _, err := part.Seek(upload.Offset, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to seek upload file: %v", err)
+	}
+
+	remaining := upload.Length - upload.Offset
+	written, copyErr := io.Copy(part, io.LimitReader(body, remaining+1))
+	if written > remaining {
+		part.Truncate(upload.Offset)
+		return 0, errUploadTooLong
+	}
+
+	if err := part.Sync(); err != nil {
+		return 0, fmt.Errorf("failed to sync upload file: %v", err)
+	}
+	return written, copyErr
+}
+
+// startUploadProcessing marks an upload as fully received and pushes it to storage in the background
+func startUploadProcessing(ctx context.Context, upload *models.Upload) error {
+	if err := utils.UpdateUploadStatus(ctx, upload.ID, models.UploadStatusProcessing, nil, ""); err != nil {
+		return err
+	}
+	upload.Status = models.UploadStatusProcessing
+
+	finished := *upload
+	utils.Go("upload-"+upload.ID, func(ctx context.Context) {
+		processUpload(ctx, &finished)
+	})
+	return nil
+}
+
+// processUpload stores a completed upload through the regular upload pipeline and
+// records the resulting attachment. Uploads interrupted by shutdown stay in the
+// processing state and are picked up again on the next start.
+func processUpload(ctx context.Context, upload *models.Upload) {
+	part, err := os.Open(uploadPartPath(upload.ID))
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to open completed upload", "upload_id", upload.ID, "error", err)
+		utils.UpdateUploadStatus(context.WithoutCancel(ctx), upload.ID, models.UploadStatusFailed, nil, "upload data is missing")
+		return
+	}
+	defer part.Close()
+
+	stored, err := storeFile(ctx, upload.UserID, upload.ArticleID, upload.Name, upload.MimeType, part)
+	if err != nil {
+		if ctx.Err() != nil {
+			slog.WarnContext(ctx, "upload processing interrupted, will resume on restart", "upload_id", upload.ID)
+			return
+		}
+		message := "failed to store file"
+		var quotaErr *quotaError
+		var typeErr *fileTypeError
+		var infectedErr *infectedError
+		var unscannableErr *unscannableError
+		var elsewhereErr *attachedElsewhereError
+		switch {
+		case errors.As(err, &quotaErr):
+			message = quotaErr.Error()
+		case errors.As(err, &typeErr):
+			message = typeErr.Error()
+		case errors.As(err, &infectedErr):
+			message = infectedErr.Error()
+		case errors.As(err, &unscannableErr):
+			message = unscannableErr.Error()
+		case errors.As(err, &elsewhereErr):
+			message = elsewhereErr.Error()
+		}
+		utils.UpdateUploadStatus(context.WithoutCancel(ctx), upload.ID, models.UploadStatusFailed, nil, message)
+		return
+	}
+
+	if err := utils.UpdateUploadStatus(ctx, upload.ID, models.UploadStatusComplete, &stored.Attachment.ID, ""); err != nil {
+		return
+	}
+	part.Close()
+	if err := os.Remove(uploadPartPath(upload.ID)); err != nil {
+		slog.WarnContext(ctx, "failed to remove completed upload file", "upload_id", upload.ID, "error", err)
+	}
+}
+
+// runUploadJanitor resumes uploads left in processing by a previous run, then
+// periodically removes uploads that expired before they were completed
+func runUploadJanitor(ctx context.Context) {
+	pending, err := utils.GetUploadsByStatus(ctx, models.UploadStatusProcessing)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to load interrupted uploads", "error", err)
+	}
+	for i := range pending {
+		slog.InfoContext(ctx, "resuming interrupted upload processing", "upload_id", pending[i].ID)
+		processUpload(ctx, &pending[i])
+	}
+
+	ticker := time.NewTicker(uploadJanitorInterval)
+	defer ticker.Stop()
+	for {
+		removeExpiredUploads(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// removeExpiredUploads deletes unfinished uploads that have been idle longer than the expiry
+func removeExpiredUploads(ctx context.Context) {
+	cutoff := time.Now().Add(-appConfig.Uploads.Expiry)
+	for _, status := range []string{models.UploadStatusUploading, models.UploadStatusFailed} {
+		expired, err := utils.GetStaleUploads(ctx, status, cutoff)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to load expired uploads", "error", err)
+			return
+		}
+		for _, upload := range expired {
+			if !lockUpload(upload.ID) {
+				continue
+			}
+			if err := removeUpload(ctx, upload.ID); err == nil {
+				slog.InfoContext(ctx, "removed expired upload", "upload_id", upload.ID, "status", status)
+			}
+			unlockUpload(upload.ID)
+		}
+	}
+}
+
+// removeUpload deletes an upload's partial data and record
+func removeUpload(ctx context.Context, id string) error {
+	if err := os.Remove(uploadPartPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.ErrorContext(ctx, "failed to remove upload file", "upload_id", id, "error", err)
+		return err
+	}
+	return utils.DeleteUpload(ctx, id)
+}
+
+// lockUpload claims exclusive write access to an upload, returning false if it is taken
+func lockUpload(id string) bool {
+	activeUploads.Lock()
+	defer activeUploads.Unlock()
+	if activeUploads.ids[id] {
+		return false
+	}
+	activeUploads.ids[id] = true
+	return true
+}
+
+func unlockUpload(id string) {
+	activeUploads.Lock()
+	defer activeUploads.Unlock()
+	delete(activeUploads.ids, id)
+}
+
+// parseUploadMetadata decodes a tus Upload-Metadata header: comma separated
+// "key base64value" pairs, where the value may be omitted
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		switch len(fields) {
+		case 1:
+			metadata[fields[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for metadata key %s: %v", fields[0], err)
+			}
+			metadata[fields[0]] = string(value)
+		default:
+			return nil, fmt.Errorf("invalid metadata pair %q", pair)
+		}
+	}
+	return metadata, nil
+}
+
+func newUploadID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validUploadID reports whether id has the shape produced by newUploadID
+func validUploadID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+func uploadPartPath(id string) string {
+	return filepath.Join(appConfig.Uploads.Dir, id+".part")
+}
+
+func uploadExpires(lastActivity time.Time) string {
+	return lastActivity.Add(appConfig.Uploads.Expiry).UTC().Format(http.TimeFormat)
+}
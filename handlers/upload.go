@@ -2,20 +2,29 @@ package handlers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
-	"os"
-
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
-	"google.golang.org/api/drive/v3"
-	"google.golang.org/api/option"
+	"strconv"
+	"strings"
 
+	"personalnote.eu/simple-go-api/filetype"
+	"personalnote.eu/simple-go-api/models"
+	"personalnote.eu/simple-go-api/storage"
 	"personalnote.eu/simple-go-api/utils"
 )
 
-// UploadHandler handles file uploads to Google Drive
+// blobStore is the storage backend selected in the configuration
+var blobStore storage.BlobStore
+
+// driveClient is the Drive client shared by all requests, nil when Drive is not configured
+var driveClient storage.DriveClient
+
+// UploadHandler handles file uploads to the configured storage backend and records them as attachments
 func UploadHandler(w http.ResponseWriter, r *http.Request) {
 	// Check authentication
 	userID, authenticated := checkAuth(w, r)
@@ -41,94 +50,354 @@ func UploadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	// Debug logs
-	log.Println("Attempting to initialize Drive service...")
+	ctx := r.Context()
+
+	// Optionally link the upload to one of the user's articles
+	articleID, ok := resolveUploadArticle(w, r, r.FormValue("article_id"), userID)
+	if !ok {
+		return
+	}
+
+	slog.InfoContext(ctx, "uploading file", "file_name", header.Filename, "size", header.Size)
+
+	stored, err := storeFile(ctx, userID, articleID, header.Filename, header.Header.Get("Content-Type"), file)
+	if err != nil {
+		var quotaErr *quotaError
+		var typeErr *fileTypeError
+		var infectedErr *infectedError
+		var unscannableErr *unscannableError
+		var elsewhereErr *attachedElsewhereError
+		switch {
+		case errors.As(err, &quotaErr):
+			utils.SendErrorResponse(w, http.StatusRequestEntityTooLarge, "Quota exceeded", quotaErr.Error())
+		case errors.As(err, &elsewhereErr):
+			utils.SendJSONResponse(w, http.StatusConflict, map[string]interface{}{
+				"error":        "File already attached",
+				"message":      elsewhereErr.Error(),
+				"attachmentId": elsewhereErr.Attachment.ID,
+				"articleId":    elsewhereErr.Attachment.ArticleID,
+			})
+		case errors.As(err, &typeErr):
+			utils.SendErrorResponse(w, http.StatusUnsupportedMediaType, "Unsupported file type", typeErr.Error())
+		case errors.As(err, &infectedErr):
+			utils.SendErrorResponse(w, http.StatusUnprocessableEntity, "Infected file", infectedErr.Error())
+		case errors.As(err, &unscannableErr):
+			utils.SendErrorResponse(w, http.StatusUnprocessableEntity, "File not scanned", unscannableErr.Error())
+		case errors.Is(err, storage.ErrNotConfigured):
+			utils.SendErrorResponse(w, http.StatusInternalServerError, "Configuration error", "File storage is not configured")
+		case errors.Is(err, errRecordAttachment):
+			utils.SendErrorResponse(w, http.StatusInternalServerError, "Database error", "Failed to record uploaded file")
+		default:
+			utils.SendErrorResponse(w, http.StatusInternalServerError, "Upload error", "Failed to upload file")
+		}
+		return
+	}
+
+	// Return success response; direct links are only handed out for files scanned clean
+	attachment := stored.Attachment
+	link := stored.Object.Link
+	if attachment.ScanStatus != models.ScanStatusClean {
+		link = ""
+	}
+	response := map[string]interface{}{
+		"message":      "File uploaded successfully",
+		"attachmentId": attachment.ID,
+		"articleId":    attachment.ArticleID,
+		"backend":      attachment.Backend,
+		"fileId":       stored.Object.ID,
+		"name":         stored.Object.Name,
+		"mimeType":     attachment.MimeType,
+		"size":         attachment.Size,
+		"sha256":       attachment.SHA256,
+		"scanStatus":   attachment.ScanStatus,
+		"webViewLink":  link,
+		"deduplicated": stored.Deduplicated,
+	}
+
+	if stored.Deduplicated {
+		response["message"] = "File already uploaded"
+		utils.SendJSONResponse(w, http.StatusOK, response)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusCreated, response)
+}
+
+// errRecordAttachment marks uploads that reached the backend but could not be recorded
+var errRecordAttachment = errors.New("failed to record attachment")
+
+// storedFile is the outcome of storeFile
+type storedFile struct {
+	Attachment *models.Attachment
+	Object     *storage.ObjectInfo
+	// Deduplicated is set when the user already had identical content and nothing was uploaded
+	Deduplicated bool
+}
+
+// storeFile sanitizes the file name, checks the file type against the configured policy,
+// strips metadata from images and hashes content. It returns the user's existing file when
+// the content is already stored, otherwise checks the quota, scans for malware, streams
+// content to the user's backend and records it as an attachment, queueing thumbnails for images. It is shared by
+// direct and resumable uploads. The quota and duplicates are checked again when the file is
+// recorded; if it is refused then, or the record cannot be written, the remote object is
+// removed again.
+func storeFile(ctx context.Context, userID int, articleID *int, name, contentType string, content io.ReadSeeker) (*storedFile, error) {
+	name = filetype.SanitizeName(name)
+	types, err := checkFileType(ctx, name, contentType, content)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := uploadStoreFor(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to resolve storage backend", "error", err)
+		return nil, err
+	}
+
+	content, imageFormat, err := prepareImage(ctx, content)
+	if err != nil {
+		return nil, err
+	}
+
+	// Hash before uploading so identical content is found without transferring it
+	hash := sha256.New()
+	size, err := io.Copy(hash, content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload: %v", err)
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind upload: %v", err)
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	if existing, err := findDuplicate(ctx, store, userID, articleID, sum); err != nil || existing != nil {
+		return existing, err
+	}
+
+	if err := checkQuota(ctx, userID, size); err != nil {
+		return nil, err
+	}
+
+	scanStatus, err := scanUpload(ctx, content)
+	if err != nil {
+		return nil, err
+	}
+
+	uploaded, err := store.Put(ctx, name, content, storage.PutOptions{
+		ContentType: types.Effective,
+		Size:        size,
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrNotConfigured) {
+			slog.ErrorContext(ctx, "storage backend credentials not configured", "backend", store.Name())
+		} else {
+			slog.ErrorContext(ctx, "failed to upload file", "backend", store.Name(), "error", err)
+		}
+		return nil, err
+	}
+
+	attachment := &models.Attachment{
+		UserID:           userID,
+		ArticleID:        articleID,
+		Backend:          store.Name(),
+		RemoteID:         uploaded.ID,
+		Name:             name,
+		MimeType:         types.Effective,
+		DeclaredMimeType: types.Declared,
+		DetectedMimeType: types.Detected,
+		Size:             size,
+		SHA256:           sum,
+		ScanStatus:       scanStatus,
+	}
+	if imageFormat != "" && len(appConfig.Images.ThumbnailSizes) > 0 {
+		status := models.ThumbnailStatusPending
+		attachment.ThumbnailStatus = &status
+	}
+	id, duplicate, err := utils.CreateAttachment(ctx, attachment, func(usage *models.Usage) error {
+		return exceedsQuota(usage, size)
+	})
+	if err != nil || duplicate != nil {
+		// A concurrent upload took the quota or stored the same content first
+		if delErr := store.Delete(context.WithoutCancel(ctx), uploaded.ID); delErr != nil {
+			slog.ErrorContext(ctx, "failed to remove orphaned upload", "file_id", uploaded.ID, "error", delErr)
+		}
+	}
+	var quotaErr *quotaError
+	switch {
+	case errors.As(err, &quotaErr):
+		return nil, err
+	case err != nil:
+		slog.ErrorContext(ctx, "failed to record attachment, removed uploaded file", "file_id", uploaded.ID, "error", err)
+		return nil, fmt.Errorf("%w: %v", errRecordAttachment, err)
+	case duplicate != nil:
+		object := &storage.ObjectInfo{ID: duplicate.RemoteID, Name: duplicate.Name, ContentType: duplicate.MimeType, Size: duplicate.Size}
+		return reuseAttachment(ctx, duplicate, object, userID, articleID)
+	}
+	attachment.ID = id
 
-	// Get Service Account credentials
-	// Option 1: From environment variable (JSON content)
-	credsJSON := os.Getenv("GOOGLE_SERVICE_ACCOUNT_JSON")
-	// Option 2: From file path
-	credsFile := os.Getenv("GOOGLE_SERVICE_ACCOUNT_FILE")
+	if attachment.ThumbnailStatus != nil {
+		enqueueThumbnail(ctx, attachment.ID)
+	}
 
-	// Option 3: From Refresh Token (for personal accounts)
-	refreshToken := os.Getenv("GOOGLE_REFRESH_TOKEN")
-	clientID := os.Getenv("GOOGLE_CLIENT_ID")
-	clientSecret := os.Getenv("GOOGLE_CLIENT_SECRET")
+	slog.InfoContext(ctx, "uploaded file", "backend", store.Name(), "file_id", uploaded.ID, "attachment_id", attachment.ID)
+	return &storedFile{Attachment: attachment, Object: uploaded}, nil
+}
 
-	log.Printf("Env var GOOGLE_SERVICE_ACCOUNT_FILE: '%s'", credsFile)
+// findDuplicate returns the user's existing file with the same content in store, linking it
+// to the requested article if it is not attached to one yet. Records whose remote object
+// has disappeared are dropped so the content is uploaded again.
+func findDuplicate(ctx context.Context, store storage.BlobStore, userID int, articleID *int, sum string) (*storedFile, error) {
+	existing, err := utils.GetAttachmentBySHA256(ctx, userID, store.Name(), sum)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return nil, nil
+		}
+		return nil, err
+	}
 
-	var driveService *drive.Service
-	var serviceErr error
+	object, err := store.Stat(ctx, existing.RemoteID)
+	if errors.Is(err, storage.ErrNotFound) {
+		slog.WarnContext(ctx, "dropping attachment whose file no longer exists", "attachment_id", existing.ID, "file_id", existing.RemoteID)
+		if err := utils.DeleteAttachment(ctx, existing.ID, userID); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	} else if err != nil {
+		slog.WarnContext(ctx, "failed to stat duplicate file", "file_id", existing.RemoteID, "error", err)
+		object = &storage.ObjectInfo{ID: existing.RemoteID, Name: existing.Name, ContentType: existing.MimeType, Size: existing.Size}
+	}
 
-	ctx := context.Background()
+	return reuseAttachment(ctx, existing, object, userID, articleID)
+}
+
+// attachedElsewhereError reports an upload for an article whose content the user already
+// attached to another article. Attachments own their remote object, so the file is not
+// shared between articles.
+type attachedElsewhereError struct {
+	Attachment *models.Attachment
+}
 
-	if refreshToken != "" && clientID != "" && clientSecret != "" {
-		log.Println("Using Refresh Token for OAuth 2.0")
-		config := &oauth2.Config{
-			ClientID:     clientID,
-			ClientSecret: clientSecret,
-			Endpoint:     google.Endpoint,
-			Scopes:       []string{drive.DriveFileScope},
+func (e *attachedElsewhereError) Error() string {
+	return fmt.Sprintf("The file is already attached to article %d as attachment %d",
+		*e.Attachment.ArticleID, e.Attachment.ID)
+}
+
+// reuseAttachment returns the user's existing file for an upload of the same content,
+// linking it to the requested article if it is not attached to one yet. Content attached
+// to a different article yields an *attachedElsewhereError.
+func reuseAttachment(ctx context.Context, existing *models.Attachment, object *storage.ObjectInfo, userID int, articleID *int) (*storedFile, error) {
+	if articleID != nil && existing.ArticleID != nil && *existing.ArticleID != *articleID {
+		return nil, &attachedElsewhereError{Attachment: existing}
+	}
+	if articleID != nil && existing.ArticleID == nil {
+		if err := utils.LinkAttachmentToArticle(ctx, existing.ID, userID, *articleID); err != nil {
+			return nil, err
 		}
-		token := &oauth2.Token{RefreshToken: refreshToken}
-		tokenSource := config.TokenSource(ctx, token)
-		driveService, serviceErr = drive.NewService(ctx, option.WithTokenSource(tokenSource))
-	} else if credsJSON != "" {
-		log.Println("Using credsJSON")
-		driveService, serviceErr = drive.NewService(ctx, option.WithCredentialsJSON([]byte(credsJSON)), option.WithScopes(drive.DriveScope))
-	} else if credsFile != "" {
-		log.Printf("Using credsFile: %s", credsFile)
-		// Check if file exists
-		if _, err := os.Stat(credsFile); os.IsNotExist(err) {
-			log.Printf("❌ Credentials file does not exist at path: %s", credsFile)
-			serviceErr = fmt.Errorf("credentials file not found: %s", credsFile)
+		existing.ArticleID = articleID
+	}
+
+	slog.InfoContext(ctx, "upload matches existing file", "attachment_id", existing.ID, "file_id", existing.RemoteID)
+	return &storedFile{Attachment: existing, Object: object, Deduplicated: true}, nil
+}
+
+// resolveUploadArticle parses an optional article ID for an upload and checks the user may edit
+// the article. It writes the error response and returns false when the ID is unusable.
+func resolveUploadArticle(w http.ResponseWriter, r *http.Request, raw string, userID int) (*int, bool) {
+	if raw == "" {
+		return nil, true
+	}
+
+	id, err := strconv.Atoi(raw)
+	if err != nil {
+		utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid article ID", "article_id must be a valid integer")
+		return nil, false
+	}
+	article, err := utils.GetArticleByID(r.Context(), 0, id, userID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			utils.SendErrorResponse(w, http.StatusNotFound, "Article not found", fmt.Sprintf("Article with ID %d not found", id))
 		} else {
-			driveService, serviceErr = drive.NewService(ctx, option.WithCredentialsFile(credsFile), option.WithScopes(drive.DriveScope))
+			slog.ErrorContext(r.Context(), "failed to fetch article for upload", "article_id", id, "error", err)
+			utils.SendErrorResponse(w, http.StatusInternalServerError, "Database error", "Failed to retrieve article from database")
 		}
-	} else {
-		log.Println("❌ Google Service Account credentials not found")
-		utils.SendErrorResponse(w, http.StatusInternalServerError, "Configuration error", "Google Drive integration is not configured")
-		return
+		return nil, false
+	}
+	if article.Role == models.RoleViewer {
+		utils.SendErrorResponse(w, http.StatusForbidden, "Access denied", "Viewers cannot attach files to the article")
+		return nil, false
 	}
+	return &id, true
+}
 
-	if serviceErr != nil {
-		log.Printf("❌ Failed to create Drive service: %v", serviceErr)
-		utils.SendErrorResponse(w, http.StatusInternalServerError, "Service error", "Failed to connect to Google Drive")
+// BlobHandler serves files of the local storage backend through the signed URLs handed
+// out by /files/{id}/link, with the same checks as /files/{id}/content
+func BlobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		utils.SendErrorResponse(w, http.StatusMethodNotAllowed,
+			"Method not allowed", "Only GET and HEAD requests are accepted")
 		return
 	}
 
-	// Create file metadata
-	driveFile := &drive.File{
-		Name: header.Filename,
+	local, ok := blobStore.(*storage.LocalStore)
+	if !ok {
+		http.NotFound(w, r)
+		return
 	}
 
-	folderID := os.Getenv("GOOGLE_DRIVE_FOLDER_ID")
-	if folderID != "" {
-		driveFile.Parents = []string{folderID}
-	} else {
-		log.Println("⚠️ GOOGLE_DRIVE_FOLDER_ID not set. Attempting to upload to root (likely to fail for Service Accounts).")
+	// Expected format: /blobs/{id}?expires=...&signature=...
+	id := strings.TrimPrefix(r.URL.Path, "/blobs/")
+	query := r.URL.Query()
+	if err := local.VerifySignature(id, query.Get("expires"), query.Get("signature")); err != nil {
+		utils.SendErrorResponse(w, http.StatusForbidden, "Access denied", "Invalid or expired link")
+		return
 	}
 
-	// Upload file
-	log.Printf("📤 Uploading file '%s' (Size: %d bytes) for user %d", header.Filename, header.Size, userID)
-
-	uploadedFile, err := driveService.Files.Create(driveFile).Media(file).Do()
+	ctx := r.Context()
+	attachment, err := utils.GetAttachmentByBackendID(ctx, local.Name(), id)
 	if err != nil {
-		log.Printf("❌ Failed to upload file to Drive: %v", err)
-		utils.SendErrorResponse(w, http.StatusInternalServerError, "Upload error", "Failed to upload file to Google Drive")
+		if strings.Contains(err.Error(), "not found") {
+			utils.SendErrorResponse(w, http.StatusNotFound, "File not found", "The requested file does not exist")
+		} else {
+			slog.ErrorContext(ctx, "failed to fetch file", "file_id", id, "error", err)
+			utils.SendErrorResponse(w, http.StatusInternalServerError,
+				"Database error", "Failed to retrieve file from database")
+		}
+		return
+	}
+
+	if !checkScanned(w, attachment) {
 		return
 	}
 
-	log.Printf("✅ File uploaded successfully. ID: %s", uploadedFile.Id)
+	serveAttachment(w, r, local, attachment, "attachment")
+}
 
-	// Return success response
-	response := map[string]interface{}{
-		"message":     "File uploaded successfully",
-		"fileId":      uploadedFile.Id,
-		"name":        uploadedFile.Name,
-		"mimeType":    uploadedFile.MimeType,
-		"webViewLink": uploadedFile.WebViewLink,
+// uploadStoreFor returns where the user's uploads go: their own Drive when they
+// have connected one, otherwise the configured backend
+func uploadStoreFor(ctx context.Context, userID int) (storage.BlobStore, error) {
+	store, err := userDriveStore(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if store != nil {
+		return store, nil
 	}
+	return blobStore, nil
+}
 
-	utils.SendJSONResponse(w, http.StatusCreated, response)
+// storeFor returns the storage backend a user's attachment was written to
+func storeFor(ctx context.Context, backend string, userID int) (storage.BlobStore, error) {
+	if backend == storage.UserDriveBackend {
+		store, err := userDriveStore(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if store == nil {
+			return nil, fmt.Errorf("google drive is no longer connected")
+		}
+		return store, nil
+	}
+	if blobStore != nil && blobStore.Name() == backend {
+		return blobStore, nil
+	}
+	return nil, fmt.Errorf("storage backend %q is not available", backend)
 }
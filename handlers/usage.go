@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"personalnote.eu/simple-go-api/models"
+	"personalnote.eu/simple-go-api/utils"
+)
+
+// quotaError reports an upload that would take the user over their storage quota
+type quotaError struct {
+	message string
+}
+
+func (e *quotaError) Error() string {
+	return e.message
+}
+
+// checkQuota returns a *quotaError if storing size more bytes would exceed the user's limits.
+// It only tells early; utils.CreateAttachment enforces the limits when the file is recorded.
+func checkQuota(ctx context.Context, userID int, size int64) error {
+	limits := appConfig.Quota
+	if limits.MaxBytes == 0 && limits.MaxFiles == 0 {
+		return nil
+	}
+
+	usage, err := utils.GetUsage(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return exceedsQuota(usage, size)
+}
+
+// exceedsQuota returns a *quotaError if adding a file of size bytes to usage would exceed the
+// configured limits
+func exceedsQuota(usage *models.Usage, size int64) error {
+	limits := appConfig.Quota
+	if limits.MaxFiles > 0 && usage.Files >= limits.MaxFiles {
+		return &quotaError{fmt.Sprintf("File limit reached: you already store %d of %d allowed files", usage.Files, limits.MaxFiles)}
+	}
+	if limits.MaxBytes > 0 && usage.Bytes+size > limits.MaxBytes {
+		return &quotaError{fmt.Sprintf("Storage quota exceeded: this %s file would bring your usage to %s of your %s limit (%s free)",
+			formatBytes(size), formatBytes(usage.Bytes+size), formatBytes(limits.MaxBytes), formatBytes(max(limits.MaxBytes-usage.Bytes, 0)))}
+	}
+	return nil
+}
+
+// UsageHandler returns the authenticated user's storage usage and quota
+func UsageHandler(w http.ResponseWriter, r *http.Request) {
+	if !utils.ValidateHTTPMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	userID, authenticated := checkAuth(w, r)
+	if !authenticated {
+		return
+	}
+
+	usage, err := utils.GetUsage(r.Context(), userID)
+	if err != nil {
+		utils.SendErrorResponse(w, http.StatusInternalServerError,
+			"Database error", "Failed to retrieve storage usage")
+		return
+	}
+	usage.MaxBytes = appConfig.Quota.MaxBytes
+	usage.MaxFiles = appConfig.Quota.MaxFiles
+
+	utils.SendJSONResponse(w, http.StatusOK, usage)
+}
+
+// formatBytes renders a byte count in binary units, e.g. 1.5 MiB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,525 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"personalnote.eu/simple-go-api/models"
+	"personalnote.eu/simple-go-api/utils"
+)
+
+// workspaceHeader selects the active workspace of a request
+const workspaceHeader = "X-Workspace-ID"
+
+// maxWorkspaceNameLength is the capacity of the workspace name column
+const maxWorkspaceNameLength = 255
+
+type workspaceKey struct{}
+
+// workspaceArticleRoutes serves the article endpoints inside /workspaces/{id}/
+var workspaceArticleRoutes = newWorkspaceArticleRoutes()
+
+func newWorkspaceArticleRoutes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/articles", ArticlesHandler)
+	mux.HandleFunc("/articles/batch", ArticlesBatchHandler)
+	mux.HandleFunc("/articles/export", ArticlesExportHandler)
+	mux.HandleFunc("/articles/import", ArticlesImportHandler)
+	mux.HandleFunc("/articles/import/", ArticlesImportHandler)
+	mux.HandleFunc("/article/filter/", ArticleFindHandler)
+	mux.HandleFunc("/article/", ArticleHandler)
+	return mux
+}
+
+// activeWorkspace resolves the workspace a request works in: the one in the
+// /workspaces/{id}/ path, else the X-Workspace-ID header, else the user's personal
+// workspace. It writes the error response and returns false when the user is not a
+// member of it.
+func activeWorkspace(w http.ResponseWriter, r *http.Request, userID int) (*models.Workspace, bool) {
+	ctx := r.Context()
+
+	raw, _ := ctx.Value(workspaceKey{}).(string)
+	if raw == "" {
+		raw = r.Header.Get(workspaceHeader)
+	}
+
+	var workspace *models.Workspace
+	var err error
+	if raw == "" {
+		workspace, err = utils.GetPersonalWorkspace(ctx, userID)
+	} else {
+		id, convErr := strconv.Atoi(raw)
+		if convErr != nil {
+			utils.SendErrorResponse(w, http.StatusBadRequest,
+				"Invalid workspace", "Workspace ID must be a valid integer")
+			return nil, false
+		}
+		workspace, err = utils.GetWorkspace(ctx, id, userID)
+	}
+	if err != nil {
+		sendWorkspaceLookupError(w, r, raw, err)
+		return nil, false
+	}
+	return workspace, true
+}
+
+func sendWorkspaceLookupError(w http.ResponseWriter, r *http.Request, id string, err error) {
+	if strings.Contains(err.Error(), "not found") {
+		utils.SendErrorResponse(w, http.StatusNotFound,
+			"Workspace not found", fmt.Sprintf("Workspace %s not found", id))
+		return
+	}
+	slog.ErrorContext(r.Context(), "failed to fetch workspace", "workspace_id", id, "error", err)
+	utils.SendErrorResponse(w, http.StatusInternalServerError,
+		"Database error", "Failed to retrieve workspace from database")
+}
+
+// requireWorkspaceRole writes a 403 response and returns false unless the user holds one
+// of roles in the workspace
+func requireWorkspaceRole(w http.ResponseWriter, workspace *models.Workspace, action string, roles ...string) bool {
+	for _, role := range roles {
+		if workspace.Role == role {
+			return true
+		}
+	}
+	utils.SendErrorResponse(w, http.StatusForbidden,
+		"Access denied", fmt.Sprintf("Your role in this workspace does not allow you to %s", action))
+	return false
+}
+
+// canWriteArticles reports whether a workspace role may create articles
+func canWriteArticles(w http.ResponseWriter, workspace *models.Workspace) bool {
+	return requireWorkspaceRole(w, workspace, "create articles",
+		models.WorkspaceOwner, models.WorkspaceAdmin, models.WorkspaceMember)
+}
+
+// WorkspacesHandler handles /workspaces: GET lists the user's workspaces and POST creates
+// one. /workspaces/{id} shows, renames (PUT) or deletes a workspace, and its members and
+// invites live under /workspaces/{id}/members and /workspaces/{id}/invites. The article
+// endpoints are also served inside a workspace, e.g. /workspaces/{id}/articles.
+func WorkspacesHandler(w http.ResponseWriter, r *http.Request) {
+	// Expected format: /workspaces[/{id}[/{resource}[/{resourceID}]]]
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) >= 3 && (parts[2] == "articles" || parts[2] == "article") {
+		scoped := r.Clone(context.WithValue(r.Context(), workspaceKey{}, parts[1]))
+		scoped.URL.Path = "/" + strings.Join(parts[2:], "/")
+		scoped.URL.RawPath = ""
+		workspaceArticleRoutes.ServeHTTP(w, scoped)
+		return
+	}
+
+	userID, authenticated := checkAuth(w, r)
+	if !authenticated {
+		return
+	}
+
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			listWorkspaces(w, r, userID)
+		case http.MethodPost:
+			createWorkspace(w, r, userID)
+		default:
+			utils.SendErrorResponse(w, http.StatusMethodNotAllowed,
+				"Method not allowed", fmt.Sprintf("Method %s is not supported for this endpoint", r.Method))
+		}
+		return
+	}
+
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Invalid ID", "Workspace ID must be a valid integer")
+		return
+	}
+	workspace, err := utils.GetWorkspace(r.Context(), id, userID)
+	if err != nil {
+		sendWorkspaceLookupError(w, r, parts[1], err)
+		return
+	}
+
+	switch {
+	case len(parts) == 2:
+		workspaceResource(w, r, workspace)
+	case parts[2] == "members" && len(parts) <= 4:
+		workspaceMembers(w, r, workspace, userID, parts[3:])
+	case parts[2] == "invites" && len(parts) <= 4:
+		workspaceInvites(w, r, workspace, userID, parts[3:])
+	default:
+		utils.SendErrorResponse(w, http.StatusNotFound, "Not found",
+			"Expected format: /workspaces/{id}, /workspaces/{id}/members[/{userID}] or /workspaces/{id}/invites[/{inviteID}]")
+	}
+}
+
+func listWorkspaces(w http.ResponseWriter, r *http.Request, userID int) {
+	workspaces, err := utils.GetWorkspaces(r.Context(), userID)
+	if err != nil {
+		utils.SendErrorResponse(w, http.StatusInternalServerError,
+			"Database error", "Failed to retrieve workspaces from database")
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"workspaces": workspaces,
+		"count":      len(workspaces),
+	})
+}
+
+// decodeWorkspaceName reads and validates the name in a workspace request body
+func decodeWorkspaceName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Invalid request body", "Failed to parse JSON")
+		return "", false
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Validation error", "Name is required")
+		return "", false
+	}
+	if len(req.Name) > maxWorkspaceNameLength {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Validation error", fmt.Sprintf("Name must be at most %d bytes", maxWorkspaceNameLength))
+		return "", false
+	}
+	return req.Name, true
+}
+
+func createWorkspace(w http.ResponseWriter, r *http.Request, userID int) {
+	name, ok := decodeWorkspaceName(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := utils.CreateWorkspace(r.Context(), userID, name)
+	if err != nil {
+		utils.SendErrorResponse(w, http.StatusInternalServerError,
+			"Database error", "Failed to create workspace")
+		return
+	}
+
+	workspace, err := utils.GetWorkspace(r.Context(), id, userID)
+	if err != nil {
+		utils.SendErrorResponse(w, http.StatusInternalServerError,
+			"Database error", "Workspace created but failed to retrieve")
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusCreated, workspace)
+}
+
+func workspaceResource(w http.ResponseWriter, r *http.Request, workspace *models.Workspace) {
+	ctx := r.Context()
+
+	switch r.Method {
+	case http.MethodGet:
+		utils.SendJSONResponse(w, http.StatusOK, workspace)
+
+	case http.MethodPut:
+		if !requireWorkspaceRole(w, workspace, "rename it", models.WorkspaceOwner, models.WorkspaceAdmin) {
+			return
+		}
+		name, ok := decodeWorkspaceName(w, r)
+		if !ok {
+			return
+		}
+		if err := utils.RenameWorkspace(ctx, workspace.ID, name); err != nil {
+			utils.SendErrorResponse(w, http.StatusInternalServerError,
+				"Database error", "Failed to rename workspace")
+			return
+		}
+		workspace.Name = name
+		utils.SendJSONResponse(w, http.StatusOK, workspace)
+
+	case http.MethodDelete:
+		if !requireWorkspaceRole(w, workspace, "delete it", models.WorkspaceOwner) {
+			return
+		}
+		if workspace.Personal {
+			utils.SendErrorResponse(w, http.StatusConflict,
+				"Personal workspace", "Your personal workspace cannot be deleted")
+			return
+		}
+		if err := utils.DeleteWorkspace(ctx, workspace.ID); err != nil {
+			if strings.Contains(err.Error(), "still has") {
+				utils.SendErrorResponse(w, http.StatusConflict,
+					"Workspace not empty", "Delete the articles of the workspace first")
+			} else {
+				utils.SendErrorResponse(w, http.StatusInternalServerError,
+					"Database error", "Failed to delete workspace")
+			}
+			return
+		}
+		utils.SendJSONResponse(w, http.StatusOK, map[string]interface{}{
+			"id":      workspace.ID,
+			"message": "Workspace deleted successfully",
+		})
+
+	default:
+		utils.SendErrorResponse(w, http.StatusMethodNotAllowed,
+			"Method not allowed", fmt.Sprintf("Method %s is not supported for this endpoint", r.Method))
+	}
+}
+
+// workspaceMembers handles /workspaces/{id}/members[/{userID}]. Any member can list the
+// members; owners and admins change roles and remove members, and members can leave.
+// Only the owner can make or unmake admins, and the owner cannot be changed.
+func workspaceMembers(w http.ResponseWriter, r *http.Request, workspace *models.Workspace, userID int, rest []string) {
+	ctx := r.Context()
+
+	if len(rest) == 0 {
+		if !utils.ValidateHTTPMethod(w, r, http.MethodGet) {
+			return
+		}
+		members, err := utils.GetWorkspaceMembers(ctx, workspace.ID)
+		if err != nil {
+			utils.SendErrorResponse(w, http.StatusInternalServerError,
+				"Database error", "Failed to retrieve workspace members")
+			return
+		}
+		utils.SendJSONResponse(w, http.StatusOK, map[string]interface{}{
+			"workspace_id": workspace.ID,
+			"members":      members,
+			"count":        len(members),
+		})
+		return
+	}
+
+	memberID, err := strconv.Atoi(rest[0])
+	if err != nil {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Invalid ID", "User ID must be a valid integer")
+		return
+	}
+	member, err := utils.GetWorkspaceMember(ctx, workspace.ID, memberID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			utils.SendErrorResponse(w, http.StatusNotFound,
+				"Member not found", fmt.Sprintf("User %d is not a member of this workspace", memberID))
+		} else {
+			utils.SendErrorResponse(w, http.StatusInternalServerError,
+				"Database error", "Failed to retrieve workspace members")
+		}
+		return
+	}
+	if member.Role == models.WorkspaceOwner {
+		utils.SendErrorResponse(w, http.StatusForbidden,
+			"Access denied", "The owner of a workspace cannot be changed or removed")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		var req struct {
+			Role string `json:"role"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.SendErrorResponse(w, http.StatusBadRequest,
+				"Invalid request body", "Failed to parse JSON")
+			return
+		}
+		if !utils.ValidWorkspaceRole(req.Role) {
+			utils.SendErrorResponse(w, http.StatusBadRequest,
+				"Validation error", "role must be guest, member or admin")
+			return
+		}
+		if !canManageMember(w, workspace, member.Role, req.Role) {
+			return
+		}
+		if err := utils.SetWorkspaceMemberRole(ctx, workspace.ID, memberID, req.Role); err != nil {
+			utils.SendErrorResponse(w, http.StatusInternalServerError,
+				"Database error", "Failed to change the member's role")
+			return
+		}
+		member.Role = req.Role
+		utils.SendJSONResponse(w, http.StatusOK, member)
+
+	case http.MethodDelete:
+		if memberID != userID && !canManageMember(w, workspace, member.Role, "") {
+			return
+		}
+		if err := utils.RemoveWorkspaceMember(ctx, workspace.ID, memberID); err != nil {
+			utils.SendErrorResponse(w, http.StatusInternalServerError,
+				"Database error", "Failed to remove the member")
+			return
+		}
+		utils.SendJSONResponse(w, http.StatusOK, map[string]interface{}{
+			"workspace_id": workspace.ID,
+			"user_id":      memberID,
+			"message":      "Member removed",
+		})
+
+	default:
+		utils.SendErrorResponse(w, http.StatusMethodNotAllowed,
+			"Method not allowed", fmt.Sprintf("Method %s is not supported for this endpoint", r.Method))
+	}
+}
+
+// canManageMember checks the user may change a member from role current to role next ("" to
+// remove them). It writes a 403 response and returns false otherwise.
+func canManageMember(w http.ResponseWriter, workspace *models.Workspace, current, next string) bool {
+	if !requireWorkspaceRole(w, workspace, "manage members", models.WorkspaceOwner, models.WorkspaceAdmin) {
+		return false
+	}
+	if workspace.Role != models.WorkspaceOwner && (current == models.WorkspaceAdmin || next == models.WorkspaceAdmin) {
+		utils.SendErrorResponse(w, http.StatusForbidden,
+			"Access denied", "Only the owner of the workspace can manage admins")
+		return false
+	}
+	return true
+}
+
+// workspaceInvites handles /workspaces/{id}/invites[/{inviteID}]. Owners and admins invite
+// people by email; invitees who already have an account become members right away.
+func workspaceInvites(w http.ResponseWriter, r *http.Request, workspace *models.Workspace, userID int, rest []string) {
+	ctx := r.Context()
+
+	if !requireWorkspaceRole(w, workspace, "manage invites", models.WorkspaceOwner, models.WorkspaceAdmin) {
+		return
+	}
+
+	if len(rest) == 1 {
+		if !utils.ValidateHTTPMethod(w, r, http.MethodDelete) {
+			return
+		}
+		inviteID, err := strconv.Atoi(rest[0])
+		if err != nil {
+			utils.SendErrorResponse(w, http.StatusBadRequest,
+				"Invalid ID", "Invite ID must be a valid integer")
+			return
+		}
+		if err := utils.DeleteWorkspaceInvite(ctx, workspace.ID, inviteID); err != nil {
+			if strings.Contains(err.Error(), "not found") {
+				utils.SendErrorResponse(w, http.StatusNotFound,
+					"Invite not found", fmt.Sprintf("Invite %d not found", inviteID))
+			} else {
+				utils.SendErrorResponse(w, http.StatusInternalServerError,
+					"Database error", "Failed to withdraw the invite")
+			}
+			return
+		}
+		utils.SendJSONResponse(w, http.StatusOK, map[string]interface{}{
+			"workspace_id": workspace.ID,
+			"id":           inviteID,
+			"message":      "Invite withdrawn",
+		})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		invites, err := utils.GetWorkspaceInvites(ctx, workspace.ID)
+		if err != nil {
+			utils.SendErrorResponse(w, http.StatusInternalServerError,
+				"Database error", "Failed to retrieve invites")
+			return
+		}
+		utils.SendJSONResponse(w, http.StatusOK, map[string]interface{}{
+			"workspace_id": workspace.ID,
+			"invites":      invites,
+			"count":        len(invites),
+		})
+
+	case http.MethodPost:
+		inviteToWorkspace(w, r, workspace, userID)
+
+	default:
+		utils.SendErrorResponse(w, http.StatusMethodNotAllowed,
+			"Method not allowed", fmt.Sprintf("Method %s is not supported for this endpoint", r.Method))
+	}
+}
+
+func inviteToWorkspace(w http.ResponseWriter, r *http.Request, workspace *models.Workspace, userID int) {
+	ctx := r.Context()
+
+	if workspace.Personal {
+		utils.SendErrorResponse(w, http.StatusConflict,
+			"Personal workspace", "A personal workspace cannot have other members")
+		return
+	}
+
+	var req struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Invalid request body", "Failed to parse JSON")
+		return
+	}
+	if req.Role == "" {
+		req.Role = models.WorkspaceMember
+	}
+	if !utils.ValidWorkspaceRole(req.Role) {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Validation error", "role must be guest, member or admin")
+		return
+	}
+	address, err := mail.ParseAddress(req.Email)
+	if err != nil || address.Name != "" {
+		utils.SendErrorResponse(w, http.StatusBadRequest,
+			"Validation error", "email must be a valid email address")
+		return
+	}
+	if !canManageMember(w, workspace, "", req.Role) {
+		return
+	}
+
+	// People whose address Google verified join right away; anyone else gets an invite that
+	// only a verified sign-in with the address can claim
+	if user, err := utils.GetVerifiedUserByEmail(ctx, address.Address); err == nil {
+		member, err := utils.GetWorkspaceMember(ctx, workspace.ID, user.ID)
+		if err == nil {
+			utils.SendErrorResponse(w, http.StatusConflict,
+				"Already a member", fmt.Sprintf("%s is already a %s of this workspace", address.Address, member.Role))
+			return
+		}
+		if err := utils.AddWorkspaceMember(ctx, workspace.ID, user.ID, req.Role); err != nil {
+			utils.SendErrorResponse(w, http.StatusInternalServerError,
+				"Database error", "Failed to add the member")
+			return
+		}
+		member, err = utils.GetWorkspaceMember(ctx, workspace.ID, user.ID)
+		if err != nil {
+			utils.SendErrorResponse(w, http.StatusInternalServerError,
+				"Database error", "Member added but failed to retrieve")
+			return
+		}
+		utils.SendJSONResponse(w, http.StatusCreated, member)
+		return
+	}
+
+	invite := &models.WorkspaceInvite{
+		WorkspaceID: workspace.ID,
+		Email:       address.Address,
+		Role:        req.Role,
+		InvitedBy:   userID,
+	}
+	if err := utils.SaveWorkspaceInvite(ctx, invite); err != nil {
+		utils.SendErrorResponse(w, http.StatusInternalServerError,
+			"Database error", "Failed to save the invite")
+		return
+	}
+
+	invites, err := utils.GetWorkspaceInvites(ctx, workspace.ID)
+	if err != nil {
+		utils.SendErrorResponse(w, http.StatusInternalServerError,
+			"Database error", "Invite saved but failed to retrieve")
+		return
+	}
+	for _, saved := range invites {
+		if saved.Email == invite.Email {
+			utils.SendJSONResponse(w, http.StatusCreated, saved)
+			return
+		}
+	}
+	utils.SendJSONResponse(w, http.StatusCreated, invite)
+}
@@ -0,0 +1,288 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// Supported image formats
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatGIF  = "gif"
+	FormatWebP = "webp"
+)
+
+// ErrMalformed is returned when an image cannot be parsed
+var ErrMalformed = errors.New("malformed image")
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// DetectFormat identifies a supported image format from the first bytes of a file
+func DetectFormat(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF}):
+		return FormatJPEG
+	case bytes.HasPrefix(header, pngSignature):
+		return FormatPNG
+	case bytes.HasPrefix(header, []byte("GIF87a")), bytes.HasPrefix(header, []byte("GIF89a")):
+		return FormatGIF
+	case len(header) >= 12 && bytes.Equal(header[:4], []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WEBP")):
+		return FormatWebP
+	default:
+		return ""
+	}
+}
+
+// StripMetadata removes EXIF, XMP, IPTC and text metadata (GPS position, camera serial
+// numbers, comments) without re-encoding the image. The JPEG orientation tag is kept so
+// photos still display upright. GIF files are returned unchanged.
+func StripMetadata(data []byte, format string) ([]byte, error) {
+	switch format {
+	case FormatJPEG:
+		return stripJPEG(data)
+	case FormatPNG:
+		return stripPNG(data)
+	case FormatWebP:
+		return stripWebP(data)
+	default:
+		return data, nil
+	}
+}
+
+// JPEG markers
+const (
+	jpegSOI  = 0xD8
+	jpegSOS  = 0xDA
+	jpegAPP0 = 0xE0
+	jpegAPP1 = 0xE1
+	jpegAPPD = 0xED
+	jpegCOM  = 0xFE
+)
+
+var exifHeader = []byte("Exif\x00\x00")
+
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != jpegSOI {
+		return nil, ErrMalformed
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, jpegSOI)
+	orientation := Orientation(data)
+
+	i := 2
+	for i < len(data) {
+		if data[i] != 0xFF {
+			return nil, ErrMalformed
+		}
+		// Skip fill bytes
+		for i < len(data) && data[i] == 0xFF {
+			i++
+		}
+		if i >= len(data) {
+			return nil, ErrMalformed
+		}
+		marker := data[i]
+		i++
+
+		// Standalone markers carry no length
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			out = append(out, 0xFF, marker)
+			continue
+		}
+		if i+2 > len(data) {
+			return nil, ErrMalformed
+		}
+		length := int(binary.BigEndian.Uint16(data[i:]))
+		if length < 2 || i+length > len(data) {
+			return nil, ErrMalformed
+		}
+
+		// The orientation goes back in after the JFIF header, which must come first
+		if orientation > 1 && marker != jpegAPP0 {
+			out = append(out, orientationSegment(orientation)...)
+			orientation = 1
+		}
+
+		switch marker {
+		case jpegAPP1, jpegAPPD, jpegCOM:
+			// Exif/XMP, IPTC/Photoshop resources and comments
+		case jpegSOS:
+			// Entropy coded data follows; copy the rest verbatim
+			out = append(out, 0xFF, marker)
+			out = append(out, data[i:]...)
+			return out, nil
+		default:
+			out = append(out, 0xFF, marker)
+			out = append(out, data[i:i+length]...)
+		}
+		i += length
+	}
+	return nil, ErrMalformed
+}
+
+// scanOrientation looks for an Exif orientation in the APP1 segments of the remaining header
+func scanOrientation(data []byte) int {
+	i := 0
+	for i+4 <= len(data) && data[i] == 0xFF {
+		marker := data[i+1]
+		if marker == jpegSOS {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			break
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == jpegAPP1 && bytes.HasPrefix(segment, exifHeader) {
+			return exifOrientation(segment[len(exifHeader):])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// Orientation returns the Exif orientation (1-8) of a JPEG, or 1 when it has none
+func Orientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != jpegSOI {
+		return 1
+	}
+	return scanOrientation(data[2:])
+}
+
+// exifOrientation reads the orientation tag from IFD0 of a TIFF structure
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	offset := int(order.Uint32(tiff[4:]))
+	if offset+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for n := 0; n < count; n++ {
+		entry := offset + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// orientationSegment builds a minimal APP1 Exif segment holding only the orientation tag
+func orientationSegment(orientation int) []byte {
+	tiff := []byte{
+		'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08, // big endian header, IFD0 at offset 8
+		0x00, 0x01, // one entry
+		0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, // orientation, SHORT, count 1
+		0x00, byte(orientation), 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, // no next IFD
+	}
+	payload := append(append([]byte{}, exifHeader...), tiff...)
+
+	segment := []byte{0xFF, jpegAPP1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// pngMetadataChunks are the ancillary PNG chunks that carry metadata
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+func stripPNG(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, ErrMalformed
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+
+	i := len(pngSignature)
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, ErrMalformed
+		}
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, ErrMalformed
+		}
+
+		if !pngMetadataChunks[string(data[i+4:i+8])] {
+			out = append(out, data[i:end]...)
+		}
+		if string(data[i+4:i+8]) == "IEND" {
+			return out, nil
+		}
+		i = end
+	}
+	return nil, ErrMalformed
+}
+
+// VP8X feature flags
+const (
+	webpFlagXMP  = 0x04
+	webpFlagEXIF = 0x08
+)
+
+func stripWebP(data []byte) ([]byte, error) {
+	if DetectFormat(data) != FormatWebP {
+		return nil, ErrMalformed
+	}
+
+	out := make([]byte, 12, len(data))
+	copy(out, data[:12])
+
+	i := 12
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, ErrMalformed
+		}
+		fourCC := string(data[i : i+4])
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2
+		if size < 0 || i+8+size > len(data) {
+			return nil, ErrMalformed
+		}
+		end = min(end, len(data))
+
+		switch fourCC {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte{}, data[i:end]...)
+			if size > 0 {
+				chunk[8] &^= webpFlagEXIF | webpFlagXMP
+			}
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
@@ -0,0 +1,216 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// gpsSecret stands in for the location data a phone writes into a photo
+const gpsSecret = "GPS 52.3676N 4.9041E"
+
+// testImage is a 4x2 image, so rotations show in its bounds
+func testImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(60 * x), G: uint8(120 * y), B: 90, A: 255})
+		}
+	}
+	return img
+}
+
+// exifSegment builds an APP1 Exif segment with an orientation tag, unless orientation is 0,
+// and a GPS IFD holding gpsSecret
+func exifSegment(order binary.ByteOrder, orientation int) []byte {
+	var tiff bytes.Buffer
+	if order == binary.LittleEndian {
+		tiff.WriteString("II")
+	} else {
+		tiff.WriteString("MM")
+	}
+	write := func(v interface{}) { binary.Write(&tiff, order, v) }
+	write(uint16(42))
+	write(uint32(8))
+
+	entries := 1
+	if orientation > 0 {
+		entries++
+	}
+	gpsOffset := 8 + 2 + 12*entries + 4
+	write(uint16(entries))
+	if orientation > 0 {
+		write([]uint16{0x0112, 3}) // Orientation, SHORT
+		write(uint32(1))
+		write([]uint16{uint16(orientation), 0})
+	}
+	write([]uint16{0x8825, 4}) // GPSInfo, LONG
+	write(uint32(1))
+	write(uint32(gpsOffset))
+	write(uint32(0))
+
+	// GPS IFD: GPSLatitudeRef and a GPSProcessingMethod pointing at the secret
+	write(uint16(2))
+	write([]uint16{0x0001, 2})
+	write(uint32(2))
+	tiff.WriteString("N\x00\x00\x00")
+	write([]uint16{0x001B, 7})
+	write(uint32(len(gpsSecret)))
+	write(uint32(gpsOffset + 2 + 2*12 + 4))
+	write(uint32(0))
+	tiff.WriteString(gpsSecret)
+
+	payload := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	segment := []byte{0xFF, jpegAPP1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// jpegWithExif encodes the test image as a JPEG with an Exif segment and a comment
+func jpegWithExif(t *testing.T, order binary.ByteOrder, orientation int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(), nil); err != nil {
+		t.Fatalf("encode JPEG: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	comment := append([]byte{0xFF, jpegCOM, 0, byte(2 + len(gpsSecret))}, gpsSecret...)
+	data := append([]byte{}, encoded[:2]...)
+	data = append(data, exifSegment(order, orientation)...)
+	data = append(data, comment...)
+	return append(data, encoded[2:]...)
+}
+
+func TestStripMetadataJPEG(t *testing.T) {
+	tests := []struct {
+		name            string
+		order           binary.ByteOrder
+		orientation     int
+		wantOrientation int
+		wantBounds      image.Rectangle
+	}{
+		{"no orientation", binary.BigEndian, 0, 1, image.Rect(0, 0, 4, 2)},
+		{"upright", binary.BigEndian, 1, 1, image.Rect(0, 0, 4, 2)},
+		{"rotated big endian", binary.BigEndian, 6, 6, image.Rect(0, 0, 2, 4)},
+		{"rotated little endian", binary.LittleEndian, 8, 8, image.Rect(0, 0, 2, 4)},
+		{"mirrored", binary.LittleEndian, 2, 2, image.Rect(0, 0, 4, 2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := jpegWithExif(t, tt.order, tt.orientation)
+			if !bytes.Contains(data, []byte(gpsSecret)) {
+				t.Fatal("test image does not contain the GPS data")
+			}
+
+			stripped, err := StripMetadata(data, FormatJPEG)
+			if err != nil {
+				t.Fatalf("StripMetadata: %v", err)
+			}
+			if bytes.Contains(stripped, []byte(gpsSecret)) {
+				t.Error("GPS data survived stripping")
+			}
+			if got := Orientation(stripped); got != tt.wantOrientation {
+				t.Errorf("Orientation = %d, want %d", got, tt.wantOrientation)
+			}
+			if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+				t.Fatalf("stripped image does not decode: %v", err)
+			}
+
+			// The kept orientation is still applied when the pixels are re-encoded
+			reencoded, err := Reencode(stripped, FormatJPEG)
+			if err != nil {
+				t.Fatalf("Reencode: %v", err)
+			}
+			img, err := jpeg.Decode(bytes.NewReader(reencoded))
+			if err != nil {
+				t.Fatalf("re-encoded image does not decode: %v", err)
+			}
+			if img.Bounds() != tt.wantBounds {
+				t.Errorf("re-encoded bounds = %v, want %v", img.Bounds(), tt.wantBounds)
+			}
+			if Orientation(reencoded) != 1 || bytes.Contains(reencoded, []byte(gpsSecret)) {
+				t.Error("re-encoded image kept metadata")
+			}
+		})
+	}
+}
+
+func TestReencodeDropsMetadata(t *testing.T) {
+	data := jpegWithExif(t, binary.BigEndian, 6)
+	reencoded, err := Reencode(data, FormatJPEG)
+	if err != nil {
+		t.Fatalf("Reencode: %v", err)
+	}
+	if bytes.Contains(reencoded, []byte(gpsSecret)) {
+		t.Error("GPS data survived re-encoding")
+	}
+	img, err := jpeg.Decode(bytes.NewReader(reencoded))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 2, 4) {
+		t.Errorf("bounds = %v, want the image rotated to 2x4", img.Bounds())
+	}
+}
+
+// pngChunk encodes a PNG chunk with its CRC
+func pngChunk(kind string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, kind...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+func TestStripMetadataPNG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage()); err != nil {
+		t.Fatalf("encode PNG: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	// Metadata chunks go right after IHDR, which is 25 bytes long
+	ihdrEnd := len(pngSignature) + 25
+	data := append([]byte{}, encoded[:ihdrEnd]...)
+	data = append(data, pngChunk("tEXt", []byte("Comment\x00"+gpsSecret))...)
+	data = append(data, pngChunk("eXIf", exifSegment(binary.BigEndian, 6)[10:])...)
+	data = append(data, encoded[ihdrEnd:]...)
+
+	stripped, err := StripMetadata(data, FormatPNG)
+	if err != nil {
+		t.Fatalf("StripMetadata: %v", err)
+	}
+	if bytes.Contains(stripped, []byte(gpsSecret)) {
+		t.Error("metadata survived stripping")
+	}
+	if !bytes.Equal(stripped, encoded) {
+		t.Error("stripping changed the image chunks")
+	}
+}
+
+func TestStripMetadataMalformed(t *testing.T) {
+	valid := jpegWithExif(t, binary.BigEndian, 1)
+	tests := []struct {
+		name   string
+		data   []byte
+		format string
+	}{
+		{"not a JPEG", []byte("GIF89a"), FormatJPEG},
+		{"truncated Exif segment", valid[:20], FormatJPEG},
+		{"not a PNG", []byte{0xFF, 0xD8, 0xFF, 0xE0}, FormatPNG},
+		{"PNG without IEND", append([]byte{}, pngSignature...), FormatPNG},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := StripMetadata(tt.data, tt.format); !errors.Is(err, ErrMalformed) {
+				t.Errorf("StripMetadata: err = %v, want ErrMalformed", err)
+			}
+		})
+	}
+}
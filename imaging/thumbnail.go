@@ -0,0 +1,163 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // register decoder
+	"image/jpeg"
+	"image/png"
+
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // register decoder
+)
+
+// MaxPixels bounds the decoded size of an image, so a small file that
+// declares huge dimensions cannot exhaust memory
+const MaxPixels = 50_000_000
+
+// jpegQuality is the quality used for JPEG thumbnails
+const jpegQuality = 85
+
+// reencodeQuality is the quality used for JPEG images re-encoded to drop their metadata
+const reencodeQuality = 92
+
+// Thumbnail is an encoded thumbnail image
+type Thumbnail struct {
+	Data        []byte
+	ContentType string
+	Width       int
+	Height      int
+}
+
+// MakeThumbnail decodes an image and scales it so its longest side is at most size
+// pixels, applying the JPEG Exif orientation. Images that are already small enough
+// are re-encoded at their own size, which also drops any metadata. Thumbnails of
+// images with transparency are PNG, all others JPEG.
+func MakeThumbnail(data []byte, size int) (*Thumbnail, error) {
+	src, format, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+	width, height := fit(bounds.Dx(), bounds.Dy(), size)
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+
+	var img image.Image = dst
+	if format == FormatJPEG {
+		img = orient(dst, Orientation(data))
+	}
+
+	var buf bytes.Buffer
+	thumb := &Thumbnail{Width: img.Bounds().Dx(), Height: img.Bounds().Dy()}
+	if dst.Opaque() {
+		thumb.ContentType = "image/jpeg"
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	} else {
+		thumb.ContentType = "image/png"
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %v", err)
+	}
+	thumb.Data = buf.Bytes()
+	return thumb, nil
+}
+
+// Reencode decodes a JPEG or PNG image and encodes its pixels again in the same format,
+// which leaves all metadata behind. The JPEG orientation is applied to the pixels so the
+// image still displays upright. Other formats cannot be re-encoded.
+func Reencode(data []byte, format string) ([]byte, error) {
+	if format != FormatJPEG && format != FormatPNG {
+		return nil, fmt.Errorf("cannot re-encode %s images", format)
+	}
+	src, decoded, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if decoded != format {
+		return nil, ErrMalformed
+	}
+
+	var buf bytes.Buffer
+	if format == FormatJPEG {
+		img := image.NewNRGBA(src.Bounds())
+		draw.Draw(img, img.Bounds(), src, src.Bounds().Min, draw.Src)
+		err = jpeg.Encode(&buf, orient(img, Orientation(data)), &jpeg.Options{Quality: reencodeQuality})
+	} else {
+		err = png.Encode(&buf, src)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode image: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decode decodes an image, refusing dimensions above MaxPixels before allocating them
+func decode(data []byte) (image.Image, string, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read image header: %v", err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > MaxPixels {
+		return nil, "", fmt.Errorf("image dimensions %dx%d are not supported", cfg.Width, cfg.Height)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode %s image: %v", format, err)
+	}
+	return src, format, nil
+}
+
+// fit scales width and height down so that neither exceeds size, keeping the aspect ratio
+func fit(width, height, size int) (int, int) {
+	if width <= size && height <= size {
+		return width, height
+	}
+	if width >= height {
+		return size, max(1, height*size/width)
+	}
+	return max(1, width*size/height), size
+}
+
+// orient transforms an image according to an Exif orientation value
+func orient(src *image.NRGBA, orientation int) *image.NRGBA {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // rotated 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // mirrored along the top-left diagonal
+				dx, dy = y, x
+			case 6: // rotated 90 clockwise
+				dx, dy = h-1-y, x
+			case 7: // mirrored along the top-right diagonal
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90 counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			dst.SetNRGBA(dx, dy, src.NRGBAAt(x, y))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,132 @@
+package importer
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"personalnote.eu/simple-go-api/markdown"
+)
+
+// enexTimeLayout is the timestamp format of Evernote exports, e.g. 20210314T152653Z
+const enexTimeLayout = "20060102T150405Z"
+
+// enexNote is a <note> element of an Evernote export. Attachments (<resource>) are
+// not imported and are skipped by the decoder.
+type enexNote struct {
+	Title   string `xml:"title"`
+	Content string `xml:"content"`
+	Created string `xml:"created"`
+	Updated string `xml:"updated"`
+}
+
+// Markers standing in for ENML checkboxes while the markup is converted; they contain
+// nothing the converter escapes and become task list items afterwards
+const (
+	enexCheckedMarker   = "ENEXTODOCHECKED"
+	enexUncheckedMarker = "ENEXTODOOPEN"
+)
+
+// ENML elements without an HTML counterpart
+var (
+	enexCheckedTodo = regexp.MustCompile(`(?i)<en-todo\s+checked="true"\s*/?>(\s*</en-todo>)?`)
+	enexTodo        = regexp.MustCompile(`(?i)<en-todo[^>]*>(\s*</en-todo>)?`)
+	enexMedia       = regexp.MustCompile(`(?is)<en-media[^>]*>(.*?</en-media>)?|<en-crypt[^>]*>.*?</en-crypt>`)
+	enexProlog      = regexp.MustCompile(`(?is)<\?xml[^>]*\?>|<!DOCTYPE[^>]*>`)
+	enexTodoMarker  = regexp.MustCompile(`(?m)^([ \t]*)(` + enexCheckedMarker + `|` + enexUncheckedMarker + `)[ \t]*`)
+)
+
+// parseENEX reads the notes of an Evernote .enex export, converting their ENML content
+// to Markdown. Notes are decoded one at a time.
+func (p *parser) parseENEX(name string, r io.Reader) error {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+
+	index := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if limitErr := p.overLimit(); limitErr != nil {
+				return limitErr
+			}
+			return fmt.Errorf("invalid ENEX file %s: %v", name, err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "note" {
+			continue
+		}
+
+		index++
+		itemName := fmt.Sprintf("%s#%d", name, index)
+
+		var raw enexNote
+		if err := decoder.DecodeElement(&raw, &start); err != nil {
+			if limitErr := p.overLimit(); limitErr != nil {
+				return limitErr
+			}
+			return fmt.Errorf("invalid ENEX file %s: %v", name, err)
+		}
+		if raw.Title != "" {
+			itemName = fmt.Sprintf("%s (%s)", itemName, raw.Title)
+		}
+
+		note, err := convertENEXNote(&raw)
+		if err != nil {
+			err = p.add(Item{Name: itemName, Err: err})
+		} else {
+			err = p.add(Item{Name: itemName, Note: note})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func convertENEXNote(raw *enexNote) (*Note, error) {
+	if len(raw.Content) > MaxNoteBytes {
+		return nil, fmt.Errorf("note is larger than %d bytes", MaxNoteBytes)
+	}
+
+	content := enexProlog.ReplaceAllString(raw.Content, "")
+	content = enexCheckedTodo.ReplaceAllString(content, enexCheckedMarker+" ")
+	content = enexTodo.ReplaceAllString(content, enexUncheckedMarker+" ")
+	content = enexMedia.ReplaceAllString(content, "")
+
+	body, err := htmlToMarkdown(content)
+	if err != nil {
+		return nil, err
+	}
+
+	// Checkboxes starting a line become task list items, any others stay inline
+	body = enexTodoMarker.ReplaceAllStringFunc(body, func(match string) string {
+		indent := match[:len(match)-len(strings.TrimLeft(match, " \t"))]
+		if strings.Contains(match, enexCheckedMarker) {
+			return indent + "- [x] "
+		}
+		return indent + "- [ ] "
+	})
+	body = strings.NewReplacer(enexCheckedMarker, "[x]", enexUncheckedMarker, "[ ]").Replace(body)
+
+	return &Note{
+		Title:   raw.Title,
+		Content: body,
+		Format:  markdown.FormatMarkdown,
+		Created: parseENEXTime(raw.Created),
+		Updated: parseENEXTime(raw.Updated),
+	}, nil
+}
+
+func parseENEXTime(value string) *time.Time {
+	t, err := time.Parse(enexTimeLayout, value)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
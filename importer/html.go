@@ -0,0 +1,31 @@
+package importer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/JohannesKaufmann/html-to-markdown/v2/converter"
+	"github.com/JohannesKaufmann/html-to-markdown/v2/plugin/base"
+	"github.com/JohannesKaufmann/html-to-markdown/v2/plugin/commonmark"
+	"github.com/JohannesKaufmann/html-to-markdown/v2/plugin/strikethrough"
+	"github.com/JohannesKaufmann/html-to-markdown/v2/plugin/table"
+)
+
+// htmlConverter turns note markup into Markdown, keeping tables and strikethrough
+var htmlConverter = converter.NewConverter(
+	converter.WithPlugins(
+		base.NewBasePlugin(),
+		commonmark.NewCommonmarkPlugin(),
+		table.NewTablePlugin(),
+		strikethrough.NewStrikethroughPlugin(),
+	),
+)
+
+// htmlToMarkdown converts an HTML fragment to Markdown
+func htmlToMarkdown(html string) (string, error) {
+	md, err := htmlConverter.ConvertString(html)
+	if err != nil {
+		return "", fmt.Errorf("failed to convert note to Markdown: %v", err)
+	}
+	return strings.TrimSpace(md) + "\n", nil
+}
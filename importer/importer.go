@@ -0,0 +1,227 @@
+// Package importer reads notes exported from other applications: Markdown and text
+// files, ZIP archives of them, Evernote ENEX and Google Keep Takeout
+package importer
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"personalnote.eu/simple-go-api/markdown"
+)
+
+// MaxNoteBytes bounds the size of a single note file, or the ENML of an ENEX note, when
+// Limits does not set a smaller bound
+const MaxNoteBytes = 16 << 20
+
+// maxTitleBytes matches the size of the article title column
+const maxTitleBytes = 255
+
+// ErrUnsupported is returned for files that are not in a known import format
+var ErrUnsupported = errors.New("unsupported import format")
+
+// Note is one imported note, ready to be stored as an article
+type Note struct {
+	Title   string
+	Content string
+	// Format is markdown.FormatMarkdown or markdown.FormatPlain
+	Format  string
+	Created *time.Time
+	Updated *time.Time
+}
+
+// Item is the outcome of reading one note. Err is set when the note could not be read;
+// the rest of the import goes on.
+type Item struct {
+	// Name identifies the note within the import, e.g. a path in the archive
+	Name string
+	Note *Note
+	Err  error
+}
+
+// Limits bounds what a single import may hold, so a crafted file such as a ZIP bomb
+// cannot exhaust memory. Zero values leave a limit off.
+type Limits struct {
+	// MaxNotes bounds the number of notes
+	MaxNotes int
+	// MaxNoteBytes bounds the size of a single note file; larger notes are reported in
+	// their Item. It is capped at MaxNoteBytes.
+	MaxNoteBytes int
+	// MaxTotalBytes bounds the bytes read from the import after decompression
+	MaxTotalBytes int64
+}
+
+// Supported reports whether a file name has an extension Parse accepts
+func Supported(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".zip", ".enex", ".json", ".md", ".markdown", ".txt":
+		return true
+	}
+	return false
+}
+
+// Parse reads the notes in an import file, choosing the format from its extension, and
+// passes them to handle one at a time. Reading stops with an error when the file itself
+// is unreadable, goes over limits, or handle fails; problems with single notes are
+// reported in their Item.
+func Parse(name string, r io.ReaderAt, size int64, limits Limits, handle func(Item) error) error {
+	if limits.MaxNoteBytes <= 0 || limits.MaxNoteBytes > MaxNoteBytes {
+		limits.MaxNoteBytes = MaxNoteBytes
+	}
+	p := &parser{limits: limits, handle: handle, remaining: limits.MaxTotalBytes}
+
+	if strings.ToLower(path.Ext(name)) == ".zip" {
+		archive, err := zip.NewReader(r, size)
+		if err != nil {
+			return fmt.Errorf("failed to open ZIP archive: %v", err)
+		}
+		for _, file := range archive.File {
+			if file.FileInfo().IsDir() || !Supported(file.Name) || strings.EqualFold(path.Ext(file.Name), ".zip") {
+				continue
+			}
+			if err := p.parseZipEntry(file); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if !Supported(name) {
+		return ErrUnsupported
+	}
+	return p.parseFile(name, io.NewSectionReader(r, 0, size))
+}
+
+// parser holds the state of one import
+type parser struct {
+	limits Limits
+	handle func(Item) error
+	notes  int
+	// remaining is what is left of limits.MaxTotalBytes; it goes negative once the
+	// import is over the limit
+	remaining int64
+}
+
+// add passes an item on, after checking the note count
+func (p *parser) add(item Item) error {
+	if p.limits.MaxNotes > 0 && p.notes >= p.limits.MaxNotes {
+		return fmt.Errorf("the import holds more than %d notes", p.limits.MaxNotes)
+	}
+	p.notes++
+	if item.Note != nil {
+		item.Note.Title = truncateTitle(item.Note.Title)
+	}
+	return p.handle(item)
+}
+
+// reader counts what is read from r against the total size limit
+func (p *parser) reader(r io.Reader) io.Reader {
+	if p.limits.MaxTotalBytes <= 0 {
+		return r
+	}
+	return &countingReader{r: r, p: p}
+}
+
+// overLimit returns the error that ends an import once it is over the total size limit
+func (p *parser) overLimit() error {
+	if p.limits.MaxTotalBytes > 0 && p.remaining < 0 {
+		return fmt.Errorf("the import holds more than %d bytes once uncompressed", p.limits.MaxTotalBytes)
+	}
+	return nil
+}
+
+type countingReader struct {
+	r io.Reader
+	p *parser
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.p.remaining -= int64(n)
+	if limitErr := c.p.overLimit(); limitErr != nil {
+		return n, limitErr
+	}
+	return n, err
+}
+
+func (p *parser) parseZipEntry(file *zip.File) error {
+	content, err := file.Open()
+	if err != nil {
+		return p.add(Item{Name: file.Name, Err: fmt.Errorf("failed to read from archive: %v", err)})
+	}
+	defer content.Close()
+	return p.parseFile(file.Name, content)
+}
+
+// parseFile reads a single note file, or the notes of an ENEX export
+func (p *parser) parseFile(name string, r io.Reader) error {
+	r = p.reader(r)
+	ext := strings.ToLower(path.Ext(name))
+	if ext == ".enex" {
+		return p.parseENEX(name, r)
+	}
+
+	maxBytes := p.limits.MaxNoteBytes
+	data, err := io.ReadAll(io.LimitReader(r, int64(maxBytes)+1))
+	if limitErr := p.overLimit(); limitErr != nil {
+		return limitErr
+	}
+	if err != nil {
+		return p.add(Item{Name: name, Err: fmt.Errorf("failed to read file: %v", err)})
+	}
+	if len(data) > maxBytes {
+		return p.add(Item{Name: name, Err: fmt.Errorf("file is larger than %d bytes", maxBytes)})
+	}
+	if !utf8.Valid(data) {
+		return p.add(Item{Name: name, Err: errors.New("file is not valid UTF-8 text")})
+	}
+
+	var note *Note
+	switch ext {
+	case ".json":
+		note, err = parseKeep(data)
+		if err == errNotKeep {
+			// Takeout archives carry other JSON files, such as the list of labels
+			return nil
+		}
+	case ".txt":
+		note = &Note{Title: fileTitle(name), Content: string(data), Format: markdown.FormatPlain}
+	default:
+		note, err = parseMarkdown(name, data)
+	}
+	if err != nil {
+		return p.add(Item{Name: name, Err: err})
+	}
+	if note == nil {
+		return nil
+	}
+	return p.add(Item{Name: name, Note: note})
+}
+
+// fileTitle derives a title from a file name without its directory and extension
+func fileTitle(name string) string {
+	base := path.Base(strings.ReplaceAll(name, `\`, "/"))
+	return strings.TrimSpace(strings.TrimSuffix(base, path.Ext(base)))
+}
+
+// truncateTitle shortens a title to the column size, keeping whole characters, and
+// names untitled notes
+func truncateTitle(title string) string {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return "Untitled"
+	}
+	if len(title) <= maxTitleBytes {
+		return title
+	}
+	limit := maxTitleBytes
+	for limit > 0 && !utf8.RuneStart(title[limit]) {
+		limit--
+	}
+	return strings.TrimSpace(title[:limit])
+}
@@ -0,0 +1,358 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"personalnote.eu/simple-go-api/markdown"
+)
+
+// parse runs Parse over data and collects the items it hands over
+func parse(t *testing.T, name string, data []byte, limits Limits) ([]Item, error) {
+	t.Helper()
+	var items []Item
+	err := Parse(name, bytes.NewReader(data), int64(len(data)), limits, func(item Item) error {
+		items = append(items, item)
+		return nil
+	})
+	return items, err
+}
+
+// zipOf builds a ZIP archive from name and content pairs
+func zipOf(t *testing.T, files ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for i := 0; i+1 < len(files); i += 2 {
+		w, err := archive.Create(files[i])
+		if err != nil {
+			t.Fatalf("create %s: %v", files[i], err)
+		}
+		if _, err := w.Write([]byte(files[i+1])); err != nil {
+			t.Fatalf("write %s: %v", files[i], err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("close archive: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func date(s string) *time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		panic(err)
+	}
+	return &t
+}
+
+const keepTextNote = `{
+	"title": "",
+	"textContent": "Groceries\nmilk\neggs",
+	"labels": [{"name": "home stuff"}],
+	"isTrashed": false,
+	"createdTimestampUsec": 1615735613000000,
+	"userEditedTimestampUsec": 1615822013000000
+}`
+
+const keepListNote = `{
+	"title": "Packing",
+	"listContent": [{"text": "passport", "isChecked": true}, {"text": "charger", "isChecked": false}],
+	"createdTimestampUsec": 1615735613000000
+}`
+
+const enexExport = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-export SYSTEM "http://xml.evernote.com/pub/evernote-export3.dtd">
+<en-export>
+<note>
+	<title>Trip plan</title>
+	<content><![CDATA[<?xml version="1.0" encoding="UTF-8"?><!DOCTYPE en-note SYSTEM "http://xml.evernote.com/pub/enml2.dtd"><en-note><div><b>Day one</b></div><div><en-todo checked="true"/>book hotel</div><div><en-todo/>rent car</div><en-media type="image/png" hash="abc"/></en-note>]]></content>
+	<created>20210314T152653Z</created>
+	<updated>20210315T080000Z</updated>
+	<resource><data encoding="base64">aGVsbG8=</data></resource>
+</note>
+<note>
+	<title>Second</title>
+	<content><![CDATA[<en-note>plain text</en-note>]]></content>
+</note>
+</en-export>`
+
+func TestParseFormats(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data string
+		want []Note
+	}{
+		{
+			name: "markdown with front matter",
+			file: "notes/trip.md",
+			data: "---\ntitle: Trip\ncreated: 2021-03-14T15:26:53Z\nupdated: \"2021-03-15 08:00\"\n---\n\n# Day one\n",
+			want: []Note{{Title: "Trip", Content: "# Day one\n", Format: markdown.FormatMarkdown,
+				Created: date("2021-03-14T15:26:53Z"), Updated: date("2021-03-15T08:00:00Z")}},
+		},
+		{
+			name: "markdown without front matter",
+			file: "Meeting notes.markdown",
+			data: "\ufeffagenda\r\n",
+			want: []Note{{Title: "Meeting notes", Content: "agenda\n", Format: markdown.FormatMarkdown}},
+		},
+		{
+			name: "plain text",
+			file: "todo.txt",
+			data: "<b>not html</b>",
+			want: []Note{{Title: "todo", Content: "<b>not html</b>", Format: markdown.FormatPlain}},
+		},
+		{
+			name: "keep text note",
+			file: "Takeout/Keep/groceries.json",
+			data: keepTextNote,
+			want: []Note{{Title: "Groceries", Content: "Groceries  \nmilk  \neggs\n\n#home_stuff\n", Format: markdown.FormatMarkdown,
+				Created: date("2021-03-14T15:26:53Z"), Updated: date("2021-03-15T15:26:53Z")}},
+		},
+		{
+			name: "keep checklist",
+			file: "packing.json",
+			data: keepListNote,
+			want: []Note{{Title: "Packing", Content: "- [x] passport\n- [ ] charger\n", Format: markdown.FormatMarkdown,
+				Created: date("2021-03-14T15:26:53Z")}},
+		},
+		{
+			name: "keep trashed note",
+			file: "old.json",
+			data: `{"textContent": "gone", "isTrashed": true}`,
+		},
+		{
+			name: "other json",
+			file: "Labels.json",
+			data: `[{"name": "home"}]`,
+		},
+		{
+			name: "enex",
+			file: "export.enex",
+			data: enexExport,
+			want: []Note{
+				{Title: "Trip plan", Content: "**Day one**\n\n- [x] book hotel\n\n- [ ] rent car\n", Format: markdown.FormatMarkdown,
+					Created: date("2021-03-14T15:26:53Z"), Updated: date("2021-03-15T08:00:00Z")},
+				{Title: "Second", Content: "plain text\n", Format: markdown.FormatMarkdown},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items, err := parse(t, tt.file, []byte(tt.data), Limits{})
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if len(items) != len(tt.want) {
+				t.Fatalf("got %d items (%+v), want %d", len(items), items, len(tt.want))
+			}
+			for i, item := range items {
+				if item.Err != nil {
+					t.Fatalf("item %s: %v", item.Name, item.Err)
+				}
+				checkNote(t, item.Note, &tt.want[i])
+			}
+		})
+	}
+}
+
+func checkNote(t *testing.T, got, want *Note) {
+	t.Helper()
+	if got.Title != want.Title || got.Format != want.Format {
+		t.Errorf("note = %q (%s), want %q (%s)", got.Title, got.Format, want.Title, want.Format)
+	}
+	if strings.TrimSpace(got.Content) != strings.TrimSpace(want.Content) {
+		t.Errorf("content = %q, want %q", got.Content, want.Content)
+	}
+	for _, times := range [][2]*time.Time{{got.Created, want.Created}, {got.Updated, want.Updated}} {
+		if (times[0] == nil) != (times[1] == nil) || times[0] != nil && !times[0].Equal(*times[1]) {
+			t.Errorf("times = %v/%v, want %v/%v", got.Created, got.Updated, want.Created, want.Updated)
+			break
+		}
+	}
+}
+
+func TestParseZip(t *testing.T) {
+	nested := zipOf(t, "inner.md", "hidden")
+	data := zipOf(t,
+		"export/", "",
+		"export/a.md", "# A",
+		"export/b.txt", "bee",
+		"export/photo.png", "\x89PNG",
+		"export/nested.zip", string(nested),
+		"Takeout/Keep/list.json", keepListNote,
+		"Takeout/Keep/Labels.txt", "\xff\xfe not utf-8",
+	)
+
+	items, err := parse(t, "export.zip", data, Limits{})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	var names []string
+	for _, item := range items {
+		names = append(names, item.Name)
+	}
+	want := []string{"export/a.md", "export/b.txt", "Takeout/Keep/list.json", "Takeout/Keep/Labels.txt"}
+	if fmt.Sprint(names) != fmt.Sprint(want) {
+		t.Fatalf("items = %v, want %v", names, want)
+	}
+	if items[3].Err == nil || !strings.Contains(items[3].Err.Error(), "UTF-8") {
+		t.Errorf("invalid text: err = %v, want a UTF-8 error", items[3].Err)
+	}
+	if items[2].Note == nil || items[2].Note.Title != "Packing" {
+		t.Errorf("keep note = %+v, want Packing", items[2].Note)
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	if _, err := parse(t, "notes.docx", []byte("x"), Limits{}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("docx: err = %v, want ErrUnsupported", err)
+	}
+	if _, err := parse(t, "notes.zip", []byte("not a zip"), Limits{}); err == nil {
+		t.Error("invalid ZIP: Parse succeeded")
+	}
+	if _, err := parse(t, "broken.enex", []byte("<en-export><note><title>x</note>"), Limits{}); err == nil {
+		t.Error("invalid ENEX: Parse succeeded")
+	}
+}
+
+func TestParseNoteSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  Limits
+		size    int
+		wantErr bool
+	}{
+		{"at the note limit", Limits{MaxNoteBytes: 100}, 100, false},
+		{"over the note limit", Limits{MaxNoteBytes: 100}, 101, true},
+		{"at MaxNoteBytes", Limits{}, MaxNoteBytes, false},
+		{"over MaxNoteBytes", Limits{}, MaxNoteBytes + 1, true},
+		{"limit above MaxNoteBytes is capped", Limits{MaxNoteBytes: 2 * MaxNoteBytes}, MaxNoteBytes + 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items, err := parse(t, "big.txt", bytes.Repeat([]byte("a"), tt.size), tt.limits)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if len(items) != 1 {
+				t.Fatalf("got %d items, want 1", len(items))
+			}
+			if gotErr := items[0].Err != nil; gotErr != tt.wantErr {
+				t.Errorf("item err = %v, want error %v", items[0].Err, tt.wantErr)
+			}
+			if tt.wantErr && !strings.Contains(items[0].Err.Error(), "larger than") {
+				t.Errorf("item err = %v, want a size error", items[0].Err)
+			}
+		})
+	}
+}
+
+func TestParseENEXNoteSize(t *testing.T) {
+	content := strings.Repeat("a", MaxNoteBytes+1)
+	data := "<en-export><note><title>Huge</title><content><![CDATA[<en-note>" + content +
+		"</en-note>]]></content></note><note><title>Small</title><content>ok</content></note></en-export>"
+
+	items, err := parse(t, "export.enex", []byte(data), Limits{})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(items) != 2 || items[0].Err == nil || items[1].Err != nil {
+		t.Fatalf("items = %+v, want the huge note rejected and the small one kept", items)
+	}
+}
+
+func TestParseZipBomb(t *testing.T) {
+	// Each entry is 4 MiB of zeros, compressed to a few KiB
+	var files []string
+	for i := 0; i < 8; i++ {
+		files = append(files, fmt.Sprintf("n%d.md", i), strings.Repeat("\x00", 4<<20))
+	}
+	data := zipOf(t, files...)
+	if len(data) > 1<<20 {
+		t.Fatalf("archive is %d bytes, want a small one", len(data))
+	}
+
+	tests := []struct {
+		name      string
+		limits    Limits
+		wantItems int
+		wantErr   bool
+	}{
+		// Each entry is read up to the note limit, so the total counts MaxNoteBytes+1 per note
+		{"over the uncompressed limit", Limits{MaxNoteBytes: 1 << 20, MaxTotalBytes: 3 << 20}, 2, true},
+		{"within the uncompressed limit", Limits{MaxNoteBytes: 1 << 20, MaxTotalBytes: 64 << 20}, 8, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items, err := parse(t, "bomb.zip", data, tt.limits)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse: err = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "uncompressed") {
+				t.Errorf("Parse: err = %v, want the uncompressed size error", err)
+			}
+			if len(items) != tt.wantItems {
+				t.Errorf("got %d items before stopping, want %d", len(items), tt.wantItems)
+			}
+			for _, item := range items {
+				if item.Err == nil {
+					t.Errorf("item %s was accepted", item.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestParseZipBombENEX(t *testing.T) {
+	data := zipOf(t, "export.enex", "<en-export><note><title>x</title><content>"+strings.Repeat("a", 8<<20)+"</content></note></en-export>")
+	if _, err := parse(t, "export.zip", data, Limits{MaxTotalBytes: 1 << 20}); err == nil || !strings.Contains(err.Error(), "uncompressed") {
+		t.Errorf("Parse: err = %v, want the uncompressed size error", err)
+	}
+}
+
+func TestParseMaxNotes(t *testing.T) {
+	data := zipOf(t, "a.md", "a", "b.md", "b", "c.md", "c")
+	items, err := parse(t, "notes.zip", data, Limits{MaxNotes: 2})
+	if err == nil || !strings.Contains(err.Error(), "more than 2 notes") {
+		t.Errorf("Parse: err = %v, want the note count error", err)
+	}
+	if len(items) != 2 {
+		t.Errorf("got %d items, want 2", len(items))
+	}
+}
+
+func TestParseStopsOnHandlerError(t *testing.T) {
+	data := zipOf(t, "a.md", "a", "b.md", "b")
+	stop := errors.New("stop")
+	calls := 0
+	err := Parse("notes.zip", bytes.NewReader(data), int64(len(data)), Limits{}, func(Item) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("Parse = %v after %d calls, want stop after 1", err, calls)
+	}
+}
+
+func TestTruncateTitle(t *testing.T) {
+	long := strings.Repeat("é", 200)
+	tests := []struct {
+		in, want string
+	}{
+		{"  Title  ", "Title"},
+		{"", "Untitled"},
+		{long, strings.Repeat("é", 127)},
+	}
+	for _, tt := range tests {
+		if got := truncateTitle(tt.in); got != tt.want {
+			t.Errorf("truncateTitle(%.20q) = %.20q (%d bytes), want %.20q", tt.in, got, len(got), tt.want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"personalnote.eu/simple-go-api/markdown"
+)
+
+// keepTitleRunes is the length of titles taken from the text of untitled notes
+const keepTitleRunes = 80
+
+// errNotKeep marks a JSON file that is not a Keep note
+var errNotKeep = errors.New("not a Google Keep note")
+
+// keepNote is a note of a Google Keep Takeout export (Takeout/Keep/*.json)
+type keepNote struct {
+	Title           string  `json:"title"`
+	TextContent     *string `json:"textContent"`
+	TextContentHTML string  `json:"textContentHtml"`
+	ListContent     []struct {
+		Text      string `json:"text"`
+		IsChecked bool   `json:"isChecked"`
+	} `json:"listContent"`
+	Labels []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+	IsTrashed               bool  `json:"isTrashed"`
+	CreatedTimestampUsec    int64 `json:"createdTimestampUsec"`
+	UserEditedTimestampUsec int64 `json:"userEditedTimestampUsec"`
+}
+
+// parseKeep converts a Keep note to Markdown. Checklists become task lists and labels
+// are appended as hashtags. Trashed notes are skipped.
+func parseKeep(data []byte) (*Note, error) {
+	var raw keepNote
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, errNotKeep
+	}
+	if raw.TextContent == nil && raw.ListContent == nil && raw.CreatedTimestampUsec == 0 {
+		return nil, errNotKeep
+	}
+	if raw.IsTrashed {
+		return nil, nil
+	}
+
+	var b strings.Builder
+	switch {
+	case raw.ListContent != nil:
+		for _, entry := range raw.ListContent {
+			mark := " "
+			if entry.IsChecked {
+				mark = "x"
+			}
+			fmt.Fprintf(&b, "- [%s] %s\n", mark, strings.ReplaceAll(entry.Text, "\n", " "))
+		}
+	case raw.TextContentHTML != "":
+		body, err := htmlToMarkdown(raw.TextContentHTML)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(body)
+	case raw.TextContent != nil:
+		// Keep text is plain; hard line breaks keep its line structure in Markdown
+		for i, line := range strings.Split(*raw.TextContent, "\n") {
+			if i > 0 {
+				b.WriteString("  \n")
+			}
+			b.WriteString(line)
+		}
+		b.WriteString("\n")
+	}
+
+	if len(raw.Labels) > 0 {
+		b.WriteString("\n")
+		for i, label := range raw.Labels {
+			if i > 0 {
+				b.WriteString(" ")
+			}
+			b.WriteString("#" + strings.ReplaceAll(label.Name, " ", "_"))
+		}
+		b.WriteString("\n")
+	}
+
+	title := raw.Title
+	if title == "" && raw.TextContent != nil {
+		title = firstLine(*raw.TextContent, keepTitleRunes)
+	}
+
+	return &Note{
+		Title:   title,
+		Content: b.String(),
+		Format:  markdown.FormatMarkdown,
+		Created: usecTime(raw.CreatedTimestampUsec),
+		Updated: usecTime(raw.UserEditedTimestampUsec),
+	}, nil
+}
+
+// firstLine returns the first non-blank line of text, shortened to at most limit characters
+func firstLine(text string, limit int) string {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if runes := []rune(line); len(runes) > limit {
+			return strings.TrimSpace(string(runes[:limit])) + "…"
+		}
+		return line
+	}
+	return ""
+}
+
+func usecTime(usec int64) *time.Time {
+	if usec <= 0 {
+		return nil
+	}
+	t := time.UnixMicro(usec).UTC()
+	return &t
+}
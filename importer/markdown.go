@@ -0,0 +1,91 @@
+package importer
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"personalnote.eu/simple-go-api/markdown"
+)
+
+// frontMatter holds the fields read from the YAML header of a Markdown file. Times are
+// decoded as nodes, since they may be YAML timestamps or quoted strings.
+type frontMatter struct {
+	Title   string    `yaml:"title"`
+	Created yaml.Node `yaml:"created"`
+	Updated yaml.Node `yaml:"updated"`
+	Date    yaml.Node `yaml:"date"`
+}
+
+// parseMarkdown reads a Markdown file with optional YAML front matter, such as the files
+// written by the Markdown export. The title falls back to the file name.
+func parseMarkdown(name string, data []byte) (*Note, error) {
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+
+	note := &Note{Title: fileTitle(name), Format: markdown.FormatMarkdown}
+
+	header, body, ok := splitFrontMatter(data)
+	if ok {
+		var meta frontMatter
+		if err := yaml.Unmarshal(header, &meta); err != nil {
+			return nil, fmt.Errorf("invalid front matter: %v", err)
+		}
+		if meta.Title != "" {
+			note.Title = meta.Title
+		}
+		note.Created = parseNodeTime(&meta.Created)
+		if note.Created == nil {
+			note.Created = parseNodeTime(&meta.Date)
+		}
+		note.Updated = parseNodeTime(&meta.Updated)
+		data = body
+	}
+
+	note.Content = string(bytes.TrimLeft(data, "\n"))
+	return note, nil
+}
+
+// splitFrontMatter separates a leading block delimited by --- lines from the body
+func splitFrontMatter(data []byte) (header, body []byte, ok bool) {
+	if !bytes.HasPrefix(data, []byte("---\n")) {
+		return nil, data, false
+	}
+	rest := data[len("---\n"):]
+	if bytes.HasPrefix(rest, []byte("---\n")) {
+		return nil, rest[len("---\n"):], true
+	}
+	end := bytes.Index(rest, []byte("\n---\n"))
+	if end < 0 {
+		if bytes.HasSuffix(rest, []byte("\n---")) {
+			return rest[:len(rest)-len("\n---")], nil, true
+		}
+		return nil, data, false
+	}
+	return rest[:end+1], rest[end+len("\n---\n"):], true
+}
+
+// frontMatterLayouts are the time formats accepted in front matter strings
+var frontMatterLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+func parseNodeTime(node *yaml.Node) *time.Time {
+	if node.Kind != yaml.ScalarNode || node.Value == "" {
+		return nil
+	}
+	for _, layout := range frontMatterLayouts {
+		if t, err := time.Parse(layout, node.Value); err == nil {
+			t = t.UTC()
+			return &t
+		}
+	}
+	return nil
+}
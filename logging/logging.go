@@ -0,0 +1,102 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// requestInfo holds the per-request fields attached to every log record
+type requestInfo struct {
+	id     string
+	route  string
+	start  time.Time
+	userID atomic.Int64
+}
+
+type contextKey struct{}
+
+// Init configures the default slog logger.
+// level is one of debug, info, warn, error; format is json or text.
+func Init(level, format string) error {
+	return InitWriter(os.Stdout, level, format)
+}
+
+// InitWriter configures the default slog logger to write to w
+func InitWriter(w io.Writer, level, format string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(strings.TrimSpace(level))); err != nil {
+		return fmt.Errorf("invalid log level %q: %v", level, err)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "json":
+		handler = slog.NewJSONHandler(w, opts)
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid log format %q: expected json or text", format)
+	}
+
+	slog.SetDefault(slog.New(contextHandler{handler}))
+	return nil
+}
+
+// WithRequest returns a context carrying the request ID and route used to annotate log records
+func WithRequest(ctx context.Context, requestID, route string) context.Context {
+	return context.WithValue(ctx, contextKey{}, &requestInfo{
+		id:    requestID,
+		route: route,
+		start: time.Now(),
+	})
+}
+
+// SetUserID records the authenticated user for the request carried by ctx
+func SetUserID(ctx context.Context, userID int) {
+	if info, ok := ctx.Value(contextKey{}).(*requestInfo); ok {
+		info.userID.Store(int64(userID))
+	}
+}
+
+// RequestID returns the request ID carried by ctx, if any
+func RequestID(ctx context.Context) string {
+	if info, ok := ctx.Value(contextKey{}).(*requestInfo); ok {
+		return info.id
+	}
+	return ""
+}
+
+// contextHandler adds the request fields from the context to each record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if info, ok := ctx.Value(contextKey{}).(*requestInfo); ok {
+		record.AddAttrs(
+			slog.String("request_id", info.id),
+			slog.String("route", info.route),
+			slog.Float64("latency_ms", float64(time.Since(info.start).Microseconds())/1000),
+		)
+		if userID := info.userID.Load(); userID != 0 {
+			record.AddAttrs(slog.Int64("user_id", userID))
+		}
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -1,40 +1,159 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"personalnote.eu/simple-go-api/config"
 	"personalnote.eu/simple-go-api/handlers"
+	"personalnote.eu/simple-go-api/logging"
+	"personalnote.eu/simple-go-api/metrics"
+	"personalnote.eu/simple-go-api/middleware"
 	"personalnote.eu/simple-go-api/router"
+	"personalnote.eu/simple-go-api/scanner"
+	"personalnote.eu/simple-go-api/storage"
 	"personalnote.eu/simple-go-api/utils"
 )
 
 func main() {
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-config file] [config print]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch args := flag.Args(); {
+	case len(args) == 0:
+	case len(args) == 2 && args[0] == "config" && args[1] == "print":
+		os.Exit(printConfig(cfg))
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// Initialize structured logging
+	if err := logging.Init(cfg.Log.Level, cfg.Log.Format); err != nil {
+		slog.Error("invalid logging configuration", "error", err)
+		os.Exit(1)
+	}
+
 	// Initialize database connection
-	if err := utils.InitDB(); err != nil {
-		log.Printf("⚠️  Database connection failed: %v", err)
-		log.Printf("🔄 Continuing without database - some endpoints may not work")
-	} else {
-		defer utils.CloseDB()
+	if err := utils.InitDB(cfg.Database); err != nil {
+		slog.Warn("database connection failed, continuing without database - some endpoints may not work", "error", err)
+	} else if err := metrics.RegisterDB(utils.DB, "mysql"); err != nil {
+		slog.Warn("failed to register database metrics", "error", err)
+	}
+
+	// Build the shared Drive client once; missing credentials are reported by /readyz
+	driveClient, err := storage.NewDriveClient(context.Background(), cfg.Drive, cfg.Auth)
+	switch {
+	case errors.Is(err, storage.ErrNotConfigured):
+		if cfg.Storage.Backend == "drive" {
+			slog.Warn("Drive credentials not configured, uploads will fail until they are set")
+		}
+	case err != nil:
+		slog.Error("failed to initialize Drive client", "error", err)
+		os.Exit(1)
 	}
 
-	// Initialize OAuth
-	handlers.InitOAuth()
+	// Initialize file storage
+	store, err := storage.New(cfg, driveClient)
+	if err != nil {
+		slog.Error("failed to initialize storage backend", "backend", cfg.Storage.Backend, "error", err)
+		os.Exit(1)
+	}
+
+	// Initialize malware scanning; an unreachable scanner is reported by /readyz
+	fileScanner, err := scanner.New(cfg.Scanner)
+	if err != nil {
+		slog.Error("failed to initialize malware scanner", "backend", cfg.Scanner.Backend, "error", err)
+		os.Exit(1)
+	}
 
-	// Setup all routes
-	router.SetupRoutes()
+	// Initialize authentication, CORS and OAuth
+	middleware.Init(cfg)
+	handlers.Init(cfg, store, driveClient, fileScanner)
+
+	server := &http.Server{
+		Addr:           cfg.Server.Addr,
+		Handler:        router.SetupRoutes(cfg),
+		ReadTimeout:    cfg.Server.ReadTimeout,
+		WriteTimeout:   cfg.Server.WriteTimeout,
+		IdleTimeout:    cfg.Server.IdleTimeout,
+		MaxHeaderBytes: cfg.Server.MaxHeaderBytes,
+	}
+	// Event streams never go idle on their own
+	server.RegisterOnShutdown(handlers.CloseEventStreams)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// Start the server
-	addr := ":8080"
-	log.Printf("🚀 Server starting on %s", addr)
-	log.Printf("📡 Endpoints available:")
-	log.Printf("   GET  / - Health check")
-	log.Printf("   POST /user - User management")
-	log.Printf("   GET  /articles - List all articles")
-	log.Printf("   GET  /article/{id} - Get article by ID")
-	log.Printf("   GET  /auth/google/login - Google OAuth login")
-
-	if err := http.ListenAndServe(addr, nil); err != nil {
-		log.Fatalf("❌ Could not start server: %s", err)
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("server starting", "addr", server.Addr)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("could not start server", "error", err)
+			utils.CloseDB()
+			os.Exit(1)
+		}
+	case <-ctx.Done():
+		slog.Info("shutdown signal received, draining requests", "timeout", cfg.Server.ShutdownTimeout)
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	// Drain in-flight requests, then stop background workers before closing the database
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("server did not shut down cleanly", "error", err)
+	}
+	if err := utils.StopBackground(shutdownCtx); err != nil {
+		slog.Error("background workers did not stop in time", "error", err)
+	}
+	utils.CloseDB()
+
+	slog.Info("server stopped")
+}
+
+// printConfig writes the effective configuration with secrets redacted and
+// reports validation problems, returning the process exit code
+func printConfig(cfg *config.Config) int {
+	out, err := cfg.YAML()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to render configuration: %v\n", err)
+		return 1
+	}
+	fmt.Print(out)
+
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
 	}
+	return 0
 }
@@ -0,0 +1,67 @@
+package markdown
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Cache keeps the most recently rendered articles. Entries are keyed by article ID
+// and version, so an edit, which bumps the version, never serves stale HTML.
+type Cache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[cacheKey]*list.Element
+}
+
+type cacheKey struct {
+	id      int
+	version int
+}
+
+type cacheEntry struct {
+	key      cacheKey
+	rendered *Rendered
+}
+
+// NewCache creates a cache holding up to size rendered articles
+func NewCache(size int) *Cache {
+	return &Cache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[cacheKey]*list.Element),
+	}
+}
+
+// Get returns the rendered version of an article, if cached
+func (c *Cache) Get(id, version int) (*Rendered, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[cacheKey{id, version}]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).rendered, true
+}
+
+// Add stores a rendered article version, evicting the least recently used entry when full
+func (c *Cache) Add(id, version int, rendered *Rendered) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := cacheKey{id, version}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*cacheEntry).rendered = rendered
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, rendered: rendered})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
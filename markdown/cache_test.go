@@ -0,0 +1,42 @@
+package markdown
+
+import "testing"
+
+func TestCacheKeysOnVersion(t *testing.T) {
+	c := NewCache(10)
+	v1 := &Rendered{HTML: "<p>one</p>"}
+	c.Add(1, 1, v1)
+
+	if got, ok := c.Get(1, 1); !ok || got != v1 {
+		t.Errorf("Get(1, 1) = %v, %v, want the cached version", got, ok)
+	}
+	if _, ok := c.Get(1, 2); ok {
+		t.Error("Get(1, 2) served the HTML of version 1")
+	}
+	if _, ok := c.Get(2, 1); ok {
+		t.Error("Get(2, 1) served the HTML of another article")
+	}
+
+	v2 := &Rendered{HTML: "<p>two</p>"}
+	c.Add(1, 2, v2)
+	if got, _ := c.Get(1, 2); got != v2 {
+		t.Errorf("Get(1, 2) = %v, want version 2", got)
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewCache(2)
+	c.Add(1, 1, &Rendered{})
+	c.Add(2, 1, &Rendered{})
+	c.Get(1, 1)
+	c.Add(3, 1, &Rendered{})
+
+	if _, ok := c.Get(2, 1); ok {
+		t.Error("the least recently used entry was kept")
+	}
+	for _, id := range []int{1, 3} {
+		if _, ok := c.Get(id, 1); !ok {
+			t.Errorf("entry %d was evicted", id)
+		}
+	}
+}
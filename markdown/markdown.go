@@ -0,0 +1,171 @@
+// Package markdown renders article content to sanitized HTML
+package markdown
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Content formats of articles
+const (
+	FormatPlain    = "plain"
+	FormatMarkdown = "markdown"
+)
+
+// ValidFormat reports whether format is a known content format
+func ValidFormat(format string) bool {
+	return format == FormatPlain || format == FormatMarkdown
+}
+
+// Heading is a table of contents entry
+type Heading struct {
+	Level int    `json:"level"`
+	ID    string `json:"id"`
+	Text  string `json:"text"`
+}
+
+// Rendered is the HTML form of an article
+type Rendered struct {
+	HTML string    `json:"html"`
+	TOC  []Heading `json:"toc"`
+}
+
+// tocKey carries the headings collected while parsing a document
+var tocKey = parser.NewContextKey()
+
+// converter renders CommonMark with the GFM extensions (tables, task lists,
+// strikethrough, autolinks) and footnotes. Raw HTML in the source is dropped.
+var converter = goldmark.New(
+	goldmark.WithExtensions(
+		extension.NewTable(extension.WithTableCellAlignMethod(extension.TableCellAlignAttribute)),
+		extension.Strikethrough,
+		extension.Linkify,
+		extension.TaskList,
+		extension.Footnote,
+	),
+	goldmark.WithParserOptions(
+		parser.WithAutoHeadingID(),
+		parser.WithASTTransformers(util.Prioritized(headingAnchors{}, 100)),
+	),
+)
+
+// policy is the allow list applied to the rendered HTML, as defence in depth
+var policy = newPolicy()
+
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("id").Matching(regexp.MustCompile(`^[\p{L}\p{N}_:.-]+$`)).
+		OnElements("h1", "h2", "h3", "h4", "h5", "h6", "li", "sup")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^(anchor|footnote-ref|footnote-backref|footnotes)$`)).
+		OnElements("a", "div")
+	p.AllowAttrs("role").Matching(regexp.MustCompile(`^doc-[a-z]+$`)).OnElements("a", "div")
+	p.AllowAttrs("align").Matching(regexp.MustCompile(`^(left|right|center)$`)).OnElements("th", "td")
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").OnElements("input")
+	return p
+}
+
+// Render converts content in the given format to sanitized HTML with a table of contents.
+// Plain text is escaped and split into paragraphs at blank lines.
+func Render(content, format string) (*Rendered, error) {
+	switch format {
+	case FormatMarkdown:
+		var buf bytes.Buffer
+		ctx := parser.NewContext()
+		if err := converter.Convert([]byte(content), &buf, parser.WithContext(ctx)); err != nil {
+			return nil, fmt.Errorf("failed to render markdown: %v", err)
+		}
+		toc, _ := ctx.Get(tocKey).([]Heading)
+		if toc == nil {
+			toc = []Heading{}
+		}
+		return &Rendered{HTML: policy.Sanitize(buf.String()), TOC: toc}, nil
+	case FormatPlain, "":
+		return &Rendered{HTML: renderPlain(content), TOC: []Heading{}}, nil
+	default:
+		return nil, fmt.Errorf("unknown content format %q", format)
+	}
+}
+
+// blankLines separates paragraphs of plain text
+var blankLines = regexp.MustCompile(`\n\s*\n`)
+
+func renderPlain(content string) string {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+
+	var b strings.Builder
+	for _, paragraph := range blankLines.Split(content, -1) {
+		paragraph = strings.Trim(paragraph, "\n")
+		if strings.TrimSpace(paragraph) == "" {
+			continue
+		}
+		b.WriteString("<p>")
+		b.WriteString(strings.ReplaceAll(html.EscapeString(paragraph), "\n", "<br>\n"))
+		b.WriteString("</p>\n")
+	}
+	return b.String()
+}
+
+// headingAnchors records every heading for the table of contents and appends a
+// self-link so readers can copy a link to the section
+type headingAnchors struct{}
+
+func (headingAnchors) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	toc := []Heading{}
+
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		heading, ok := n.(*ast.Heading)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		id, ok := heading.AttributeString("id")
+		if !ok {
+			return ast.WalkSkipChildren, nil
+		}
+		idBytes, _ := id.([]byte)
+
+		toc = append(toc, Heading{Level: heading.Level, ID: string(idBytes), Text: plainText(heading, source)})
+
+		anchor := ast.NewLink()
+		anchor.Destination = append([]byte("#"), idBytes...)
+		anchor.SetAttributeString("class", []byte("anchor"))
+		anchor.AppendChild(anchor, ast.NewString([]byte("#")))
+		heading.AppendChild(heading, anchor)
+		return ast.WalkSkipChildren, nil
+	})
+
+	pc.Set(tocKey, toc)
+}
+
+// plainText concatenates the text content of a node
+func plainText(n ast.Node, source []byte) string {
+	var b strings.Builder
+	ast.Walk(n, func(child ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch t := child.(type) {
+		case *ast.Text:
+			b.Write(t.Value(source))
+			if t.SoftLineBreak() {
+				b.WriteByte(' ')
+			}
+		case *ast.String:
+			b.Write(t.Value)
+		}
+		return ast.WalkContinue, nil
+	})
+	return strings.TrimSpace(b.String())
+}
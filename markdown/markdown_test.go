@@ -0,0 +1,138 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderSanitizes(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		forbidden []string
+		want      []string
+	}{
+		{
+			name:      "script tag",
+			content:   "Hello\n\n<script>alert(1)</script>\n",
+			forbidden: []string{"<script", "alert(1)"},
+			want:      []string{"<p>Hello</p>"},
+		},
+		{
+			name:      "inline script",
+			content:   "Hello <script>alert(1)</script> world",
+			forbidden: []string{"<script"},
+		},
+		{
+			name:      "javascript link",
+			content:   "[click](javascript:alert(1))",
+			forbidden: []string{"javascript:"},
+			want:      []string{"click"},
+		},
+		{
+			name:      "javascript link with entities",
+			content:   "[click](&#106;avascript:alert(1))",
+			forbidden: []string{"javascript:", "avascript:"},
+		},
+		{
+			name:      "javascript autolink",
+			content:   "<javascript:alert(1)>",
+			forbidden: []string{`href="javascript:`},
+		},
+		{
+			name:      "event handler attribute",
+			content:   `<img src="x.png" onerror="alert(1)">`,
+			forbidden: []string{"onerror", "alert(1)"},
+		},
+		{
+			name:      "event handler in block html",
+			content:   "<div onclick=\"alert(1)\">hi</div>\n",
+			forbidden: []string{"onclick", "<div"},
+		},
+		{
+			name:      "raw html",
+			content:   "<iframe src=\"https://evil.example\"></iframe>\n\n<style>body{display:none}</style>\n\ntext <b>bold</b>",
+			forbidden: []string{"<iframe", "<style", "<b>"},
+			want:      []string{"text"},
+		},
+		{
+			name:      "image with data url",
+			content:   "![x](data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==)",
+			forbidden: []string{"data:text/html"},
+		},
+		{
+			name:    "allowed markdown",
+			content: "# Title\n\n- [x] done\n\n| a |\n|:-:|\n| b |\n\n~~old~~ https://example.com",
+			want: []string{
+				`<h1 id="title">`, `class="anchor"`, `type="checkbox"`, `checked`,
+				`<td align="center">`, "<del>old</del>", `href="https://example.com"`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rendered, err := Render(tt.content, FormatMarkdown)
+			if err != nil {
+				t.Fatalf("Render: %v", err)
+			}
+			for _, s := range tt.forbidden {
+				if strings.Contains(rendered.HTML, s) {
+					t.Errorf("HTML contains %q:\n%s", s, rendered.HTML)
+				}
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(rendered.HTML, s) {
+					t.Errorf("HTML lacks %q:\n%s", s, rendered.HTML)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderPlain(t *testing.T) {
+	rendered, err := Render("<script>alert(1)</script>\nline two\n\n\nsecond paragraph", FormatPlain)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	want := "<p>&lt;script&gt;alert(1)&lt;/script&gt;<br>\nline two</p>\n<p>second paragraph</p>\n"
+	if rendered.HTML != want {
+		t.Errorf("HTML = %q, want %q", rendered.HTML, want)
+	}
+}
+
+func TestRenderTOC(t *testing.T) {
+	rendered, err := Render("# Intro\n\n## Details *here*\n\n## Details *here*", FormatMarkdown)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	want := []Heading{{1, "intro", "Intro"}, {2, "details-here", "Details here"}, {2, "details-here-1", "Details here"}}
+	if len(rendered.TOC) != len(want) {
+		t.Fatalf("TOC = %+v, want %+v", rendered.TOC, want)
+	}
+	for i := range want {
+		if rendered.TOC[i] != want[i] {
+			t.Errorf("TOC[%d] = %+v, want %+v", i, rendered.TOC[i], want[i])
+		}
+	}
+}
+
+func TestValidFormat(t *testing.T) {
+	tests := []struct {
+		format string
+		want   bool
+	}{
+		{FormatPlain, true},
+		{FormatMarkdown, true},
+		{"", false},
+		{"html", false},
+		{"Markdown", false},
+	}
+	for _, tt := range tests {
+		if got := ValidFormat(tt.format); got != tt.want {
+			t.Errorf("ValidFormat(%q) = %v, want %v", tt.format, got, tt.want)
+		}
+	}
+	if _, err := Render("x", "html"); err == nil {
+		t.Error("Render accepted an unknown format")
+	}
+}
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// HTTPRequests counts handled requests per route, method and status
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Number of HTTP requests handled, by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	// HTTPDuration observes request latency per route, method and status
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency, by route, method and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	// ArticleOperations counts successful article writes by operation (create, update, delete)
+	ArticleOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "articles_operations_total",
+		Help: "Number of successful article writes, by operation.",
+	}, []string{"operation"})
+
+	// DriveUploadDuration observes the time spent uploading a file to Google Drive
+	DriveUploadDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "drive_upload_duration_seconds",
+		Help:    "Time spent uploading files to Google Drive.",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	})
+
+	// DriveUploadFailures counts failed Google Drive uploads by stage (client, upload)
+	DriveUploadFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "drive_upload_failures_total",
+		Help: "Number of failed Google Drive uploads, by stage.",
+	}, []string{"stage"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		HTTPRequests,
+		HTTPDuration,
+		ArticleOperations,
+		DriveUploadDuration,
+		DriveUploadFailures,
+	)
+}
+
+// RegisterDB exposes the connection pool statistics of db
+func RegisterDB(db *sql.DB, name string) error {
+	return prometheus.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler serves the Prometheus metrics, protected by a bearer token.
+// The endpoint is disabled when token is empty.
+func Handler(token string) http.HandlerFunc {
+	promHandler := promhttp.Handler()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, `{"error":"Invalid metrics token"}`, http.StatusUnauthorized)
+			return
+		}
+
+		promHandler.ServeHTTP(w, r)
+	}
+}
@@ -2,14 +2,17 @@ package middleware
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
 	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
+	"personalnote.eu/simple-go-api/config"
+	"personalnote.eu/simple-go-api/logging"
 )
 
+var jwtSecret []byte
+
 // Claims represents the JWT claims
 type Claims struct {
 	UserID   int    `json:"user_id"`
@@ -33,29 +36,47 @@ func RequireAuth(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		tokenString := parts[1]
-		jwtSecret := os.Getenv("JWT_SECRET")
-		if jwtSecret == "" {
-			log.Println("JWT_SECRET not set")
-			http.Error(w, `{"error":"Server configuration error"}`, http.StatusInternalServerError)
-			return
-		}
-
-		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(jwtSecret), nil
-		})
-
-		if err != nil || !token.Valid {
-			log.Printf("Invalid token: %v", err)
+		claims, err := ParseToken(parts[1])
+		if err != nil {
+			slog.WarnContext(r.Context(), "invalid token", "error", err)
 			http.Error(w, `{"error":"Invalid or expired token"}`, http.StatusUnauthorized)
 			return
 		}
 
+		logging.SetUserID(r.Context(), claims.UserID)
+
 		// Token is valid, proceed with the request
 		next.ServeHTTP(w, r)
 	}
 }
+
+// Init applies the authentication and CORS settings
+func Init(cfg *config.Config) {
+	jwtSecret = []byte(cfg.Auth.JWTSecret.Value())
+
+	allowedOrigins = cfg.CORS.AllowedOrigins
+	allowAllOrigins = len(allowedOrigins) == 1 && allowedOrigins[0] == "*"
+}
+
+// ParseToken validates a signed JWT and returns its claims
+func ParseToken(tokenString string) (*Claims, error) {
+	if len(jwtSecret) == 0 {
+		return nil, fmt.Errorf("JWT secret not configured")
+	}
+
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return jwtSecret, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("token is not valid")
+	}
+
+	return claims, nil
+}
@@ -1,15 +1,13 @@
 package middleware
 
 import (
-	"log"
 	"net/http"
-	"os"
 	"strings"
 )
 
 var (
-	allowedOrigins        = parseAllowedOrigins()
-	allowAllOrigins       = len(allowedOrigins) == 1 && allowedOrigins[0] == "*"
+	allowedOrigins        = []string{"*"}
+	allowAllOrigins       = true
 	defaultAllowedMethods = strings.Join([]string{
 		http.MethodGet,
 		http.MethodPost,
@@ -18,8 +16,9 @@ var (
 		http.MethodDelete,
 		http.MethodOptions,
 	}, ", ")
-	defaultAllowedHeaders = "Content-Type, Authorization, X-Requested-With"
-	defaultExposedHeaders = "Content-Length, Content-Disposition"
+	defaultAllowedHeaders = "Content-Type, Authorization, X-Requested-With, X-Request-ID, X-Workspace-ID"
+	defaultExposedHeaders = "Content-Length, Content-Disposition, Content-Range, Accept-Ranges, ETag, X-Request-ID, " +
+		"Location, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Upload-Offset, Upload-Length, Upload-Metadata, Upload-Expires"
 )
 
 // WithCORS adds the standard CORS headers and handles preflight requests
@@ -27,8 +26,6 @@ func WithCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		origin := r.Header.Get("Origin")
 
-		log.Printf("CORS request from origin: %s", origin)
-
 		if allowAllOrigins {
 			if origin != "" {
 				w.Header().Set("Access-Control-Allow-Origin", origin)
@@ -58,7 +55,8 @@ func WithCORS(next http.Handler) http.Handler {
 		w.Header().Set("Access-Control-Expose-Headers", defaultExposedHeaders)
 		w.Header().Set("Access-Control-Max-Age", "600")
 
-		if r.Method == http.MethodOptions {
+		// Plain OPTIONS requests (e.g. tus capability discovery) reach the handler
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 			w.WriteHeader(http.StatusNoContent)
 			return
 		}
@@ -67,28 +65,6 @@ func WithCORS(next http.Handler) http.Handler {
 	})
 }
 
-func parseAllowedOrigins() []string {
-	raw := strings.TrimSpace(os.Getenv("CORS_ALLOWED_ORIGINS"))
-	if raw == "" {
-		return []string{"*"}
-	}
-
-	parts := strings.Split(raw, ",")
-	var origins []string
-	for _, part := range parts {
-		origin := strings.TrimSpace(part)
-		if origin != "" {
-			origins = append(origins, origin)
-		}
-	}
-
-	if len(origins) == 0 {
-		return []string{"*"}
-	}
-
-	return origins
-}
-
 func isOriginAllowed(origin string) bool {
 	if origin == "" {
 		return false
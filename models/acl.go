@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Article roles, from least to most privileged
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleOwner  = "owner"
+)
+
+// ArticleACL grants a user other than the owner access to an article. Invites by email
+// have no user yet and are claimed when that person signs in.
+type ArticleACL struct {
+	ID        int        `json:"id" db:"id"`
+	ArticleID int        `json:"article_id" db:"article_id"`
+	UserID    *int       `json:"user_id" db:"user_id"`
+	Email     string     `json:"email" db:"email"`
+	Name      string     `json:"name,omitempty" db:"name"`
+	Role      string     `json:"role" db:"role"`
+	Pending   bool       `json:"pending"`
+	GrantedBy int        `json:"granted_by" db:"granted_by"`
+	Created   *time.Time `json:"created" db:"created"`
+}
@@ -0,0 +1,68 @@
+package models
+
+import "time"
+
+// Attachment represents an uploaded file stored in one of the storage backends
+type Attachment struct {
+	ID        int    `json:"id" db:"id"`
+	UserID    int    `json:"user_id" db:"user_id"`
+	ArticleID *int   `json:"article_id" db:"article_id"`
+	Backend   string `json:"backend" db:"backend"`
+	RemoteID  string `json:"remote_id" db:"remote_id"`
+	Name      string `json:"name" db:"name"`
+	MimeType  string `json:"mime_type" db:"mime_type"`
+	// DeclaredMimeType is the type the client sent, DetectedMimeType the one found in the content
+	DeclaredMimeType string     `json:"declared_mime_type" db:"declared_mime_type"`
+	DetectedMimeType string     `json:"detected_mime_type" db:"detected_mime_type"`
+	Size             int64      `json:"size" db:"size"`
+	SHA256           string     `json:"sha256" db:"sha256"`
+	Created          *time.Time `json:"created" db:"created"`
+	// ScanStatus is the malware scan result: pending, clean, infected or failed
+	ScanStatus    string `json:"scan_status" db:"scan_status"`
+	ScanSignature string `json:"scan_signature,omitempty" db:"scan_signature"`
+	// ThumbnailStatus is set for images only: pending, ready or failed
+	ThumbnailStatus *string `json:"thumbnail_status,omitempty" db:"thumbnail_status"`
+}
+
+// AttachmentListResponse represents a response containing multiple attachments
+type AttachmentListResponse struct {
+	Attachments []Attachment `json:"attachments"`
+	Count       int          `json:"count"`
+	Message     string       `json:"message"`
+}
+
+// Usage represents a user's stored files and quota
+type Usage struct {
+	Bytes    int64 `json:"bytes" db:"bytes"`
+	Files    int   `json:"files" db:"files"`
+	MaxBytes int64 `json:"max_bytes"`
+	MaxFiles int   `json:"max_files"`
+}
+
+// Malware scan statuses of attachments
+const (
+	ScanStatusPending  = "pending"
+	ScanStatusClean    = "clean"
+	ScanStatusInfected = "infected"
+	// ScanStatusFailed marks files the scanner could not check, e.g. because they are too large
+	ScanStatusFailed = "failed"
+)
+
+// Thumbnail statuses of image attachments
+const (
+	ThumbnailStatusPending = "pending"
+	ThumbnailStatusReady   = "ready"
+	ThumbnailStatusFailed  = "failed"
+)
+
+// Thumbnail represents a scaled-down copy of an image attachment, stored next to the original
+type Thumbnail struct {
+	AttachmentID int    `json:"attachment_id" db:"attachment_id"`
+	Size         int    `json:"size" db:"size"`
+	Backend      string `json:"backend" db:"backend"`
+	RemoteID     string `json:"remote_id" db:"remote_id"`
+	MimeType     string `json:"mime_type" db:"mime_type"`
+	Width        int    `json:"width" db:"width"`
+	Height       int    `json:"height" db:"height"`
+	Bytes        int64  `json:"bytes" db:"bytes"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// DriveConnection links a user to their own Google Drive
+type DriveConnection struct {
+	UserID int `json:"user_id" db:"user_id"`
+	// RefreshToken is stored encrypted and never serialized
+	RefreshToken string     `json:"-" db:"refresh_token"`
+	FolderID     string     `json:"folder_id" db:"folder_id"`
+	Email        string     `json:"email" db:"email"`
+	Created      *time.Time `json:"created" db:"created"`
+	Updated      *time.Time `json:"updated" db:"updated"`
+}
+
+// DriveStatusResponse reports whether the user has linked their Drive
+type DriveStatusResponse struct {
+	Connected   bool       `json:"connected"`
+	Email       string     `json:"email,omitempty"`
+	FolderID    string     `json:"folder_id,omitempty"`
+	ConnectedAt *time.Time `json:"connected_at,omitempty"`
+}
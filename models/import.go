@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// Import states
+const (
+	ImportStatusQueued   = "queued"
+	ImportStatusRunning  = "running"
+	ImportStatusComplete = "complete"
+	ImportStatusFailed   = "failed"
+)
+
+// ImportJob tracks an asynchronous import of notes from an uploaded file
+type ImportJob struct {
+	ID     string `json:"id" db:"id"`
+	UserID int    `json:"user_id" db:"user_id"`
+	// Name is the name of the uploaded file
+	Name      string `json:"name" db:"name"`
+	Status    string `json:"status" db:"status"`
+	Total     int    `json:"total" db:"total"`
+	Processed int    `json:"processed" db:"processed"`
+	Imported  int    `json:"imported" db:"imported"`
+	Failed    int    `json:"failed" db:"failed"`
+	// Errors lists the notes that could not be imported
+	Errors []ImportItemError `json:"errors" db:"errors"`
+	// Error explains why the whole import failed
+	Error   string     `json:"error,omitempty" db:"error_message"`
+	Created *time.Time `json:"created" db:"created"`
+	Updated *time.Time `json:"updated" db:"updated"`
+}
+
+// ImportItemError reports a note that could not be imported
+type ImportItemError struct {
+	Item  string `json:"item"`
+	Error string `json:"error"`
+}
@@ -9,10 +9,18 @@ type Response struct {
 
 // Article represents an article entity from the database
 type Article struct {
-	ID      int        `json:"id" db:"id"`
-	UserID  int        `json:"user_id" db:"user_id"`
-	Title   string     `json:"title" db:"title"`
-	Content string     `json:"content" db:"content"`
+	ID     int `json:"id" db:"id"`
+	UserID int `json:"user_id" db:"user_id"`
+	// WorkspaceID is the workspace owning the article
+	WorkspaceID int    `json:"workspace_id" db:"workspace_id"`
+	Title       string `json:"title" db:"title"`
+	Content     string `json:"content" db:"content"`
+	// ContentFormat is plain or markdown
+	ContentFormat string `json:"content_format" db:"content_format"`
+	// Version starts at 1 and is incremented on every update
+	Version int `json:"version" db:"version"`
+	// Role is the requesting user's role on the article (owner, editor or viewer)
+	Role    string     `json:"role,omitempty" db:"role"`
 	Created *time.Time `json:"created" db:"created"`
 	Updated *time.Time `json:"updated" db:"updated"`
 	Deleted *time.Time `json:"deleted" db:"deleted"`
@@ -30,3 +38,16 @@ type ErrorResponse struct {
 	Error   string `json:"error"`
 	Message string `json:"message"`
 }
+
+// DependencyStatus represents the health of a single dependency
+type DependencyStatus struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// HealthResponse represents the result of a liveness or readiness check
+type HealthResponse struct {
+	Status string                      `json:"status"`
+	Checks map[string]DependencyStatus `json:"checks,omitempty"`
+}
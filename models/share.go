@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// ArticleShare is the public read-only link of an article
+type ArticleShare struct {
+	ArticleID int `json:"article_id" db:"article_id"`
+	// CreatedBy is the user who last created the link; it grants nothing, as the link
+	// belongs to the article and any of its owners manage it
+	CreatedBy int `json:"created_by" db:"user_id"`
+	// TokenHash is the SHA-256 of the link token; the token itself is only shown once
+	TokenHash string `json:"-" db:"token_hash"`
+	// PasswordHash is the bcrypt hash of the optional link password
+	PasswordHash string     `json:"-" db:"password_hash"`
+	Expires      *time.Time `json:"expires" db:"expires"`
+	Views        int        `json:"views" db:"views"`
+	LastViewed   *time.Time `json:"last_viewed" db:"last_viewed"`
+	Created      *time.Time `json:"created" db:"created"`
+}
+
+// ShareResponse describes a share link to its owner
+type ShareResponse struct {
+	ArticleShare
+	// Token and URL are only returned when the link is created
+	Token             string `json:"token,omitempty"`
+	URL               string `json:"url,omitempty"`
+	PasswordProtected bool   `json:"password_protected"`
+}
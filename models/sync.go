@@ -0,0 +1,18 @@
+package models
+
+// Kinds of entries in a sync change feed
+const (
+	SyncCreated = "created"
+	SyncUpdated = "updated"
+	SyncDeleted = "deleted"
+)
+
+// SyncChange is an entry of a user's change feed with the current state of the article.
+// Deleted entries are tombstones for articles that were deleted or that the user can no
+// longer access; they carry no article.
+type SyncChange struct {
+	Seq       int64    `json:"seq"`
+	Type      string   `json:"type"`
+	ArticleID int      `json:"article_id"`
+	Article   *Article `json:"article,omitempty"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Upload states
+const (
+	UploadStatusUploading  = "uploading"
+	UploadStatusProcessing = "processing"
+	UploadStatusComplete   = "complete"
+	UploadStatusFailed     = "failed"
+)
+
+// Upload represents a resumable (tus) upload and the attachment it produces once complete
+type Upload struct {
+	ID           string     `json:"id" db:"id"`
+	UserID       int        `json:"user_id" db:"user_id"`
+	ArticleID    *int       `json:"article_id" db:"article_id"`
+	Name         string     `json:"name" db:"name"`
+	MimeType     string     `json:"mime_type" db:"mime_type"`
+	Metadata     string     `json:"-" db:"metadata"`
+	Length       int64      `json:"length" db:"upload_length"`
+	Offset       int64      `json:"offset" db:"upload_offset"`
+	Status       string     `json:"status" db:"status"`
+	AttachmentID *int       `json:"attachment_id" db:"attachment_id"`
+	Error        string     `json:"error,omitempty" db:"error_message"`
+	Created      *time.Time `json:"created" db:"created"`
+	Updated      *time.Time `json:"updated" db:"updated"`
+}
@@ -4,13 +4,15 @@ import "time"
 
 // User represents a user entity from the database
 type User struct {
-	ID        int        `json:"id" db:"id"`
-	GoogleID  string     `json:"google_id" db:"google_id"`
-	Email     string     `json:"email" db:"email"`
-	Name      string     `json:"name" db:"name"`
-	Picture   string     `json:"picture" db:"picture"`
-	CreatedAt *time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt *time.Time `json:"updated_at" db:"updated_at"`
+	ID       int    `json:"id" db:"id"`
+	GoogleID string `json:"google_id" db:"google_id"`
+	Email    string `json:"email" db:"email"`
+	// EmailVerified reports whether Google had verified Email when the user last signed in
+	EmailVerified bool       `json:"email_verified" db:"email_verified"`
+	Name          string     `json:"name" db:"name"`
+	Picture       string     `json:"picture" db:"picture"`
+	CreatedAt     *time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt     *time.Time `json:"updated_at" db:"updated_at"`
 }
 
 // GoogleUserInfo represents the user info from Google
@@ -19,4 +21,6 @@ type GoogleUserInfo struct {
 	Email   string `json:"email"`
 	Name    string `json:"name"`
 	Picture string `json:"picture"`
+	// VerifiedEmail reports whether Google has verified that the user owns Email
+	VerifiedEmail bool `json:"verified_email"`
 }
@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// Workspace member roles, from least to most privileged
+const (
+	WorkspaceGuest  = "guest"
+	WorkspaceMember = "member"
+	WorkspaceAdmin  = "admin"
+	WorkspaceOwner  = "owner"
+)
+
+// Workspace is a space whose members share its articles. Every user has a personal
+// workspace that holds their own notes and cannot have other members.
+type Workspace struct {
+	ID       int    `json:"id" db:"id"`
+	Name     string `json:"name" db:"name"`
+	Personal bool   `json:"personal" db:"personal"`
+	// Role is the requesting user's role in the workspace
+	Role    string     `json:"role,omitempty" db:"role"`
+	Members int        `json:"members" db:"members"`
+	Created *time.Time `json:"created" db:"created"`
+	Updated *time.Time `json:"updated" db:"updated"`
+}
+
+// WorkspaceMemberEntry is a user belonging to a workspace
+type WorkspaceMemberEntry struct {
+	WorkspaceID int        `json:"workspace_id" db:"workspace_id"`
+	UserID      int        `json:"user_id" db:"user_id"`
+	Email       string     `json:"email" db:"email"`
+	Name        string     `json:"name" db:"name"`
+	Role        string     `json:"role" db:"role"`
+	Joined      *time.Time `json:"joined" db:"joined"`
+}
+
+// WorkspaceInvite invites an email address without an account to a workspace. It is
+// claimed when that person signs in.
+type WorkspaceInvite struct {
+	ID          int        `json:"id" db:"id"`
+	WorkspaceID int        `json:"workspace_id" db:"workspace_id"`
+	Email       string     `json:"email" db:"email"`
+	Role        string     `json:"role" db:"role"`
+	InvitedBy   int        `json:"invited_by" db:"invited_by"`
+	Created     *time.Time `json:"created" db:"created"`
+}
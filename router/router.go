@@ -3,29 +3,44 @@ package router
 import (
 	"net/http"
 
+	"personalnote.eu/simple-go-api/config"
 	"personalnote.eu/simple-go-api/handlers"
+	"personalnote.eu/simple-go-api/metrics"
 	"personalnote.eu/simple-go-api/middleware"
 )
 
-// SetupRoutes configures all the application routes
-func SetupRoutes() {
+// SetupRoutes configures all the application routes and returns the resulting handler
+func SetupRoutes(cfg *config.Config) http.Handler {
+	mux := http.NewServeMux()
+
 	register := func(pattern string, handler http.HandlerFunc) {
-		http.Handle(pattern, middleware.WithCORS(handler))
+		mux.Handle(pattern, middleware.WithRequestLogging(pattern,
+			middleware.WithMetrics(pattern, middleware.WithCORS(handler))))
 	}
 
 	// Static file serving
-	http.Handle("/garnetstar.ico", middleware.WithCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	register("/garnetstar.ico", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "./static/garnetstar.ico")
-	})))
-	http.Handle("/garnetstar.jpeg", middleware.WithCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	})
+	register("/garnetstar.jpeg", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "./static/garnetstar.jpeg")
-	})))
+	})
 
 	// Public routes
 	register("/", handlers.HelloHandler)
 	register("/articles", handlers.ArticlesHandler)
+	register("/articles/batch", handlers.ArticlesBatchHandler)
+	register("/articles/export", handlers.ArticlesExportHandler)
+	register("/articles/import", handlers.ArticlesImportHandler)
+	register("/articles/import/", handlers.ArticlesImportHandler)
+	register("/articles/shared-with-me", handlers.SharedWithMeHandler)
 	register("/article/filter/", handlers.ArticleFindHandler)
 	register("/article/", handlers.ArticleHandler)
+	register("/s/", handlers.SharedArticleHandler)
+	register("/workspaces", handlers.WorkspacesHandler)
+	register("/workspaces/", handlers.WorkspacesHandler)
+	register("/events", handlers.EventsHandler)
+	register("/sync", handlers.SyncHandler)
 
 	// Auth routes
 	register("/auth/google/login", handlers.GoogleLoginHandler)
@@ -34,4 +49,23 @@ func SetupRoutes() {
 
 	// File upload routes
 	register("/upload", handlers.UploadHandler)
+	register("/blobs/", handlers.BlobHandler)
+	register("/files", handlers.FilesHandler)
+	register("/files/", handlers.FileHandler)
+	register("/uploads", handlers.TusHandler)
+	register("/uploads/", handlers.TusHandler)
+	register("/me/usage", handlers.UsageHandler)
+
+	// Google Drive connection routes
+	register("/drive/connect", handlers.DriveConnectHandler)
+	register("/drive/callback", handlers.DriveCallbackHandler)
+	register("/drive/status", handlers.DriveStatusHandler)
+	register("/drive/disconnect", handlers.DriveDisconnectHandler)
+
+	// Operational routes
+	register("/healthz", handlers.HealthzHandler)
+	register("/readyz", handlers.ReadyzHandler)
+	register("/metrics", metrics.Handler(cfg.Metrics.Token.Value()))
+
+	return mux
 }
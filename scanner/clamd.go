@@ -0,0 +1,160 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// clamdChunkSize is the size of the INSTREAM chunks sent to clamd
+const clamdChunkSize = 64 << 10
+
+// Clamd scans files with a ClamAV daemon using its INSTREAM command
+type Clamd struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamd creates a clamd scanner for an address such as tcp://127.0.0.1:3310
+// or unix:///var/run/clamav/clamd.ctl. timeout bounds a whole scan.
+func NewClamd(address string, timeout time.Duration) (*Clamd, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid clamd address %q: %v", address, err)
+	}
+
+	c := &Clamd{timeout: timeout}
+	switch u.Scheme {
+	case "tcp":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid clamd address %q: missing host", address)
+		}
+		c.network, c.address = "tcp", u.Host
+	case "unix":
+		if u.Path == "" {
+			return nil, fmt.Errorf("invalid clamd address %q: missing socket path", address)
+		}
+		c.network, c.address = "unix", u.Path
+	default:
+		return nil, fmt.Errorf("invalid clamd address %q: scheme must be tcp or unix", address)
+	}
+	return c, nil
+}
+
+func (c *Clamd) Name() string {
+	return "clamd"
+}
+
+// Scan streams r to clamd. Replies look like "stream: OK" or "stream: Eicar-Signature FOUND".
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) (*Result, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	buf := make([]byte, clamdChunkSize+4)
+	for {
+		n, readErr := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				// clamd closes the connection when the stream exceeds its StreamMaxLength
+				if reply, replyErr := readReply(conn); replyErr == nil {
+					return nil, replyError(reply)
+				}
+				return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return nil, fmt.Errorf("failed to read file for scanning: %v", readErr)
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	reply, err := readReply(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	verdict := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+	switch {
+	case verdict == "OK":
+		return &Result{}, nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		return &Result{Infected: true, Signature: strings.TrimSuffix(verdict, " FOUND")}, nil
+	default:
+		return nil, replyError(reply)
+	}
+}
+
+// replyError turns a clamd error reply into an error, e.g. "INSTREAM size limit exceeded. ERROR"
+// when the stream is longer than clamd's StreamMaxLength
+func replyError(reply string) error {
+	if strings.Contains(reply, "size limit exceeded") {
+		return fmt.Errorf("%w: %s", ErrTooLarge, reply)
+	}
+	return fmt.Errorf("clamd error: %s", reply)
+}
+
+func (c *Clamd) Ping(ctx context.Context) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("zPING\x00")); err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	reply, err := readReply(conn)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("unexpected clamd reply: %s", reply)
+	}
+	return nil
+}
+
+// dial connects to clamd, with a deadline from the timeout or ctx, whichever is earlier
+func (c *Clamd) dial(ctx context.Context) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+	return conn, nil
+}
+
+// readReply reads one NUL terminated reply
+func readReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && len(reply) == 0 {
+		return "", fmt.Errorf("%w: failed to read reply: %v", ErrUnavailable, err)
+	}
+	return string(bytes.TrimRight(reply, "\x00\n")), nil
+}
@@ -0,0 +1,200 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eicar is the EICAR anti-virus test file
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeClamd is a clamd stub listening on a local TCP port. It answers zPING with PONG and
+// zINSTREAM the way clamd does: infected for streams containing the EICAR string, an
+// error once the stream exceeds maxStream bytes.
+type fakeClamd struct {
+	maxStream int
+	// reply, when set, is sent for every stream instead of a verdict
+	reply string
+	// hangUp closes the connection at the end of a stream without answering
+	hangUp bool
+}
+
+func startFakeClamd(t *testing.T, fake *fakeClamd) *Clamd {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go fake.serve(conn)
+		}
+	}()
+
+	c, err := NewClamd("tcp://"+ln.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Fatalf("NewClamd: %v", err)
+	}
+	return c
+}
+
+func (f *fakeClamd) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	command, err := r.ReadString(0)
+	if err != nil {
+		return
+	}
+	switch command {
+	case "zPING\x00":
+		conn.Write([]byte("PONG\x00"))
+	case "zINSTREAM\x00":
+		f.instream(conn, r)
+	default:
+		conn.Write([]byte("UNKNOWN COMMAND\x00"))
+	}
+}
+
+func (f *fakeClamd) instream(conn net.Conn, r *bufio.Reader) {
+	var stream bytes.Buffer
+	for {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return
+		}
+		if size == 0 {
+			break
+		}
+		if _, err := io.CopyN(&stream, r, int64(size)); err != nil {
+			return
+		}
+		if f.maxStream > 0 && stream.Len() > f.maxStream {
+			conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+			// Drain the rest so closing does not reset the connection before the reply is read
+			io.Copy(io.Discard, r)
+			return
+		}
+	}
+
+	switch {
+	case f.hangUp:
+		return
+	case f.reply != "":
+		conn.Write([]byte(f.reply + "\x00"))
+	case strings.Contains(stream.String(), eicar):
+		conn.Write([]byte("stream: Eicar-Signature FOUND\x00"))
+	default:
+		conn.Write([]byte("stream: OK\x00"))
+	}
+}
+
+func TestNewClamd(t *testing.T) {
+	tests := []struct {
+		address string
+		network string
+		wantErr bool
+	}{
+		{"tcp://127.0.0.1:3310", "tcp", false},
+		{"unix:///var/run/clamav/clamd.ctl", "unix", false},
+		{"tcp://", "", true},
+		{"unix://", "", true},
+		{"http://127.0.0.1:3310", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			c, err := NewClamd(tt.address, time.Second)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewClamd: err = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && c.network != tt.network {
+				t.Errorf("network = %q, want %q", c.network, tt.network)
+			}
+		})
+	}
+}
+
+func TestClamdScan(t *testing.T) {
+	large := strings.Repeat("a", 3*clamdChunkSize)
+
+	tests := []struct {
+		name          string
+		fake          fakeClamd
+		content       string
+		wantInfected  bool
+		wantSignature string
+		wantErr       error
+	}{
+		{name: "clean", content: "hello world"},
+		{name: "empty", content: ""},
+		{name: "clean over several chunks", content: large},
+		{name: "infected", content: "prefix " + eicar, wantInfected: true, wantSignature: "Eicar-Signature"},
+		{name: "size limit exceeded", fake: fakeClamd{maxStream: clamdChunkSize}, content: large, wantErr: ErrTooLarge},
+		{name: "no reply", fake: fakeClamd{hangUp: true}, content: "hello", wantErr: ErrUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := startFakeClamd(t, &tt.fake)
+			result, err := c.Scan(context.Background(), strings.NewReader(tt.content))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Scan: err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Scan: %v", err)
+			}
+			if result.Infected != tt.wantInfected || result.Signature != tt.wantSignature {
+				t.Errorf("Scan = %+v, want infected %v with signature %q", result, tt.wantInfected, tt.wantSignature)
+			}
+		})
+	}
+}
+
+func TestClamdScanErrorReply(t *testing.T) {
+	c := startFakeClamd(t, &fakeClamd{reply: "stream: Can't allocate memory ERROR"})
+	_, err := c.Scan(context.Background(), strings.NewReader("hello"))
+	if err == nil || errors.Is(err, ErrUnavailable) || errors.Is(err, ErrTooLarge) {
+		t.Errorf("Scan: err = %v, want a clamd error", err)
+	}
+}
+
+func TestClamdUnavailable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	address := ln.Addr().String()
+	ln.Close()
+
+	c, err := NewClamd("tcp://"+address, time.Second)
+	if err != nil {
+		t.Fatalf("NewClamd: %v", err)
+	}
+	if _, err := c.Scan(context.Background(), strings.NewReader("hello")); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Scan: err = %v, want ErrUnavailable", err)
+	}
+	if err := c.Ping(context.Background()); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Ping: err = %v, want ErrUnavailable", err)
+	}
+}
+
+func TestClamdPing(t *testing.T) {
+	c := startFakeClamd(t, &fakeClamd{})
+	if err := c.Ping(context.Background()); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+}
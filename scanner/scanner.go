@@ -0,0 +1,61 @@
+// Package scanner checks uploaded files for malware before they are stored
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"personalnote.eu/simple-go-api/config"
+)
+
+var (
+	// ErrUnavailable is returned when the scanning service cannot be reached
+	ErrUnavailable = errors.New("scanner unavailable")
+	// ErrTooLarge is returned when a file is larger than the scanning service accepts
+	ErrTooLarge = errors.New("file too large to scan")
+)
+
+// Result is the verdict for one file
+type Result struct {
+	Infected bool
+	// Signature names the malware found, if any
+	Signature string
+}
+
+// Scanner inspects file content for malware
+type Scanner interface {
+	// Name identifies the implementation, e.g. "none" or "clamd"
+	Name() string
+	Scan(ctx context.Context, r io.Reader) (*Result, error)
+	// Ping verifies the scanning service is reachable
+	Ping(ctx context.Context) error
+}
+
+// New creates the scanner selected in the configuration
+func New(cfg config.ScannerConfig) (Scanner, error) {
+	switch cfg.Backend {
+	case "none":
+		return Noop{}, nil
+	case "clamd":
+		return NewClamd(cfg.Address, cfg.Timeout)
+	default:
+		return nil, fmt.Errorf("unknown scanner backend %q", cfg.Backend)
+	}
+}
+
+// Noop treats every file as clean; it is used when no scanner is configured
+type Noop struct{}
+
+func (Noop) Name() string {
+	return "none"
+}
+
+func (Noop) Scan(ctx context.Context, r io.Reader) (*Result, error) {
+	return &Result{}, nil
+}
+
+func (Noop) Ping(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+	"personalnote.eu/simple-go-api/metrics"
+)
+
+// UserDriveBackend is the backend name of files stored in a user's own Drive
+const UserDriveBackend = "user_drive"
+
+// DriveStore keeps objects in Google Drive, optionally inside a folder
+type DriveStore struct {
+	name     string
+	client   DriveClient
+	folderID string
+}
+
+// NewDriveStore creates a store on top of the shared operator Drive client.
+// A nil client makes every operation fail with ErrNotConfigured.
+func NewDriveStore(client DriveClient, folderID string) *DriveStore {
+	return &DriveStore{name: "drive", client: client, folderID: folderID}
+}
+
+// NewUserDriveStore creates a store for the app folder in a user's own Drive
+func NewUserDriveStore(client DriveClient, folderID string) *DriveStore {
+	return &DriveStore{name: UserDriveBackend, client: client, folderID: folderID}
+}
+
+// Name identifies the backend
+func (s *DriveStore) Name() string {
+	return s.name
+}
+
+// Put uploads the file into the configured folder
+func (s *DriveStore) Put(ctx context.Context, name string, r io.Reader, opts PutOptions) (*ObjectInfo, error) {
+	if s.client == nil {
+		metrics.DriveUploadFailures.WithLabelValues("client").Inc()
+		return nil, ErrNotConfigured
+	}
+
+	driveFile := &drive.File{
+		Name:     name,
+		MimeType: opts.ContentType,
+	}
+	if s.folderID != "" {
+		driveFile.Parents = []string{s.folderID}
+	} else {
+		slog.WarnContext(ctx, "Drive folder ID not set, uploading to Drive root (likely to fail for service accounts)")
+	}
+
+	start := time.Now()
+	created, err := s.client.CreateFile(ctx, driveFile, r)
+	metrics.DriveUploadDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.DriveUploadFailures.WithLabelValues("upload").Inc()
+		return nil, fmt.Errorf("failed to upload file to Drive: %v", err)
+	}
+
+	return driveObjectInfo(created), nil
+}
+
+// Get downloads the file content, reading only the requested range
+func (s *DriveStore) Get(ctx context.Context, id string, opts GetOptions) (io.ReadCloser, error) {
+	if s.client == nil {
+		return nil, ErrNotConfigured
+	}
+
+	body, err := s.client.Download(ctx, id, opts)
+	if err != nil {
+		return nil, translateDriveError(err)
+	}
+	return body, nil
+}
+
+// Delete permanently removes the file
+func (s *DriveStore) Delete(ctx context.Context, id string) error {
+	if s.client == nil {
+		return ErrNotConfigured
+	}
+	return translateDriveError(s.client.DeleteFile(ctx, id))
+}
+
+// Stat returns the file metadata
+func (s *DriveStore) Stat(ctx context.Context, id string) (*ObjectInfo, error) {
+	if s.client == nil {
+		return nil, ErrNotConfigured
+	}
+
+	file, err := s.client.GetFile(ctx, id)
+	if err != nil {
+		return nil, translateDriveError(err)
+	}
+	return driveObjectInfo(file), nil
+}
+
+// SignedURL returns the Drive web view link. Drive cannot mint expiring URLs,
+// so access is governed by the file's Drive permissions and expiry is ignored.
+func (s *DriveStore) SignedURL(ctx context.Context, id string, expiry time.Duration) (string, error) {
+	info, err := s.Stat(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	return info.Link, nil
+}
+
+func driveObjectInfo(file *drive.File) *ObjectInfo {
+	info := &ObjectInfo{
+		ID:          file.Id,
+		Name:        file.Name,
+		ContentType: file.MimeType,
+		Size:        file.Size,
+		Link:        file.WebViewLink,
+	}
+	if modified, err := time.Parse(time.RFC3339, file.ModifiedTime); err == nil {
+		info.ModTime = modified
+	}
+	return info
+}
+
+// translateDriveError maps Drive 404 responses onto ErrNotFound
+func translateDriveError(err error) error {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return ErrNotFound
+	}
+	return err
+}
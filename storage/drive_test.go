@@ -0,0 +1,297 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+	"personalnote.eu/simple-go-api/config"
+)
+
+// fakeDrive is an httptest Drive server implementing the file, upload and about calls the
+// Drive client makes
+type fakeDrive struct {
+	email string
+
+	mu     sync.Mutex
+	nextID int
+	files  map[string]*fakeDriveFile
+}
+
+type fakeDriveFile struct {
+	meta    drive.File
+	content []byte
+}
+
+func newFakeDrive(t *testing.T) (*fakeDrive, DriveClient) {
+	t.Helper()
+	fake := &fakeDrive{email: "owner@example.com", files: map[string]*fakeDriveFile{}}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	client, err := NewDriveClientWithOptions(context.Background(), "",
+		option.WithEndpoint(srv.URL+"/drive/v3/"),
+		option.WithHTTPClient(srv.Client()),
+	)
+	if err != nil {
+		t.Fatalf("NewDriveClientWithOptions: %v", err)
+	}
+	return fake, client
+}
+
+func (f *fakeDrive) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := r.URL.Path
+	switch {
+	case r.Method == http.MethodPost && path == "/upload/drive/v3/files":
+		f.createWithMedia(w, r)
+	case r.Method == http.MethodPost && path == "/drive/v3/files":
+		var meta drive.File
+		if err := json.NewDecoder(r.Body).Decode(&meta); err != nil {
+			f.sendError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		f.sendJSON(w, f.add(meta, nil))
+	case r.Method == http.MethodGet && path == "/drive/v3/files":
+		f.list(w, r)
+	case r.Method == http.MethodGet && path == "/drive/v3/about":
+		f.sendJSON(w, &drive.About{User: &drive.User{EmailAddress: f.email}})
+	case strings.HasPrefix(path, "/drive/v3/files/"):
+		file, ok := f.files[strings.TrimPrefix(path, "/drive/v3/files/")]
+		if !ok {
+			f.sendError(w, http.StatusNotFound, "File not found")
+			return
+		}
+		switch {
+		case r.Method == http.MethodDelete:
+			delete(f.files, file.meta.Id)
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodGet && r.URL.Query().Get("alt") == "media":
+			http.ServeContent(w, r, file.meta.Name, time.Time{}, bytes.NewReader(file.content))
+		case r.Method == http.MethodGet:
+			f.sendJSON(w, &file.meta)
+		default:
+			f.sendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	default:
+		f.sendError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+// createWithMedia handles a multipart upload: JSON metadata followed by the content
+func (f *fakeDrive) createWithMedia(w http.ResponseWriter, r *http.Request) {
+	if uploadType := r.URL.Query().Get("uploadType"); uploadType != "multipart" {
+		f.sendError(w, http.StatusBadRequest, "unsupported uploadType "+uploadType)
+		return
+	}
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		f.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	parts := multipart.NewReader(r.Body, params["boundary"])
+
+	var meta drive.File
+	part, err := parts.NextPart()
+	if err == nil {
+		err = json.NewDecoder(part).Decode(&meta)
+	}
+	if err != nil {
+		f.sendError(w, http.StatusBadRequest, "invalid metadata part")
+		return
+	}
+	part, err = parts.NextPart()
+	if err != nil {
+		f.sendError(w, http.StatusBadRequest, "missing media part")
+		return
+	}
+	content, err := io.ReadAll(part)
+	if err != nil {
+		f.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	f.sendJSON(w, f.add(meta, content))
+}
+
+// list answers the folder lookup made by EnsureFolder
+func (f *fakeDrive) list(w http.ResponseWriter, r *http.Request) {
+	result := &drive.FileList{Files: []*drive.File{}}
+	for _, file := range f.files {
+		// Drive query strings escape quotes and backslashes
+		name := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(file.meta.Name)
+		query := fmt.Sprintf("name = '%s' and mimeType = '%s'", name, file.meta.MimeType)
+		if strings.HasPrefix(r.URL.Query().Get("q"), query) {
+			result.Files = append(result.Files, &drive.File{Id: file.meta.Id})
+		}
+	}
+	f.sendJSON(w, result)
+}
+
+func (f *fakeDrive) add(meta drive.File, content []byte) *drive.File {
+	f.nextID++
+	meta.Id = fmt.Sprintf("file%d", f.nextID)
+	meta.Size = int64(len(content))
+	meta.ModifiedTime = time.Now().UTC().Format(time.RFC3339)
+	meta.WebViewLink = "https://drive.example.com/file/d/" + meta.Id + "/view"
+	f.files[meta.Id] = &fakeDriveFile{meta: meta, content: content}
+	return &meta
+}
+
+func (f *fakeDrive) sendJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (f *fakeDrive) sendError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	fmt.Fprintf(w, `{"error":{"code":%d,"message":%s}}`, code, strconv.Quote(message))
+}
+
+func TestDriveStore(t *testing.T) {
+	_, client := newFakeDrive(t)
+	testBlobStore(t, NewDriveStore(client, "folder"))
+}
+
+func TestDriveStorePutIntoFolder(t *testing.T) {
+	fake, client := newFakeDrive(t)
+	store := NewUserDriveStore(client, "folder")
+	ctx := context.Background()
+
+	info, err := store.Put(ctx, "report.pdf", strings.NewReader("%PDF-1.7"), PutOptions{ContentType: "application/pdf", Size: 8})
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if parents := fake.files[info.ID].meta.Parents; len(parents) != 1 || parents[0] != "folder" {
+		t.Errorf("parents = %v, want [folder]", parents)
+	}
+	if store.Name() != UserDriveBackend {
+		t.Errorf("Name = %q, want %q", store.Name(), UserDriveBackend)
+	}
+
+	link, err := store.SignedURL(ctx, info.ID, time.Minute)
+	if err != nil {
+		t.Fatalf("SignedURL: %v", err)
+	}
+	if link != info.Link || link == "" {
+		t.Errorf("SignedURL = %q, want the web view link %q", link, info.Link)
+	}
+}
+
+func TestDriveStoreWithoutClient(t *testing.T) {
+	store := NewDriveStore(nil, "")
+	ctx := context.Background()
+
+	if _, err := store.Put(ctx, "a.txt", strings.NewReader("a"), PutOptions{}); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("Put: err = %v, want ErrNotConfigured", err)
+	}
+	if _, err := store.Get(ctx, "file1", GetOptions{}); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("Get: err = %v, want ErrNotConfigured", err)
+	}
+	if _, err := store.Stat(ctx, "file1"); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("Stat: err = %v, want ErrNotConfigured", err)
+	}
+	if err := store.Delete(ctx, "file1"); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("Delete: err = %v, want ErrNotConfigured", err)
+	}
+}
+
+func TestDriveClient(t *testing.T) {
+	fake, client := newFakeDrive(t)
+	ctx := context.Background()
+
+	folderID, err := client.EnsureFolder(ctx, "Person's Notes")
+	if err != nil {
+		t.Fatalf("EnsureFolder: %v", err)
+	}
+	if meta := fake.files[folderID].meta; meta.MimeType != driveFolderMimeType || meta.Name != "Person's Notes" {
+		t.Errorf("folder = %+v, want a folder named Person's Notes", meta)
+	}
+	again, err := client.EnsureFolder(ctx, "Person's Notes")
+	if err != nil {
+		t.Fatalf("EnsureFolder again: %v", err)
+	}
+	if again != folderID {
+		t.Errorf("EnsureFolder again = %q, want the existing folder %q", again, folderID)
+	}
+
+	email, err := client.UserEmail(ctx)
+	if err != nil {
+		t.Fatalf("UserEmail: %v", err)
+	}
+	if email != fake.email {
+		t.Errorf("UserEmail = %q, want %q", email, fake.email)
+	}
+
+	if err := client.Ping(ctx); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+}
+
+func TestDriveClientPingFolder(t *testing.T) {
+	fake := &fakeDrive{files: map[string]*fakeDriveFile{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	folderID := fake.add(drive.File{Name: "uploads", MimeType: driveFolderMimeType}, nil).Id
+
+	tests := []struct {
+		name     string
+		folderID string
+		wantErr  bool
+	}{
+		{"existing folder", folderID, false},
+		{"missing folder", "deleted-folder", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewDriveClientWithOptions(context.Background(), tt.folderID,
+				option.WithEndpoint(srv.URL+"/drive/v3/"),
+				option.WithHTTPClient(srv.Client()),
+			)
+			if err != nil {
+				t.Fatalf("NewDriveClientWithOptions: %v", err)
+			}
+			if err := client.Ping(context.Background()); (err != nil) != tt.wantErr {
+				t.Errorf("Ping: err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewDriveClientCredentials(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.DriveConfig
+		wantErr error
+	}{
+		{"no credentials", config.DriveConfig{}, ErrNotConfigured},
+		{"missing credentials file", config.DriveConfig{ServiceAccountFile: t.TempDir() + "/missing.json"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewDriveClient(context.Background(), tt.cfg, config.AuthConfig{})
+			if err == nil {
+				t.Fatalf("NewDriveClient = %v, want an error", client)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("NewDriveClient: err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"personalnote.eu/simple-go-api/config"
+)
+
+// driveFileFields are the file fields requested from the Drive API
+const driveFileFields = "id,name,mimeType,size,modifiedTime,webViewLink"
+
+// driveFolderMimeType identifies folders in Drive
+const driveFolderMimeType = "application/vnd.google-apps.folder"
+
+// driveChunkSize is the chunk size for Drive's resumable upload protocol.
+// Content larger than one chunk is sent chunk by chunk, and a failed chunk is
+// retried on its own instead of restarting the whole transfer.
+const driveChunkSize = 8 << 20
+
+// DriveClient is the part of the Google Drive API the application uses.
+// It is created once and shared by all requests; tests can substitute a fake,
+// or point a real client at an httptest server with NewDriveClientWithOptions.
+type DriveClient interface {
+	CreateFile(ctx context.Context, file *drive.File, media io.Reader) (*drive.File, error)
+	GetFile(ctx context.Context, id string) (*drive.File, error)
+	Download(ctx context.Context, id string, opts GetOptions) (io.ReadCloser, error)
+	DeleteFile(ctx context.Context, id string) error
+	// EnsureFolder returns the ID of the named folder in the Drive root, creating it if needed
+	EnsureFolder(ctx context.Context, name string) (string, error)
+	// UserEmail returns the email address of the account the client acts for
+	UserEmail(ctx context.Context) (string, error)
+	// Ping verifies the credentials and, when configured, access to the upload folder
+	Ping(ctx context.Context) error
+}
+
+// driveClient implements DriveClient on top of the generated Drive service
+type driveClient struct {
+	srv      *drive.Service
+	folderID string
+}
+
+// NewDriveClient builds a Drive client from the first configured credential option:
+// a refresh token (personal accounts), service account JSON, or a service account file.
+// It returns ErrNotConfigured when no credentials are set.
+func NewDriveClient(ctx context.Context, driveCfg config.DriveConfig, auth config.AuthConfig) (DriveClient, error) {
+	var opts []option.ClientOption
+
+	switch {
+	case driveCfg.RefreshToken != "":
+		slog.InfoContext(ctx, "using Drive refresh token credentials")
+		oauthConfig := &oauth2.Config{
+			ClientID:     auth.GoogleClientID,
+			ClientSecret: auth.GoogleClientSecret.Value(),
+			Endpoint:     google.Endpoint,
+			Scopes:       []string{drive.DriveFileScope},
+		}
+		// The token source outlives ctx, so it must not be bound to it
+		token := &oauth2.Token{RefreshToken: driveCfg.RefreshToken.Value()}
+		opts = append(opts, option.WithTokenSource(oauthConfig.TokenSource(context.Background(), token)))
+	case driveCfg.ServiceAccountJSON != "":
+		slog.InfoContext(ctx, "using Drive service account JSON credentials")
+		opts = append(opts, option.WithCredentialsJSON([]byte(driveCfg.ServiceAccountJSON.Value())), option.WithScopes(drive.DriveScope))
+	case driveCfg.ServiceAccountFile != "":
+		slog.InfoContext(ctx, "using Drive service account file credentials", "path", driveCfg.ServiceAccountFile)
+		if _, err := os.Stat(driveCfg.ServiceAccountFile); err != nil {
+			return nil, fmt.Errorf("credentials file not accessible: %s", driveCfg.ServiceAccountFile)
+		}
+		opts = append(opts, option.WithCredentialsFile(driveCfg.ServiceAccountFile), option.WithScopes(drive.DriveScope))
+	default:
+		return nil, ErrNotConfigured
+	}
+
+	return NewDriveClientWithOptions(ctx, driveCfg.FolderID, opts...)
+}
+
+// NewDriveClientWithOptions builds a Drive client from raw client options, e.g.
+// option.WithEndpoint and option.WithHTTPClient for a fake Drive server
+func NewDriveClientWithOptions(ctx context.Context, folderID string, opts ...option.ClientOption) (DriveClient, error) {
+	srv, err := drive.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Drive client: %v", err)
+	}
+	return &driveClient{srv: srv, folderID: folderID}, nil
+}
+
+func (c *driveClient) CreateFile(ctx context.Context, file *drive.File, media io.Reader) (*drive.File, error) {
+	return c.srv.Files.Create(file).
+		Media(media, googleapi.ChunkSize(driveChunkSize)).
+		Fields(driveFileFields).
+		Context(ctx).
+		Do()
+}
+
+func (c *driveClient) GetFile(ctx context.Context, id string) (*drive.File, error) {
+	return c.srv.Files.Get(id).Fields(driveFileFields).Context(ctx).Do()
+}
+
+// Download reads the file content, using an HTTP Range header for partial reads
+func (c *driveClient) Download(ctx context.Context, id string, opts GetOptions) (io.ReadCloser, error) {
+	call := c.srv.Files.Get(id).Context(ctx)
+	if opts.Offset > 0 || opts.Length > 0 {
+		rangeHeader := fmt.Sprintf("bytes=%d-", opts.Offset)
+		if opts.Length > 0 {
+			rangeHeader += fmt.Sprint(opts.Offset + opts.Length - 1)
+		}
+		call.Header().Set("Range", rangeHeader)
+	}
+
+	resp, err := call.Download()
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (c *driveClient) DeleteFile(ctx context.Context, id string) error {
+	return c.srv.Files.Delete(id).Context(ctx).Do()
+}
+
+func (c *driveClient) EnsureFolder(ctx context.Context, name string) (string, error) {
+	// With the drive.file scope only folders created by this app are visible,
+	// so a folder of the same name made by the user is never reused
+	query := fmt.Sprintf("name = '%s' and mimeType = '%s' and 'root' in parents and trashed = false",
+		strings.ReplaceAll(strings.ReplaceAll(name, `\`, `\\`), "'", `\'`), driveFolderMimeType)
+	list, err := c.srv.Files.List().Q(query).Fields("files(id)").PageSize(1).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("failed to look up Drive folder: %v", err)
+	}
+	if len(list.Files) > 0 {
+		return list.Files[0].Id, nil
+	}
+
+	folder, err := c.srv.Files.Create(&drive.File{Name: name, MimeType: driveFolderMimeType}).Fields("id").Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("failed to create Drive folder: %v", err)
+	}
+	return folder.Id, nil
+}
+
+func (c *driveClient) UserEmail(ctx context.Context) (string, error) {
+	about, err := c.srv.About.Get().Fields("user(emailAddress)").Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("failed to get Drive user: %v", err)
+	}
+	if about.User == nil {
+		return "", nil
+	}
+	return about.User.EmailAddress, nil
+}
+
+func (c *driveClient) Ping(ctx context.Context) error {
+	if c.folderID != "" {
+		if _, err := c.srv.Files.Get(c.folderID).Fields("id").Context(ctx).Do(); err != nil {
+			return fmt.Errorf("cannot access Drive folder: %v", err)
+		}
+		return nil
+	}
+	if _, err := c.srv.About.Get().Fields("user").Context(ctx).Do(); err != nil {
+		return fmt.Errorf("cannot reach Drive: %v", err)
+	}
+	return nil
+}
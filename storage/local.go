@@ -0,0 +1,254 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LocalStore keeps objects on the local filesystem, with a JSON sidecar holding metadata
+type LocalStore struct {
+	root       string
+	baseURL    string
+	signingKey []byte
+}
+
+// localMeta is the sidecar written next to every object
+type localMeta struct {
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Created     time.Time `json:"created"`
+}
+
+// NewLocalStore creates a store rooted at dir. Signed URLs point at baseURL + "/blobs/{id}".
+func NewLocalStore(dir, baseURL string, signingKey []byte) (*LocalStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("local storage directory not set")
+	}
+	if len(signingKey) == 0 {
+		return nil, fmt.Errorf("local storage signing key not set")
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %v", err)
+	}
+
+	return &LocalStore{
+		root:       dir,
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		signingKey: signingKey,
+	}, nil
+}
+
+// Name identifies the backend
+func (s *LocalStore) Name() string {
+	return "local"
+}
+
+// Put writes the object to a temporary file and renames it into place
+func (s *LocalStore) Put(ctx context.Context, name string, r io.Reader, opts PutOptions) (*ObjectInfo, error) {
+	id, err := newObjectID(name)
+	if err != nil {
+		return nil, err
+	}
+	objectPath := s.objectPath(id)
+	if err := os.MkdirAll(filepath.Dir(objectPath), 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create object directory: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(objectPath), ".upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err := io.Copy(tmp, contextReader{ctx, r})
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write object: %v", err)
+	}
+
+	meta := localMeta{Name: name, ContentType: opts.ContentType, Created: time.Now().UTC()}
+	metaJSON, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(objectPath+".meta.json", metaJSON, 0o640); err != nil {
+		return nil, fmt.Errorf("failed to write object metadata: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), objectPath); err != nil {
+		return nil, fmt.Errorf("failed to store object: %v", err)
+	}
+
+	return &ObjectInfo{
+		ID:          id,
+		Name:        name,
+		ContentType: opts.ContentType,
+		Size:        size,
+		ModTime:     meta.Created,
+	}, nil
+}
+
+// Get opens the object and positions it at the requested range
+func (s *LocalStore) Get(ctx context.Context, id string, opts GetOptions) (io.ReadCloser, error) {
+	file, err := s.open(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Offset > 0 {
+		if _, err := file.Seek(opts.Offset, io.SeekStart); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	if opts.Length > 0 {
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(file, opts.Length), file}, nil
+	}
+	return file, nil
+}
+
+// Open returns the object file for callers that need to seek, e.g. http.ServeContent
+func (s *LocalStore) Open(id string) (*os.File, *ObjectInfo, error) {
+	info, err := s.Stat(context.Background(), id)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := s.open(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, info, nil
+}
+
+// Delete removes the object and its metadata
+func (s *LocalStore) Delete(ctx context.Context, id string) error {
+	if !validObjectID(id) {
+		return ErrNotFound
+	}
+
+	objectPath := s.objectPath(id)
+	if err := os.Remove(objectPath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrNotFound
+		}
+		return err
+	}
+	if err := os.Remove(objectPath + ".meta.json"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Stat returns the object metadata
+func (s *LocalStore) Stat(ctx context.Context, id string) (*ObjectInfo, error) {
+	if !validObjectID(id) {
+		return nil, ErrNotFound
+	}
+
+	objectPath := s.objectPath(id)
+	fi, err := os.Stat(objectPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	var meta localMeta
+	if data, err := os.ReadFile(objectPath + ".meta.json"); err == nil {
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return nil, fmt.Errorf("failed to parse object metadata: %v", err)
+		}
+	}
+
+	return &ObjectInfo{
+		ID:          id,
+		Name:        meta.Name,
+		ContentType: meta.ContentType,
+		Size:        fi.Size(),
+		ModTime:     fi.ModTime(),
+	}, nil
+}
+
+// SignedURL returns an HMAC signed link served by the /blobs/ route
+func (s *LocalStore) SignedURL(ctx context.Context, id string, expiry time.Duration) (string, error) {
+	if !validObjectID(id) {
+		return "", ErrNotFound
+	}
+
+	expires := strconv.FormatInt(time.Now().Add(expiry).Unix(), 10)
+	query := url.Values{
+		"expires":   {expires},
+		"signature": {s.sign(id, expires)},
+	}
+	return fmt.Sprintf("%s/blobs/%s?%s", s.baseURL, id, query.Encode()), nil
+}
+
+// VerifySignature checks a signature produced by SignedURL and that it has not expired
+func (s *LocalStore) VerifySignature(id, expires, signature string) error {
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid expiry")
+	}
+	if time.Now().Unix() > expiresAt {
+		return fmt.Errorf("signed URL expired")
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.sign(id, expires))) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+func (s *LocalStore) sign(id, expires string) string {
+	mac := hmac.New(sha256.New, s.signingKey)
+	mac.Write([]byte(id + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *LocalStore) open(id string) (*os.File, error) {
+	if !validObjectID(id) {
+		return nil, ErrNotFound
+	}
+	file, err := os.Open(s.objectPath(id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return file, nil
+}
+
+// objectPath shards objects into subdirectories by the first two ID characters
+func (s *LocalStore) objectPath(id string) string {
+	return filepath.Join(s.root, id[:2], id)
+}
+
+// contextReader stops a copy once the context is cancelled
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLocalStore(t *testing.T) *LocalStore {
+	t.Helper()
+	store, err := NewLocalStore(t.TempDir(), "http://api.test/", []byte("signing-key"))
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	return store
+}
+
+func TestLocalStore(t *testing.T) {
+	testBlobStore(t, newTestLocalStore(t))
+}
+
+func TestLocalStoreRejectsInvalidIDs(t *testing.T) {
+	store := newTestLocalStore(t)
+	ctx := context.Background()
+
+	for _, id := range []string{"", "a", "../etc/passwd", "ab/../../x", "UPPER", "a..b"} {
+		if _, err := store.Stat(ctx, id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Stat(%q): err = %v, want ErrNotFound", id, err)
+		}
+		if err := store.Delete(ctx, id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Delete(%q): err = %v, want ErrNotFound", id, err)
+		}
+	}
+}
+
+func TestLocalStoreSignedURL(t *testing.T) {
+	store := newTestLocalStore(t)
+	ctx := context.Background()
+
+	info, err := store.Put(ctx, "notes.txt", strings.NewReader("notes"), PutOptions{ContentType: "text/plain", Size: 5})
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	link, err := store.SignedURL(ctx, info.ID, time.Minute)
+	if err != nil {
+		t.Fatalf("SignedURL: %v", err)
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("parse %q: %v", link, err)
+	}
+	if u.Host != "api.test" || u.Path != "/blobs/"+info.ID {
+		t.Errorf("SignedURL = %q, want http://api.test/blobs/%s?...", link, info.ID)
+	}
+	expires, signature := u.Query().Get("expires"), u.Query().Get("signature")
+
+	expired := store.sign(info.ID, "1")
+	tests := []struct {
+		name      string
+		id        string
+		expires   string
+		signature string
+		valid     bool
+	}{
+		{"valid", info.ID, expires, signature, true},
+		{"other object", "0123456789abcdef0123456789abcdef.txt", expires, signature, false},
+		{"extended expiry", info.ID, expires + "0", signature, false},
+		{"bad signature", info.ID, expires, strings.Repeat("0", len(signature)), false},
+		{"expired", info.ID, "1", expired, false},
+		{"invalid expiry", info.ID, "soon", signature, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := store.VerifySignature(tt.id, tt.expires, tt.signature)
+			if (err == nil) != tt.valid {
+				t.Errorf("VerifySignature: err = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"personalnote.eu/simple-go-api/config"
+)
+
+// nameMetadataKey stores the original file name as user metadata
+const nameMetadataKey = "Original-Name"
+
+// S3Store keeps objects in an S3-compatible bucket (AWS S3, MinIO, ...)
+type S3Store struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3Store creates a client for the configured endpoint and bucket
+func NewS3Store(cfg config.S3Config) (*S3Store, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3 endpoint and bucket are required")
+	}
+
+	lookup := minio.BucketLookupAuto
+	if cfg.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(cfg.AccessKeyID, cfg.SecretAccessKey.Value(), ""),
+		Secure:       cfg.UseSSL,
+		Region:       cfg.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 client: %v", err)
+	}
+
+	return &S3Store{
+		client: client,
+		bucket: cfg.Bucket,
+		prefix: strings.Trim(cfg.Prefix, "/"),
+	}, nil
+}
+
+// Name identifies the backend
+func (s *S3Store) Name() string {
+	return "s3"
+}
+
+// Put uploads the object, using multipart upload when the size is unknown or large
+func (s *S3Store) Put(ctx context.Context, name string, r io.Reader, opts PutOptions) (*ObjectInfo, error) {
+	id, err := newObjectID(name)
+	if err != nil {
+		return nil, err
+	}
+	size := opts.Size
+	if size == 0 {
+		size = -1
+	}
+
+	info, err := s.client.PutObject(ctx, s.bucket, s.key(id), r, size, minio.PutObjectOptions{
+		ContentType:  opts.ContentType,
+		UserMetadata: map[string]string{nameMetadataKey: url.QueryEscape(name)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload object: %v", err)
+	}
+
+	return &ObjectInfo{
+		ID:          id,
+		Name:        name,
+		ContentType: opts.ContentType,
+		Size:        info.Size,
+		ModTime:     time.Now().UTC(),
+	}, nil
+}
+
+// Get streams the object, optionally limited to a byte range
+func (s *S3Store) Get(ctx context.Context, id string, opts GetOptions) (io.ReadCloser, error) {
+	if !validObjectID(id) {
+		return nil, ErrNotFound
+	}
+
+	getOpts := minio.GetObjectOptions{}
+	if opts.Offset > 0 || opts.Length > 0 {
+		end := int64(0)
+		if opts.Length > 0 {
+			end = opts.Offset + opts.Length - 1
+		}
+		if err := getOpts.SetRange(opts.Offset, end); err != nil {
+			return nil, err
+		}
+	}
+
+	// Client.GetObject is lazy and drops the range once anything but a read comes first,
+	// so request the object right away; a missing object is reported here
+	body, _, _, err := minio.Core{Client: s.client}.GetObject(ctx, s.bucket, s.key(id), getOpts)
+	if err != nil {
+		return nil, s.translate(err)
+	}
+	return body, nil
+}
+
+// Delete removes the object
+func (s *S3Store) Delete(ctx context.Context, id string) error {
+	if !validObjectID(id) {
+		return ErrNotFound
+	}
+	if _, err := s.Stat(ctx, id); err != nil {
+		return err
+	}
+	return s.translate(s.client.RemoveObject(ctx, s.bucket, s.key(id), minio.RemoveObjectOptions{}))
+}
+
+// Stat returns the object metadata
+func (s *S3Store) Stat(ctx context.Context, id string) (*ObjectInfo, error) {
+	if !validObjectID(id) {
+		return nil, ErrNotFound
+	}
+
+	info, err := s.client.StatObject(ctx, s.bucket, s.key(id), minio.StatObjectOptions{})
+	if err != nil {
+		return nil, s.translate(err)
+	}
+
+	name, _ := url.QueryUnescape(info.UserMetadata[nameMetadataKey])
+	return &ObjectInfo{
+		ID:          id,
+		Name:        name,
+		ContentType: info.ContentType,
+		Size:        info.Size,
+		ModTime:     info.LastModified,
+	}, nil
+}
+
+// SignedURL returns a presigned GET URL
+func (s *S3Store) SignedURL(ctx context.Context, id string, expiry time.Duration) (string, error) {
+	if !validObjectID(id) {
+		return "", ErrNotFound
+	}
+
+	u, err := s.client.PresignedGetObject(ctx, s.bucket, s.key(id), expiry, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to presign object URL: %v", err)
+	}
+	return u.String(), nil
+}
+
+func (s *S3Store) key(id string) string {
+	if s.prefix == "" {
+		return id
+	}
+	return path.Join(s.prefix, id)
+}
+
+// translate maps S3 "not found" responses onto ErrNotFound
+func (s *S3Store) translate(err error) error {
+	if err == nil {
+		return nil
+	}
+	resp := minio.ToErrorResponse(err)
+	if resp.Code == "NoSuchKey" || resp.StatusCode == 404 {
+		return ErrNotFound
+	}
+	return err
+}
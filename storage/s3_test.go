@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"personalnote.eu/simple-go-api/config"
+)
+
+// fakeS3 is an in-process S3 server for a single path-style bucket, implementing just
+// the object calls S3Store makes. It does not check request signatures.
+type fakeS3 struct {
+	bucket string
+
+	mu      sync.Mutex
+	objects map[string]fakeS3Object
+}
+
+type fakeS3Object struct {
+	data     []byte
+	header   http.Header
+	modified time.Time
+}
+
+func newFakeS3(t *testing.T, bucket string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(&fakeS3{bucket: bucket, objects: map[string]fakeS3Object{}})
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket || key == "" {
+		f.sendError(w, r, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		data, err := readS3Payload(r)
+		if err != nil {
+			f.sendError(w, r, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		header := http.Header{}
+		header.Set("Content-Type", r.Header.Get("Content-Type"))
+		for name, values := range r.Header {
+			if strings.HasPrefix(strings.ToLower(name), "x-amz-meta-") {
+				header[name] = values
+			}
+		}
+		f.objects[key] = fakeS3Object{data: data, header: header, modified: time.Now().UTC().Truncate(time.Second)}
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, len(data)))
+	case http.MethodGet, http.MethodHead:
+		object, ok := f.objects[key]
+		if !ok {
+			f.sendError(w, r, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		for name, values := range object.header {
+			w.Header()[name] = values
+		}
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, len(object.data)))
+		http.ServeContent(w, r, key, object.modified, bytes.NewReader(object.data))
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		f.sendError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (f *fakeS3) sendError(w http.ResponseWriter, r *http.Request, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+	}
+}
+
+// readS3Payload reads an object body, decoding the aws-chunked encoding clients use for
+// streaming signatures over plain HTTP
+func readS3Payload(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+
+	var data bytes.Buffer
+	body := bufio.NewReader(r.Body)
+	for {
+		line, err := body.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			// Trailers, if any, are not needed
+			return data.Bytes(), nil
+		}
+		if _, err := io.CopyN(&data, body, size); err != nil {
+			return nil, err
+		}
+		if _, err := body.Discard(2); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func newTestS3Store(t *testing.T, prefix string) *S3Store {
+	t.Helper()
+	srv := newFakeS3(t, "notes")
+	store, err := NewS3Store(config.S3Config{
+		Endpoint:        strings.TrimPrefix(srv.URL, "http://"),
+		Region:          "us-east-1",
+		Bucket:          "notes",
+		Prefix:          prefix,
+		AccessKeyID:     "test",
+		SecretAccessKey: "test-secret",
+		PathStyle:       true,
+	})
+	if err != nil {
+		t.Fatalf("NewS3Store: %v", err)
+	}
+	return store
+}
+
+func TestS3Store(t *testing.T) {
+	for _, prefix := range []string{"", "uploads/"} {
+		t.Run(fmt.Sprintf("prefix %q", prefix), func(t *testing.T) {
+			testBlobStore(t, newTestS3Store(t, prefix))
+		})
+	}
+}
+
+func TestNewS3StoreRequiresBucket(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.S3Config
+	}{
+		{"no endpoint", config.S3Config{Bucket: "notes"}},
+		{"no bucket", config.S3Config{Endpoint: "localhost:9000"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewS3Store(tt.cfg); err == nil {
+				t.Error("NewS3Store succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestS3StoreSignedURL(t *testing.T) {
+	store := newTestS3Store(t, "")
+
+	link, err := store.SignedURL(t.Context(), "0123456789abcdef0123456789abcdef.txt", time.Minute)
+	if err != nil {
+		t.Fatalf("SignedURL: %v", err)
+	}
+	if !strings.Contains(link, "/notes/0123456789abcdef0123456789abcdef.txt?") || !strings.Contains(link, "X-Amz-Signature=") {
+		t.Errorf("SignedURL = %q, want a presigned URL for the object", link)
+	}
+	if _, err := store.SignedURL(t.Context(), "../other", time.Minute); err == nil {
+		t.Error("SignedURL accepted an invalid ID")
+	}
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// objectReader adapts a BlobStore object of known size to io.ReadSeekCloser.
+// Seeking is free; the next Read opens a ranged Get from the current offset,
+// so http.ServeContent can answer Range requests without downloading the whole object.
+type objectReader struct {
+	ctx    context.Context
+	store  BlobStore
+	id     string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+// NewReadSeeker returns a lazily opened, seekable reader over the object
+func NewReadSeeker(ctx context.Context, store BlobStore, id string, size int64) io.ReadSeekCloser {
+	return &objectReader{ctx: ctx, store: store, id: id, size: size}
+}
+
+func (o *objectReader) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+
+	if o.body == nil {
+		body, err := o.store.Get(o.ctx, o.id, GetOptions{Offset: o.offset})
+		if err != nil {
+			return 0, err
+		}
+		o.body = body
+	}
+
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	return n, err
+}
+
+func (o *objectReader) Seek(offset int64, whence int) (int64, error) {
+	var next int64
+	switch whence {
+	case io.SeekStart:
+		next = offset
+	case io.SeekCurrent:
+		next = o.offset + offset
+	case io.SeekEnd:
+		next = o.size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if next < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	if next != o.offset && o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+	o.offset = next
+	return next, nil
+}
+
+func (o *objectReader) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	err := o.body.Close()
+	o.body = nil
+	return err
+}
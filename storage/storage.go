@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"personalnote.eu/simple-go-api/config"
+)
+
+var (
+	// ErrNotFound is returned when an object does not exist in the store
+	ErrNotFound = errors.New("object not found")
+	// ErrNotConfigured is returned when the backend is missing credentials
+	ErrNotConfigured = errors.New("storage backend not configured")
+)
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mod_time"`
+	// Link is a backend specific URL for viewing the object, if any
+	Link string `json:"link,omitempty"`
+}
+
+// PutOptions describes an object being stored
+type PutOptions struct {
+	ContentType string
+	// Size is the content length, or -1 when unknown
+	Size int64
+}
+
+// GetOptions selects the byte range to read; a zero Length reads to the end
+type GetOptions struct {
+	Offset int64
+	Length int64
+}
+
+// BlobStore stores opaque files. IDs are assigned by the store on Put.
+type BlobStore interface {
+	// Name identifies the backend, e.g. "local", "s3" or "drive"
+	Name() string
+	Put(ctx context.Context, name string, r io.Reader, opts PutOptions) (*ObjectInfo, error)
+	Get(ctx context.Context, id string, opts GetOptions) (io.ReadCloser, error)
+	Delete(ctx context.Context, id string) error
+	Stat(ctx context.Context, id string) (*ObjectInfo, error)
+	// SignedURL returns a URL that grants read access to the object until expiry
+	SignedURL(ctx context.Context, id string, expiry time.Duration) (string, error)
+}
+
+// New creates the backend selected in the configuration. The drive backend
+// uses the shared driveClient, which is nil when no credentials are configured.
+func New(cfg *config.Config, driveClient DriveClient) (BlobStore, error) {
+	switch cfg.Storage.Backend {
+	case "local":
+		signingKey := cfg.Storage.Local.SigningKey.Value()
+		if signingKey == "" {
+			signingKey = cfg.Auth.JWTSecret.Value()
+		}
+		return NewLocalStore(cfg.Storage.Local.Dir, cfg.Storage.Local.BaseURL, []byte(signingKey))
+	case "s3":
+		return NewS3Store(cfg.Storage.S3)
+	case "drive":
+		return NewDriveStore(driveClient, cfg.Drive.FolderID), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Storage.Backend)
+	}
+}
+
+// newObjectID generates a random ID that keeps the extension of name
+func newObjectID(name string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate object ID: %v", err)
+	}
+
+	id := hex.EncodeToString(b)
+	ext := strings.ToLower(path.Ext(name))
+	if len(ext) > 1 && len(ext) <= 10 && isAlphanumeric(ext[1:]) {
+		id += ext
+	}
+	return id, nil
+}
+
+func isAlphanumeric(s string) bool {
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// validObjectID guards stores that map IDs onto paths or keys
+func validObjectID(id string) bool {
+	if len(id) < 2 || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '.') {
+			return false
+		}
+	}
+	return !strings.Contains(id, "..")
+}
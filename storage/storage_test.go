@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// testBlobStore runs the behaviour every BlobStore must share against store
+func testBlobStore(t *testing.T, store BlobStore) {
+	t.Helper()
+	ctx := context.Background()
+	const content = "The quick brown fox jumps over the lazy dog"
+
+	info, err := store.Put(ctx, "fox.txt", strings.NewReader(content), PutOptions{
+		ContentType: "text/plain",
+		Size:        int64(len(content)),
+	})
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if info.ID == "" {
+		t.Fatal("Put returned an empty ID")
+	}
+	if info.Size != int64(len(content)) {
+		t.Errorf("Put size = %d, want %d", info.Size, len(content))
+	}
+
+	t.Run("Stat", func(t *testing.T) {
+		stat, err := store.Stat(ctx, info.ID)
+		if err != nil {
+			t.Fatalf("Stat: %v", err)
+		}
+		if stat.Name != "fox.txt" || stat.ContentType != "text/plain" || stat.Size != int64(len(content)) {
+			t.Errorf("Stat = %+v, want fox.txt, text/plain, %d bytes", stat, len(content))
+		}
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		tests := []struct {
+			name string
+			opts GetOptions
+			want string
+		}{
+			{"whole object", GetOptions{}, content},
+			{"from offset", GetOptions{Offset: 4}, content[4:]},
+			{"range", GetOptions{Offset: 4, Length: 5}, content[4:9]},
+			{"range at start", GetOptions{Length: 3}, content[:3]},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				body, err := store.Get(ctx, info.ID, tt.opts)
+				if err != nil {
+					t.Fatalf("Get: %v", err)
+				}
+				defer body.Close()
+				got, err := io.ReadAll(body)
+				if err != nil {
+					t.Fatalf("read: %v", err)
+				}
+				if string(got) != tt.want {
+					t.Errorf("Get = %q, want %q", got, tt.want)
+				}
+			})
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := store.Delete(ctx, info.ID); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if _, err := store.Stat(ctx, info.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Stat after Delete: err = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		const missing = "0123456789abcdef0123456789abcdef.txt"
+		if _, err := store.Stat(ctx, missing); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Stat: err = %v, want ErrNotFound", err)
+		}
+		if body, err := store.Get(ctx, missing, GetOptions{}); !errors.Is(err, ErrNotFound) {
+			if body != nil {
+				body.Close()
+			}
+			t.Errorf("Get: err = %v, want ErrNotFound", err)
+		}
+		if err := store.Delete(ctx, missing); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Delete: err = %v, want ErrNotFound", err)
+		}
+	})
+}
@@ -0,0 +1,191 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"personalnote.eu/simple-go-api/models"
+)
+
+// ValidRole reports whether role can be granted to another user
+func ValidRole(role string) bool {
+	return role == models.RoleViewer || role == models.RoleEditor
+}
+
+// GrantArticleAccess gives a user, or an email address that has no account yet, a role on
+// an article. Granting again changes the role.
+func GrantArticleAccess(ctx context.Context, entry *models.ArticleACL) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	var email interface{}
+	if entry.UserID == nil {
+		email = strings.ToLower(entry.Email)
+	}
+
+	query := `
+		INSERT INTO article_acl (article_id, user_id, email, role, granted_by, created)
+		VALUES (?, ?, ?, ?, ?, NOW())
+		ON DUPLICATE KEY UPDATE role = VALUES(role), granted_by = VALUES(granted_by)
+	`
+
+	if _, err := DB.ExecContext(ctx, query, entry.ArticleID, entry.UserID, email, entry.Role, entry.GrantedBy); err != nil {
+		slog.ErrorContext(ctx, "failed to grant article access", "article_id", entry.ArticleID, "error", err)
+		return fmt.Errorf("failed to grant article access: %v", err)
+	}
+
+	slog.InfoContext(ctx, "granted article access", "article_id", entry.ArticleID, "grantee_id", entry.UserID, "role", entry.Role)
+	if entry.UserID == nil {
+		return nil
+	}
+	return recordArticleChanges(ctx, []int{entry.ArticleID}, []int{*entry.UserID})
+}
+
+// GetArticleACL lists who besides the owner can access an article
+func GetArticleACL(ctx context.Context, articleID int) ([]models.ArticleACL, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+
+	query := `
+		SELECT acl.id, acl.article_id, acl.user_id, COALESCE(u.email, acl.email), COALESCE(u.name, ''),
+			acl.role, acl.granted_by, acl.created
+		FROM article_acl acl
+		LEFT JOIN users u ON u.id = acl.user_id
+		WHERE acl.article_id = ?
+		ORDER BY acl.created, acl.id
+	`
+
+	rows, err := DB.QueryContext(ctx, query, articleID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to execute query", "error", err)
+		return nil, fmt.Errorf("failed to execute query: %v", err)
+	}
+	defer rows.Close()
+
+	entries := []models.ArticleACL{}
+	for rows.Next() {
+		var entry models.ArticleACL
+		var email sql.NullString
+		err := rows.Scan(
+			&entry.ID,
+			&entry.ArticleID,
+			&entry.UserID,
+			&email,
+			&entry.Name,
+			&entry.Role,
+			&entry.GrantedBy,
+			&entry.Created,
+		)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to scan row", "error", err)
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		entry.Email = email.String
+		entry.Pending = entry.UserID == nil
+		entries = append(entries, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		slog.ErrorContext(ctx, "failed to iterate rows", "error", err)
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+	return entries, nil
+}
+
+// GetArticleACLEntry retrieves one access entry of an article
+func GetArticleACLEntry(ctx context.Context, articleID, entryID int) (*models.ArticleACL, error) {
+	entries, err := GetArticleACL(ctx, articleID)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.ID == entryID {
+			return &entry, nil
+		}
+	}
+	return nil, fmt.Errorf("access entry %d not found", entryID)
+}
+
+// RevokeArticleAccess removes an access entry of an article
+func RevokeArticleAccess(ctx context.Context, articleID, entryID int) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	// The grantee's feed learns whether they still have access through the workspace
+	var granteeID sql.NullInt64
+	err := DB.QueryRowContext(ctx,
+		`SELECT user_id FROM article_acl WHERE id = ? AND article_id = ?`, entryID, articleID,
+	).Scan(&granteeID)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to get access entry: %v", err)
+	}
+
+	result, err := DB.ExecContext(ctx, `DELETE FROM article_acl WHERE id = ? AND article_id = ?`, entryID, articleID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to revoke article access", "article_id", articleID, "error", err)
+		return fmt.Errorf("failed to revoke article access: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("access entry %d not found", entryID)
+	}
+
+	slog.InfoContext(ctx, "revoked article access", "article_id", articleID, "entry_id", entryID)
+	if !granteeID.Valid {
+		return nil
+	}
+	return recordArticleChanges(ctx, []int{articleID}, []int{int(granteeID.Int64)})
+}
+
+// ClaimArticleInvites turns the pending email invites of a user into grants. Invites for
+// articles the user can already access are dropped.
+func ClaimArticleInvites(ctx context.Context, userID int, email string) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	email = strings.ToLower(email)
+	query := `UPDATE IGNORE article_acl SET user_id = ?, email = NULL WHERE email = ? AND user_id IS NULL`
+	result, err := DB.ExecContext(ctx, query, userID, email)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to claim article invites", "error", err)
+		return fmt.Errorf("failed to claim article invites: %v", err)
+	}
+	if _, err := DB.ExecContext(ctx, `DELETE FROM article_acl WHERE email = ? AND user_id IS NULL`, email); err != nil {
+		slog.ErrorContext(ctx, "failed to remove claimed invites", "error", err)
+		return fmt.Errorf("failed to remove claimed invites: %v", err)
+	}
+
+	if claimed, _ := result.RowsAffected(); claimed > 0 {
+		slog.InfoContext(ctx, "claimed article invites", "user_id", userID, "count", claimed)
+		return reconcileSyncAccess(ctx, userID)
+	}
+	return nil
+}
+
+// GetSharedArticles retrieves the articles of other users that were shared with a user,
+// with the user's role on each
+func GetSharedArticles(ctx context.Context, userID int) ([]models.Article, error) {
+	query := `
+		SELECT ` + articleColumns + `
+		FROM article a
+		WHERE a.id IN (SELECT article_id FROM article_acl WHERE user_id = ?) AND a.user_id <> ? AND a.deleted IS NULL
+		ORDER BY a.updated DESC, a.id DESC
+	`
+
+	articles, err := queryArticles(ctx, userID, 0, query, userID, userID, userID, userID)
+	if articles == nil && err == nil {
+		articles = []models.Article{}
+	}
+	return articles, err
+}
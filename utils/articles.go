@@ -1,277 +1,462 @@
 package utils
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
+	"time"
 
 	"personalnote.eu/simple-go-api/models"
 )
 
-// GetAllArticles retrieves all articles from the database for a specific user (excluding deleted ones)
-func GetAllArticles(userID int) ([]models.Article, error) {
-	if DB == nil {
-		return nil, fmt.Errorf("database connection not initialized")
+// articleColumns are the columns read by scanArticle from article a, including the reading
+// user's role in the article's workspace and their grant on the article. They take the
+// user ID twice.
+const articleColumns = `a.id, a.user_id, COALESCE(a.workspace_id, 0), a.title, a.content, a.content_format, a.version,
+	(SELECT m.role FROM workspace_member m WHERE m.workspace_id = a.workspace_id AND m.user_id = ?),
+	(SELECT acl.role FROM article_acl acl WHERE acl.article_id = a.id AND acl.user_id = ?),
+	a.created, a.updated, a.deleted`
+
+// scanArticle reads a row of articleColumns and sets the user's role on the article. The
+// workspace role only counts inside workspaceID, or anywhere when workspaceID is 0.
+func scanArticle(row rowScanner, userID, workspaceID int) (*models.Article, error) {
+	var article models.Article
+	var memberRole, grant sql.NullString
+	err := row.Scan(
+		&article.ID,
+		&article.UserID,
+		&article.WorkspaceID,
+		&article.Title,
+		&article.Content,
+		&article.ContentFormat,
+		&article.Version,
+		&memberRole,
+		&grant,
+		&article.Created,
+		&article.Updated,
+		&article.Deleted,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if workspaceID != 0 && article.WorkspaceID != workspaceID {
+		memberRole.String = ""
 	}
+	article.Role = articleRole(memberRole.String, grant.String, article.UserID == userID)
+	return &article, nil
+}
 
-	query := `
-		SELECT id, user_id, title, content, created, updated, deleted 
-		FROM article 
-		WHERE deleted IS NULL AND user_id = ?
-		ORDER BY updated DESC, id DESC
-	`
+// articleRole combines a user's role in an article's workspace and their grant on the
+// article into their role on it, or "" when they cannot access it. Workspace owners and
+// admins and the authors among members control an article like its owner.
+func articleRole(memberRole, grant string, author bool) string {
+	switch memberRole {
+	case models.WorkspaceOwner, models.WorkspaceAdmin:
+		return models.RoleOwner
+	case models.WorkspaceMember:
+		if author {
+			return models.RoleOwner
+		}
+		return models.RoleEditor
+	case models.WorkspaceGuest:
+		if grant == "" {
+			return models.RoleViewer
+		}
+	}
+	return grant
+}
+
+// queryArticles runs a query selecting articleColumns and collects the articles
+func queryArticles(ctx context.Context, userID, workspaceID int, query string, args ...interface{}) ([]models.Article, error) {
+	var articles []models.Article
+	err := eachArticleRow(ctx, userID, workspaceID, func(article *models.Article) error {
+		articles = append(articles, *article)
+		return nil
+	}, query, args...)
+	return articles, err
+}
+
+func eachArticleRow(ctx context.Context, userID, workspaceID int, fn func(article *models.Article) error, query string, args ...interface{}) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
 
-	rows, err := DB.Query(query, userID)
+	rows, err := conn(ctx).QueryContext(ctx, query, args...)
 	if err != nil {
-		log.Printf("Error executing query: %v", err)
-		return nil, fmt.Errorf("failed to execute query: %v", err)
+		slog.ErrorContext(ctx, "failed to execute query", "error", err)
+		return fmt.Errorf("failed to execute query: %v", err)
 	}
 	defer rows.Close()
 
-	var articles []models.Article
-
 	for rows.Next() {
-		var article models.Article
-		err := rows.Scan(
-			&article.ID,
-			&article.UserID,
-			&article.Title,
-			&article.Content,
-			&article.Created,
-			&article.Updated,
-			&article.Deleted,
-		)
+		article, err := scanArticle(rows, userID, workspaceID)
 		if err != nil {
-			log.Printf("Error scanning row: %v", err)
-			return nil, fmt.Errorf("failed to scan row: %v", err)
+			slog.ErrorContext(ctx, "failed to scan row", "error", err)
+			return fmt.Errorf("failed to scan row: %v", err)
+		}
+		if err := fn(article); err != nil {
+			return err
 		}
-		articles = append(articles, article)
 	}
 
 	if err = rows.Err(); err != nil {
-		log.Printf("Error iterating rows: %v", err)
-		return nil, fmt.Errorf("error iterating rows: %v", err)
+		slog.ErrorContext(ctx, "failed to iterate rows", "error", err)
+		return fmt.Errorf("error iterating rows: %v", err)
+	}
+	return nil
+}
+
+// inWorkspace restricts article a to a workspace the user is a member of. It takes the
+// workspace ID and the user ID.
+const inWorkspace = `a.workspace_id = ? AND a.workspace_id IN (SELECT workspace_id FROM workspace_member WHERE user_id = ?)`
+
+// GetAllArticles retrieves all articles of a workspace (excluding deleted ones)
+func GetAllArticles(ctx context.Context, workspaceID, userID int) ([]models.Article, error) {
+	var articles []models.Article
+
+	err := EachArticle(ctx, workspaceID, userID, func(article *models.Article) error {
+		articles = append(articles, *article)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	log.Printf("📚 Retrieved %d articles from database", len(articles))
+	slog.DebugContext(ctx, "retrieved articles", "count", len(articles))
 	return articles, nil
 }
 
-// GetArticleByID retrieves a single article by its ID for a specific user
-func GetArticleByID(id int, userID int) (*models.Article, error) {
+// EachArticle calls fn for every article of a workspace (excluding deleted ones) as rows
+// are read, so callers can stream all articles without loading them at once. An error
+// from fn stops the iteration and is returned.
+func EachArticle(ctx context.Context, workspaceID, userID int, fn func(article *models.Article) error) error {
+	query := `
+		SELECT ` + articleColumns + `
+		FROM article a
+		WHERE a.deleted IS NULL AND ` + inWorkspace + `
+		ORDER BY a.updated DESC, a.id DESC
+	`
+	return eachArticleRow(ctx, userID, workspaceID, fn, query, userID, userID, workspaceID, userID)
+}
+
+// GetArticleByID retrieves a single article by its ID with the user's role on it. The user
+// needs to be a member of the article's workspace or have been granted access to it. With
+// a non-zero workspaceID, membership only counts for articles of that workspace.
+func GetArticleByID(ctx context.Context, workspaceID, id, userID int) (*models.Article, error) {
 	if DB == nil {
 		return nil, fmt.Errorf("database connection not initialized")
 	}
 
 	query := `
-		SELECT id, user_id, title, content, created, updated, deleted 
-		FROM article 
-		WHERE id = ? AND user_id = ? AND deleted IS NULL
+		SELECT ` + articleColumns + `
+		FROM article a
+		WHERE a.id = ? AND a.deleted IS NULL
 	`
 
-	var article models.Article
-	err := DB.QueryRow(query, id, userID).Scan(
-		&article.ID,
-		&article.UserID,
-		&article.Title,
-		&article.Content,
-		&article.Created,
-		&article.Updated,
-		&article.Deleted,
-	)
-
+	article, err := scanArticle(conn(ctx).QueryRowContext(ctx, query, userID, userID, id), userID, workspaceID)
+	if err == nil && article.Role == "" {
+		err = sql.ErrNoRows
+	}
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("article with ID %d not found", id)
 		}
-		log.Printf("Error querying article by ID: %v", err)
+		slog.ErrorContext(ctx, "failed to query article by ID", "article_id", id, "error", err)
 		return nil, fmt.Errorf("failed to query article: %v", err)
 	}
 
-	log.Printf("📄 Retrieved article ID %d: %s", article.ID, article.Title)
-	return &article, nil
+	slog.DebugContext(ctx, "retrieved article", "article_id", article.ID)
+	return article, nil
 }
 
-func FindArticlesByTitle(title string) ([]models.Article, error) {
-	if DB == nil {
-		return nil, fmt.Errorf("database connection not initialized")
-	}
-
+// FindArticlesByTitle searches the titles of the articles of a workspace
+func FindArticlesByTitle(ctx context.Context, workspaceID, userID int, title string) ([]models.Article, error) {
 	query := `
-		SELECT id, user_id, title, content, created, updated, deleted 
-		FROM article 
-		WHERE title LIKE ? AND deleted IS NULL
-		ORDER BY updated DESC
+		SELECT ` + articleColumns + `
+		FROM article a
+		WHERE a.title LIKE ? AND a.deleted IS NULL AND ` + inWorkspace + `
+		ORDER BY a.updated DESC
 	`
 
-	rows, err := DB.Query(query, "%"+title+"%")
+	articles, err := queryArticles(ctx, userID, workspaceID, query, userID, userID, "%"+title+"%", workspaceID, userID)
 	if err != nil {
-		log.Printf("Error executing query: %v", err)
-		return nil, fmt.Errorf("failed to execute query: %v", err)
+		return nil, err
 	}
-	defer rows.Close()
 
-	var articles []models.Article
+	slog.DebugContext(ctx, "found articles by title", "count", len(articles), "keyword", title)
+	return articles, nil
+}
 
-	for rows.Next() {
-		var article models.Article
-		err := rows.Scan(
-			&article.ID,
-			&article.UserID,
-			&article.Title,
-			&article.Content,
-			&article.Created,
-			&article.Updated,
-			&article.Deleted,
-		)
-		if err != nil {
-			log.Printf("Error scanning row: %v", err)
-			return nil, fmt.Errorf("failed to scan row: %v", err)
-		}
-		articles = append(articles, article)
-	}
+// FindArticlesByAll searches the titles and content of the articles of a workspace
+func FindArticlesByAll(ctx context.Context, workspaceID, userID int, keyword string) ([]models.Article, error) {
+	query := `
+		SELECT ` + articleColumns + `
+		FROM article a
+		WHERE (a.title LIKE ? OR a.content LIKE ?) AND a.deleted IS NULL AND ` + inWorkspace + `
+		ORDER BY a.updated DESC
+	`
 
-	if err = rows.Err(); err != nil {
-		log.Printf("Error iterating rows: %v", err)
-		return nil, fmt.Errorf("error iterating rows: %v", err)
+	articles, err := queryArticles(ctx, userID, workspaceID, query,
+		userID, userID, "%"+keyword+"%", "%"+keyword+"%", workspaceID, userID)
+	if err != nil {
+		return nil, err
 	}
 
-	log.Printf("🔍 Found %d articles matching title '%s'", len(articles), title)
+	slog.DebugContext(ctx, "found articles by title or content", "count", len(articles), "keyword", keyword)
 	return articles, nil
 }
 
-func FindArticlesByAll(keyword string) ([]models.Article, error) {
+// SetArticleTimestamps overwrites the created and updated times of an article, for
+// example to keep the original times of imported notes. Nil values are left unchanged.
+func SetArticleTimestamps(ctx context.Context, id int, userID int, created, updated *time.Time) error {
 	if DB == nil {
-		return nil, fmt.Errorf("database connection not initialized")
+		return fmt.Errorf("database connection not initialized")
 	}
 
 	query := `
-		SELECT id, user_id, title, content, created, updated, deleted 
-		FROM article 
-		WHERE (title LIKE ? OR content LIKE ?) AND deleted IS NULL
-		ORDER BY updated DESC
+		UPDATE article
+		SET created = COALESCE(?, created), updated = COALESCE(?, updated)
+		WHERE id = ? AND user_id = ?
 	`
 
-	rows, err := DB.Query(query, "%"+keyword+"%", "%"+keyword+"%")
-	if err != nil {
-		log.Printf("Error executing query: %v", err)
-		return nil, fmt.Errorf("failed to execute query: %v", err)
+	if _, err := conn(ctx).ExecContext(ctx, query, created, updated, id, userID); err != nil {
+		slog.ErrorContext(ctx, "failed to set article timestamps", "article_id", id, "error", err)
+		return fmt.Errorf("failed to set article timestamps: %v", err)
 	}
-	defer rows.Close()
+	return nil
+}
 
-	var articles []models.Article
+// UpdateArticle updates an existing article the user may edit, and increments its version.
+// An empty contentFormat keeps the current format.
+func UpdateArticle(ctx context.Context, workspaceID, id, userID int, title, content, contentFormat string) error {
+	return updateArticle(ctx, workspaceID, id, userID, 0, title, content, contentFormat)
+}
 
-	for rows.Next() {
-		var article models.Article
-		err := rows.Scan(
-			&article.ID,
-			&article.UserID,
-			&article.Title,
-			&article.Content,
-			&article.Created,
-			&article.Updated,
-			&article.Deleted,
-		)
+// UpdateArticleAtVersion updates an article like UpdateArticle, but only while it is still
+// at baseVersion. It fails with "changed" when someone else updated it meanwhile.
+func UpdateArticleAtVersion(ctx context.Context, workspaceID, id, userID, baseVersion int, title, content, contentFormat string) error {
+	return updateArticle(ctx, workspaceID, id, userID, baseVersion, title, content, contentFormat)
+}
+
+// updateArticle updates an article at baseVersion, or at any version when it is 0
+func updateArticle(ctx context.Context, workspaceID, id, userID, baseVersion int, title, content, contentFormat string) error {
+	return InTransaction(ctx, func(ctx context.Context) error {
+		article, err := GetArticleByID(ctx, workspaceID, id, userID)
 		if err != nil {
-			log.Printf("Error scanning row: %v", err)
-			return nil, fmt.Errorf("failed to scan row: %v", err)
+			return err
+		}
+		if article.Role == models.RoleViewer {
+			return fmt.Errorf("article with ID %d not found for editing", id)
+		}
+		if baseVersion != 0 && article.Version != baseVersion {
+			return fmt.Errorf("article with ID %d was changed concurrently", id)
 		}
-		articles = append(articles, article)
-	}
 
-	if err = rows.Err(); err != nil {
-		log.Printf("Error iterating rows: %v", err)
-		return nil, fmt.Errorf("error iterating rows: %v", err)
-	}
+		query := `
+			UPDATE article
+			SET title = ?, content = ?, content_format = COALESCE(NULLIF(?, ''), content_format),
+				version = version + 1, updated = NOW()
+			WHERE id = ? AND deleted IS NULL AND (? = 0 OR version = ?)
+		`
 
-	log.Printf("🔍 Found %d articles matching title '%s'", len(articles), keyword)
-	return articles, nil
+		result, err := conn(ctx).ExecContext(ctx, query, title, content, contentFormat, id, baseVersion, baseVersion)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to update article", "article_id", id, "error", err)
+			return fmt.Errorf("failed to update article: %v", err)
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get rows affected: %v", err)
+		}
+
+		if rowsAffected == 0 {
+			if baseVersion != 0 {
+				return fmt.Errorf("article with ID %d was changed concurrently", id)
+			}
+			return fmt.Errorf("article with ID %d not found", id)
+		}
+
+		slog.InfoContext(ctx, "updated article", "article_id", id)
+		return recordArticleChanges(ctx, []int{id}, nil)
+	})
 }
 
-// UpdateArticle updates an existing article (with ownership check)
-func UpdateArticle(id int, userID int, title, content string) error {
+// GetArticleContent returns the content and version of an article without checking who
+// asks; callers must have checked access already
+func GetArticleContent(ctx context.Context, id int) (string, int, error) {
 	if DB == nil {
-		return fmt.Errorf("database connection not initialized")
+		return "", 0, fmt.Errorf("database connection not initialized")
 	}
 
-	query := `
-		UPDATE article 
-		SET title = ?, content = ?, updated = NOW() 
-		WHERE id = ? AND user_id = ? AND deleted IS NULL
-	`
-
-	result, err := DB.Exec(query, title, content, id, userID)
-	if err != nil {
-		log.Printf("Error updating article: %v", err)
-		return fmt.Errorf("failed to update article: %v", err)
+	var content string
+	var version int
+	err := conn(ctx).QueryRowContext(ctx,
+		"SELECT content, version FROM article WHERE id = ? AND deleted IS NULL", id,
+	).Scan(&content, &version)
+	if err == sql.ErrNoRows {
+		return "", 0, fmt.Errorf("article with ID %d not found", id)
 	}
-
-	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %v", err)
+		return "", 0, fmt.Errorf("failed to get article content: %v", err)
 	}
+	return content, version, nil
+}
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("article with ID %d not found", id)
+// SaveArticleContent replaces the content of an article if it is still at version, which
+// then becomes version+1. It fails with "changed" when someone else updated it meanwhile.
+func SaveArticleContent(ctx context.Context, id, version int, content string) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
 	}
 
-	log.Printf("✏️ Updated article ID %d: %s", id, title)
-	return nil
+	return InTransaction(ctx, func(ctx context.Context) error {
+		result, err := conn(ctx).ExecContext(ctx, `
+			UPDATE article SET content = ?, version = version + 1, updated = NOW()
+			WHERE id = ? AND version = ? AND deleted IS NULL
+		`, content, id, version)
+		if err != nil {
+			return fmt.Errorf("failed to save article content: %v", err)
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get rows affected: %v", err)
+		}
+		if rowsAffected == 0 {
+			if _, _, err := GetArticleContent(ctx, id); err != nil {
+				return err
+			}
+			return fmt.Errorf("article with ID %d was changed concurrently", id)
+		}
+
+		slog.DebugContext(ctx, "saved article content", "article_id", id, "version", version+1)
+		return recordArticleChanges(ctx, []int{id}, nil)
+	})
 }
 
-// CreateArticle creates a new article in the database
-func CreateArticle(userID int, title, content string) (int, error) {
+// CreateArticle creates a new article by the user in a workspace
+func CreateArticle(ctx context.Context, workspaceID, userID int, title, content, contentFormat string) (int, error) {
 	if DB == nil {
 		return 0, fmt.Errorf("database connection not initialized")
 	}
 
 	query := `
-		INSERT INTO article (user_id, title, content, created, updated) 
-		VALUES (?, ?, ?, NOW(), NOW())
+		INSERT INTO article (user_id, workspace_id, title, content, content_format, created, updated)
+		VALUES (?, ?, ?, ?, ?, NOW(), NOW())
 	`
 
-	result, err := DB.Exec(query, userID, title, content)
-	if err != nil {
-		log.Printf("Error creating article: %v", err)
-		return 0, fmt.Errorf("failed to create article: %v", err)
-	}
+	var id int64
+	err := InTransaction(ctx, func(ctx context.Context) error {
+		result, err := conn(ctx).ExecContext(ctx, query, userID, workspaceID, title, content, contentFormat)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to create article", "error", err)
+			return fmt.Errorf("failed to create article: %v", err)
+		}
 
-	id, err := result.LastInsertId()
+		id, err = result.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("failed to get last insert ID: %v", err)
+		}
+		return recordArticleChanges(ctx, []int{int(id)}, nil)
+	})
 	if err != nil {
-		return 0, fmt.Errorf("failed to get last insert ID: %v", err)
+		return 0, err
 	}
 
-	log.Printf("✨ Created new article ID %d: %s", id, title)
+	slog.InfoContext(ctx, "created article", "article_id", id)
 	return int(id), nil
 }
 
-// DeleteArticle performs a soft delete on an article by setting the deleted timestamp (with ownership check)
-func DeleteArticle(id int, userID int) error {
+// DeleteArticle performs a soft delete on an article by setting the deleted timestamp.
+// It needs the owner role on the article; editors and viewers cannot delete.
+func DeleteArticle(ctx context.Context, workspaceID, id, userID int) error {
+	return deleteArticle(ctx, workspaceID, id, userID, 0)
+}
+
+// DeleteArticleAtVersion deletes an article like DeleteArticle, but only while it is still
+// at baseVersion. It fails with "changed" when someone else updated it meanwhile.
+func DeleteArticleAtVersion(ctx context.Context, workspaceID, id, userID, baseVersion int) error {
+	return deleteArticle(ctx, workspaceID, id, userID, baseVersion)
+}
+
+// deleteArticle deletes an article at baseVersion, or at any version when it is 0
+func deleteArticle(ctx context.Context, workspaceID, id, userID, baseVersion int) error {
+	return InTransaction(ctx, func(ctx context.Context) error {
+		article, err := GetArticleByID(ctx, workspaceID, id, userID)
+		if err != nil {
+			return err
+		}
+		if article.Role != models.RoleOwner {
+			return fmt.Errorf("article with ID %d not found for deleting", id)
+		}
+		if baseVersion != 0 && article.Version != baseVersion {
+			return fmt.Errorf("article with ID %d was changed concurrently", id)
+		}
+
+		query := `
+			UPDATE article 
+			SET deleted = NOW() 
+			WHERE id = ? AND deleted IS NULL AND (? = 0 OR version = ?)
+		`
+
+		result, err := conn(ctx).ExecContext(ctx, query, id, baseVersion, baseVersion)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to delete article", "article_id", id, "error", err)
+			return fmt.Errorf("failed to delete article: %v", err)
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get rows affected: %v", err)
+		}
+
+		if rowsAffected == 0 {
+			if baseVersion != 0 {
+				return fmt.Errorf("article with ID %d was changed concurrently", id)
+			}
+			return fmt.Errorf("article with ID %d not found or already deleted", id)
+		}
+
+		slog.InfoContext(ctx, "soft deleted article", "article_id", id)
+		return recordArticleChanges(ctx, []int{id}, nil)
+	})
+}
+
+// GetArticleAudience lists the users who can access an article: the members of its
+// workspace and the users it was shared with
+func GetArticleAudience(ctx context.Context, id int) ([]int, error) {
 	if DB == nil {
-		return fmt.Errorf("database connection not initialized")
+		return nil, fmt.Errorf("database connection not initialized")
 	}
 
 	query := `
-		UPDATE article 
-		SET deleted = NOW() 
-		WHERE id = ? AND user_id = ? AND deleted IS NULL
+		SELECT m.user_id FROM workspace_member m JOIN article a ON a.workspace_id = m.workspace_id WHERE a.id = ?
+		UNION
+		SELECT acl.user_id FROM article_acl acl WHERE acl.article_id = ? AND acl.user_id IS NOT NULL
 	`
 
-	result, err := DB.Exec(query, id, userID)
+	rows, err := conn(ctx).QueryContext(ctx, query, id, id)
 	if err != nil {
-		log.Printf("Error deleting article: %v", err)
-		return fmt.Errorf("failed to delete article: %v", err)
+		slog.ErrorContext(ctx, "failed to execute query", "error", err)
+		return nil, fmt.Errorf("failed to execute query: %v", err)
 	}
+	defer rows.Close()
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %v", err)
+	var users []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			slog.ErrorContext(ctx, "failed to scan row", "error", err)
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		users = append(users, userID)
 	}
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("article with ID %d not found or already deleted", id)
+	if err = rows.Err(); err != nil {
+		slog.ErrorContext(ctx, "failed to iterate rows", "error", err)
+		return nil, fmt.Errorf("error iterating rows: %v", err)
 	}
-
-	log.Printf("🗑️ Soft deleted article ID %d", id)
-	return nil
+	return users, nil
 }
@@ -0,0 +1,342 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	"personalnote.eu/simple-go-api/models"
+)
+
+const attachmentColumns = `id, user_id, article_id, backend, remote_id, name, mime_type, declared_mime_type, detected_mime_type, size, sha256, created, scan_status, scan_signature, thumbnail_status`
+
+// CreateAttachment records an uploaded file and adds it to the owner's usage. The owner's
+// usage row stays locked until the record is written, so concurrent uploads of one user are
+// recorded one at a time: allow sees the usage at that point and may refuse the file, and
+// when the user already has a file with the same content in the backend, nothing is recorded
+// and that attachment is returned as the duplicate.
+func CreateAttachment(ctx context.Context, attachment *models.Attachment, allow func(usage *models.Usage) error) (id int, duplicate *models.Attachment, err error) {
+	if DB == nil {
+		return 0, nil, fmt.Errorf("database connection not initialized")
+	}
+
+	err = InTransaction(ctx, func(ctx context.Context) error {
+		if _, err := conn(ctx).ExecContext(ctx,
+			`INSERT IGNORE INTO user_usage (user_id, bytes, files) VALUES (?, 0, 0)`, attachment.UserID); err != nil {
+			return fmt.Errorf("failed to create usage: %v", err)
+		}
+		var usage models.Usage
+		err := conn(ctx).QueryRowContext(ctx,
+			`SELECT bytes, files FROM user_usage WHERE user_id = ? FOR UPDATE`, attachment.UserID,
+		).Scan(&usage.Bytes, &usage.Files)
+		if err != nil {
+			return fmt.Errorf("failed to lock usage: %v", err)
+		}
+
+		// Another upload of the same content may have been recorded since it was looked for
+		query := `SELECT ` + attachmentColumns + ` FROM attachment WHERE user_id = ? AND sha256 = ? AND backend = ? ORDER BY id LIMIT 1 FOR UPDATE`
+		existing, err := scanAttachment(conn(ctx).QueryRowContext(ctx, query, attachment.UserID, attachment.SHA256, attachment.Backend))
+		if err == nil {
+			duplicate = existing
+			return nil
+		}
+		if err != sql.ErrNoRows {
+			return fmt.Errorf("failed to query attachment: %v", err)
+		}
+
+		if allow != nil {
+			if err := allow(&usage); err != nil {
+				return err
+			}
+		}
+
+		query = `
+			INSERT INTO attachment (user_id, article_id, backend, remote_id, name, mime_type, declared_mime_type, detected_mime_type,
+				size, sha256, scan_status, scan_signature, thumbnail_status, created)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NOW())
+		`
+		result, err := conn(ctx).ExecContext(ctx, query,
+			attachment.UserID,
+			attachment.ArticleID,
+			attachment.Backend,
+			attachment.RemoteID,
+			attachment.Name,
+			attachment.MimeType,
+			attachment.DeclaredMimeType,
+			attachment.DetectedMimeType,
+			attachment.Size,
+			attachment.SHA256,
+			attachment.ScanStatus,
+			attachment.ScanSignature,
+			attachment.ThumbnailStatus,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create attachment: %v", err)
+		}
+		lastID, err := result.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("failed to get last insert ID: %v", err)
+		}
+		id = int(lastID)
+
+		if _, err := conn(ctx).ExecContext(ctx,
+			`UPDATE user_usage SET bytes = bytes + ?, files = files + 1 WHERE user_id = ?`, attachment.Size, attachment.UserID); err != nil {
+			return fmt.Errorf("failed to update usage: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if duplicate != nil {
+		slog.InfoContext(ctx, "attachment already recorded", "attachment_id", duplicate.ID, "backend", attachment.Backend)
+		return 0, duplicate, nil
+	}
+	slog.InfoContext(ctx, "created attachment", "attachment_id", id, "backend", attachment.Backend)
+	return id, nil, nil
+}
+
+// GetAttachmentByID retrieves an attachment owned by the user
+func GetAttachmentByID(ctx context.Context, id int, userID int) (*models.Attachment, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+
+	query := `SELECT ` + attachmentColumns + ` FROM attachment WHERE id = ? AND user_id = ?`
+
+	attachment, err := scanAttachment(DB.QueryRowContext(ctx, query, id, userID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("attachment with ID %d not found", id)
+		}
+		slog.ErrorContext(ctx, "failed to query attachment", "attachment_id", id, "error", err)
+		return nil, fmt.Errorf("failed to query attachment: %v", err)
+	}
+
+	return attachment, nil
+}
+
+// GetAttachmentByRemoteID retrieves an attachment owned by the user by its storage backend ID
+func GetAttachmentByRemoteID(ctx context.Context, remoteID string, userID int) (*models.Attachment, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+
+	query := `SELECT ` + attachmentColumns + ` FROM attachment WHERE remote_id = ? AND user_id = ?`
+
+	attachment, err := scanAttachment(DB.QueryRowContext(ctx, query, remoteID, userID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("attachment with remote ID %s not found", remoteID)
+		}
+		slog.ErrorContext(ctx, "failed to query attachment", "file_id", remoteID, "error", err)
+		return nil, fmt.Errorf("failed to query attachment: %v", err)
+	}
+
+	return attachment, nil
+}
+
+// GetAttachmentByBackendID retrieves an attachment of any user by its storage backend ID,
+// for requests authorized by a signed URL rather than a user
+func GetAttachmentByBackendID(ctx context.Context, backend, remoteID string) (*models.Attachment, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+
+	query := `SELECT ` + attachmentColumns + ` FROM attachment WHERE backend = ? AND remote_id = ? ORDER BY id LIMIT 1`
+
+	attachment, err := scanAttachment(DB.QueryRowContext(ctx, query, backend, remoteID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("attachment with remote ID %s not found", remoteID)
+		}
+		slog.ErrorContext(ctx, "failed to query attachment", "file_id", remoteID, "error", err)
+		return nil, fmt.Errorf("failed to query attachment: %v", err)
+	}
+
+	return attachment, nil
+}
+
+// GetAttachmentBySHA256 retrieves the user's attachment with identical content in the given backend
+func GetAttachmentBySHA256(ctx context.Context, userID int, backend, sha256 string) (*models.Attachment, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+
+	query := `SELECT ` + attachmentColumns + ` FROM attachment WHERE user_id = ? AND sha256 = ? AND backend = ? ORDER BY id LIMIT 1`
+
+	attachment, err := scanAttachment(DB.QueryRowContext(ctx, query, userID, sha256, backend))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("attachment with SHA-256 %s not found", sha256)
+		}
+		slog.ErrorContext(ctx, "failed to query attachment", "sha256", sha256, "error", err)
+		return nil, fmt.Errorf("failed to query attachment: %v", err)
+	}
+
+	return attachment, nil
+}
+
+// LinkAttachmentToArticle attaches an unlinked attachment to one of the user's articles
+func LinkAttachmentToArticle(ctx context.Context, id int, userID int, articleID int) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	query := `UPDATE attachment SET article_id = ? WHERE id = ? AND user_id = ? AND article_id IS NULL`
+	if _, err := DB.ExecContext(ctx, query, articleID, id, userID); err != nil {
+		slog.ErrorContext(ctx, "failed to link attachment", "attachment_id", id, "article_id", articleID, "error", err)
+		return fmt.Errorf("failed to link attachment: %v", err)
+	}
+	return nil
+}
+
+// GetAttachmentsByScanStatus retrieves attachments of all users with the given malware scan status, oldest first
+func GetAttachmentsByScanStatus(ctx context.Context, status string, limit int) ([]models.Attachment, error) {
+	query := `SELECT ` + attachmentColumns + ` FROM attachment WHERE scan_status = ? ORDER BY id LIMIT ?`
+	return queryAttachments(ctx, query, status, limit)
+}
+
+// UpdateScanStatus records the malware scan result of an attachment
+func UpdateScanStatus(ctx context.Context, id int, status, signature string) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	query := `UPDATE attachment SET scan_status = ?, scan_signature = ? WHERE id = ?`
+	if _, err := DB.ExecContext(ctx, query, status, signature, id); err != nil {
+		slog.ErrorContext(ctx, "failed to update scan status", "attachment_id", id, "error", err)
+		return fmt.Errorf("failed to update scan status: %v", err)
+	}
+	return nil
+}
+
+// GetUsage returns the bytes and number of files the user has stored
+func GetUsage(ctx context.Context, userID int) (*models.Usage, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+
+	var usage models.Usage
+	err := DB.QueryRowContext(ctx, `SELECT bytes, files FROM user_usage WHERE user_id = ?`, userID).Scan(&usage.Bytes, &usage.Files)
+	if err != nil && err != sql.ErrNoRows {
+		slog.ErrorContext(ctx, "failed to query usage", "error", err)
+		return nil, fmt.Errorf("failed to query usage: %v", err)
+	}
+
+	return &usage, nil
+}
+
+// GetAttachmentsByUser retrieves all attachments owned by the user, newest first
+func GetAttachmentsByUser(ctx context.Context, userID int) ([]models.Attachment, error) {
+	query := `SELECT ` + attachmentColumns + ` FROM attachment WHERE user_id = ? ORDER BY created DESC, id DESC`
+	return queryAttachments(ctx, query, userID)
+}
+
+// GetAttachmentsByArticle retrieves the attachments linked to an article owned by the user
+func GetAttachmentsByArticle(ctx context.Context, articleID int, userID int) ([]models.Attachment, error) {
+	query := `SELECT ` + attachmentColumns + ` FROM attachment WHERE article_id = ? AND user_id = ? ORDER BY created DESC, id DESC`
+	return queryAttachments(ctx, query, articleID, userID)
+}
+
+// DeleteAttachment removes the attachment record (with ownership check) and subtracts it from the owner's usage
+func DeleteAttachment(ctx context.Context, id int, userID int) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var size int64
+	err = tx.QueryRowContext(ctx, `SELECT size FROM attachment WHERE id = ? AND user_id = ? FOR UPDATE`, id, userID).Scan(&size)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("attachment with ID %d not found", id)
+	} else if err != nil {
+		slog.ErrorContext(ctx, "failed to query attachment", "attachment_id", id, "error", err)
+		return fmt.Errorf("failed to query attachment: %v", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM attachment WHERE id = ? AND user_id = ?`, id, userID); err != nil {
+		slog.ErrorContext(ctx, "failed to delete attachment", "attachment_id", id, "error", err)
+		return fmt.Errorf("failed to delete attachment: %v", err)
+	}
+
+	usageQuery := `UPDATE user_usage SET bytes = GREATEST(bytes - ?, 0), files = GREATEST(files - 1, 0) WHERE user_id = ?`
+	if _, err := tx.ExecContext(ctx, usageQuery, size, userID); err != nil {
+		slog.ErrorContext(ctx, "failed to update usage", "error", err)
+		return fmt.Errorf("failed to update usage: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit attachment deletion: %v", err)
+	}
+
+	slog.InfoContext(ctx, "deleted attachment", "attachment_id", id)
+	return nil
+}
+
+func queryAttachments(ctx context.Context, query string, args ...interface{}) ([]models.Attachment, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+
+	rows, err := DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to execute query", "error", err)
+		return nil, fmt.Errorf("failed to execute query: %v", err)
+	}
+	defer rows.Close()
+
+	attachments := []models.Attachment{}
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to scan row", "error", err)
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		attachments = append(attachments, *attachment)
+	}
+
+	if err = rows.Err(); err != nil {
+		slog.ErrorContext(ctx, "failed to iterate rows", "error", err)
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	return attachments, nil
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAttachment(row rowScanner) (*models.Attachment, error) {
+	var attachment models.Attachment
+	err := row.Scan(
+		&attachment.ID,
+		&attachment.UserID,
+		&attachment.ArticleID,
+		&attachment.Backend,
+		&attachment.RemoteID,
+		&attachment.Name,
+		&attachment.MimeType,
+		&attachment.DeclaredMimeType,
+		&attachment.DetectedMimeType,
+		&attachment.Size,
+		&attachment.SHA256,
+		&attachment.Created,
+		&attachment.ScanStatus,
+		&attachment.ScanSignature,
+		&attachment.ThumbnailStatus,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+var (
+	backgroundCtx, stopBackground = context.WithCancel(context.Background())
+	backgroundWG                  sync.WaitGroup
+)
+
+// Go runs fn in a background goroutine that is stopped by StopBackground.
+// fn must return once its context is cancelled.
+func Go(name string, fn func(ctx context.Context)) {
+	backgroundWG.Add(1)
+	go func() {
+		defer backgroundWG.Done()
+		slog.Debug("background worker started", "worker", name)
+		fn(backgroundCtx)
+		slog.Debug("background worker stopped", "worker", name)
+	}()
+}
+
+// StopBackground cancels all background workers and waits for them to return
+// or for ctx to expire, whichever comes first
+func StopBackground(ctx context.Context) error {
+	stopBackground()
+
+	done := make(chan struct{})
+	go func() {
+		backgroundWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
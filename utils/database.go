@@ -1,27 +1,22 @@
 package utils
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
 
 	_ "github.com/go-sql-driver/mysql"
+	"personalnote.eu/simple-go-api/config"
 )
 
 var DB *sql.DB
 
 // InitDB initializes the database connection
-func InitDB() error {
-	host := getEnv("DB_HOST", "localhost")
-	port := getEnv("DB_PORT", "3306")
-	dbname := getEnv("DB_NAME", "simple_go_api")
-	user := getEnv("DB_USER", "api_user")
-	password := getEnv("DB_PASSWORD", "api_password")
-
+func InitDB(cfg config.DatabaseConfig) error {
 	// Create connection string
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-		user, password, host, port, dbname)
+		cfg.User, cfg.Password.Value(), cfg.Host, cfg.Port, cfg.Name)
 
 	var err error
 	DB, err = sql.Open("mysql", dsn)
@@ -34,7 +29,7 @@ func InitDB() error {
 		return fmt.Errorf("failed to connect to database: %v", err)
 	}
 
-	log.Printf("✅ Successfully connected to MySQL database: %s", dbname)
+	slog.Info("connected to MySQL database", "database", cfg.Name, "host", cfg.Host)
 
 	// Create tables if they don't exist
 	if err := createTables(); err != nil {
@@ -50,6 +45,7 @@ func createTables() error {
 		id INT AUTO_INCREMENT PRIMARY KEY,
 		google_id VARCHAR(255) UNIQUE NOT NULL,
 		email VARCHAR(255) NOT NULL,
+		email_verified BOOLEAN NOT NULL DEFAULT FALSE,
 		name VARCHAR(255),
 		picture TEXT,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
@@ -75,7 +71,381 @@ func createTables() error {
 		return fmt.Errorf("failed to create article table: %v", err)
 	}
 
-	log.Println("✅ Database tables checked/created successfully")
+	attachmentTableQuery := `CREATE TABLE IF NOT EXISTS attachment (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		user_id INT NOT NULL,
+		article_id INT DEFAULT NULL,
+		backend VARCHAR(32) NOT NULL,
+		remote_id VARCHAR(255) NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		mime_type VARCHAR(255) NOT NULL DEFAULT '',
+		size BIGINT NOT NULL DEFAULT 0,
+		sha256 CHAR(64) NOT NULL,
+		created DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE KEY uq_attachment_remote (backend, remote_id),
+		INDEX idx_attachment_user (user_id),
+		FOREIGN KEY (user_id) REFERENCES users(id),
+		FOREIGN KEY (article_id) REFERENCES article(id)
+	);`
+
+	if _, err := DB.Exec(attachmentTableQuery); err != nil {
+		return fmt.Errorf("failed to create attachment table: %v", err)
+	}
+
+	uploadTableQuery := `CREATE TABLE IF NOT EXISTS upload (
+		id CHAR(32) PRIMARY KEY,
+		user_id INT NOT NULL,
+		article_id INT DEFAULT NULL,
+		name VARCHAR(255) NOT NULL,
+		mime_type VARCHAR(255) NOT NULL DEFAULT '',
+		metadata TEXT,
+		upload_length BIGINT NOT NULL,
+		upload_offset BIGINT NOT NULL DEFAULT 0,
+		status VARCHAR(16) NOT NULL DEFAULT 'uploading',
+		attachment_id INT DEFAULT NULL,
+		error_message TEXT,
+		created DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		INDEX idx_upload_user (user_id),
+		INDEX idx_upload_status (status, updated),
+		FOREIGN KEY (user_id) REFERENCES users(id),
+		FOREIGN KEY (article_id) REFERENCES article(id)
+	);`
+
+	if _, err := DB.Exec(uploadTableQuery); err != nil {
+		return fmt.Errorf("failed to create upload table: %v", err)
+	}
+
+	importJobTableQuery := `CREATE TABLE IF NOT EXISTS import_job (
+		id CHAR(32) PRIMARY KEY,
+		user_id INT NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		status VARCHAR(16) NOT NULL DEFAULT 'queued',
+		total INT NOT NULL DEFAULT 0,
+		processed INT NOT NULL DEFAULT 0,
+		imported INT NOT NULL DEFAULT 0,
+		failed INT NOT NULL DEFAULT 0,
+		errors MEDIUMTEXT,
+		error_message TEXT,
+		created DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		INDEX idx_import_job_user (user_id),
+		INDEX idx_import_job_status (status),
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);`
+
+	if _, err := DB.Exec(importJobTableQuery); err != nil {
+		return fmt.Errorf("failed to create import_job table: %v", err)
+	}
+
+	articleShareTableQuery := `CREATE TABLE IF NOT EXISTS article_share (
+		article_id INT PRIMARY KEY,
+		user_id INT NOT NULL,
+		token_hash CHAR(64) NOT NULL,
+		password_hash VARCHAR(255) DEFAULT NULL,
+		expires DATETIME DEFAULT NULL,
+		views INT NOT NULL DEFAULT 0,
+		last_viewed DATETIME DEFAULT NULL,
+		created DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE KEY idx_article_share_token (token_hash),
+		FOREIGN KEY (article_id) REFERENCES article(id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);`
+
+	if _, err := DB.Exec(articleShareTableQuery); err != nil {
+		return fmt.Errorf("failed to create article_share table: %v", err)
+	}
+
+	articleACLTableQuery := `CREATE TABLE IF NOT EXISTS article_acl (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		article_id INT NOT NULL,
+		user_id INT DEFAULT NULL,
+		email VARCHAR(255) DEFAULT NULL,
+		role VARCHAR(16) NOT NULL,
+		granted_by INT NOT NULL,
+		created DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE KEY idx_article_acl_user (article_id, user_id),
+		UNIQUE KEY idx_article_acl_email (article_id, email),
+		INDEX idx_article_acl_grantee (user_id),
+		INDEX idx_article_acl_invite (email),
+		FOREIGN KEY (article_id) REFERENCES article(id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users(id),
+		FOREIGN KEY (granted_by) REFERENCES users(id)
+	);`
+
+	if _, err := DB.Exec(articleACLTableQuery); err != nil {
+		return fmt.Errorf("failed to create article_acl table: %v", err)
+	}
+
+	workspaceTableQuery := `CREATE TABLE IF NOT EXISTS workspace (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		personal_user_id INT DEFAULT NULL,
+		created DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE KEY idx_workspace_personal (personal_user_id),
+		FOREIGN KEY (personal_user_id) REFERENCES users(id)
+	);`
+
+	if _, err := DB.Exec(workspaceTableQuery); err != nil {
+		return fmt.Errorf("failed to create workspace table: %v", err)
+	}
+
+	workspaceMemberTableQuery := `CREATE TABLE IF NOT EXISTS workspace_member (
+		workspace_id INT NOT NULL,
+		user_id INT NOT NULL,
+		role VARCHAR(16) NOT NULL,
+		joined DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (workspace_id, user_id),
+		INDEX idx_workspace_member_user (user_id),
+		FOREIGN KEY (workspace_id) REFERENCES workspace(id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);`
+
+	if _, err := DB.Exec(workspaceMemberTableQuery); err != nil {
+		return fmt.Errorf("failed to create workspace_member table: %v", err)
+	}
+
+	workspaceInviteTableQuery := `CREATE TABLE IF NOT EXISTS workspace_invite (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		workspace_id INT NOT NULL,
+		email VARCHAR(255) NOT NULL,
+		role VARCHAR(16) NOT NULL,
+		invited_by INT NOT NULL,
+		created DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE KEY idx_workspace_invite_email (workspace_id, email),
+		INDEX idx_workspace_invite_lookup (email),
+		FOREIGN KEY (workspace_id) REFERENCES workspace(id) ON DELETE CASCADE,
+		FOREIGN KEY (invited_by) REFERENCES users(id)
+	);`
+
+	if _, err := DB.Exec(workspaceInviteTableQuery); err != nil {
+		return fmt.Errorf("failed to create workspace_invite table: %v", err)
+	}
+
+	syncSequenceTableQuery := `CREATE TABLE IF NOT EXISTS sync_sequence (
+		user_id INT PRIMARY KEY,
+		seq BIGINT NOT NULL DEFAULT 0,
+		created DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	);`
+
+	if _, err := DB.Exec(syncSequenceTableQuery); err != nil {
+		return fmt.Errorf("failed to create sync_sequence table: %v", err)
+	}
+
+	syncChangeTableQuery := `CREATE TABLE IF NOT EXISTS sync_change (
+		user_id INT NOT NULL,
+		article_id INT NOT NULL,
+		seq BIGINT NOT NULL,
+		created_seq BIGINT NOT NULL,
+		removed BOOLEAN NOT NULL DEFAULT FALSE,
+		PRIMARY KEY (user_id, article_id),
+		INDEX idx_sync_change_seq (user_id, seq),
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+		FOREIGN KEY (article_id) REFERENCES article(id) ON DELETE CASCADE
+	);`
+
+	if _, err := DB.Exec(syncChangeTableQuery); err != nil {
+		return fmt.Errorf("failed to create sync_change table: %v", err)
+	}
+
+	syncClientArticleTableQuery := `CREATE TABLE IF NOT EXISTS sync_client_article (
+		user_id INT NOT NULL,
+		client_id VARCHAR(64) NOT NULL,
+		article_id INT NULL,
+		created DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (user_id, client_id),
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+		FOREIGN KEY (article_id) REFERENCES article(id) ON DELETE CASCADE
+	);`
+
+	if _, err := DB.Exec(syncClientArticleTableQuery); err != nil {
+		return fmt.Errorf("failed to create sync_client_article table: %v", err)
+	}
+
+	driveConnectionTableQuery := `CREATE TABLE IF NOT EXISTS drive_connection (
+		user_id INT PRIMARY KEY,
+		refresh_token TEXT NOT NULL,
+		folder_id VARCHAR(255) NOT NULL,
+		email VARCHAR(255) NOT NULL DEFAULT '',
+		created DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);`
+
+	if _, err := DB.Exec(driveConnectionTableQuery); err != nil {
+		return fmt.Errorf("failed to create drive_connection table: %v", err)
+	}
+
+	usageTableQuery := `CREATE TABLE IF NOT EXISTS user_usage (
+		user_id INT PRIMARY KEY,
+		bytes BIGINT NOT NULL DEFAULT 0,
+		files INT NOT NULL DEFAULT 0,
+		updated DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);`
+
+	if _, err := DB.Exec(usageTableQuery); err != nil {
+		return fmt.Errorf("failed to create user_usage table: %v", err)
+	}
+
+	// Backfill usage for users whose files predate usage tracking
+	backfillUsageQuery := `INSERT IGNORE INTO user_usage (user_id, bytes, files)
+		SELECT user_id, SUM(size), COUNT(*) FROM attachment GROUP BY user_id`
+	if _, err := DB.Exec(backfillUsageQuery); err != nil {
+		return fmt.Errorf("failed to backfill user_usage table: %v", err)
+	}
+
+	if err := ensureIndex("attachment", "idx_attachment_sha256", "(user_id, sha256)"); err != nil {
+		return err
+	}
+
+	if err := ensureColumn("users", "email_verified", "BOOLEAN NOT NULL DEFAULT FALSE"); err != nil {
+		return err
+	}
+	if err := ensureColumn("article", "content_format", "VARCHAR(16) NOT NULL DEFAULT 'plain'"); err != nil {
+		return err
+	}
+	if err := ensureColumn("article", "version", "INT NOT NULL DEFAULT 1"); err != nil {
+		return err
+	}
+	if err := ensureColumn("article", "workspace_id", "INT DEFAULT NULL"); err != nil {
+		return err
+	}
+	if err := ensureIndex("article", "idx_article_workspace", "(workspace_id, deleted)"); err != nil {
+		return err
+	}
+	// Notes that predate workspaces move to their author's personal workspace
+	if err := migratePersonalWorkspaces(); err != nil {
+		return err
+	}
+
+	if err := ensureColumn("attachment", "thumbnail_status", "VARCHAR(16) DEFAULT NULL"); err != nil {
+		return err
+	}
+	if err := ensureColumn("attachment", "declared_mime_type", "VARCHAR(255) NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureColumn("attachment", "detected_mime_type", "VARCHAR(255) NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	// Files stored before scanning was introduced count as clean
+	if err := ensureColumn("attachment", "scan_status", "VARCHAR(16) NOT NULL DEFAULT 'clean'"); err != nil {
+		return err
+	}
+	if err := ensureColumn("attachment", "scan_signature", "VARCHAR(255) NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureIndex("attachment", "idx_attachment_scan_status", "(scan_status)"); err != nil {
+		return err
+	}
+
+	thumbnailTableQuery := `CREATE TABLE IF NOT EXISTS thumbnail (
+		attachment_id INT NOT NULL,
+		size INT NOT NULL,
+		backend VARCHAR(32) NOT NULL,
+		remote_id VARCHAR(255) NOT NULL,
+		mime_type VARCHAR(255) NOT NULL,
+		width INT NOT NULL,
+		height INT NOT NULL,
+		bytes BIGINT NOT NULL,
+		created DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (attachment_id, size),
+		FOREIGN KEY (attachment_id) REFERENCES attachment(id) ON DELETE CASCADE
+	);`
+
+	if _, err := DB.Exec(thumbnailTableQuery); err != nil {
+		return fmt.Errorf("failed to create thumbnail table: %v", err)
+	}
+
+	slog.Info("database tables checked/created")
+	return nil
+}
+
+// ensureIndex adds an index to an existing table unless it is already there
+func ensureIndex(table, name, columns string) error {
+	query := `
+		SELECT COUNT(*)
+		FROM information_schema.statistics
+		WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?
+	`
+
+	var count int
+	if err := DB.QueryRow(query, table, name).Scan(&count); err != nil {
+		return fmt.Errorf("failed to check index %s: %v", name, err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	if _, err := DB.Exec(fmt.Sprintf("CREATE INDEX %s ON %s %s", name, table, columns)); err != nil {
+		return fmt.Errorf("failed to create index %s: %v", name, err)
+	}
+	slog.Info("created index", "table", table, "index", name)
+	return nil
+}
+
+// ensureColumn adds a column to an existing table unless it is already there
+func ensureColumn(table, name, definition string) error {
+	query := `
+		SELECT COUNT(*)
+		FROM information_schema.columns
+		WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?
+	`
+
+	var count int
+	if err := DB.QueryRow(query, table, name).Scan(&count); err != nil {
+		return fmt.Errorf("failed to check column %s.%s: %v", table, name, err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	if _, err := DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, name, definition)); err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %v", table, name, err)
+	}
+	slog.Info("added column", "table", table, "column", name)
+	return nil
+}
+
+// requiredTables lists the tables the application cannot work without
+var requiredTables = []string{"users", "article", "attachment", "upload", "drive_connection", "user_usage", "thumbnail", "import_job", "article_share", "article_acl", "workspace", "workspace_member", "workspace_invite", "sync_sequence", "sync_change", "sync_client_article"}
+
+// PingDB verifies the database connection is alive
+func PingDB(ctx context.Context) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	return DB.PingContext(ctx)
+}
+
+// CheckTables verifies that all required tables exist in the current schema
+func CheckTables(ctx context.Context) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	query := `
+		SELECT COUNT(*)
+		FROM information_schema.tables
+		WHERE table_schema = DATABASE() AND table_name = ?
+	`
+
+	var missing []string
+	for _, table := range requiredTables {
+		var count int
+		if err := DB.QueryRowContext(ctx, query, table).Scan(&count); err != nil {
+			return fmt.Errorf("failed to check table %s: %v", table, err)
+		}
+		if count == 0 {
+			missing = append(missing, table)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("missing tables: %v", missing)
+	}
 	return nil
 }
 
@@ -83,14 +453,6 @@ func createTables() error {
 func CloseDB() {
 	if DB != nil {
 		DB.Close()
-		log.Println("📥 Database connection closed")
-	}
-}
-
-// getEnv gets environment variable with fallback
-func getEnv(key, fallback string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+		slog.Info("database connection closed")
 	}
-	return fallback
 }
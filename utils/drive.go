@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	"personalnote.eu/simple-go-api/models"
+)
+
+// SaveDriveConnection creates or replaces the user's Drive connection.
+// The refresh token must already be encrypted.
+func SaveDriveConnection(ctx context.Context, conn *models.DriveConnection) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	query := `
+		INSERT INTO drive_connection (user_id, refresh_token, folder_id, email, created, updated)
+		VALUES (?, ?, ?, ?, NOW(), NOW())
+		ON DUPLICATE KEY UPDATE refresh_token = VALUES(refresh_token), folder_id = VALUES(folder_id),
+			email = VALUES(email), updated = NOW()
+	`
+
+	if _, err := DB.ExecContext(ctx, query, conn.UserID, conn.RefreshToken, conn.FolderID, conn.Email); err != nil {
+		slog.ErrorContext(ctx, "failed to save drive connection", "error", err)
+		return fmt.Errorf("failed to save drive connection: %v", err)
+	}
+
+	slog.InfoContext(ctx, "saved drive connection", "folder_id", conn.FolderID)
+	return nil
+}
+
+// GetDriveConnection retrieves the user's Drive connection
+func GetDriveConnection(ctx context.Context, userID int) (*models.DriveConnection, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+
+	var conn models.DriveConnection
+	query := `SELECT user_id, refresh_token, folder_id, email, created, updated FROM drive_connection WHERE user_id = ?`
+	err := DB.QueryRowContext(ctx, query, userID).Scan(
+		&conn.UserID,
+		&conn.RefreshToken,
+		&conn.FolderID,
+		&conn.Email,
+		&conn.Created,
+		&conn.Updated,
+	)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("drive connection for user %d not found", userID)
+	} else if err != nil {
+		slog.ErrorContext(ctx, "failed to query drive connection", "error", err)
+		return nil, fmt.Errorf("failed to query drive connection: %v", err)
+	}
+
+	return &conn, nil
+}
+
+// DeleteDriveConnection removes the user's Drive connection
+func DeleteDriveConnection(ctx context.Context, userID int) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	result, err := DB.ExecContext(ctx, `DELETE FROM drive_connection WHERE user_id = ?`, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to delete drive connection", "error", err)
+		return fmt.Errorf("failed to delete drive connection: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("drive connection for user %d not found", userID)
+	}
+
+	slog.InfoContext(ctx, "deleted drive connection")
+	return nil
+}
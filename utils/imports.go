@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"personalnote.eu/simple-go-api/models"
+)
+
+const importJobColumns = `id, user_id, name, status, total, processed, imported, failed, errors, error_message, created, updated`
+
+// CreateImportJob records a new queued import
+func CreateImportJob(ctx context.Context, job *models.ImportJob) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	query := `
+		INSERT INTO import_job (id, user_id, name, status, created, updated)
+		VALUES (?, ?, ?, ?, NOW(), NOW())
+	`
+
+	if _, err := DB.ExecContext(ctx, query, job.ID, job.UserID, job.Name, models.ImportStatusQueued); err != nil {
+		slog.ErrorContext(ctx, "failed to create import job", "error", err)
+		return fmt.Errorf("failed to create import job: %v", err)
+	}
+
+	slog.InfoContext(ctx, "created import job", "import_id", job.ID, "name", job.Name)
+	return nil
+}
+
+// GetImportJob retrieves an import owned by the user
+func GetImportJob(ctx context.Context, id string, userID int) (*models.ImportJob, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+
+	query := `SELECT ` + importJobColumns + ` FROM import_job WHERE id = ? AND user_id = ?`
+
+	job, err := scanImportJob(DB.QueryRowContext(ctx, query, id, userID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("import %s not found", id)
+		}
+		slog.ErrorContext(ctx, "failed to query import job", "import_id", id, "error", err)
+		return nil, fmt.Errorf("failed to query import job: %v", err)
+	}
+
+	return job, nil
+}
+
+// UpdateImportJob stores the status, counters and errors of an import
+func UpdateImportJob(ctx context.Context, job *models.ImportJob) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	errs, err := json.Marshal(job.Errors)
+	if err != nil {
+		return fmt.Errorf("failed to encode import errors: %v", err)
+	}
+
+	query := `
+		UPDATE import_job
+		SET status = ?, total = ?, processed = ?, imported = ?, failed = ?, errors = ?, error_message = ?, updated = NOW()
+		WHERE id = ?
+	`
+
+	_, err = DB.ExecContext(ctx, query,
+		job.Status, job.Total, job.Processed, job.Imported, job.Failed, string(errs), job.Error, job.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to update import job", "import_id", job.ID, "error", err)
+		return fmt.Errorf("failed to update import job: %v", err)
+	}
+	return nil
+}
+
+// FailUnfinishedImports marks imports that were queued or running as failed and returns
+// their IDs; they are left over when the server stops in the middle of an import
+func FailUnfinishedImports(ctx context.Context, message string) ([]string, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+
+	rows, err := DB.QueryContext(ctx, `SELECT id FROM import_job WHERE status IN (?, ?)`,
+		models.ImportStatusQueued, models.ImportStatusRunning)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to execute query", "error", err)
+		return nil, fmt.Errorf("failed to execute query: %v", err)
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	for _, id := range ids {
+		query := `UPDATE import_job SET status = ?, error_message = ?, updated = NOW() WHERE id = ?`
+		if _, err := DB.ExecContext(ctx, query, models.ImportStatusFailed, message, id); err != nil {
+			slog.ErrorContext(ctx, "failed to update import job", "import_id", id, "error", err)
+			return nil, fmt.Errorf("failed to update import job: %v", err)
+		}
+	}
+	return ids, nil
+}
+
+func scanImportJob(row rowScanner) (*models.ImportJob, error) {
+	var job models.ImportJob
+	var errs, errorMessage sql.NullString
+	err := row.Scan(
+		&job.ID,
+		&job.UserID,
+		&job.Name,
+		&job.Status,
+		&job.Total,
+		&job.Processed,
+		&job.Imported,
+		&job.Failed,
+		&errs,
+		&errorMessage,
+		&job.Created,
+		&job.Updated,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	job.Errors = []models.ImportItemError{}
+	if errs.String != "" {
+		if err := json.Unmarshal([]byte(errs.String), &job.Errors); err != nil {
+			return nil, fmt.Errorf("failed to decode import errors: %v", err)
+		}
+	}
+	job.Error = errorMessage.String
+	return &job, nil
+}
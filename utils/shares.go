@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	"personalnote.eu/simple-go-api/models"
+)
+
+const shareColumns = `article_id, user_id, token_hash, password_hash, expires, views, last_viewed, created`
+
+// SaveShare creates the share link of an article or replaces it, which revokes the
+// previous token, resets the view count and records who created the new link
+func SaveShare(ctx context.Context, share *models.ArticleShare) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	query := `
+		INSERT INTO article_share (article_id, user_id, token_hash, password_hash, expires, views, created)
+		VALUES (?, ?, ?, NULLIF(?, ''), ?, 0, NOW())
+		ON DUPLICATE KEY UPDATE user_id = VALUES(user_id), token_hash = VALUES(token_hash),
+			password_hash = VALUES(password_hash), expires = VALUES(expires), views = 0, last_viewed = NULL, created = NOW()
+	`
+
+	_, err := DB.ExecContext(ctx, query, share.ArticleID, share.CreatedBy, share.TokenHash, share.PasswordHash, share.Expires)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to save share link", "article_id", share.ArticleID, "error", err)
+		return fmt.Errorf("failed to save share link: %v", err)
+	}
+
+	slog.InfoContext(ctx, "saved share link", "article_id", share.ArticleID)
+	return nil
+}
+
+// GetShare retrieves the share link of an article
+func GetShare(ctx context.Context, articleID int) (*models.ArticleShare, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+
+	query := `SELECT ` + shareColumns + ` FROM article_share WHERE article_id = ?`
+
+	share, err := scanShare(DB.QueryRowContext(ctx, query, articleID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("share link for article %d not found", articleID)
+	} else if err != nil {
+		slog.ErrorContext(ctx, "failed to query share link", "article_id", articleID, "error", err)
+		return nil, fmt.Errorf("failed to query share link: %v", err)
+	}
+	return share, nil
+}
+
+// GetShareByToken retrieves a share link by the hash of its token, as long as the article
+// has not been deleted. Expiry is left to the caller.
+func GetShareByToken(ctx context.Context, tokenHash string) (*models.ArticleShare, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+
+	query := `
+		SELECT s.article_id, s.user_id, s.token_hash, s.password_hash, s.expires, s.views, s.last_viewed, s.created
+		FROM article_share s
+		JOIN article a ON a.id = s.article_id
+		WHERE s.token_hash = ? AND a.deleted IS NULL
+	`
+
+	share, err := scanShare(DB.QueryRowContext(ctx, query, tokenHash))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("share link not found")
+	} else if err != nil {
+		slog.ErrorContext(ctx, "failed to query share link", "error", err)
+		return nil, fmt.Errorf("failed to query share link: %v", err)
+	}
+	return share, nil
+}
+
+// GetSharedArticle retrieves an article for its share link. The link itself grants read
+// access, so nobody's workspace role or grant is checked and the article is read as a viewer.
+func GetSharedArticle(ctx context.Context, articleID int) (*models.Article, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+
+	query := `
+		SELECT ` + articleColumns + `
+		FROM article a
+		WHERE a.id = ? AND a.deleted IS NULL
+	`
+
+	article, err := scanArticle(DB.QueryRowContext(ctx, query, 0, 0, articleID), 0, 0)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("article with ID %d not found", articleID)
+	} else if err != nil {
+		slog.ErrorContext(ctx, "failed to query shared article", "article_id", articleID, "error", err)
+		return nil, fmt.Errorf("failed to query article: %v", err)
+	}
+	article.Role = models.RoleViewer
+	return article, nil
+}
+
+// RecordShareView counts a view of a share link
+func RecordShareView(ctx context.Context, articleID int) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	query := `UPDATE article_share SET views = views + 1, last_viewed = NOW() WHERE article_id = ?`
+	if _, err := DB.ExecContext(ctx, query, articleID); err != nil {
+		slog.ErrorContext(ctx, "failed to record share view", "article_id", articleID, "error", err)
+		return fmt.Errorf("failed to record share view: %v", err)
+	}
+	return nil
+}
+
+// DeleteShare revokes the share link of an article
+func DeleteShare(ctx context.Context, articleID int) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	result, err := DB.ExecContext(ctx, `DELETE FROM article_share WHERE article_id = ?`, articleID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to delete share link", "article_id", articleID, "error", err)
+		return fmt.Errorf("failed to delete share link: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("share link for article %d not found", articleID)
+	}
+
+	slog.InfoContext(ctx, "revoked share link", "article_id", articleID)
+	return nil
+}
+
+func scanShare(row rowScanner) (*models.ArticleShare, error) {
+	var share models.ArticleShare
+	var passwordHash sql.NullString
+	err := row.Scan(
+		&share.ArticleID,
+		&share.CreatedBy,
+		&share.TokenHash,
+		&passwordHash,
+		&share.Expires,
+		&share.Views,
+		&share.LastViewed,
+		&share.Created,
+	)
+	if err != nil {
+		return nil, err
+	}
+	share.PasswordHash = passwordHash.String
+	return &share, nil
+}
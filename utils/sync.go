@@ -0,0 +1,356 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"personalnote.eu/simple-go-api/models"
+)
+
+// Every user who syncs has a change sequence that advances whenever one of the articles they
+// can access changes, and one sync_change row per article holding the sequence number of its
+// latest change. Changes are only recorded once the user's feed was started by their first
+// sync, which records all the articles they can access at that point.
+
+// GetSyncSequence returns the latest change sequence number of a user. The first call
+// starts the user's change feed with every article they can access.
+func GetSyncSequence(ctx context.Context, userID int) (int64, error) {
+	if DB == nil {
+		return 0, fmt.Errorf("database connection not initialized")
+	}
+
+	var seq int64
+	err := InTransaction(ctx, func(ctx context.Context) error {
+		result, err := conn(ctx).ExecContext(ctx,
+			`INSERT IGNORE INTO sync_sequence (user_id, seq, created) VALUES (?, 0, NOW())`, userID)
+		if err != nil {
+			return fmt.Errorf("failed to start change feed: %v", err)
+		}
+		if started, _ := result.RowsAffected(); started > 0 {
+			if err := reconcileSyncAccess(ctx, userID); err != nil {
+				return err
+			}
+			slog.InfoContext(ctx, "started change feed", "user_id", userID)
+		}
+
+		err = conn(ctx).QueryRowContext(ctx, `SELECT seq FROM sync_sequence WHERE user_id = ?`, userID).Scan(&seq)
+		if err != nil {
+			return fmt.Errorf("failed to get change sequence: %v", err)
+		}
+		return nil
+	})
+	return seq, err
+}
+
+// GetSyncChanges returns up to limit entries of a user's change feed after since and up to
+// until, oldest first, with the current state of each article
+func GetSyncChanges(ctx context.Context, userID int, since, until int64, limit int) ([]models.SyncChange, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+
+	rows, err := conn(ctx).QueryContext(ctx, `
+		SELECT article_id, seq, created_seq, removed FROM sync_change
+		WHERE user_id = ? AND seq > ? AND seq <= ?
+		ORDER BY seq
+		LIMIT ?
+	`, userID, since, until, limit)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to execute query", "error", err)
+		return nil, fmt.Errorf("failed to execute query: %v", err)
+	}
+	defer rows.Close()
+
+	var changes []models.SyncChange
+	var ids []interface{}
+	for rows.Next() {
+		var change models.SyncChange
+		var createdSeq int64
+		var removed bool
+		if err := rows.Scan(&change.ArticleID, &change.Seq, &createdSeq, &removed); err != nil {
+			slog.ErrorContext(ctx, "failed to scan row", "error", err)
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		switch {
+		case removed:
+			change.Type = models.SyncDeleted
+		case createdSeq > since:
+			change.Type = models.SyncCreated
+		default:
+			change.Type = models.SyncUpdated
+		}
+		if !removed {
+			ids = append(ids, change.ArticleID)
+		}
+		changes = append(changes, change)
+	}
+	if err = rows.Err(); err != nil {
+		slog.ErrorContext(ctx, "failed to iterate rows", "error", err)
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+	if len(ids) == 0 {
+		return changes, nil
+	}
+
+	query := `
+		SELECT ` + articleColumns + `
+		FROM article a
+		WHERE a.deleted IS NULL AND a.id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)
+	`
+	articles, err := queryArticles(ctx, userID, 0, query, append([]interface{}{userID, userID}, ids...)...)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]*models.Article, len(articles))
+	for i := range articles {
+		if articles[i].Role != "" {
+			byID[articles[i].ID] = &articles[i]
+		}
+	}
+	for i := range changes {
+		if changes[i].Type == models.SyncDeleted {
+			continue
+		}
+		// Deleted or out of reach since the change was recorded; a later entry says so
+		if changes[i].Article = byID[changes[i].ArticleID]; changes[i].Article == nil {
+			changes[i].Type = models.SyncDeleted
+		}
+	}
+	return changes, nil
+}
+
+// IsSyncTombstone reports whether the user's change feed holds a tombstone for an article,
+// meaning it was deleted or the user lost access to it
+func IsSyncTombstone(ctx context.Context, userID, articleID int) (bool, error) {
+	if DB == nil {
+		return false, fmt.Errorf("database connection not initialized")
+	}
+
+	var removed bool
+	err := conn(ctx).QueryRowContext(ctx,
+		`SELECT removed FROM sync_change WHERE user_id = ? AND article_id = ?`, userID, articleID,
+	).Scan(&removed)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get sync change: %v", err)
+	}
+	return removed, nil
+}
+
+// CreateSyncArticle creates an article for a client's offline create like CreateArticle,
+// once per client ID: when the user sends the same create again, it returns the article the
+// first one made and false.
+func CreateSyncArticle(ctx context.Context, workspaceID, userID int, clientID, title, content, contentFormat string) (int, bool, error) {
+	if DB == nil {
+		return 0, false, fmt.Errorf("database connection not initialized")
+	}
+
+	var id int
+	created := false
+	err := InTransaction(ctx, func(ctx context.Context) error {
+		// Claiming the client ID first makes a concurrent retry wait for this create and
+		// then find its article
+		result, err := conn(ctx).ExecContext(ctx,
+			`INSERT IGNORE INTO sync_client_article (user_id, client_id, created) VALUES (?, ?, NOW())`,
+			userID, clientID)
+		if err != nil {
+			return fmt.Errorf("failed to claim client ID: %v", err)
+		}
+		if claimed, _ := result.RowsAffected(); claimed == 0 {
+			err := conn(ctx).QueryRowContext(ctx,
+				`SELECT article_id FROM sync_client_article WHERE user_id = ? AND client_id = ?`,
+				userID, clientID).Scan(&id)
+			if err != nil {
+				return fmt.Errorf("failed to get article of client ID: %v", err)
+			}
+			slog.InfoContext(ctx, "client create already applied", "client_id", clientID, "article_id", id)
+			return nil
+		}
+
+		id, err = CreateArticle(ctx, workspaceID, userID, title, content, contentFormat)
+		if err != nil {
+			return err
+		}
+		_, err = conn(ctx).ExecContext(ctx,
+			`UPDATE sync_client_article SET article_id = ? WHERE user_id = ? AND client_id = ?`,
+			id, userID, clientID)
+		if err != nil {
+			return fmt.Errorf("failed to record article of client ID: %v", err)
+		}
+		created = true
+		return nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return id, created, nil
+}
+
+// recordArticleChanges adds changes of articles to the feeds of users: to those of
+// everyone who can access each article when userIDs is nil. Users who cannot access an
+// article (any more) get a tombstone for it.
+func recordArticleChanges(ctx context.Context, articleIDs []int, userIDs []int) error {
+	for _, id := range articleIDs {
+		var deleted bool
+		err := conn(ctx).QueryRowContext(ctx, `SELECT deleted IS NOT NULL FROM article WHERE id = ?`, id).Scan(&deleted)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to record article change: %v", err)
+		}
+
+		audience, err := GetArticleAudience(ctx, id)
+		if err != nil {
+			return err
+		}
+		users := userIDs
+		if users == nil {
+			users = audience
+		}
+		// Lock the sequences in a fixed order
+		users = slices.Sorted(slices.Values(users))
+		for _, userID := range users {
+			if err := recordSyncChange(ctx, userID, id, deleted || !slices.Contains(audience, userID)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// recordWorkspaceAccess records the articles of a workspace for a user whose membership
+// or role in it changed
+func recordWorkspaceAccess(ctx context.Context, workspaceID, userID int) error {
+	rows, err := conn(ctx).QueryContext(ctx, `SELECT id FROM article WHERE workspace_id = ? ORDER BY id`, workspaceID)
+	if err != nil {
+		return fmt.Errorf("failed to list workspace articles: %v", err)
+	}
+	ids, err := scanIDs(rows)
+	if err != nil {
+		return err
+	}
+	return recordArticleChanges(ctx, ids, []int{userID})
+}
+
+// reconcileSyncAccess records the articles a user gained access to and tombstones the ones
+// they lost since their feed last saw them
+func reconcileSyncAccess(ctx context.Context, userID int) error {
+	rows, err := conn(ctx).QueryContext(ctx, `
+		SELECT a.id FROM article a
+		WHERE a.deleted IS NULL AND (
+			a.workspace_id IN (SELECT workspace_id FROM workspace_member WHERE user_id = ?)
+			OR a.id IN (SELECT article_id FROM article_acl WHERE user_id = ?))
+		ORDER BY a.id
+	`, userID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to list accessible articles: %v", err)
+	}
+	accessible, err := scanIDs(rows)
+	if err != nil {
+		return err
+	}
+
+	rows, err = conn(ctx).QueryContext(ctx,
+		`SELECT article_id FROM sync_change WHERE user_id = ? AND removed = FALSE ORDER BY article_id`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to list synced articles: %v", err)
+	}
+	synced, err := scanIDs(rows)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range accessible {
+		if _, found := slices.BinarySearch(synced, id); !found {
+			if err := recordSyncChange(ctx, userID, id, false); err != nil {
+				return err
+			}
+		}
+	}
+	for _, id := range synced {
+		if _, found := slices.BinarySearch(accessible, id); !found {
+			if err := recordSyncChange(ctx, userID, id, true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// recordSyncChange gives an article the next sequence number in the user's feed, as a
+// tombstone when removed. Users who never synced are skipped.
+func recordSyncChange(ctx context.Context, userID, articleID int, removed bool) error {
+	return InTransaction(ctx, func(ctx context.Context) error {
+		// Taking the next number locks the user's sequence until the transaction ends, so
+		// changes become visible in sequence order
+		result, err := conn(ctx).ExecContext(ctx, `UPDATE sync_sequence SET seq = seq + 1 WHERE user_id = ?`, userID)
+		if err != nil {
+			return fmt.Errorf("failed to advance change sequence: %v", err)
+		}
+		if started, _ := result.RowsAffected(); started == 0 {
+			return nil
+		}
+
+		var seq int64
+		if err := conn(ctx).QueryRowContext(ctx, `SELECT seq FROM sync_sequence WHERE user_id = ?`, userID).Scan(&seq); err != nil {
+			return fmt.Errorf("failed to get change sequence: %v", err)
+		}
+
+		var createdSeq int64
+		var wasRemoved bool
+		err = conn(ctx).QueryRowContext(ctx,
+			`SELECT created_seq, removed FROM sync_change WHERE user_id = ? AND article_id = ?`, userID, articleID,
+		).Scan(&createdSeq, &wasRemoved)
+		switch {
+		case err == sql.ErrNoRows:
+			if removed {
+				// The user's feed never had the article
+				return nil
+			}
+			_, err = conn(ctx).ExecContext(ctx,
+				`INSERT INTO sync_change (user_id, article_id, seq, created_seq, removed) VALUES (?, ?, ?, ?, FALSE)`,
+				userID, articleID, seq, seq)
+		case err != nil:
+			return fmt.Errorf("failed to get sync change: %v", err)
+		case wasRemoved && removed:
+			return nil
+		default:
+			if wasRemoved {
+				// Back after a tombstone, so the feed reports it as created again
+				createdSeq = seq
+			}
+			_, err = conn(ctx).ExecContext(ctx,
+				`UPDATE sync_change SET seq = ?, created_seq = ?, removed = ? WHERE user_id = ? AND article_id = ?`,
+				seq, createdSeq, removed, userID, articleID)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to record sync change: %v", err)
+		}
+		return nil
+	})
+}
+
+// scanIDs collects and closes rows of a single integer column
+func scanIDs(rows *sql.Rows) ([]int, error) {
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+	return ids, nil
+}
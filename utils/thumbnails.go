@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	"personalnote.eu/simple-go-api/models"
+)
+
+// GetAttachmentsByThumbnailStatus retrieves attachments of all users whose thumbnails are in the given status, oldest first
+func GetAttachmentsByThumbnailStatus(ctx context.Context, status string, limit int) ([]models.Attachment, error) {
+	query := `SELECT ` + attachmentColumns + ` FROM attachment WHERE thumbnail_status = ? ORDER BY id LIMIT ?`
+	return queryAttachments(ctx, query, status, limit)
+}
+
+// GetAttachmentByThumbnailStatus retrieves an attachment of any user if its thumbnails are in the given status
+func GetAttachmentByThumbnailStatus(ctx context.Context, id int, status string) (*models.Attachment, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+
+	query := `SELECT ` + attachmentColumns + ` FROM attachment WHERE id = ? AND thumbnail_status = ?`
+
+	attachment, err := scanAttachment(DB.QueryRowContext(ctx, query, id, status))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("attachment with ID %d and thumbnail status %s not found", id, status)
+		}
+		slog.ErrorContext(ctx, "failed to query attachment", "attachment_id", id, "error", err)
+		return nil, fmt.Errorf("failed to query attachment: %v", err)
+	}
+
+	return attachment, nil
+}
+
+// UpdateThumbnailStatus sets the thumbnail status of an attachment
+func UpdateThumbnailStatus(ctx context.Context, attachmentID int, status string) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	if _, err := DB.ExecContext(ctx, `UPDATE attachment SET thumbnail_status = ? WHERE id = ?`, status, attachmentID); err != nil {
+		slog.ErrorContext(ctx, "failed to update thumbnail status", "attachment_id", attachmentID, "error", err)
+		return fmt.Errorf("failed to update thumbnail status: %v", err)
+	}
+	return nil
+}
+
+// SaveThumbnail records a generated thumbnail, replacing an earlier one of the same size
+func SaveThumbnail(ctx context.Context, thumbnail *models.Thumbnail) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	query := `
+		INSERT INTO thumbnail (attachment_id, size, backend, remote_id, mime_type, width, height, bytes, created)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, NOW())
+		ON DUPLICATE KEY UPDATE backend = VALUES(backend), remote_id = VALUES(remote_id),
+			mime_type = VALUES(mime_type), width = VALUES(width), height = VALUES(height),
+			bytes = VALUES(bytes), created = NOW()
+	`
+
+	_, err := DB.ExecContext(ctx, query,
+		thumbnail.AttachmentID,
+		thumbnail.Size,
+		thumbnail.Backend,
+		thumbnail.RemoteID,
+		thumbnail.MimeType,
+		thumbnail.Width,
+		thumbnail.Height,
+		thumbnail.Bytes,
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to save thumbnail", "attachment_id", thumbnail.AttachmentID, "error", err)
+		return fmt.Errorf("failed to save thumbnail: %v", err)
+	}
+	return nil
+}
+
+// GetThumbnails retrieves the thumbnails of an attachment, smallest first
+func GetThumbnails(ctx context.Context, attachmentID int) ([]models.Thumbnail, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+
+	query := `
+		SELECT attachment_id, size, backend, remote_id, mime_type, width, height, bytes
+		FROM thumbnail WHERE attachment_id = ? ORDER BY size
+	`
+
+	rows, err := DB.QueryContext(ctx, query, attachmentID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to query thumbnails", "attachment_id", attachmentID, "error", err)
+		return nil, fmt.Errorf("failed to query thumbnails: %v", err)
+	}
+	defer rows.Close()
+
+	thumbnails := []models.Thumbnail{}
+	for rows.Next() {
+		var t models.Thumbnail
+		if err := rows.Scan(&t.AttachmentID, &t.Size, &t.Backend, &t.RemoteID, &t.MimeType, &t.Width, &t.Height, &t.Bytes); err != nil {
+			slog.ErrorContext(ctx, "failed to scan row", "error", err)
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		thumbnails = append(thumbnails, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		slog.ErrorContext(ctx, "failed to iterate rows", "error", err)
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	return thumbnails, nil
+}
@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// dbConn is implemented by both *sql.DB and *sql.Tx
+type dbConn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type txKey struct{}
+
+// InTransaction runs fn in a database transaction. Article queries made with the context
+// passed to fn join the transaction, which commits when fn returns nil and rolls back
+// otherwise. Calls nested in a transaction join the outer one.
+func InTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	if _, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
+
+// conn returns the transaction of ctx, if any, or else the connection pool
+func conn(ctx context.Context) dbConn {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return tx
+	}
+	return DB
+}
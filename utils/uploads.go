@@ -0,0 +1,174 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"personalnote.eu/simple-go-api/models"
+)
+
+const uploadColumns = `id, user_id, article_id, name, mime_type, metadata, upload_length, upload_offset, status, attachment_id, error_message, created, updated`
+
+// CreateUpload records a new resumable upload
+func CreateUpload(ctx context.Context, upload *models.Upload) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	query := `
+		INSERT INTO upload (id, user_id, article_id, name, mime_type, metadata, upload_length, upload_offset, status, created, updated)
+		VALUES (?, ?, ?, ?, ?, ?, ?, 0, ?, NOW(), NOW())
+	`
+
+	_, err := DB.ExecContext(ctx, query,
+		upload.ID,
+		upload.UserID,
+		upload.ArticleID,
+		upload.Name,
+		upload.MimeType,
+		upload.Metadata,
+		upload.Length,
+		models.UploadStatusUploading,
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to create upload", "error", err)
+		return fmt.Errorf("failed to create upload: %v", err)
+	}
+
+	slog.InfoContext(ctx, "created upload", "upload_id", upload.ID, "length", upload.Length)
+	return nil
+}
+
+// GetUploadByID retrieves a resumable upload owned by the user
+func GetUploadByID(ctx context.Context, id string, userID int) (*models.Upload, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+
+	query := `SELECT ` + uploadColumns + ` FROM upload WHERE id = ? AND user_id = ?`
+
+	upload, err := scanUpload(DB.QueryRowContext(ctx, query, id, userID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("upload %s not found", id)
+		}
+		slog.ErrorContext(ctx, "failed to query upload", "upload_id", id, "error", err)
+		return nil, fmt.Errorf("failed to query upload: %v", err)
+	}
+
+	return upload, nil
+}
+
+// GetUploadsByStatus retrieves all uploads in the given state, oldest first
+func GetUploadsByStatus(ctx context.Context, status string) ([]models.Upload, error) {
+	query := `SELECT ` + uploadColumns + ` FROM upload WHERE status = ? ORDER BY updated`
+	return queryUploads(ctx, query, status)
+}
+
+// GetStaleUploads retrieves uploads in the given state that have not changed since before
+func GetStaleUploads(ctx context.Context, status string, before time.Time) ([]models.Upload, error) {
+	query := `SELECT ` + uploadColumns + ` FROM upload WHERE status = ? AND updated < ? ORDER BY updated`
+	return queryUploads(ctx, query, status, before)
+}
+
+// UpdateUploadOffset persists the number of bytes received so far
+func UpdateUploadOffset(ctx context.Context, id string, offset int64) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	_, err := DB.ExecContext(ctx, `UPDATE upload SET upload_offset = ?, updated = NOW() WHERE id = ?`, offset, id)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to update upload offset", "upload_id", id, "error", err)
+		return fmt.Errorf("failed to update upload offset: %v", err)
+	}
+	return nil
+}
+
+// UpdateUploadStatus moves an upload to a new state, recording the resulting attachment or error
+func UpdateUploadStatus(ctx context.Context, id string, status string, attachmentID *int, errorMessage string) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	query := `UPDATE upload SET status = ?, attachment_id = ?, error_message = ?, updated = NOW() WHERE id = ?`
+	if _, err := DB.ExecContext(ctx, query, status, attachmentID, errorMessage, id); err != nil {
+		slog.ErrorContext(ctx, "failed to update upload status", "upload_id", id, "status", status, "error", err)
+		return fmt.Errorf("failed to update upload status: %v", err)
+	}
+
+	slog.InfoContext(ctx, "updated upload status", "upload_id", id, "status", status)
+	return nil
+}
+
+// DeleteUpload removes the upload record
+func DeleteUpload(ctx context.Context, id string) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	if _, err := DB.ExecContext(ctx, `DELETE FROM upload WHERE id = ?`, id); err != nil {
+		slog.ErrorContext(ctx, "failed to delete upload", "upload_id", id, "error", err)
+		return fmt.Errorf("failed to delete upload: %v", err)
+	}
+	return nil
+}
+
+func queryUploads(ctx context.Context, query string, args ...interface{}) ([]models.Upload, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+
+	rows, err := DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to execute query", "error", err)
+		return nil, fmt.Errorf("failed to execute query: %v", err)
+	}
+	defer rows.Close()
+
+	uploads := []models.Upload{}
+	for rows.Next() {
+		upload, err := scanUpload(rows)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to scan row", "error", err)
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		uploads = append(uploads, *upload)
+	}
+
+	if err = rows.Err(); err != nil {
+		slog.ErrorContext(ctx, "failed to iterate rows", "error", err)
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	return uploads, nil
+}
+
+func scanUpload(row rowScanner) (*models.Upload, error) {
+	var upload models.Upload
+	var metadata, errorMessage sql.NullString
+	err := row.Scan(
+		&upload.ID,
+		&upload.UserID,
+		&upload.ArticleID,
+		&upload.Name,
+		&upload.MimeType,
+		&metadata,
+		&upload.Length,
+		&upload.Offset,
+		&upload.Status,
+		&upload.AttachmentID,
+		&errorMessage,
+		&upload.Created,
+		&upload.Updated,
+	)
+	if err != nil {
+		return nil, err
+	}
+	upload.Metadata = metadata.String
+	upload.Error = errorMessage.String
+	return &upload, nil
+}
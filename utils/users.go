@@ -1,26 +1,29 @@
 package utils
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
 
 	"personalnote.eu/simple-go-api/models"
 )
 
-// CreateOrUpdateUser creates a new user or updates an existing one
-func CreateOrUpdateUser(googleID, email, name, picture string) (*models.User, error) {
+// CreateOrUpdateUser creates a new user or updates an existing one. emailVerified records
+// whether Google has verified the address at this sign-in.
+func CreateOrUpdateUser(ctx context.Context, googleID, email string, emailVerified bool, name, picture string) (*models.User, error) {
 	if DB == nil {
 		return nil, fmt.Errorf("database connection not initialized")
 	}
 
 	// Check if user exists
 	var user models.User
-	query := `SELECT id, google_id, email, name, picture, created_at, updated_at FROM users WHERE google_id = ?`
-	err := DB.QueryRow(query, googleID).Scan(
+	query := `SELECT id, google_id, email, email_verified, name, picture, created_at, updated_at FROM users WHERE google_id = ?`
+	err := DB.QueryRowContext(ctx, query, googleID).Scan(
 		&user.ID,
 		&user.GoogleID,
 		&user.Email,
+		&user.EmailVerified,
 		&user.Name,
 		&user.Picture,
 		&user.CreatedAt,
@@ -29,11 +32,11 @@ func CreateOrUpdateUser(googleID, email, name, picture string) (*models.User, er
 
 	if err == sql.ErrNoRows {
 		// User doesn't exist, create new one
-		insertQuery := `INSERT INTO users (google_id, email, name, picture, created_at, updated_at) 
-			VALUES (?, ?, ?, ?, NOW(), NOW())`
-		result, err := DB.Exec(insertQuery, googleID, email, name, picture)
+		insertQuery := `INSERT INTO users (google_id, email, email_verified, name, picture, created_at, updated_at) 
+			VALUES (?, ?, ?, ?, ?, NOW(), NOW())`
+		result, err := DB.ExecContext(ctx, insertQuery, googleID, email, emailVerified, name, picture)
 		if err != nil {
-			log.Printf("Error creating user: %v", err)
+			slog.ErrorContext(ctx, "failed to create user", "error", err)
 			return nil, fmt.Errorf("failed to create user: %v", err)
 		}
 
@@ -45,44 +48,47 @@ func CreateOrUpdateUser(googleID, email, name, picture string) (*models.User, er
 		user.ID = int(id)
 		user.GoogleID = googleID
 		user.Email = email
+		user.EmailVerified = emailVerified
 		user.Name = name
 		user.Picture = picture
 
-		log.Printf("👤 Created new user: %s (%s)", name, email)
+		slog.InfoContext(ctx, "created user", "user_id", user.ID)
 		return &user, nil
 	} else if err != nil {
-		log.Printf("Error querying user: %v", err)
+		slog.ErrorContext(ctx, "failed to query user", "error", err)
 		return nil, fmt.Errorf("failed to query user: %v", err)
 	}
 
 	// User exists, update info
-	updateQuery := `UPDATE users SET email = ?, name = ?, picture = ?, updated_at = NOW() WHERE google_id = ?`
-	_, err = DB.Exec(updateQuery, email, name, picture, googleID)
+	updateQuery := `UPDATE users SET email = ?, email_verified = ?, name = ?, picture = ?, updated_at = NOW() WHERE google_id = ?`
+	_, err = DB.ExecContext(ctx, updateQuery, email, emailVerified, name, picture, googleID)
 	if err != nil {
-		log.Printf("Error updating user: %v", err)
+		slog.ErrorContext(ctx, "failed to update user", "error", err)
 		return nil, fmt.Errorf("failed to update user: %v", err)
 	}
 
 	user.Email = email
+	user.EmailVerified = emailVerified
 	user.Name = name
 	user.Picture = picture
 
-	log.Printf("👤 Updated user: %s (%s)", name, email)
+	slog.InfoContext(ctx, "updated user", "user_id", user.ID)
 	return &user, nil
 }
 
 // GetUserByID retrieves a user by their ID
-func GetUserByID(id int) (*models.User, error) {
+func GetUserByID(ctx context.Context, id int) (*models.User, error) {
 	if DB == nil {
 		return nil, fmt.Errorf("database connection not initialized")
 	}
 
 	var user models.User
-	query := `SELECT id, google_id, email, name, picture, created_at, updated_at FROM users WHERE id = ?`
-	err := DB.QueryRow(query, id).Scan(
+	query := `SELECT id, google_id, email, email_verified, name, picture, created_at, updated_at FROM users WHERE id = ?`
+	err := DB.QueryRowContext(ctx, query, id).Scan(
 		&user.ID,
 		&user.GoogleID,
 		&user.Email,
+		&user.EmailVerified,
 		&user.Name,
 		&user.Picture,
 		&user.CreatedAt,
@@ -92,7 +98,37 @@ func GetUserByID(id int) (*models.User, error) {
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("user with ID %d not found", id)
 	} else if err != nil {
-		log.Printf("Error querying user: %v", err)
+		slog.ErrorContext(ctx, "failed to query user", "error", err)
+		return nil, fmt.Errorf("failed to query user: %v", err)
+	}
+
+	return &user, nil
+}
+
+// GetVerifiedUserByEmail retrieves the user whose email address, ignoring case, Google
+// verified when they last signed in. Access given by email goes straight to such a user only.
+func GetVerifiedUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+
+	var user models.User
+	query := `SELECT id, google_id, email, email_verified, name, picture, created_at, updated_at FROM users WHERE LOWER(email) = LOWER(?) AND email_verified ORDER BY id LIMIT 1`
+	err := DB.QueryRowContext(ctx, query, email).Scan(
+		&user.ID,
+		&user.GoogleID,
+		&user.Email,
+		&user.EmailVerified,
+		&user.Name,
+		&user.Picture,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("user with verified email %s not found", email)
+	} else if err != nil {
+		slog.ErrorContext(ctx, "failed to query user", "error", err)
 		return nil, fmt.Errorf("failed to query user: %v", err)
 	}
 
@@ -0,0 +1,503 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"personalnote.eu/simple-go-api/models"
+)
+
+// personalWorkspaceName is the name given to new personal workspaces
+const personalWorkspaceName = "Personal"
+
+// workspaceColumns are the columns read by scanWorkspace from workspace w joined with the
+// requesting user's membership m
+const workspaceColumns = `w.id, w.name, w.personal_user_id IS NOT NULL, m.role,
+	(SELECT COUNT(*) FROM workspace_member mc WHERE mc.workspace_id = w.id), w.created, w.updated`
+
+func scanWorkspace(row rowScanner) (*models.Workspace, error) {
+	var workspace models.Workspace
+	err := row.Scan(
+		&workspace.ID,
+		&workspace.Name,
+		&workspace.Personal,
+		&workspace.Role,
+		&workspace.Members,
+		&workspace.Created,
+		&workspace.Updated,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &workspace, nil
+}
+
+// ValidWorkspaceRole reports whether role can be given to a workspace member. There is
+// exactly one owner, so owner cannot be given.
+func ValidWorkspaceRole(role string) bool {
+	return role == models.WorkspaceGuest || role == models.WorkspaceMember || role == models.WorkspaceAdmin
+}
+
+// migratePersonalWorkspaces gives every user a personal workspace and moves articles that
+// have no workspace yet into their author's
+func migratePersonalWorkspaces() error {
+	createQuery := `INSERT INTO workspace (name, personal_user_id, created, updated)
+		SELECT ?, u.id, NOW(), NOW() FROM users u
+		WHERE u.id NOT IN (SELECT personal_user_id FROM workspace WHERE personal_user_id IS NOT NULL)`
+	if _, err := DB.Exec(createQuery, personalWorkspaceName); err != nil {
+		return fmt.Errorf("failed to create personal workspaces: %v", err)
+	}
+
+	ownerQuery := `INSERT IGNORE INTO workspace_member (workspace_id, user_id, role, joined)
+		SELECT id, personal_user_id, ?, NOW() FROM workspace
+		WHERE personal_user_id IS NOT NULL
+			AND id NOT IN (SELECT workspace_id FROM workspace_member WHERE role = ?)`
+	if _, err := DB.Exec(ownerQuery, models.WorkspaceOwner, models.WorkspaceOwner); err != nil {
+		return fmt.Errorf("failed to add personal workspace owners: %v", err)
+	}
+
+	moveQuery := `UPDATE article a JOIN workspace w ON w.personal_user_id = a.user_id
+		SET a.workspace_id = w.id WHERE a.workspace_id IS NULL`
+	result, err := DB.Exec(moveQuery)
+	if err != nil {
+		return fmt.Errorf("failed to move articles to personal workspaces: %v", err)
+	}
+	if moved, _ := result.RowsAffected(); moved > 0 {
+		slog.Info("moved articles to personal workspaces", "count", moved)
+	}
+	return nil
+}
+
+// GetPersonalWorkspace retrieves the personal workspace of a user, creating it for users
+// who signed up after workspaces were introduced
+func GetPersonalWorkspace(ctx context.Context, userID int) (*models.Workspace, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+
+	query := `SELECT ` + workspaceColumns + `
+		FROM workspace w JOIN workspace_member m ON m.workspace_id = w.id
+		WHERE w.personal_user_id = ? AND m.user_id = ?`
+
+	workspace, err := scanWorkspace(DB.QueryRowContext(ctx, query, userID, userID))
+	if err == sql.ErrNoRows {
+		if err := createPersonalWorkspace(ctx, userID); err != nil {
+			return nil, err
+		}
+		workspace, err = scanWorkspace(DB.QueryRowContext(ctx, query, userID, userID))
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to query personal workspace", "error", err)
+		return nil, fmt.Errorf("failed to query personal workspace: %v", err)
+	}
+	return workspace, nil
+}
+
+func createPersonalWorkspace(ctx context.Context, userID int) error {
+	return InTransaction(ctx, func(ctx context.Context) error {
+		createQuery := `INSERT IGNORE INTO workspace (name, personal_user_id, created, updated) VALUES (?, ?, NOW(), NOW())`
+		if _, err := conn(ctx).ExecContext(ctx, createQuery, personalWorkspaceName, userID); err != nil {
+			slog.ErrorContext(ctx, "failed to create personal workspace", "error", err)
+			return fmt.Errorf("failed to create personal workspace: %v", err)
+		}
+
+		ownerQuery := `INSERT IGNORE INTO workspace_member (workspace_id, user_id, role, joined)
+			SELECT id, personal_user_id, ?, NOW() FROM workspace WHERE personal_user_id = ?`
+		if _, err := conn(ctx).ExecContext(ctx, ownerQuery, models.WorkspaceOwner, userID); err != nil {
+			slog.ErrorContext(ctx, "failed to add personal workspace owner", "error", err)
+			return fmt.Errorf("failed to create personal workspace: %v", err)
+		}
+
+		slog.InfoContext(ctx, "created personal workspace", "user_id", userID)
+		return nil
+	})
+}
+
+// GetWorkspace retrieves a workspace the user is a member of, with their role in it
+func GetWorkspace(ctx context.Context, id int, userID int) (*models.Workspace, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+
+	query := `SELECT ` + workspaceColumns + `
+		FROM workspace w JOIN workspace_member m ON m.workspace_id = w.id
+		WHERE w.id = ? AND m.user_id = ?`
+
+	workspace, err := scanWorkspace(DB.QueryRowContext(ctx, query, id, userID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("workspace with ID %d not found", id)
+	} else if err != nil {
+		slog.ErrorContext(ctx, "failed to query workspace", "workspace_id", id, "error", err)
+		return nil, fmt.Errorf("failed to query workspace: %v", err)
+	}
+	return workspace, nil
+}
+
+// GetWorkspaces lists the workspaces a user is a member of, their personal one first
+func GetWorkspaces(ctx context.Context, userID int) ([]models.Workspace, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+
+	// Make sure the personal workspace exists before listing
+	if _, err := GetPersonalWorkspace(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + workspaceColumns + `
+		FROM workspace w JOIN workspace_member m ON m.workspace_id = w.id
+		WHERE m.user_id = ?
+		ORDER BY w.personal_user_id IS NULL, w.name, w.id`
+
+	rows, err := DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to execute query", "error", err)
+		return nil, fmt.Errorf("failed to execute query: %v", err)
+	}
+	defer rows.Close()
+
+	workspaces := []models.Workspace{}
+	for rows.Next() {
+		workspace, err := scanWorkspace(rows)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to scan row", "error", err)
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		workspaces = append(workspaces, *workspace)
+	}
+
+	if err = rows.Err(); err != nil {
+		slog.ErrorContext(ctx, "failed to iterate rows", "error", err)
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+	return workspaces, nil
+}
+
+// CreateWorkspace creates a shared workspace owned by the user
+func CreateWorkspace(ctx context.Context, userID int, name string) (int, error) {
+	if DB == nil {
+		return 0, fmt.Errorf("database connection not initialized")
+	}
+
+	var id int
+	err := InTransaction(ctx, func(ctx context.Context) error {
+		result, err := conn(ctx).ExecContext(ctx,
+			`INSERT INTO workspace (name, created, updated) VALUES (?, NOW(), NOW())`, name)
+		if err != nil {
+			return err
+		}
+		lastID, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		id = int(lastID)
+
+		_, err = conn(ctx).ExecContext(ctx,
+			`INSERT INTO workspace_member (workspace_id, user_id, role, joined) VALUES (?, ?, ?, NOW())`,
+			id, userID, models.WorkspaceOwner)
+		return err
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to create workspace", "error", err)
+		return 0, fmt.Errorf("failed to create workspace: %v", err)
+	}
+
+	slog.InfoContext(ctx, "created workspace", "workspace_id", id)
+	return id, nil
+}
+
+// RenameWorkspace changes the name of a workspace
+func RenameWorkspace(ctx context.Context, id int, name string) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	result, err := DB.ExecContext(ctx, `UPDATE workspace SET name = ?, updated = NOW() WHERE id = ?`, name, id)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to rename workspace", "workspace_id", id, "error", err)
+		return fmt.Errorf("failed to rename workspace: %v", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	} else if rowsAffected == 0 {
+		return fmt.Errorf("workspace with ID %d not found", id)
+	}
+	return nil
+}
+
+// DeleteWorkspace removes a shared workspace with its members and invites. Workspaces
+// that still hold articles are kept.
+func DeleteWorkspace(ctx context.Context, id int) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	var articles int
+	countQuery := `SELECT COUNT(*) FROM article WHERE workspace_id = ? AND deleted IS NULL`
+	if err := DB.QueryRowContext(ctx, countQuery, id).Scan(&articles); err != nil {
+		slog.ErrorContext(ctx, "failed to count workspace articles", "workspace_id", id, "error", err)
+		return fmt.Errorf("failed to delete workspace: %v", err)
+	}
+	if articles > 0 {
+		return fmt.Errorf("workspace %d still has %d articles", id, articles)
+	}
+
+	result, err := DB.ExecContext(ctx, `DELETE FROM workspace WHERE id = ? AND personal_user_id IS NULL`, id)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to delete workspace", "workspace_id", id, "error", err)
+		return fmt.Errorf("failed to delete workspace: %v", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	} else if rowsAffected == 0 {
+		return fmt.Errorf("workspace with ID %d not found", id)
+	}
+
+	slog.InfoContext(ctx, "deleted workspace", "workspace_id", id)
+	return nil
+}
+
+// GetWorkspaceMembers lists the members of a workspace
+func GetWorkspaceMembers(ctx context.Context, id int) ([]models.WorkspaceMemberEntry, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+
+	query := `
+		SELECT m.workspace_id, m.user_id, u.email, COALESCE(u.name, ''), m.role, m.joined
+		FROM workspace_member m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.workspace_id = ?
+		ORDER BY m.joined, m.user_id
+	`
+
+	rows, err := DB.QueryContext(ctx, query, id)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to execute query", "error", err)
+		return nil, fmt.Errorf("failed to execute query: %v", err)
+	}
+	defer rows.Close()
+
+	members := []models.WorkspaceMemberEntry{}
+	for rows.Next() {
+		var member models.WorkspaceMemberEntry
+		err := rows.Scan(
+			&member.WorkspaceID,
+			&member.UserID,
+			&member.Email,
+			&member.Name,
+			&member.Role,
+			&member.Joined,
+		)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to scan row", "error", err)
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		members = append(members, member)
+	}
+
+	if err = rows.Err(); err != nil {
+		slog.ErrorContext(ctx, "failed to iterate rows", "error", err)
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+	return members, nil
+}
+
+// GetWorkspaceMember retrieves one member of a workspace
+func GetWorkspaceMember(ctx context.Context, id, userID int) (*models.WorkspaceMemberEntry, error) {
+	members, err := GetWorkspaceMembers(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		if member.UserID == userID {
+			return &member, nil
+		}
+	}
+	return nil, fmt.Errorf("member %d of workspace %d not found", userID, id)
+}
+
+// AddWorkspaceMember adds a user to a workspace. Adding an existing member changes their role.
+func AddWorkspaceMember(ctx context.Context, id, userID int, role string) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	query := `
+		INSERT INTO workspace_member (workspace_id, user_id, role, joined)
+		VALUES (?, ?, ?, NOW())
+		ON DUPLICATE KEY UPDATE role = VALUES(role)
+	`
+
+	if _, err := DB.ExecContext(ctx, query, id, userID, role); err != nil {
+		slog.ErrorContext(ctx, "failed to add workspace member", "workspace_id", id, "error", err)
+		return fmt.Errorf("failed to add workspace member: %v", err)
+	}
+
+	slog.InfoContext(ctx, "added workspace member", "workspace_id", id, "member_id", userID, "role", role)
+	return recordWorkspaceAccess(ctx, id, userID)
+}
+
+// SetWorkspaceMemberRole changes the role of a workspace member
+func SetWorkspaceMemberRole(ctx context.Context, id, userID int, role string) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	result, err := DB.ExecContext(ctx,
+		`UPDATE workspace_member SET role = ? WHERE workspace_id = ? AND user_id = ?`, role, id, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to change workspace role", "workspace_id", id, "error", err)
+		return fmt.Errorf("failed to change workspace role: %v", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	} else if rowsAffected == 0 {
+		// MySQL reports no change when the role stays the same
+		if _, err := GetWorkspaceMember(ctx, id, userID); err != nil {
+			return err
+		}
+	}
+
+	slog.InfoContext(ctx, "changed workspace role", "workspace_id", id, "member_id", userID, "role", role)
+	return recordWorkspaceAccess(ctx, id, userID)
+}
+
+// RemoveWorkspaceMember removes a user from a workspace. Their articles stay in it.
+func RemoveWorkspaceMember(ctx context.Context, id, userID int) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	result, err := DB.ExecContext(ctx,
+		`DELETE FROM workspace_member WHERE workspace_id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to remove workspace member", "workspace_id", id, "error", err)
+		return fmt.Errorf("failed to remove workspace member: %v", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	} else if rowsAffected == 0 {
+		return fmt.Errorf("member %d of workspace %d not found", userID, id)
+	}
+
+	slog.InfoContext(ctx, "removed workspace member", "workspace_id", id, "member_id", userID)
+	return recordWorkspaceAccess(ctx, id, userID)
+}
+
+// SaveWorkspaceInvite invites an email address to a workspace. Inviting the same address
+// again changes the role.
+func SaveWorkspaceInvite(ctx context.Context, invite *models.WorkspaceInvite) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	invite.Email = strings.ToLower(invite.Email)
+	query := `
+		INSERT INTO workspace_invite (workspace_id, email, role, invited_by, created)
+		VALUES (?, ?, ?, ?, NOW())
+		ON DUPLICATE KEY UPDATE role = VALUES(role), invited_by = VALUES(invited_by)
+	`
+
+	if _, err := DB.ExecContext(ctx, query, invite.WorkspaceID, invite.Email, invite.Role, invite.InvitedBy); err != nil {
+		slog.ErrorContext(ctx, "failed to save workspace invite", "workspace_id", invite.WorkspaceID, "error", err)
+		return fmt.Errorf("failed to save workspace invite: %v", err)
+	}
+
+	slog.InfoContext(ctx, "invited to workspace", "workspace_id", invite.WorkspaceID, "role", invite.Role)
+	return nil
+}
+
+// GetWorkspaceInvites lists the pending invites of a workspace
+func GetWorkspaceInvites(ctx context.Context, id int) ([]models.WorkspaceInvite, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+
+	query := `
+		SELECT id, workspace_id, email, role, invited_by, created
+		FROM workspace_invite
+		WHERE workspace_id = ?
+		ORDER BY created, id
+	`
+
+	rows, err := DB.QueryContext(ctx, query, id)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to execute query", "error", err)
+		return nil, fmt.Errorf("failed to execute query: %v", err)
+	}
+	defer rows.Close()
+
+	invites := []models.WorkspaceInvite{}
+	for rows.Next() {
+		var invite models.WorkspaceInvite
+		err := rows.Scan(
+			&invite.ID,
+			&invite.WorkspaceID,
+			&invite.Email,
+			&invite.Role,
+			&invite.InvitedBy,
+			&invite.Created,
+		)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to scan row", "error", err)
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		invites = append(invites, invite)
+	}
+
+	if err = rows.Err(); err != nil {
+		slog.ErrorContext(ctx, "failed to iterate rows", "error", err)
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+	return invites, nil
+}
+
+// DeleteWorkspaceInvite withdraws a pending invite
+func DeleteWorkspaceInvite(ctx context.Context, id, inviteID int) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	result, err := DB.ExecContext(ctx, `DELETE FROM workspace_invite WHERE id = ? AND workspace_id = ?`, inviteID, id)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to delete workspace invite", "workspace_id", id, "error", err)
+		return fmt.Errorf("failed to delete workspace invite: %v", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	} else if rowsAffected == 0 {
+		return fmt.Errorf("invite %d not found", inviteID)
+	}
+	return nil
+}
+
+// ClaimWorkspaceInvites makes a user a member of the workspaces their email address was
+// invited to. Invites to workspaces they already belong to are dropped.
+func ClaimWorkspaceInvites(ctx context.Context, userID int, email string) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	email = strings.ToLower(email)
+	query := `INSERT IGNORE INTO workspace_member (workspace_id, user_id, role, joined)
+		SELECT workspace_id, ?, role, NOW() FROM workspace_invite WHERE email = ?`
+	result, err := DB.ExecContext(ctx, query, userID, email)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to claim workspace invites", "error", err)
+		return fmt.Errorf("failed to claim workspace invites: %v", err)
+	}
+	if _, err := DB.ExecContext(ctx, `DELETE FROM workspace_invite WHERE email = ?`, email); err != nil {
+		slog.ErrorContext(ctx, "failed to remove claimed invites", "error", err)
+		return fmt.Errorf("failed to remove claimed invites: %v", err)
+	}
+
+	if claimed, _ := result.RowsAffected(); claimed > 0 {
+		slog.InfoContext(ctx, "claimed workspace invites", "user_id", userID, "count", claimed)
+		return reconcileSyncAccess(ctx, userID)
+	}
+	return nil
+}